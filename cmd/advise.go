@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/advisor"
+	"github.com/pkbhowmick/pg-monitoring/pkg/logs"
+	"github.com/pkbhowmick/pg-monitoring/pkg/output"
+	"github.com/pkbhowmick/pg-monitoring/pkg/producer"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	adviseIndexOptions  = advisor.DefaultIndexOptions()
+	adviseVacuumOptions = advisor.DefaultVacuumOptions()
+	adviseLogSpan       = 7 * 24 * time.Hour
+
+	adviseConfigOptions advisor.ConfigOptions
+	adviseMemory        string
+	adviseCPUs          int
+	adviseStorage       = "ssd"
+)
+
+var adviseCmd = &cobra.Command{
+	Use:   "advise",
+	Short: "Recommend changes to a server from its statistics",
+	Long: "Recommend changes to a server from what its statistics show of the workload. Nothing\n" +
+		"is changed: the statements applying the advice are printed for review.",
+}
+
+var advisers = []inspection{
+	{
+		use:        "indexes",
+		statements: true,
+		short: "Suggest indexes for the statements scanning large tables sequentially, and the unused, " +
+			"invalid or duplicate indexes to drop",
+		list: func(s inspectSource) (*output.List, error) {
+			statements, err := producer.GetTopStatements(s.db, 0)
+			if err != nil {
+				return nil, err
+			}
+			advice, err := advisor.AdviseIndexes(s.db, statements, adviseIndexOptions)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "action"},
+				output.Column{Name: "schema"},
+				output.Column{Name: "table"},
+				output.Column{Name: "index"},
+				output.Column{Name: "columns"},
+				output.Column{Name: "size_bytes"},
+				output.Column{Name: "statements"},
+				output.Column{Name: "total_time", Wide: true},
+				output.Column{Name: "tested", Wide: true},
+				output.Column{Name: "cost_before", Wide: true},
+				output.Column{Name: "cost_after", Wide: true},
+				output.Column{Name: "reason"},
+				output.Column{Name: "sql", Wide: true},
+			)
+			for _, a := range advice {
+				l.Add(a.Action, a.Schema, a.Table, a.Index, strings.Join(a.Columns, ", "), a.SizeBytes, a.Statements,
+					a.TotalTime, a.Tested, a.CostBefore, a.CostAfter, a.Reason, a.SQL)
+			}
+			return l, nil
+		},
+		flags: func(f *pflag.FlagSet) {
+			o := &adviseIndexOptions
+			f.Int64Var(&o.MinRows, "min-rows", o.MinRows, "only suggest indexes for tables of at least this many live rows")
+			f.Int64Var(&o.MinSeqScans, "min-seq-scans", o.MinSeqScans, "only suggest indexes for tables scanned sequentially at least this many times")
+			f.Float64Var(&o.MaxSelectivity, "max-selectivity", o.MaxSelectivity, "largest estimated fraction of a table a suggested index may return per lookup")
+			f.BoolVar(&o.Hypothetical, "hypothetical", o.Hypothetical, "test the suggestions with EXPLAIN and hypothetical indexes when hypopg is installed")
+		},
+	},
+	{
+		use:        "vacuum",
+		statements: true,
+		short: "Flag the tables autovacuum falls behind on and propose storage parameters to let it keep up; " +
+			"vacuum durations are read from the server log when [LOG] is configured",
+		list: func(s inspectSource) (*output.List, error) {
+			settings, err := producer.GetSettings(s.db)
+			if err != nil {
+				return nil, err
+			}
+			var vacuums []model.MaintenanceRuns
+			if cc := s.target.CollectConfig(); cc.LogDir != "" || cc.LogFile != "" {
+				loc, err := producer.GetLogTimezone(s.db)
+				if err != nil {
+					log.Printf("could not get the log timezone, reading the log as UTC: %s\n", err)
+				}
+				summary, err := logs.Read(cc.LogDir, cc.LogFile, adviseLogSpan, s.target.LogLinePrefix, loc)
+				if err != nil {
+					log.Printf("could not read the server log: %s\n", err)
+				}
+				vacuums = summary.Maintenance
+			}
+			advice, err := advisor.AdviseVacuum(s.db, settings, vacuums, adviseVacuumOptions)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "schema"},
+				output.Column{Name: "table"},
+				output.Column{Name: "live"},
+				output.Column{Name: "dead"},
+				output.Column{Name: "trigger"},
+				output.Column{Name: "last_vacuum"},
+				output.Column{Name: "vacuums", Wide: true},
+				output.Column{Name: "longest_vacuum", Wide: true},
+				output.Column{Name: "options"},
+				output.Column{Name: "reason"},
+				output.Column{Name: "sql", Wide: true},
+			)
+			for _, a := range advice {
+				var options []string
+				for name, value := range a.Options {
+					options = append(options, name+"="+value)
+				}
+				sort.Strings(options)
+				l.Add(a.Schema, a.Table, a.LiveRows, a.DeadRows, a.Trigger, a.LastVacuum, a.Vacuums,
+					a.LongestVacuum.Seconds(), strings.Join(options, ", "), strings.Join(a.Issues, "; "), a.SQL)
+			}
+			return l, nil
+		},
+		flags: func(f *pflag.FlagSet) {
+			o := &adviseVacuumOptions
+			f.Int64Var(&o.MinRows, "min-rows", o.MinRows, "leave alone the tables of fewer live and dead rows")
+			f.Float64Var(&o.MaxDeadFraction, "max-dead-fraction", o.MaxDeadFraction, "fraction of dead rows tables should stay below")
+			f.DurationVar(&o.MaxVacuumAge, "max-vacuum-age", o.MaxVacuumAge, "how long a vacuum may be left due before it is overdue")
+			f.DurationVar(&o.LongVacuum, "long-vacuum", o.LongVacuum, "duration of the autovacuums worth reporting")
+			f.Int64Var(&o.TargetDeadRows, "target-dead-rows", o.TargetDeadRows, "dead rows the autovacuum of large tables should start at")
+			f.DurationVar(&adviseLogSpan, "log-span", adviseLogSpan, "how far back to read the autovacuums of the server log")
+		},
+	},
+	{
+		use:        "config",
+		statements: true,
+		short: "Recommend values for the memory, checkpoint, planner and parallelism settings from the host " +
+			"and the workload, flagging those needing a restart",
+		list: func(s inspectSource) (*output.List, error) {
+			o := adviseConfigOptions
+			var err error
+			if o.Host, err = adviseHost(s); err != nil {
+				return nil, err
+			}
+			databases, err := producer.GetDatabases(s.db)
+			if err != nil {
+				return nil, err
+			}
+			checkpointer, wal, err := producer.GetCheckpointer(s.db)
+			if err != nil {
+				return nil, err
+			}
+			advice, err := advisor.AdviseConfig(s.db, databases, checkpointer, wal, o)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "setting"},
+				output.Column{Name: "current"},
+				output.Column{Name: "recommended"},
+				output.Column{Name: "restart"},
+				output.Column{Name: "change", Wide: true},
+				output.Column{Name: "reason"},
+				output.Column{Name: "sql", Wide: true},
+			)
+			for _, a := range advice {
+				l.Add(a.Name, a.Current, a.Recommended, a.Restart, a.Change, a.Reason, a.SQL)
+			}
+			return l, nil
+		},
+		flags: func(f *pflag.FlagSet) {
+			f.StringVar(&adviseMemory, "memory", adviseMemory, "memory of the host of the server, such as 16GB, read from /proc when it runs locally")
+			f.IntVar(&adviseCPUs, "cpus", adviseCPUs, "processors of the host of the server, counted when it runs locally")
+			f.StringVar(&adviseStorage, "storage", adviseStorage, "storage of the data of the server: ssd or hdd")
+			f.BoolVar(&adviseConfigOptions.All, "all", adviseConfigOptions.All, "list the settings found right too")
+		},
+	},
+}
+
+// adviseHost returns the host of the server from the flags, reading what they
+// leave out from /proc when the server runs locally.
+func adviseHost(s inspectSource) (advisor.Host, error) {
+	var h advisor.Host
+	switch adviseStorage {
+	case "ssd":
+		h.SSD = true
+	case "hdd":
+	default:
+		return h, fmt.Errorf("invalid storage %q, expected ssd or hdd", adviseStorage)
+	}
+	if adviseMemory != "" {
+		b, err := advisor.ParseBytes(adviseMemory)
+		if err != nil {
+			return h, err
+		}
+		h.MemoryBytes = b
+	}
+	h.CPUs = adviseCPUs
+	if h.MemoryBytes > 0 && h.CPUs > 0 {
+		return h, nil
+	}
+
+	local, err := advisor.IsLocal(s.db)
+	if err != nil {
+		return h, err
+	}
+	if !local {
+		return h, fmt.Errorf("target %s does not run on this host, give its memory and processors with --memory and --cpus", s.target.Name)
+	}
+	lh, err := advisor.LocalHost()
+	if err != nil {
+		return h, fmt.Errorf("could not read the host: %v", err)
+	}
+	if h.MemoryBytes <= 0 {
+		h.MemoryBytes = lh.MemoryBytes
+	}
+	if h.CPUs <= 0 {
+		h.CPUs = lh.CPUs
+	}
+	return h, nil
+}
+
+func init() {
+	for _, a := range advisers {
+		adviseCmd.AddCommand(a.command())
+	}
+	rootCmd.AddCommand(adviseCmd)
+}
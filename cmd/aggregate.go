@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkbhowmick/pg-monitoring/pkg/aggregator"
+	"github.com/pkbhowmick/pg-monitoring/pkg/database"
+	"github.com/pkbhowmick/pg-monitoring/pkg/producer"
+	"github.com/spf13/cobra"
+)
+
+var aggregateMigrateOnly bool
+
+func init() {
+	aggregateCmd.Flags().BoolVar(&aggregateMigrateOnly, "migrate-only", false, "bring the history schema up to date and exit")
+	rootCmd.AddCommand(aggregateCmd)
+}
+
+var aggregateCmd = &cobra.Command{
+	Use:   "aggregate",
+	Short: "Store the snapshots of the agents' stream in a history database",
+	Run: func(cmd *cobra.Command, args []string) {
+		producer.LoadConfig()
+		c := producer.AggregatorConfig()
+		if c.DBURL == "" {
+			log.Fatalln("[AGGREGATOR] DB_URL is not set")
+		}
+
+		db, err := database.GetDBConnection(c.DBURL, database.GetDefaultCollectConfig())
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer db.Close()
+
+		if aggregateMigrateOnly {
+			if err := aggregator.Migrate(db); err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
+
+		nc, err := producer.NewConnection()
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		go func() {
+			stop := make(chan os.Signal, 1)
+			signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+			<-stop
+			nc.Close()
+		}()
+
+		if err := aggregator.Run(nc, db, c); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/history"
+	"github.com/pkbhowmick/pg-monitoring/pkg/producer"
+	"github.com/pkbhowmick/pg-monitoring/pkg/report"
+	"github.com/pkbhowmick/pg-monitoring/pkg/snapshot"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffOutput  string
+	diffHistory bool
+	diffTarget  string
+	diffStream  string
+	diffTop     int
+)
+
+func init() {
+	diffCmd.Flags().StringVarP(&diffOutput, "output", "o", "table", "output format: table, json or markdown")
+	diffCmd.Flags().StringVar(&diffStream, "stream", "", "JetStream stream holding the snapshots; STREAM of [NATS] if empty")
+	diffCmd.Flags().BoolVar(&diffHistory, "history", false, "take the snapshots from the history store, the arguments being times")
+	diffCmd.Flags().StringVar(&diffTarget, "target", snapshot.DefaultTarget, "target whose snapshots are read with --history")
+	diffCmd.Flags().IntVar(&diffTop, "top", 5, "biggest movers listed for each metric")
+	rootCmd.AddCommand(diffCmd)
+}
+
+// loadSnapshotArg loads a snapshot named by a file path, or by a sequence
+// number of the JetStream stream.
+func loadSnapshotArg(arg string, nc **nats.Conn) model.Model {
+	if _, err := os.Stat(arg); err == nil {
+		m, err := snapshot.Load(arg)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return m
+	}
+
+	seq, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		log.Fatalf("%s is neither a snapshot file nor a stream sequence number\n", arg)
+	}
+	if *nc == nil {
+		producer.LoadConfig()
+		if *nc, err = producer.NewConnection(); err != nil {
+			log.Fatalln(err)
+		}
+		if diffStream == "" {
+			diffStream = producer.StreamName()
+		}
+	}
+
+	m, err := producer.GetStreamSnapshot(*nc, diffStream, seq)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return m
+}
+
+// historySnapshotArg loads the last snapshot of the history store taken at or
+// before the time arg names.
+func historySnapshotArg(arg string, store **history.Store) model.Model {
+	t, err := parseTime(arg)
+	if err != nil {
+		log.Fatalf("%s is not a time: %s\n", arg, err)
+	}
+	if *store == nil {
+		*store = openHistory()
+	}
+
+	m, err := (*store).At(diffTarget, t)
+	if err != nil {
+		log.Fatalf("snapshot of %s at %s: %s\n", diffTarget, arg, err)
+	}
+	return m
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <a> <b>",
+	Short: "Show what changed between two snapshots",
+	Long: "Show the statements, tables and databases that appeared or disappeared, the biggest\n" +
+		"movers and the changed settings between two snapshots, each given as a snapshot file\n" +
+		"or as the sequence number of a message of the JetStream stream. With --history they are\n" +
+		"times, RFC 3339 or a duration ago, each naming the last snapshot of the history store taken by then.",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var a, b model.Model
+		if diffHistory {
+			var store *history.Store
+			a = historySnapshotArg(args[0], &store)
+			b = historySnapshotArg(args[1], &store)
+		} else {
+			var nc *nats.Conn
+			a = loadSnapshotArg(args[0], &nc)
+			b = loadSnapshotArg(args[1], &nc)
+			if nc != nil {
+				nc.Close()
+			}
+		}
+
+		if b.UpdatedAt.Before(a.UpdatedAt) {
+			a, b = b, a
+		}
+		if a.Target != b.Target {
+			log.Printf("comparing snapshots of different targets: %q and %q\n", a.Target, b.Target)
+		}
+		d := report.NewDiff(a, b, diffTop)
+
+		var err error
+		switch diffOutput {
+		case "table":
+			err = d.WriteTable(os.Stdout)
+		case "json":
+			err = d.WriteJSON(os.Stdout)
+		case "markdown", "md":
+			err = d.WriteMarkdown(os.Stdout)
+		default:
+			log.Fatalf("unknown output format %q\n", diffOutput)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
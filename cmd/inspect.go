@@ -0,0 +1,532 @@
+package cmd
+
+import (
+	"database/sql"
+	"log"
+	"os"
+
+	"github.com/pkbhowmick/pg-monitoring/pkg/output"
+	"github.com/pkbhowmick/pg-monitoring/pkg/producer"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// The inspection commands print what a collector returns for a target, read
+// directly from the database without going through NATS.
+var (
+	inspectTarget  string
+	inspectDB      string
+	inspectOutput  string
+	inspectColumns []string
+	inspectSort    string
+	inspectReverse bool
+	inspectTop     int
+)
+
+// inspectSource is what the collectors of an inspection read from.
+type inspectSource struct {
+	target    producer.Target
+	db        *sql.DB
+	collector *producer.Collector
+}
+
+// inspection is a read-only command listing what a collector returns.
+type inspection struct {
+	use   string
+	short string
+	// sortBy is the column sorted by without --sort, the order of the
+	// collector if empty.
+	sortBy string
+	list   func(s inspectSource) (*output.List, error)
+	// flags adds the flags of the command, if any, to the shared ones.
+	flags func(f *pflag.FlagSet)
+	// statements is set for the lists of advice, which -o sql prints as the
+	// statements applying it.
+	statements bool
+}
+
+func init() {
+	for _, i := range inspections {
+		rootCmd.AddCommand(i.command())
+	}
+}
+
+func (i inspection) command() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   i.use,
+		Short: i.short,
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			format, err := output.ParseFormat(inspectOutput)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			producer.LoadConfig()
+			t, ok := findTarget(inspectTarget)
+			if !ok {
+				log.Fatalf("no target %q is configured\n", inspectTarget)
+			}
+			if inspectDB != "" {
+				t = t.WithDatabase(inspectDB)
+			}
+			db, err := producer.ConnectTarget(t)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer db.Close()
+
+			l, err := i.list(inspectSource{target: t, db: db, collector: producer.NewCollector(db)})
+			if err != nil {
+				log.Fatalln(err)
+			}
+			// the views of the whole server are narrowed down to the database
+			if inspectDB != "" {
+				l.Filter("db", inspectDB)
+			}
+
+			o := output.Options{
+				Format:  format,
+				Columns: inspectColumns,
+				Sort:    inspectSort,
+				Reverse: inspectReverse,
+				Top:     inspectTop,
+			}
+			if o.Sort == "" {
+				o.Sort = i.sortBy
+			}
+			if err := output.Write(os.Stdout, l, o); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&inspectTarget, "target", "", "target to inspect, the first configured one if empty")
+	f.StringVar(&inspectDB, "db", "", "database to connect to; views of the whole server only show its rows")
+	formats := "output format: table, wide, json, yaml or csv"
+	if i.statements {
+		formats = "output format: table, wide, json, yaml, csv or sql, the statements applying the advice"
+	}
+	f.StringVarP(&inspectOutput, "output", "o", string(output.Table), formats)
+	f.StringSliceVar(&inspectColumns, "columns", nil, "comma separated columns to show, in order; see the header of -o wide for the names")
+	sortHelp := "column to sort by, numbers biggest first and text alphabetically"
+	if i.sortBy != "" {
+		sortHelp += " (default " + i.sortBy + ")"
+	}
+	f.StringVar(&inspectSort, "sort", "", sortHelp)
+	f.BoolVar(&inspectReverse, "reverse", false, "reverse the order")
+	f.IntVar(&inspectTop, "top", 0, "show only the first rows, all if 0")
+	if i.flags != nil {
+		i.flags(f)
+	}
+	return cmd
+}
+
+func percent(part, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
+
+var inspections = []inspection{
+	{
+		use:    "statements",
+		short:  "List the statements of pg_stat_statements, times in milliseconds",
+		sortBy: "total",
+		list: func(s inspectSource) (*output.List, error) {
+			// only the statements shown are needed in the default order
+			limit := 0
+			if (inspectSort == "" || inspectSort == "total") && !inspectReverse && inspectDB == "" {
+				limit = inspectTop
+			}
+			statements, err := s.collector.Statements(limit)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "queryid", Wide: true},
+				output.Column{Name: "db"},
+				output.Column{Name: "user"},
+				output.Column{Name: "calls"},
+				output.Column{Name: "total"},
+				output.Column{Name: "mean"},
+				output.Column{Name: "min", Wide: true},
+				output.Column{Name: "max"},
+				output.Column{Name: "rows"},
+				output.Column{Name: "hit_pct"},
+				output.Column{Name: "shared_hit", Wide: true},
+				output.Column{Name: "shared_read", Wide: true},
+				output.Column{Name: "temp_written", Wide: true},
+				output.Column{Name: "fingerprint", Wide: true},
+				output.Column{Name: "query"},
+			)
+			for _, st := range statements {
+				mean := 0.0
+				if st.Calls > 0 {
+					mean = st.TotalTime / float64(st.Calls)
+				}
+				l.Add(st.QueryID, st.DBName, st.UserName, st.Calls, st.TotalTime, mean, st.MinTime, st.MaxTime,
+					st.Rows, percent(st.SharedBlksHit, st.SharedBlksHit+st.SharedBlksRead),
+					st.SharedBlksHit, st.SharedBlksRead, st.TempBlksWritten, st.Fingerprint, st.Query)
+			}
+			return l, nil
+		},
+	},
+	{
+		use:   "databases",
+		short: "List the databases with their activity since the statistics were reset",
+		list: func(s inspectSource) (*output.List, error) {
+			databases, err := s.collector.Databases()
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "oid", Wide: true},
+				output.Column{Name: "db"},
+				output.Column{Name: "owner"},
+				output.Column{Name: "tablespace", Wide: true},
+				output.Column{Name: "backends"},
+				output.Column{Name: "commits"},
+				output.Column{Name: "rollbacks"},
+				output.Column{Name: "hit_pct"},
+				output.Column{Name: "blks_read", Wide: true},
+				output.Column{Name: "blks_hit", Wide: true},
+				output.Column{Name: "tup_returned", Wide: true},
+				output.Column{Name: "tup_fetched", Wide: true},
+				output.Column{Name: "tup_inserted"},
+				output.Column{Name: "tup_updated"},
+				output.Column{Name: "tup_deleted"},
+				output.Column{Name: "temp_files"},
+				output.Column{Name: "temp_bytes"},
+				output.Column{Name: "deadlocks"},
+			)
+			for _, d := range databases {
+				l.Add(d.OID, d.Name, d.OwnerName, d.TableSpace, d.NumBackends, d.XactCommit, d.XactRollback,
+					percent(d.BlksHit, d.BlksHit+d.BlksRead), d.BlksRead, d.BlksHit, d.TupReturned, d.TupFetched,
+					d.TupInserted, d.TupUpdated, d.TupDeleted, d.TempFiles, d.TempBytes, d.Deadlocks)
+			}
+			return l, nil
+		},
+	},
+	{
+		use:   "tables",
+		short: "List the user tables of the database connected to",
+		list: func(s inspectSource) (*output.List, error) {
+			tables, err := producer.GetTablesInfo(s.db)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "oid", Wide: true},
+				output.Column{Name: "db", Wide: true},
+				output.Column{Name: "schema"},
+				output.Column{Name: "table"},
+				output.Column{Name: "seq_scan"},
+				output.Column{Name: "idx_scan"},
+				output.Column{Name: "inserted"},
+				output.Column{Name: "updated"},
+				output.Column{Name: "deleted"},
+				output.Column{Name: "live"},
+				output.Column{Name: "dead"},
+				output.Column{Name: "dead_pct"},
+				output.Column{Name: "hit_pct"},
+				output.Column{Name: "heap_read", Wide: true},
+				output.Column{Name: "heap_hit", Wide: true},
+			)
+			for _, t := range tables {
+				l.Add(t.OID, t.DBName, t.SchemaName, t.Name, t.SeqScan, t.IdxScan, t.RowsInserted, t.RowsUpdated,
+					t.RowsDeleted, t.RowsLive, t.RowsDead, percent(int64(t.RowsDead), int64(t.RowsLive+t.RowsDead)),
+					percent(t.HeapBlksHit, t.HeapBlksHit+t.HeapBlksRead), t.HeapBlksRead, t.HeapBlksHit)
+			}
+			return l, nil
+		},
+	},
+	{
+		use:   "indexes",
+		short: "List the user indexes of the database connected to with their usage",
+		list: func(s inspectSource) (*output.List, error) {
+			indexes, err := producer.GetIndexes(s.db)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "db", Wide: true},
+				output.Column{Name: "schema"},
+				output.Column{Name: "table"},
+				output.Column{Name: "index"},
+				output.Column{Name: "scans"},
+				output.Column{Name: "tup_read", Wide: true},
+				output.Column{Name: "tup_fetch", Wide: true},
+				output.Column{Name: "size_bytes"},
+				output.Column{Name: "unique"},
+				output.Column{Name: "primary"},
+				output.Column{Name: "valid"},
+				output.Column{Name: "definition", Wide: true},
+			)
+			for _, i := range indexes {
+				l.Add(i.DBName, i.SchemaName, i.TableName, i.Name, i.IdxScan, i.IdxTupRead, i.IdxTupFetch,
+					i.SizeBytes, i.Unique, i.Primary, i.Valid, i.Definition)
+			}
+			return l, nil
+		},
+	},
+	{
+		use:    "sequences",
+		short:  "List the sequences of the database connected to and how much of their range is used",
+		sortBy: "used_pct",
+		list: func(s inspectSource) (*output.List, error) {
+			sequences, err := producer.GetSequences(s.db)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "schema"},
+				output.Column{Name: "sequence"},
+				output.Column{Name: "type"},
+				output.Column{Name: "last_value"},
+				output.Column{Name: "max_value"},
+				output.Column{Name: "increment", Wide: true},
+				output.Column{Name: "cycle", Wide: true},
+				output.Column{Name: "used_pct"},
+				output.Column{Name: "table"},
+				output.Column{Name: "column"},
+				output.Column{Name: "column_type", Wide: true},
+				output.Column{Name: "column_max", Wide: true},
+				output.Column{Name: "narrower", Wide: true},
+			)
+			for _, sq := range sequences {
+				var last interface{}
+				if sq.LastValue != nil {
+					last = *sq.LastValue
+				}
+				l.Add(sq.SchemaName, sq.Name, sq.DataType, last, sq.MaxValue, sq.Increment, sq.Cycle, sq.PercentUsed,
+					sq.TableName, sq.ColumnName, sq.ColumnType, sq.ColumnMaxValue, sq.ColumnNarrower)
+			}
+			return l, nil
+		},
+	},
+	{
+		use:    "wraparound",
+		short:  "List the transaction ID ages of the databases and of the oldest tables",
+		sortBy: "xid_age",
+		list: func(s inspectSource) (*output.List, error) {
+			w, err := producer.GetWraparound(s.db)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "kind"},
+				output.Column{Name: "db"},
+				output.Column{Name: "schema"},
+				output.Column{Name: "name"},
+				output.Column{Name: "xid_age"},
+				output.Column{Name: "mxid_age"},
+				output.Column{Name: "freeze_pct"},
+				output.Column{Name: "wraparound_pct"},
+				output.Column{Name: "mxid_freeze_pct", Wide: true},
+				output.Column{Name: "mxid_wraparound_pct", Wide: true},
+			)
+			for _, a := range w.Databases {
+				l.Add("database", a.DBName, a.SchemaName, a.Name, a.XIDAge, a.MXIDAge, a.FreezeMaxAgePercent,
+					a.WraparoundPercent, a.MultixactFreezeMaxAgePercent, a.MultixactWraparoundPercent)
+			}
+			for _, a := range w.Tables {
+				l.Add("table", a.DBName, a.SchemaName, a.Name, a.XIDAge, a.MXIDAge, a.FreezeMaxAgePercent,
+					a.WraparoundPercent, a.MultixactFreezeMaxAgePercent, a.MultixactWraparoundPercent)
+			}
+			return l, nil
+		},
+	},
+	{
+		use:    "xmin-holders",
+		short:  "List what holds the xmin horizon back",
+		sortBy: "xmin_age",
+		list: func(s inspectSource) (*output.List, error) {
+			w, err := producer.GetWraparound(s.db)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "kind"},
+				output.Column{Name: "name"},
+				output.Column{Name: "db"},
+				output.Column{Name: "xmin_age"},
+				output.Column{Name: "catalog_xmin_age"},
+				output.Column{Name: "since"},
+			)
+			for _, h := range w.XminHolders {
+				l.Add(h.Kind, h.Name, h.DBName, h.XminAge, h.CatalogXminAge, h.Since)
+			}
+			return l, nil
+		},
+	},
+	{
+		use:    "settings",
+		short:  "List the settings of pg_settings",
+		sortBy: "name",
+		list: func(s inspectSource) (*output.List, error) {
+			settings, err := producer.GetSettings(s.db)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "name"},
+				output.Column{Name: "setting"},
+				output.Column{Name: "unit"},
+				output.Column{Name: "source"},
+				output.Column{Name: "pending_restart"},
+			)
+			for _, p := range settings.Parameters {
+				l.Add(p.Name, p.Setting, p.Unit, p.Source, p.PendingRestart)
+			}
+			return l, nil
+		},
+	},
+	{
+		use:   "replication",
+		short: "List the standbys streaming from the server, lags in seconds",
+		list: func(s inspectSource) (*output.List, error) {
+			r, err := producer.GetReplication(s.db)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "application"},
+				output.Column{Name: "client"},
+				output.Column{Name: "state"},
+				output.Column{Name: "sync"},
+				output.Column{Name: "write_lag"},
+				output.Column{Name: "flush_lag"},
+				output.Column{Name: "replay_lag"},
+				output.Column{Name: "lag_bytes"},
+			)
+			for _, sb := range r.Standbys {
+				l.Add(sb.ApplicationName, sb.ClientAddr, sb.State, sb.SyncState, sb.WriteLagSeconds,
+					sb.FlushLagSeconds, sb.ReplayLagSeconds, sb.ReplayLagBytes)
+			}
+			return l, nil
+		},
+	},
+	{
+		use:   "checkpointer",
+		short: "Show the checkpointer and WAL counters, times in milliseconds",
+		list: func(s inspectSource) (*output.List, error) {
+			c, wal, err := producer.GetCheckpointer(s.db)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "timed"},
+				output.Column{Name: "requested"},
+				output.Column{Name: "write_time"},
+				output.Column{Name: "sync_time"},
+				output.Column{Name: "buffers_checkpoint"},
+				output.Column{Name: "buffers_clean"},
+				output.Column{Name: "maxwritten_clean", Wide: true},
+				output.Column{Name: "buffers_backend", Wide: true},
+				output.Column{Name: "buffers_alloc", Wide: true},
+				output.Column{Name: "stats_reset", Wide: true},
+				output.Column{Name: "wal_records", Wide: true},
+				output.Column{Name: "wal_fpi", Wide: true},
+				output.Column{Name: "wal_bytes"},
+				output.Column{Name: "wal_buffers_full", Wide: true},
+			)
+			// pg_stat_wal is missing before Postgres 14
+			var records, fpi, bytes, buffersFull interface{}
+			if wal != nil {
+				records, fpi, bytes, buffersFull = wal.Records, wal.FPI, wal.Bytes, wal.BuffersFull
+			}
+			l.Add(c.CheckpointsTimed, c.CheckpointsRequested, c.WriteTime, c.SyncTime, c.BuffersCheckpoint,
+				c.BuffersClean, c.MaxWrittenClean, c.BuffersBackend, c.BuffersAlloc, c.StatsReset,
+				records, fpi, bytes, buffersFull)
+			return l, nil
+		},
+	},
+	{
+		use:   "progress",
+		short: "List the running vacuums, analyzes, index builds, clusters, base backups and copies",
+		list: func(s inspectSource) (*output.List, error) {
+			progress, err := producer.GetProgress(s.db)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "pid"},
+				output.Column{Name: "command"},
+				output.Column{Name: "db"},
+				output.Column{Name: "relation"},
+				output.Column{Name: "phase"},
+				output.Column{Name: "done"},
+				output.Column{Name: "total"},
+				output.Column{Name: "percent"},
+				output.Column{Name: "eta_seconds"},
+			)
+			for _, p := range progress {
+				l.Add(p.PID, p.Command, p.DBName, p.RelName, p.Phase, p.Done, p.Total, p.Percent, p.ETASeconds)
+			}
+			return l, nil
+		},
+	},
+	{
+		use:    "sessions",
+		short:  "List the backends of pg_stat_activity, times in seconds",
+		sortBy: "seconds",
+		list: func(s inspectSource) (*output.List, error) {
+			sessions, err := s.collector.Sessions(s.target.CollectConfig().SQLLength)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "pid"},
+				output.Column{Name: "db"},
+				output.Column{Name: "user"},
+				output.Column{Name: "application"},
+				output.Column{Name: "client", Wide: true},
+				output.Column{Name: "backend_type", Wide: true},
+				output.Column{Name: "state"},
+				output.Column{Name: "wait_type"},
+				output.Column{Name: "wait_event"},
+				output.Column{Name: "xact_start", Wide: true},
+				output.Column{Name: "query_start", Wide: true},
+				output.Column{Name: "seconds"},
+				output.Column{Name: "blocked_by"},
+				output.Column{Name: "queryid", Wide: true},
+				output.Column{Name: "query"},
+			)
+			for _, se := range sessions {
+				l.Add(se.PID, se.DBName, se.UserName, se.Application, se.ClientAddr, se.BackendType, se.State,
+					se.WaitEventType, se.WaitEvent, se.XactStart, se.QueryStart, se.QuerySeconds, se.BlockedBy,
+					se.QueryID, se.Query)
+			}
+			return l, nil
+		},
+	},
+	{
+		use:    "locks",
+		short:  "List the locks waited for and the ones blocking them, times in seconds",
+		sortBy: "wait_seconds",
+		list: func(s inspectSource) (*output.List, error) {
+			locks, err := s.collector.Locks(s.target.CollectConfig().SQLLength)
+			if err != nil {
+				return nil, err
+			}
+			l := output.NewList(
+				output.Column{Name: "pid"},
+				output.Column{Name: "db", Wide: true},
+				output.Column{Name: "lock_type"},
+				output.Column{Name: "mode"},
+				output.Column{Name: "granted"},
+				output.Column{Name: "relation"},
+				output.Column{Name: "wait_seconds"},
+				output.Column{Name: "blocked_by"},
+				output.Column{Name: "query"},
+			)
+			for _, lk := range locks {
+				l.Add(lk.PID, lk.DBName, lk.LockType, lk.Mode, lk.Granted, lk.Relation, lk.WaitSeconds,
+					lk.BlockedBy, lk.Query)
+			}
+			return l, nil
+		},
+	},
+}
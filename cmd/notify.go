@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pkbhowmick/pg-monitoring/pkg/notify"
+	"github.com/pkbhowmick/pg-monitoring/pkg/producer"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	notifyCmd.AddCommand(notifyTestCmd)
+	rootCmd.AddCommand(notifyCmd)
+}
+
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Work with the alert notification sinks",
+}
+
+var notifyTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Send a test notification to every configured sink",
+	Run: func(cmd *cobra.Command, args []string) {
+		producer.LoadConfig()
+		c, err := producer.NotifyConfig()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if len(c.Sinks) == 0 {
+			log.Fatalln("no notification sink is configured")
+		}
+
+		failed := false
+		for name, err := range notify.Test(c) {
+			if err != nil {
+				fmt.Printf("%s: %s\n", name, err)
+				failed = true
+			} else {
+				fmt.Printf("%s: ok\n", name)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
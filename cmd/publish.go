@@ -1,11 +1,16 @@
 package cmd
 
 import (
+	"time"
+
 	"github.com/pkbhowmick/pg-monitoring/pkg/producer"
 	"github.com/spf13/cobra"
 )
 
+var publishInterval time.Duration
+
 func init() {
+	publishCmd.Flags().DurationVar(&publishInterval, "interval", 0, "keep publishing at this interval instead of publishing once")
 	rootCmd.AddCommand(publishCmd)
 }
 
@@ -14,6 +19,6 @@ var publishCmd = &cobra.Command{
 	Short: "It will publish the database info to producer",
 	Long:  "",
 	Run: func(cmd *cobra.Command, args []string) {
-		producer.Publish()
+		producer.Publish(publishInterval)
 	},
 }
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/history"
+	"github.com/pkbhowmick/pg-monitoring/pkg/producer"
+	"github.com/pkbhowmick/pg-monitoring/pkg/report"
+	"github.com/pkbhowmick/pg-monitoring/pkg/snapshot"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportDir      string
+	reportHistory  bool
+	reportTarget   string
+	reportFrom     string
+	reportTo       string
+	reportHTML     string
+	reportMarkdown string
+	reportTop      int
+)
+
+func init() {
+	reportCmd.Flags().StringVar(&reportDir, "dir", "", "snapshot directory (SNAPSHOT_DIR) to pick the snapshots from")
+	reportCmd.Flags().BoolVar(&reportHistory, "history", false, "pick the snapshots from the history store ([HISTORY] DIR)")
+	reportCmd.Flags().StringVar(&reportTarget, "target", snapshot.DefaultTarget, "target whose snapshots are read from --dir or --history")
+	reportCmd.Flags().StringVar(&reportFrom, "from", "", "start of the time range, RFC 3339 or a duration ago such as 24h")
+	reportCmd.Flags().StringVar(&reportTo, "to", "", "end of the time range, RFC 3339 or a duration ago; now if empty")
+	reportCmd.Flags().StringVar(&reportHTML, "html", "report.html", "HTML file to write, none if empty")
+	reportCmd.Flags().StringVar(&reportMarkdown, "markdown", "report.md", "Markdown file to write, - for stdout, none if empty")
+	reportCmd.Flags().IntVar(&reportTop, "top", report.DefaultTop, "rows of the top SQL lists")
+	rootCmd.AddCommand(reportCmd)
+}
+
+// parseTime parses an RFC 3339 time or a duration before now.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// rangeSnapshots loads the snapshots of target stored in dir between from and
+// to.
+func rangeSnapshots(dir, target, from, to string) ([]model.Model, error) {
+	start, err := parseTime(from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTime(to)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := snapshot.List(dir, target, start, end)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []model.Model
+	for _, f := range files {
+		m, err := snapshot.Load(f.Path)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, m)
+	}
+	return snapshots, nil
+}
+
+// openHistory opens the history store of the configuration.
+func openHistory() *history.Store {
+	producer.LoadConfig()
+	store, err := producer.OpenHistory()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if store == nil {
+		log.Fatalln("the history store is not enabled, set [HISTORY] DIR")
+	}
+	return store
+}
+
+// historySnapshots loads the snapshots of target kept in the history store
+// between from and to, at the finest resolution still available.
+func historySnapshots(target, from, to string) ([]model.Model, error) {
+	start, err := parseTime(from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTime(to)
+	if err != nil {
+		return nil, err
+	}
+	return openHistory().Query(target, start, end, history.Auto)
+}
+
+func writeFile(path string, write func(f *os.File) error) {
+	if path == "-" {
+		if err := write(os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := write(f); err != nil {
+		log.Fatalln(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report [<first.json> <last.json>]",
+	Short: "Write a workload report of the activity between two snapshots",
+	Long: "Write an HTML and a Markdown report of the activity between two snapshot files,\n" +
+		"or between the first and the last snapshot of a time range of --dir or of the history store.\n" +
+		"Snapshot files are the JSON published on metrics.postgres.",
+	Args: cobra.RangeArgs(0, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var snapshots []model.Model
+		switch {
+		case len(args) == 2:
+			for _, path := range args {
+				m, err := snapshot.Load(path)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				snapshots = append(snapshots, m)
+			}
+		case len(args) == 0 && reportDir != "":
+			var err error
+			snapshots, err = rangeSnapshots(reportDir, reportTarget, reportFrom, reportTo)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		case len(args) == 0 && reportHistory:
+			var err error
+			snapshots, err = historySnapshots(reportTarget, reportFrom, reportTo)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		default:
+			log.Fatalln("give either two snapshot files, --dir or --history")
+		}
+
+		r, err := report.Build(snapshots, reportTop)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		if reportHTML != "" {
+			writeFile(reportHTML, func(f *os.File) error { return r.WriteHTML(f) })
+		}
+		if reportMarkdown != "" {
+			writeFile(reportMarkdown, func(f *os.File) error { return r.WriteMarkdown(f) })
+		}
+		if reportMarkdown != "-" {
+			fmt.Printf("report of %s from %s to %s written\n", r.Target,
+				r.Begin.Format(time.RFC3339), r.End.Format(time.RFC3339))
+		}
+	},
+}
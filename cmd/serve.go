@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/pkg/api"
+	"github.com/pkbhowmick/pg-monitoring/pkg/consumer"
+	"github.com/pkbhowmick/pg-monitoring/pkg/dashboard"
+	"github.com/pkbhowmick/pg-monitoring/pkg/database"
+	"github.com/pkbhowmick/pg-monitoring/pkg/producer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveListen    string
+	serveLive      bool
+	serveSubscribe bool
+)
+
+func init() {
+	serveCmd.Flags().StringVar(&serveListen, "listen", "", "address to listen on; LISTEN of [HTTP] if empty")
+	serveCmd.Flags().BoolVar(&serveLive, "live", false, "connect to the targets for live activity, locks and indexes")
+	serveCmd.Flags().BoolVar(&serveSubscribe, "subscribe", false, "follow the snapshots and alerts published on NATS")
+	rootCmd.AddCommand(serveCmd)
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the dashboard and the HTTP JSON API of the history store",
+	Run: func(cmd *cobra.Command, args []string) {
+		store := openHistory()
+		if serveListen == "" {
+			serveListen = producer.HTTPListenAddr()
+		}
+		apiServer := api.NewServer(store)
+
+		if serveLive {
+			apiServer.Live = api.NewLive(database.GetDefaultCollectConfig().SQLLength, producer.QueryTextMode())
+			for _, t := range producer.Targets() {
+				db, err := producer.ConnectTarget(t)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				defer db.Close()
+				apiServer.Live.Add(t.Name, db)
+			}
+		}
+
+		if serveSubscribe {
+			nc, err := producer.NewConnection()
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer nc.Close()
+
+			apiServer.Cache = api.NewCache()
+			opts := consumer.Options{
+				Subjects: []string{producer.MetricsSubject, producer.AlertFiringSubject, producer.AlertResolvedSubject},
+				Errors: func(subject string, err error) {
+					log.Println(err)
+				},
+			}
+			c, err := consumer.Subscribe(nc, opts, apiServer.Cache.Update)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer c.Close()
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle(api.Prefix, apiServer)
+		mux.Handle("/", dashboard.Handler())
+		srv := &http.Server{Addr: serveListen, Handler: mux}
+
+		go func() {
+			stop := make(chan os.Signal, 1)
+			signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+			<-stop
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Println(err)
+			}
+		}()
+
+		log.Printf("serving the dashboard on %s\n", serveListen)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalln(err)
+		}
+	},
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/pkg/notify"
+	"github.com/pkbhowmick/pg-monitoring/pkg/producer"
+	"github.com/spf13/cobra"
+)
+
+var (
+	silenceMatchers []string
+	silenceDuration time.Duration
+	silenceComment  string
+	silenceAll      bool
+
+	maintenanceDays     string
+	maintenanceStart    string
+	maintenanceDuration time.Duration
+)
+
+func init() {
+	silenceAddCmd.Flags().StringSliceVar(&silenceMatchers, "match", nil, "label=value the alerts must have, repeatable")
+	silenceAddCmd.Flags().DurationVar(&silenceDuration, "duration", 2*time.Hour, "how long the silence lasts")
+	silenceAddCmd.Flags().StringVar(&silenceComment, "comment", "", "why the alerts are silenced")
+	silenceListCmd.Flags().BoolVar(&silenceAll, "all", false, "also list the silences that ended")
+	silenceCmd.AddCommand(silenceAddCmd, silenceListCmd, silenceExpireCmd)
+
+	maintenanceAddCmd.Flags().StringSliceVar(&silenceMatchers, "match", nil, "label=value the alerts must have, repeatable")
+	maintenanceAddCmd.Flags().StringVar(&maintenanceDays, "days", "", "days of the week, e.g. sat,sun; every day if empty")
+	maintenanceAddCmd.Flags().StringVar(&maintenanceStart, "start", "", "start of the window, HH:MM local time")
+	maintenanceAddCmd.Flags().DurationVar(&maintenanceDuration, "duration", time.Hour, "length of the window")
+	maintenanceAddCmd.Flags().StringVar(&silenceComment, "comment", "", "what the window is for")
+	maintenanceAddCmd.MarkFlagRequired("start")
+	maintenanceCmd.AddCommand(maintenanceAddCmd, maintenanceListCmd, maintenanceRemoveCmd)
+
+	rootCmd.AddCommand(silenceCmd, maintenanceCmd)
+}
+
+// loadNotifyState loads the state file named in the configuration.
+func loadNotifyState() (string, *notify.State) {
+	producer.LoadConfig()
+	c, err := producer.NotifyConfig()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	state, err := notify.LoadState(c.StateFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return c.StateFile, state
+}
+
+func saveNotifyState(path string, state *notify.State) {
+	if err := state.Save(path); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func parseMatchers(list []string) map[string]string {
+	matchers := map[string]string{}
+	for _, m := range list {
+		kv := strings.SplitN(m, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			log.Fatalf("invalid matcher %q, expected label=value\n", m)
+		}
+		matchers[kv[0]] = kv[1]
+	}
+	if len(matchers) == 0 {
+		log.Fatalln("at least one --match is required")
+	}
+	return matchers
+}
+
+func formatMatchers(matchers map[string]string) string {
+	var pairs []string
+	for k, v := range matchers {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+var silenceCmd = &cobra.Command{
+	Use:   "silence",
+	Short: "Manage the silences of alert notifications",
+}
+
+var silenceAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Silence the alerts matching labels for a while",
+	Run: func(cmd *cobra.Command, args []string) {
+		path, state := loadNotifyState()
+
+		now := time.Now()
+		s := notify.Silence{
+			ID:       notify.NewID(),
+			Matchers: parseMatchers(silenceMatchers),
+			StartsAt: now,
+			EndsAt:   now.Add(silenceDuration),
+			Comment:  silenceComment,
+		}
+		if u, err := user.Current(); err == nil {
+			s.CreatedBy = u.Username
+		}
+
+		state.Prune(now.Add(-24 * time.Hour))
+		state.Silences = append(state.Silences, s)
+		saveNotifyState(path, state)
+		fmt.Println(s.ID)
+	},
+}
+
+var silenceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the silences",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, state := loadNotifyState()
+
+		now := time.Now()
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tMATCHERS\tENDS\tCREATED BY\tCOMMENT")
+		for _, s := range state.Silences {
+			if !silenceAll && !s.Active(now) {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.ID, formatMatchers(s.Matchers),
+				s.EndsAt.Format(time.RFC3339), s.CreatedBy, s.Comment)
+		}
+		w.Flush()
+	},
+}
+
+var silenceExpireCmd = &cobra.Command{
+	Use:   "expire <id>",
+	Short: "End a silence now",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path, state := loadNotifyState()
+		if err := state.Expire(args[0], time.Now()); err != nil {
+			log.Fatalln(err)
+		}
+		saveNotifyState(path, state)
+	},
+}
+
+var maintenanceCmd = &cobra.Command{
+	Use:   "maintenance",
+	Short: "Manage the weekly maintenance windows muting alert notifications",
+}
+
+var maintenanceAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a maintenance window",
+	Run: func(cmd *cobra.Command, args []string) {
+		path, state := loadNotifyState()
+
+		days, err := notify.ParseWeekdays(maintenanceDays)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if _, err := time.Parse("15:04", maintenanceStart); err != nil {
+			log.Fatalf("invalid start %q, expected HH:MM\n", maintenanceStart)
+		}
+
+		mw := notify.MaintenanceWindow{
+			ID:       notify.NewID(),
+			Matchers: parseMatchers(silenceMatchers),
+			Days:     days,
+			Start:    maintenanceStart,
+			Duration: notify.Duration(maintenanceDuration),
+			Comment:  silenceComment,
+		}
+		state.Maintenance = append(state.Maintenance, mw)
+		saveNotifyState(path, state)
+		fmt.Println(mw.ID)
+	},
+}
+
+var maintenanceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the maintenance windows",
+	Run: func(cmd *cobra.Command, args []string) {
+		_, state := loadNotifyState()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tMATCHERS\tDAYS\tSTART\tDURATION\tCOMMENT")
+		for _, mw := range state.Maintenance {
+			var days []string
+			for _, d := range mw.Days {
+				days = append(days, d.String()[:3])
+			}
+			if len(days) == 0 {
+				days = []string{"every day"}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", mw.ID, formatMatchers(mw.Matchers),
+				strings.Join(days, ","), mw.Start, time.Duration(mw.Duration), mw.Comment)
+		}
+		w.Flush()
+	},
+}
+
+var maintenanceRemoveCmd = &cobra.Command{
+	Use:   "remove <id>",
+	Short: "Remove a maintenance window",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path, state := loadNotifyState()
+		if err := state.Expire(args[0], time.Now()); err != nil {
+			log.Fatalln(err)
+		}
+		saveNotifyState(path, state)
+	},
+}
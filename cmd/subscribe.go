@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/pkg/consumer"
+	"github.com/pkbhowmick/pg-monitoring/pkg/producer"
+	"github.com/spf13/cobra"
+	"github.com/tidwall/pretty"
+)
+
+var (
+	subscribeSubjects []string
+	subscribeTargets  []string
+	subscribeSections []string
+	subscribeStream   string
+	subscribeDurable  string
+	subscribeAll      bool
+	subscribeOutput   string
+)
+
+func init() {
+	subscribeCmd.Flags().StringSliceVar(&subscribeSubjects, "subject", nil, "subjects to receive, everything the agent publishes if empty")
+	subscribeCmd.Flags().StringSliceVar(&subscribeTargets, "target", nil, "only show messages about these targets")
+	subscribeCmd.Flags().StringSliceVar(&subscribeSections, "section", nil, "only show these sections of the snapshots, e.g. statements,tables")
+	subscribeCmd.Flags().StringVar(&subscribeStream, "stream", "", "read from this JetStream stream instead of core NATS")
+	subscribeCmd.Flags().StringVar(&subscribeDurable, "durable", "", "name of the durable JetStream consumer, to resume where it stopped")
+	subscribeCmd.Flags().BoolVar(&subscribeAll, "all", false, "replay the whole stream instead of new messages only")
+	subscribeCmd.Flags().StringVarP(&subscribeOutput, "output", "o", "pretty", "output format: pretty or jsonl")
+	rootCmd.AddCommand(subscribeCmd)
+}
+
+// subscribeLine is a message as written in JSONL output.
+type subscribeLine struct {
+	Subject  string      `json:"subject"`
+	Sequence uint64      `json:"seq,omitempty"`
+	Time     time.Time   `json:"time"`
+	Data     interface{} `json:"data"`
+}
+
+var subscribeCmd = &cobra.Command{
+	Use:   "subscribe",
+	Short: "Show what the agents publish on NATS",
+	Run: func(cmd *cobra.Command, args []string) {
+		if subscribeOutput != "pretty" && subscribeOutput != "jsonl" {
+			log.Fatalf("unknown output format %q\n", subscribeOutput)
+		}
+		if subscribeDurable != "" && subscribeStream == "" {
+			log.Fatalln("--durable needs --stream")
+		}
+
+		producer.LoadConfig()
+		nc, err := producer.NewConnection()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer nc.Close()
+
+		var mu sync.Mutex
+		enc := json.NewEncoder(os.Stdout)
+		opts := consumer.Options{
+			Subjects:   subscribeSubjects,
+			Targets:    subscribeTargets,
+			Stream:     subscribeStream,
+			Durable:    subscribeDurable,
+			DeliverAll: subscribeAll,
+			Errors: func(subject string, err error) {
+				log.Println(err)
+			},
+		}
+
+		c, err := consumer.Subscribe(nc, opts, func(msg consumer.Message) {
+			v := msg.Value()
+			if msg.Metrics != nil && len(subscribeSections) > 0 {
+				sections, err := consumer.Sections(msg.Metrics, subscribeSections)
+				if err != nil {
+					log.Println(err)
+					return
+				}
+				v = sections
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if subscribeOutput == "jsonl" {
+				line := subscribeLine{Subject: msg.Subject, Sequence: msg.Sequence, Time: msg.Time, Data: v}
+				if err := enc.Encode(line); err != nil {
+					log.Println(err)
+				}
+				return
+			}
+
+			data, err := json.Marshal(v)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			header := []string{msg.Time.Format(time.RFC3339), msg.Subject}
+			if msg.Sequence > 0 {
+				header = append(header, fmt.Sprintf("seq %d", msg.Sequence))
+			}
+			if targets := msg.Targets(); len(targets) == 1 && targets[0] != "" {
+				header = append(header, "target "+targets[0])
+			}
+			fmt.Printf("--- %s\n%s", strings.Join(header, " "), pretty.Pretty(data))
+		})
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer c.Close()
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+	},
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/pkbhowmick/pg-monitoring/pkg/producer"
+	"github.com/pkbhowmick/pg-monitoring/pkg/top"
+	"github.com/spf13/cobra"
+)
+
+var (
+	topSource   string
+	topTarget   string
+	topStream   string
+	topInterval = top.DefaultInterval
+)
+
+func init() {
+	topCmd.Flags().StringVar(&topSource, "source", "db", "where to read from: db, the target's database, or nats, what the agent publishes")
+	topCmd.Flags().StringVar(&topTarget, "target", "", "target to show; the first configured one for db, the first published for nats if empty")
+	topCmd.Flags().StringVar(&topStream, "stream", "", "with --source nats, read from this JetStream stream instead of core NATS")
+	topCmd.Flags().DurationVar(&topInterval, "interval", topInterval, "refresh interval")
+	rootCmd.AddCommand(topCmd)
+}
+
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Show the activity of a server in a full-screen terminal view",
+	Long: "Show the sessions, statements, tables, locks and replication of a server, refreshed\n" +
+		"every --interval. Read from the database, sessions and locks are live; from NATS the\n" +
+		"view follows the snapshots the agent publishes.",
+	Run: func(cmd *cobra.Command, args []string) {
+		producer.LoadConfig()
+
+		var src top.Source
+		switch topSource {
+		case "db":
+			t, ok := findTarget(topTarget)
+			if !ok {
+				log.Fatalf("no target %q is configured\n", topTarget)
+			}
+			db, err := producer.ConnectTarget(t)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer db.Close()
+			src = top.NewDBSource(t.Name, producer.NewCollector(db), t.CollectConfig().SQLLength)
+		case "nats":
+			nc, err := producer.NewConnection()
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer nc.Close()
+			s, err := top.NewNATSSource(nc, topTarget, topStream)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer s.Close()
+			src = s
+		default:
+			log.Fatalf("unknown source %q\n", topSource)
+		}
+
+		if err := top.Run(src, topInterval); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+// findTarget returns the configured target named name, the first one if name
+// is empty.
+func findTarget(name string) (producer.Target, bool) {
+	for _, t := range producer.Targets() {
+		if name == "" || t.Name == name {
+			return t, true
+		}
+	}
+	return producer.Target{}, false
+}
@@ -8,6 +8,7 @@ require (
 	github.com/nats-io/nats-server/v2 v2.2.2 // indirect
 	github.com/nats-io/nats.go v1.11.0
 	github.com/spf13/cobra v1.1.3
+	github.com/spf13/pflag v1.0.5
 	github.com/tidwall/pretty v1.1.0
 	gopkg.in/ini.v1 v1.62.0
 )
@@ -3,29 +3,84 @@ package model
 import "time"
 
 type Model struct {
-	Statements []Statement `json:"statements"`
-	Databases  []Database  `json:"databases"`
-	Tables     []Table     `json:"tables"`
-	UpdatedAt  time.Time   `json:"updated_at"`
+	Target       string                `json:"target,omitempty"`
+	Labels       map[string]string     `json:"labels,omitempty"`
+	Statements   []Statement           `json:"statements"`
+	Databases    []Database            `json:"databases"`
+	Tables       []Table               `json:"tables"`
+	Sequences    []Sequence            `json:"sequences"`
+	TableSpaces  []TableSpace          `json:"table_spaces"`
+	Wraparound   Wraparound            `json:"wraparound"`
+	Settings     Settings              `json:"settings"`
+	Replication  Replication           `json:"replication"`
+	Checkpointer Checkpointer          `json:"checkpointer"`
+	WAL          *WAL                  `json:"wal,omitempty"`
+	Logs         *LogSummary           `json:"logs,omitempty"`
+	ASH          *ActiveSessionHistory `json:"ash,omitempty"`
+	UpdatedAt    time.Time             `json:"updated_at"`
+
+	// StatementsComplete is set when Statements holds every statement of
+	// pg_stat_statements, not only the ones with the largest total time.
+	StatementsComplete bool `json:"statements_complete,omitempty"`
 }
 
 type Statement struct {
 	UserOID   int     `json:"user_oid"`
+	UserName  string  `json:"user_name,omitempty"`
 	DBOID     int     `json:"db_oid"`
+	DBName    string  `json:"db_name,omitempty"`
 	QueryID   int64   `json:"query_id"`
 	Query     string  `json:"query"`
 	Calls     int64   `json:"calls"`
 	TotalTime float64 `json:"total_time"`
 	MinTime   float64 `json:"min_time"`
 	MaxTime   float64 `json:"max_time"`
+
+	Rows            int64 `json:"rows"`
+	SharedBlksHit   int64 `json:"shared_blks_hit"`
+	SharedBlksRead  int64 `json:"shared_blks_read"`
+	TempBlksWritten int64 `json:"temp_blks_written"`
+
+	// Fingerprint identifies the normalized query text.
+	Fingerprint string `json:"fingerprint"`
+	// TextHash identifies Query. When the query text dictionary is enabled,
+	// Query is left empty and the text is published separately under its
+	// QueryID and TextHash.
+	TextHash string `json:"text_hash,omitempty"`
 }
 
 type Database struct {
-	OID           int    `json:"oid"`
-	Name          string `json:"name"`
-	DatDBA        int    `json:"dat_dba"`
-	DatTableSpace int    `json:"dat_table_space"`
-	NumBackends   int    `json:"num_backends"`
+	OID                int    `json:"oid"`
+	Name               string `json:"name"`
+	DatDBA             int    `json:"dat_dba"`
+	OwnerName          string `json:"owner_name,omitempty"`
+	DatTableSpace      int    `json:"dat_table_space"`
+	TableSpace         string `json:"table_space,omitempty"`
+	TableSpaceLocation string `json:"table_space_location,omitempty"`
+	TableSpaceSize     int64  `json:"table_space_size,omitempty"`
+	NumBackends        int    `json:"num_backends"`
+
+	XactCommit   int64 `json:"xact_commit"`
+	XactRollback int64 `json:"xact_rollback"`
+	BlksRead     int64 `json:"blks_read"`
+	BlksHit      int64 `json:"blks_hit"`
+	TupReturned  int64 `json:"tup_returned"`
+	TupFetched   int64 `json:"tup_fetched"`
+	TupInserted  int64 `json:"tup_inserted"`
+	TupUpdated   int64 `json:"tup_updated"`
+	TupDeleted   int64 `json:"tup_deleted"`
+	TempFiles    int64 `json:"temp_files"`
+	TempBytes    int64 `json:"temp_bytes"`
+	Deadlocks    int64 `json:"deadlocks"`
+}
+
+type TableSpace struct {
+	OID       int    `json:"oid"`
+	Name      string `json:"name"`
+	OwnerName string `json:"owner_name"`
+	// Location is empty for the built-in pg_default and pg_global.
+	Location string `json:"location"`
+	Size     int64  `json:"size"`
 }
 
 type Table struct {
@@ -35,4 +90,456 @@ type Table struct {
 	Name         string `json:"name"`
 	RowsInserted int    `json:"rows_inserted"`
 	RowsLive     int    `json:"rows_live"`
+	RowsDead     int    `json:"rows_dead"`
+
+	RowsUpdated  int64 `json:"rows_updated"`
+	RowsDeleted  int64 `json:"rows_deleted"`
+	SeqScan      int64 `json:"seq_scan"`
+	IdxScan      int64 `json:"idx_scan"`
+	HeapBlksRead int64 `json:"heap_blks_read"`
+	HeapBlksHit  int64 `json:"heap_blks_hit"`
+}
+
+type Progress struct {
+	Target     string    `json:"target,omitempty"`
+	Command    string    `json:"command"`
+	PID        int       `json:"pid"`
+	DBName     string    `json:"db_name"`
+	RelName    string    `json:"rel_name"`
+	Phase      string    `json:"phase"`
+	Done       int64     `json:"done"`
+	Total      int64     `json:"total"`
+	Percent    float64   `json:"percent"`
+	ETASeconds float64   `json:"eta_seconds,omitempty"`
+	SampledAt  time.Time `json:"sampled_at"`
+}
+
+type Wraparound struct {
+	FreezeMaxAge          int64        `json:"freeze_max_age"`
+	MultixactFreezeMaxAge int64        `json:"multixact_freeze_max_age"`
+	Databases             []XIDAge     `json:"databases"`
+	Tables                []XIDAge     `json:"tables"`
+	XminHolders           []XminHolder `json:"xmin_holders"`
+}
+
+// XIDAge is the transaction ID and multixact age of a database or a table,
+// along with how far it has progressed towards autovacuum_freeze_max_age
+// (and its multixact counterpart) and towards the wraparound hard limit.
+type XIDAge struct {
+	DBName                       string  `json:"db_name"`
+	SchemaName                   string  `json:"schema_name,omitempty"`
+	Name                         string  `json:"name,omitempty"`
+	XIDAge                       int64   `json:"xid_age"`
+	MXIDAge                      int64   `json:"mxid_age"`
+	FreezeMaxAgePercent          float64 `json:"freeze_max_age_percent"`
+	WraparoundPercent            float64 `json:"wraparound_percent"`
+	MultixactFreezeMaxAgePercent float64 `json:"multixact_freeze_max_age_percent"`
+	MultixactWraparoundPercent   float64 `json:"multixact_wraparound_percent"`
+}
+
+// XminHolder is something that holds the xmin horizon back: a running
+// transaction, a prepared transaction, a replication slot or the feedback of
+// a hot standby.
+type XminHolder struct {
+	Kind           string     `json:"kind"`
+	Name           string     `json:"name"`
+	DBName         string     `json:"db_name,omitempty"`
+	XminAge        int64      `json:"xmin_age"`
+	CatalogXminAge int64      `json:"catalog_xmin_age,omitempty"`
+	Since          *time.Time `json:"since,omitempty"`
+}
+
+// Sequence describes how much of its value range a sequence has used up. When
+// the sequence backs a serial or identity column, the column is included and
+// the percentage is computed against the smaller of the two ranges, since an
+// int4 column overflows long before the int8 sequence behind it does.
+type Sequence struct {
+	SchemaName     string  `json:"schema_name"`
+	Name           string  `json:"name"`
+	DataType       string  `json:"data_type"`
+	LastValue      *int64  `json:"last_value"`
+	StartValue     int64   `json:"start_value"`
+	MinValue       int64   `json:"min_value"`
+	MaxValue       int64   `json:"max_value"`
+	Increment      int64   `json:"increment"`
+	Cycle          bool    `json:"cycle"`
+	PercentUsed    float64 `json:"percent_used"`
+	TableName      string  `json:"table_name,omitempty"`
+	ColumnName     string  `json:"column_name,omitempty"`
+	ColumnType     string  `json:"column_type,omitempty"`
+	ColumnMaxValue int64   `json:"column_max_value,omitempty"`
+	ColumnNarrower bool    `json:"column_narrower,omitempty"`
+}
+
+type Settings struct {
+	// Hash identifies the effective configuration. It changes whenever any
+	// server-wide setting does.
+	Hash       string             `json:"hash"`
+	Parameters []Setting          `json:"parameters"`
+	FileErrors []FileSettingError `json:"file_errors,omitempty"`
+}
+
+type Setting struct {
+	Name           string `json:"name"`
+	Setting        string `json:"setting"`
+	Unit           string `json:"unit,omitempty"`
+	Source         string `json:"source"`
+	PendingRestart bool   `json:"pending_restart"`
+}
+
+// FileSettingError is an entry of pg_file_settings that could not be applied.
+type FileSettingError struct {
+	SourceFile string `json:"source_file"`
+	SourceLine int    `json:"source_line"`
+	Name       string `json:"name"`
+	Setting    string `json:"setting"`
+	Error      string `json:"error"`
+}
+
+type SettingsChange struct {
+	Target    string          `json:"target,omitempty"`
+	OldHash   string          `json:"old_hash"`
+	NewHash   string          `json:"new_hash"`
+	Changes   []SettingChange `json:"changes"`
+	ChangedAt time.Time       `json:"changed_at"`
+}
+
+// SettingChange is a setting whose value differs between two cycles. OldValue
+// is empty for settings that appeared, NewValue for settings that went away.
+type SettingChange struct {
+	Name     string `json:"name"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+	Unit     string `json:"unit,omitempty"`
+}
+
+// DriftReport lists the settings that differ between servers that are
+// expected to be configured alike: a primary and its replicas, or servers
+// sharing a label.
+type DriftReport struct {
+	Groups    []DriftGroup `json:"groups"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+type DriftGroup struct {
+	// Kind is "replication" for a primary and its replicas, or "label".
+	Kind        string         `json:"kind"`
+	Name        string         `json:"name"`
+	Targets     []string       `json:"targets"`
+	Differences []SettingDrift `json:"differences"`
+}
+
+type SettingDrift struct {
+	Name string `json:"name"`
+	// Values maps each target to its value of the setting. Targets lacking
+	// the setting are mapped to an empty string.
+	Values map[string]string `json:"values"`
+}
+
+// LogSummary aggregates the server log over the last LogSpan minutes.
+type LogSummary struct {
+	WindowStart        time.Time          `json:"window_start"`
+	WindowEnd          time.Time          `json:"window_end"`
+	Errors             []LogErrorCount    `json:"errors"`
+	SlowStatementCount int                `json:"slow_statement_count"`
+	SlowStatements     []SlowStatement    `json:"slow_statements"`
+	Maintenance        []MaintenanceRuns  `json:"maintenance"`
+	Checkpoints        CheckpointLogStats `json:"checkpoints"`
+	LockWaits          int                `json:"lock_waits"`
+	Deadlocks          int                `json:"deadlocks"`
+	TempFiles          int                `json:"temp_files"`
+	TempFileBytes      int64              `json:"temp_file_bytes"`
+}
+
+type LogErrorCount struct {
+	Severity string `json:"severity"`
+	SQLState string `json:"sql_state,omitempty"`
+	Count    int    `json:"count"`
+	// Example is the message of the last error counted.
+	Example string `json:"example"`
+}
+
+// SlowStatement is a statement logged because it ran longer than
+// log_min_duration_statement.
+type SlowStatement struct {
+	Time        time.Time `json:"time"`
+	User        string    `json:"user,omitempty"`
+	DBName      string    `json:"db_name,omitempty"`
+	DurationMs  float64   `json:"duration_ms"`
+	Query       string    `json:"query"`
+	Fingerprint string    `json:"fingerprint"`
+}
+
+// MaintenanceRuns summarizes the autovacuum or autoanalyze runs on a table.
+type MaintenanceRuns struct {
+	// Kind is either "vacuum" or "analyze".
+	Kind         string  `json:"kind"`
+	Table        string  `json:"table"`
+	Count        int     `json:"count"`
+	TotalSeconds float64 `json:"total_seconds"`
+	MaxSeconds   float64 `json:"max_seconds"`
+}
+
+type CheckpointLogStats struct {
+	Timed          int     `json:"timed"`
+	Requested      int     `json:"requested"`
+	Completed      int     `json:"completed"`
+	BuffersWritten int64   `json:"buffers_written"`
+	TotalSeconds   float64 `json:"total_seconds"`
+	MaxSeconds     float64 `json:"max_seconds"`
+}
+
+// PlanChange is published when a query is executed with a plan of a
+// different shape than the one it used before, as captured by auto_explain.
+type PlanChange struct {
+	Target      string    `json:"target,omitempty"`
+	QueryID     int64     `json:"query_id,omitempty"`
+	Query       string    `json:"query"`
+	Fingerprint string    `json:"fingerprint"`
+	OldPlan     PlanStats `json:"old_plan"`
+	NewPlan     PlanStats `json:"new_plan"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
+
+// PlanStats is the latency of the executions of a query that used a plan of
+// the given shape.
+type PlanStats struct {
+	Fingerprint string    `json:"fingerprint"`
+	Shape       string    `json:"shape"`
+	Executions  int       `json:"executions"`
+	MeanMs      float64   `json:"mean_ms"`
+	MaxMs       float64   `json:"max_ms"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// ActivitySample is an active session seen by one poll of pg_stat_activity.
+type ActivitySample struct {
+	SampledAt     time.Time `json:"sampled_at"`
+	PID           int       `json:"pid"`
+	DBName        string    `json:"db_name"`
+	UserName      string    `json:"user_name"`
+	Application   string    `json:"application"`
+	BackendType   string    `json:"backend_type"`
+	WaitEventType string    `json:"wait_event_type,omitempty"`
+	WaitEvent     string    `json:"wait_event,omitempty"`
+	QueryID       int64     `json:"query_id,omitempty"`
+	Query         string    `json:"query"`
+	Fingerprint   string    `json:"fingerprint"`
+}
+
+// ASHDumpRequest asks an agent for a page of its raw active session history:
+// at most Limit samples taken since Since, skipping the first Offset of them.
+type ASHDumpRequest struct {
+	Since  time.Time `json:"since,omitempty"`
+	Offset int       `json:"offset,omitempty"`
+	Limit  int       `json:"limit,omitempty"`
+}
+
+// ASHDump is a page of the raw active session history, oldest first. Total is
+// the number of samples the request matched, and NextOffset the offset of the
+// next page if there is one.
+type ASHDump struct {
+	Samples    []ActivitySample `json:"samples"`
+	Total      int              `json:"total"`
+	NextOffset *int             `json:"next_offset,omitempty"`
+}
+
+// ActiveSessionHistory breaks down the time spent by active sessions ("DB
+// time") since the previous snapshot. Each sample of a session counts for one
+// sample interval.
+type ActiveSessionHistory struct {
+	WindowStart           time.Time `json:"window_start"`
+	WindowEnd             time.Time `json:"window_end"`
+	SampleIntervalSeconds float64   `json:"sample_interval_seconds"`
+	Samples               int       `json:"samples"`
+	DBTimeSeconds         float64   `json:"db_time_seconds"`
+	// AverageActiveSessions is DB time divided by the length of the window.
+	AverageActiveSessions float64  `json:"average_active_sessions"`
+	ByWaitEvent           []DBTime `json:"by_wait_event"`
+	ByQuery               []DBTime `json:"by_query"`
+	ByUser                []DBTime `json:"by_user"`
+	ByApplication         []DBTime `json:"by_application"`
+	ByDatabase            []DBTime `json:"by_database"`
+}
+
+type DBTime struct {
+	Key     string  `json:"key"`
+	Seconds float64 `json:"seconds"`
+	Percent float64 `json:"percent"`
+	// Query is an example text of the query, for the breakdown by query.
+	Query string `json:"query,omitempty"`
+}
+
+// QueryText is an entry of the query text dictionary.
+type QueryText struct {
+	Target   string `json:"target,omitempty"`
+	QueryID  int64  `json:"query_id"`
+	TextHash string `json:"text_hash"`
+	Query    string `json:"query"`
+}
+
+// QueryTextKey identifies an entry of the query text dictionary. The same
+// text may run under several query ids, as they also depend on the objects
+// the names resolve to, so a text is looked up by both.
+type QueryTextKey struct {
+	QueryID  int64  `json:"query_id"`
+	TextHash string `json:"text_hash"`
+}
+
+// QueryTextRequest asks an agent for the texts of the given keys, or for all
+// the texts it knows if there are none, at most Limit of them skipping the
+// first Offset.
+type QueryTextRequest struct {
+	Keys   []QueryTextKey `json:"keys,omitempty"`
+	Offset int            `json:"offset,omitempty"`
+	Limit  int            `json:"limit,omitempty"`
+}
+
+// QueryTexts is a page of the texts answering a QueryTextRequest. Total is
+// the number of texts the request matched, and NextOffset the offset of the
+// next page if there is one.
+type QueryTexts struct {
+	Texts      []QueryText `json:"texts"`
+	Total      int         `json:"total"`
+	NextOffset *int        `json:"next_offset,omitempty"`
+}
+
+type Replication struct {
+	InRecovery bool `json:"in_recovery"`
+	// ReplayLagSeconds is the time since the last transaction replayed by a
+	// standby. It is zero on a primary.
+	ReplayLagSeconds float64   `json:"replay_lag_seconds"`
+	Standbys         []Standby `json:"standbys"`
+}
+
+// Standby is a server streaming WAL from the monitored one.
+type Standby struct {
+	ApplicationName  string  `json:"application_name"`
+	ClientAddr       string  `json:"client_addr"`
+	State            string  `json:"state"`
+	SyncState        string  `json:"sync_state"`
+	WriteLagSeconds  float64 `json:"write_lag_seconds"`
+	FlushLagSeconds  float64 `json:"flush_lag_seconds"`
+	ReplayLagSeconds float64 `json:"replay_lag_seconds"`
+	ReplayLagBytes   int64   `json:"replay_lag_bytes"`
+}
+
+// Alert is an instance of an alerting rule, for the snapshot or the element
+// of the snapshot (a table, a statement...) identified by its labels.
+type Alert struct {
+	ID string `json:"id"`
+	// Name is the name of the rule.
+	Name string `json:"name"`
+	// Status is "pending", "firing" or "resolved".
+	Status   string            `json:"status"`
+	Severity string            `json:"severity"`
+	Target   string            `json:"target,omitempty"`
+	Labels   map[string]string `json:"labels"`
+	Summary  string            `json:"summary,omitempty"`
+	Expr     string            `json:"expr"`
+	Value    float64           `json:"value"`
+	StartsAt time.Time         `json:"starts_at"`
+	EndsAt   *time.Time        `json:"ends_at,omitempty"`
+}
+
+// Anomaly is a statement whose latency or frequency deviates from its
+// baseline for the same hour of the week.
+type Anomaly struct {
+	Target      string `json:"target,omitempty"`
+	QueryID     int64  `json:"query_id"`
+	DBName      string `json:"db_name,omitempty"`
+	UserName    string `json:"user_name,omitempty"`
+	Fingerprint string `json:"fingerprint"`
+	Query       string `json:"query,omitempty"`
+	TextHash    string `json:"text_hash,omitempty"`
+	// Metric is "mean_time" (milliseconds per call) or "calls_per_second".
+	Metric string `json:"metric"`
+	// HourOfWeek counts the hours since Sunday midnight, UTC.
+	HourOfWeek     int       `json:"hour_of_week"`
+	Observed       float64   `json:"observed"`
+	BaselineMean   float64   `json:"baseline_mean"`
+	BaselineStdDev float64   `json:"baseline_stddev"`
+	BaselineCount  int64     `json:"baseline_count"`
+	ZScore         float64   `json:"z_score"`
+	DetectedAt     time.Time `json:"detected_at"`
+}
+
+// Checkpointer holds the cumulative counters of the checkpointer and the
+// background writer.
+type Checkpointer struct {
+	CheckpointsTimed     int64   `json:"checkpoints_timed"`
+	CheckpointsRequested int64   `json:"checkpoints_requested"`
+	WriteTime            float64 `json:"write_time"`
+	SyncTime             float64 `json:"sync_time"`
+	BuffersCheckpoint    int64   `json:"buffers_checkpoint"`
+	BuffersClean         int64   `json:"buffers_clean"`
+	MaxWrittenClean      int64   `json:"maxwritten_clean"`
+	// BuffersBackend is missing from Postgres 17 on.
+	BuffersBackend int64     `json:"buffers_backend"`
+	BuffersAlloc   int64     `json:"buffers_alloc"`
+	StatsReset     time.Time `json:"stats_reset"`
+}
+
+// WAL holds the cumulative counters of pg_stat_wal, available from Postgres 14
+// on.
+type WAL struct {
+	Records     int64     `json:"records"`
+	FPI         int64     `json:"fpi"`
+	Bytes       int64     `json:"bytes"`
+	BuffersFull int64     `json:"buffers_full"`
+	StatsReset  time.Time `json:"stats_reset"`
+}
+
+// Session is a backend of pg_stat_activity, as seen live.
+type Session struct {
+	PID           int        `json:"pid"`
+	DBName        string     `json:"db_name"`
+	UserName      string     `json:"user_name"`
+	Application   string     `json:"application"`
+	ClientAddr    string     `json:"client_addr,omitempty"`
+	BackendType   string     `json:"backend_type"`
+	State         string     `json:"state"`
+	WaitEventType string     `json:"wait_event_type,omitempty"`
+	WaitEvent     string     `json:"wait_event,omitempty"`
+	XactStart     *time.Time `json:"xact_start,omitempty"`
+	QueryStart    *time.Time `json:"query_start,omitempty"`
+	// QuerySeconds is how long the current or last query has been running.
+	QuerySeconds float64 `json:"query_seconds"`
+	// BlockedBy lists the sessions holding the locks this one waits for.
+	BlockedBy []int  `json:"blocked_by,omitempty"`
+	QueryID   int64  `json:"query_id,omitempty"`
+	Query     string `json:"query"`
+}
+
+// Lock is a lock of pg_locks that is waited for or blocks another session.
+type Lock struct {
+	PID      int    `json:"pid"`
+	LockType string `json:"lock_type"`
+	Mode     string `json:"mode"`
+	Granted  bool   `json:"granted"`
+	DBName   string `json:"db_name,omitempty"`
+	// Relation is the qualified name of the locked relation, if any.
+	Relation string `json:"relation,omitempty"`
+	// WaitSeconds is how long the lock has been waited for, Postgres 14 on.
+	WaitSeconds float64 `json:"wait_seconds,omitempty"`
+	BlockedBy   []int   `json:"blocked_by,omitempty"`
+	Query       string  `json:"query"`
+}
+
+// Index is a user index of the database connected to, with its usage.
+type Index struct {
+	DBName      string `json:"db_name"`
+	SchemaName  string `json:"schema_name"`
+	TableName   string `json:"table_name"`
+	Name        string `json:"name"`
+	IdxScan     int64  `json:"idx_scan"`
+	IdxTupRead  int64  `json:"idx_tup_read"`
+	IdxTupFetch int64  `json:"idx_tup_fetch"`
+	SizeBytes   int64  `json:"size_bytes"`
+	Unique      bool   `json:"unique"`
+	Primary     bool   `json:"primary"`
+	Valid       bool   `json:"valid"`
+	// Definition is the CREATE INDEX statement.
+	Definition string `json:"definition"`
 }
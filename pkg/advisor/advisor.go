@@ -0,0 +1,33 @@
+// Package advisor recommends changes to a server from what its statistics
+// show of the workload. Recommendations are advice for a person to review:
+// nothing is changed on the server, and the statements that would apply them
+// are only printed.
+package advisor
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// qualified returns the quoted name of a relation in schema.
+func qualified(schema, name string) string {
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(name)
+}
+
+func quoteColumns(columns []string) string {
+	var quoted []string
+	for _, c := range columns {
+		quoted = append(quoted, pq.QuoteIdentifier(c))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// currentDatabase returns the OID of the database connected to.
+func currentDatabase(ctx context.Context, db *sql.DB) (int, error) {
+	var oid int
+	err := db.QueryRowContext(ctx, `SELECT oid FROM pg_database WHERE datname = current_database()`).Scan(&oid)
+	return oid, err
+}
@@ -0,0 +1,363 @@
+package advisor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+const (
+	// maxRequestedCheckpoints is the fraction of checkpoints above which
+	// they should be timed rather than requested by WAL filling up.
+	maxRequestedCheckpoints = 0.1
+	// minTempFiles is the number of temporary files worth sizing work_mem
+	// for.
+	minTempFiles = 100
+)
+
+// ConfigOptions tune the configuration advisor.
+type ConfigOptions struct {
+	Host Host
+	// All lists the settings checked and found right too.
+	All bool
+}
+
+// ConfigAdvice is a server setting and the value recommended for it.
+type ConfigAdvice struct {
+	Name        string
+	Current     string
+	Recommended string
+	// Restart is whether the setting only takes effect after a restart.
+	Restart bool
+	// Change is whether the current value should be changed, SQL the
+	// ALTER SYSTEM statement changing it if so.
+	Change bool
+	Reason string
+	SQL    string
+}
+
+type serverSetting struct {
+	value          string
+	unit           string
+	context        string
+	pendingRestart bool
+}
+
+func (s serverSetting) float() (float64, bool) {
+	f, err := strconv.ParseFloat(s.value, 64)
+	return f, err == nil
+}
+
+// bytes returns the amount of memory of a setting, false for the settings
+// that are not amounts of memory or are -1.
+func (s serverSetting) bytes() (int64, bool) {
+	v, err := strconv.ParseInt(s.value, 10, 64)
+	if err != nil || v < 0 {
+		return 0, false
+	}
+	unit, ok := unitBytes(s.unit)
+	if !ok {
+		return 0, false
+	}
+	return v * unit, true
+}
+
+func (s serverSetting) String() string {
+	if b, ok := s.bytes(); ok {
+		return formatMemory(b)
+	}
+	return s.value + s.unit
+}
+
+// getServerSettings reads pg_settings, which unlike model.Settings tells
+// the settings needing a restart.
+func getServerSettings(ctx context.Context, db *sql.DB) (map[string]serverSetting, error) {
+	q := `SELECT name, setting, COALESCE(unit, ''), context, pending_restart
+			FROM pg_settings`
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := map[string]serverSetting{}
+	for rows.Next() {
+		var name string
+		var s serverSetting
+		if err := rows.Scan(&name, &s.value, &s.unit, &s.context, &s.pendingRestart); err != nil {
+			return nil, err
+		}
+		settings[name] = s
+	}
+	return settings, rows.Err()
+}
+
+// workload sums the statistics of the databases of a server.
+type workload struct {
+	rowsRead, rowsWritten int64
+	blksRead, blksHit     int64
+	tempFiles, tempBytes  int64
+}
+
+func sumWorkload(databases []model.Database) workload {
+	var w workload
+	for _, d := range databases {
+		w.rowsRead += d.TupReturned + d.TupFetched
+		w.rowsWritten += d.TupInserted + d.TupUpdated + d.TupDeleted
+		w.blksRead += d.BlksRead
+		w.blksHit += d.BlksHit
+		w.tempFiles += d.TempFiles
+		w.tempBytes += d.TempBytes
+	}
+	return w
+}
+
+// writeShare is the fraction of the rows processed that were written.
+func (w workload) writeShare() float64 {
+	if w.rowsRead+w.rowsWritten == 0 {
+		return 0
+	}
+	return float64(w.rowsWritten) / float64(w.rowsRead+w.rowsWritten)
+}
+
+func (w workload) describe() string {
+	share := w.writeShare()
+	switch {
+	case share >= 0.2:
+		return fmt.Sprintf("a write-heavy workload, %.0f%% of the rows processed being written", 100*share)
+	case share >= 0.05:
+		return fmt.Sprintf("a mixed workload, %.0f%% of the rows processed being written", 100*share)
+	default:
+		return fmt.Sprintf("a read-mostly workload, %.1f%% of the rows processed being written", 100*share)
+	}
+}
+
+type configAdvisor struct {
+	settings map[string]serverSetting
+	all      bool
+	advice   []ConfigAdvice
+}
+
+// add records the recommended value of a setting, left out when the server
+// does not have the setting.
+func (c *configAdvisor) add(name, recommended string, change bool, reason string) {
+	s, ok := c.settings[name]
+	if !ok {
+		return
+	}
+	a := ConfigAdvice{
+		Name:        name,
+		Current:     s.String(),
+		Recommended: recommended,
+		Restart:     s.context == "postmaster",
+		Change:      change,
+		Reason:      reason,
+	}
+	if change {
+		a.SQL = fmt.Sprintf("ALTER SYSTEM SET %s = %s;", name, pq.QuoteLiteral(recommended))
+		if a.Restart {
+			a.Reason += "; takes effect after a restart"
+		}
+	}
+	if s.pendingRestart {
+		a.Reason += "; a change to it is pending a restart"
+	}
+	if change || c.all {
+		c.advice = append(c.advice, a)
+	}
+}
+
+// memory recommends an amount of memory, changed when the current one is
+// below low or above high times it.
+func (c *configAdvisor) memory(name string, recommended int64, low, high float64, reason string) {
+	current, ok := c.settings[name].bytes()
+	change := !ok || float64(current) < low*float64(recommended) || float64(current) > high*float64(recommended)
+	c.add(name, formatMemory(recommended), change, reason)
+}
+
+func (c *configAdvisor) number(name string, recommended float64, change func(current float64) bool, reason string) {
+	current, ok := c.settings[name].float()
+	c.add(name, formatFloat(recommended), !ok || change(current), reason)
+}
+
+func (c *configAdvisor) integer(name string, def int64) int64 {
+	if f, ok := c.settings[name].float(); ok {
+		return int64(f)
+	}
+	return def
+}
+
+// AdviseConfig recommends values for the memory, checkpoint, planner and
+// parallelism settings of a server from the host it runs on and the
+// statistics of its workload: databases are those of pg_stat_database,
+// checkpointer and wal the counters of GetCheckpointer, wal being nil on
+// servers without pg_stat_wal.
+func AdviseConfig(db *sql.DB, databases []model.Database, checkpointer model.Checkpointer, wal *model.WAL, o ConfigOptions) ([]ConfigAdvice, error) {
+	if o.Host.MemoryBytes <= 0 || o.Host.CPUs <= 0 {
+		return nil, fmt.Errorf("the memory and processors of the host are needed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	settings, err := getServerSettings(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &configAdvisor{settings: settings, all: o.All}
+	w := sumWorkload(databases)
+	adviseMemory(c, w, o.Host)
+	adviseCheckpoints(c, w, checkpointer, wal, time.Now())
+	adviseStorage(c, o.Host)
+	adviseParallelism(c, o.Host)
+	return c.advice, nil
+}
+
+func adviseMemory(c *configAdvisor, w workload, h Host) {
+	ram := h.MemoryBytes
+	memory := formatMemory(roundMemory(ram))
+
+	shared := roundMemory(ram / 4)
+	reason := fmt.Sprintf("25%% of the %s of memory of the host, the operating system caching much of the rest", memory)
+	if ram < 1<<30 {
+		shared = roundMemory(ram * 15 / 100)
+		reason = fmt.Sprintf("15%% of the %s of memory of the host, leaving enough for connections on a small host", memory)
+	}
+	if w.blksHit+w.blksRead > 0 {
+		reason += fmt.Sprintf("; %.2f%% of the blocks read were found in shared buffers", 100*float64(w.blksHit)/float64(w.blksHit+w.blksRead))
+	}
+	c.memory("shared_buffers", shared, 0.75, 1.6, reason)
+
+	c.memory("effective_cache_size", roundMemory(ram*3/4), 0.75, 1.34,
+		"the planner counts on shared buffers and the operating system cache holding about 75% of memory")
+
+	// each connection may run a few sorts or hashes at once
+	connections := c.integer("max_connections", 100)
+	if connections < 1 {
+		connections = 1
+	}
+	pool := ram - shared
+	work := roundMemory(pool / (3 * connections))
+	reason = fmt.Sprintf("the %s of memory beyond shared buffers shared by %d connections running up to three sorts or hashes each",
+		formatMemory(roundMemory(pool)), connections)
+	if w.tempFiles >= minTempFiles {
+		// sorts spilling to disk want more, as long as a sort per
+		// connection still fits
+		average := w.tempBytes / w.tempFiles
+		if spill := roundMemory(int64(math.Min(float64(average), float64(pool/connections)))); spill > work {
+			work = spill
+			reason = fmt.Sprintf("%d temporary files averaging %s were written, sorts and hashes spilling to disk; "+
+				"a sort for each of the %d connections still fits in the %s of memory beyond shared buffers",
+				w.tempFiles, formatMemory(roundMemory(average)), connections, formatMemory(roundMemory(pool)))
+		}
+	}
+	if work < 4<<20 {
+		work = 4 << 20
+	}
+	if work > 1<<30 {
+		work = 1 << 30
+	}
+	c.memory("work_mem", work, 0.5, 2, reason)
+
+	maintenance := ram / 16
+	if maintenance < 64<<20 {
+		maintenance = 64 << 20
+	}
+	if maintenance > 2<<30 {
+		maintenance = 2 << 30
+	}
+	c.memory("maintenance_work_mem", roundMemory(maintenance), 0.75, 2,
+		"speeds up vacuum and index builds: 1/16 of memory up to 2GB, each autovacuum worker using as much")
+
+	// the default of wal_buffers, shared_buffers/32, is capped at 16MB
+	if shared >= 512<<20 {
+		reason := "16MB of WAL buffers suits servers with large shared buffers"
+		change := false
+		if current, ok := c.settings["wal_buffers"].bytes(); ok && current < 16<<20 {
+			change = true
+		}
+		c.add("wal_buffers", "16MB", change, reason)
+	}
+}
+
+func adviseCheckpoints(c *configAdvisor, w workload, checkpointer model.Checkpointer, wal *model.WAL, now time.Time) {
+	c.number("checkpoint_completion_target", 0.9, func(current float64) bool { return current < 0.9 },
+		"spreads the writes of a checkpoint over 90% of the interval between checkpoints, the default from Postgres 14")
+
+	current, ok := c.settings["max_wal_size"].bytes()
+	if !ok {
+		return
+	}
+	timeout := time.Duration(c.integer("checkpoint_timeout", 300)) * time.Second
+
+	total := checkpointer.CheckpointsTimed + checkpointer.CheckpointsRequested
+	requested := 0.0
+	reason := "no checkpoint was recorded yet"
+	if total > 0 {
+		requested = float64(checkpointer.CheckpointsRequested) / float64(total)
+		reason = fmt.Sprintf("%d of %d checkpoints were requested rather than timed", checkpointer.CheckpointsRequested, total)
+	}
+
+	// WAL written between checkpoints, with the checkpoint spread over most
+	// of the interval the server keeps about twice as much, with some margin
+	needed := int64(0)
+	if wal != nil && wal.StatsReset.Unix() > 0 && now.Sub(wal.StatsReset) > timeout {
+		perCheckpoint := float64(wal.Bytes) / now.Sub(wal.StatsReset).Seconds() * timeout.Seconds()
+		needed = roundMemory(int64(3 * perCheckpoint))
+		reason += fmt.Sprintf("; the server writes %s of WAL per checkpoint_timeout of %s on average, with %s",
+			formatMemory(roundMemory(int64(perCheckpoint))), timeout, w.describe())
+	}
+
+	recommended := current
+	change := false
+	if requested > maxRequestedCheckpoints {
+		change = true
+		reason += "; WAL fills max_wal_size before checkpoint_timeout, checkpointing more often than needed"
+		recommended = 2 * current
+		if needed > recommended {
+			recommended = needed
+		}
+	}
+	if recommended < 1<<30 {
+		change = true
+		recommended = 1 << 30
+		reason += "; below the default of 1GB"
+	}
+	c.add("max_wal_size", formatMemory(roundMemory(recommended)), change, reason)
+}
+
+func adviseStorage(c *configAdvisor, h Host) {
+	if h.SSD {
+		c.number("random_page_cost", 1.1, func(current float64) bool { return current > 1.5 },
+			"random reads cost about as much as sequential ones on solid-state storage, making index scans cheaper to the planner")
+		c.number("effective_io_concurrency", 200, func(current float64) bool { return current < 100 },
+			"solid-state storage serves many concurrent reads, which bitmap heap scans prefetch")
+		return
+	}
+	c.number("random_page_cost", 4, func(current float64) bool { return current < 2 },
+		"random reads cost several times sequential ones on spinning disks")
+	c.number("effective_io_concurrency", 2, func(current float64) bool { return current > 8 },
+		"spinning disks serve few concurrent reads")
+}
+
+func adviseParallelism(c *configAdvisor, h Host) {
+	cpus := float64(h.CPUs)
+	workers := math.Max(8, cpus)
+	c.number("max_worker_processes", workers, func(current float64) bool { return current < cpus },
+		fmt.Sprintf("a background worker for each of the %d processors, and at least the default of 8", h.CPUs))
+	c.number("max_parallel_workers", cpus, func(current float64) bool { return current != cpus },
+		fmt.Sprintf("parallel queries may use all %d processors at once, and no more", h.CPUs))
+
+	gather := math.Min(4, math.Floor(cpus/2))
+	c.number("max_parallel_workers_per_gather", gather, func(current float64) bool { return current != gather },
+		"half the processors, up to 4, for a single query, leaving the others to concurrent queries")
+	c.number("max_parallel_maintenance_workers", gather, func(current float64) bool { return current != gather },
+		"half the processors, up to 4, for a single index build or vacuum")
+}
@@ -0,0 +1,147 @@
+package advisor
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Host is the machine a server runs on.
+type Host struct {
+	MemoryBytes int64
+	CPUs        int
+	// SSD is whether the data lives on solid-state storage.
+	SSD bool
+}
+
+// LocalHost reads the memory and processors of the machine the command runs
+// on from /proc.
+func LocalHost() (Host, error) {
+	h := Host{CPUs: runtime.NumCPU(), SSD: true}
+
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return h, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// MemTotal:       16315204 kB
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return h, fmt.Errorf("could not parse /proc/meminfo: %v", err)
+			}
+			h.MemoryBytes = kb * 1024
+			return h, nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return h, err
+	}
+	return h, fmt.Errorf("no MemTotal in /proc/meminfo")
+}
+
+// IsLocal reports whether the server connected to runs on the machine the
+// command runs on, being reached through a Unix socket or a loopback address.
+func IsLocal(db *sql.DB) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var addr sql.NullString
+	if err := db.QueryRowContext(ctx, `SELECT host(inet_server_addr())`).Scan(&addr); err != nil {
+		return false, err
+	}
+	if !addr.Valid {
+		return true, nil
+	}
+	ip := net.ParseIP(addr.String)
+	return ip != nil && ip.IsLoopback(), nil
+}
+
+var byteSize = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([kKmMgGtT]?)(?:i?[bB])?$`)
+
+var byteUnits = map[string]int64{
+	"":   1,
+	"B":  1,
+	"kB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+	"TB": 1 << 40,
+}
+
+// ParseBytes parses an amount of memory such as "16GB", "512M" or "1.5GiB".
+func ParseBytes(s string) (int64, error) {
+	m := byteSize.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid amount of memory %q, expected for instance 16GB", s)
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	unit := strings.ToUpper(m[2])
+	if unit == "K" {
+		unit = "k"
+	}
+	if unit != "" {
+		unit += "B"
+	}
+	return int64(math.Round(v * float64(byteUnits[unit]))), nil
+}
+
+// unitBytes returns the bytes of a unit of pg_settings, such as "8kB".
+func unitBytes(unit string) (int64, bool) {
+	i := strings.IndexFunc(unit, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		return 0, false
+	}
+	n := int64(1)
+	if i > 0 {
+		n, _ = strconv.ParseInt(unit[:i], 10, 64)
+	}
+	b, ok := byteUnits[unit[i:]]
+	if !ok {
+		return 0, false
+	}
+	return n * b, true
+}
+
+// formatMemory formats an amount of memory the way the server accepts it, in
+// the largest unit it is a whole number of.
+func formatMemory(b int64) string {
+	for _, unit := range []string{"TB", "GB", "MB", "kB"} {
+		if u := byteUnits[unit]; b >= u && b%u == 0 {
+			return fmt.Sprintf("%d%s", b/u, unit)
+		}
+	}
+	return fmt.Sprintf("%dkB", b/1024)
+}
+
+// roundMemory rounds an amount of memory to a whole number of gigabytes when
+// large, of 16MB or megabytes otherwise.
+func roundMemory(b int64) int64 {
+	unit := int64(1 << 20)
+	switch {
+	case b >= 2<<30:
+		unit = 1 << 30
+	case b >= 64<<20:
+		unit = 16 << 20
+	}
+	r := int64(math.Round(float64(b)/float64(unit))) * unit
+	if r < unit {
+		return unit
+	}
+	return r
+}
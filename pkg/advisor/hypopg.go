@@ -0,0 +1,149 @@
+package advisor
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func hypopgInstalled(ctx context.Context, db *sql.DB) (bool, error) {
+	var installed bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'hypopg')`).Scan(&installed)
+	return installed, err
+}
+
+// explainedPlan is a node of the plans of EXPLAIN (FORMAT JSON).
+type explainedPlan struct {
+	TotalCost float64         `json:"Total Cost"`
+	IndexName string          `json:"Index Name"`
+	Plans     []explainedPlan `json:"Plans"`
+}
+
+func (p explainedPlan) uses(index string) bool {
+	if p.IndexName == index {
+		return true
+	}
+	for _, child := range p.Plans {
+		if child.uses(index) {
+			return true
+		}
+	}
+	return false
+}
+
+var parameter = regexp.MustCompile(`\$(\d+)`)
+
+// explain returns the generic plan of a statement of pg_stat_statements, its
+// constants being $n parameters. Statements that cannot be prepared, their
+// parameter types not being inferred for instance, return ok false.
+func explain(ctx context.Context, conn *sql.Conn, query string) (plan explainedPlan, ok bool, err error) {
+	params := 0
+	for _, m := range parameter.FindAllStringSubmatch(query, -1) {
+		if n, _ := strconv.Atoi(m[1]); n > params {
+			params = n
+		}
+	}
+
+	if _, err := conn.ExecContext(ctx, "PREPARE pgmon_advise AS "+query); err != nil {
+		return plan, false, nil
+	}
+	defer conn.ExecContext(ctx, "DEALLOCATE pgmon_advise")
+
+	// the generic plan does not depend on the values
+	args := ""
+	if params > 0 {
+		args = "(" + strings.TrimSuffix(strings.Repeat("NULL, ", params), ", ") + ")"
+	}
+	var out string
+	if err := conn.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) EXECUTE pgmon_advise"+args).Scan(&out); err != nil {
+		return plan, false, nil
+	}
+	var plans []struct {
+		Plan explainedPlan `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(out), &plans); err != nil || len(plans) == 0 {
+		return plan, false, err
+	}
+	return plans[0].Plan, true, nil
+}
+
+// testCandidate explains the most expensive statements of c without and with
+// a hypothetical index on its columns.
+func testCandidate(db *sql.DB, c *candidate, a *IndexAdvice) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// hypothetical indexes and prepared statements live in the session
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET plan_cache_mode = force_generic_plan"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "RESET plan_cache_mode")
+
+	statements := append(c.statements[:0:0], c.statements...)
+	sort.SliceStable(statements, func(i, j int) bool { return statements[i].TotalTime > statements[j].TotalTime })
+	if len(statements) > maxTestedStatements {
+		statements = statements[:maxTestedStatements]
+	}
+
+	before := map[int]float64{}
+	for i, s := range statements {
+		plan, ok, err := explain(ctx, conn, s.Query)
+		if err != nil {
+			return err
+		}
+		if ok {
+			before[i] = plan.TotalCost
+		}
+	}
+	if len(before) == 0 {
+		return nil
+	}
+
+	var index string
+	create := fmt.Sprintf("CREATE INDEX ON %s (%s)", qualified(c.table.schema, c.table.name), quoteColumns(c.columns()))
+	if err := conn.QueryRowContext(ctx, "SELECT indexname FROM hypopg_create_index($1)", create).Scan(&index); err != nil {
+		return fmt.Errorf("could not create a hypothetical index: %v", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT hypopg_reset()")
+
+	explained, used := 0, 0
+	for i, s := range statements {
+		cost, ok := before[i]
+		if !ok {
+			continue
+		}
+		plan, ok, err := explain(ctx, conn, s.Query)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		a.Tested = true
+		explained++
+		a.CostBefore += cost
+		a.CostAfter += plan.TotalCost
+		if plan.uses(index) {
+			used++
+		}
+	}
+	if a.Tested {
+		if used == 0 {
+			a.Reason += "; the planner would not use it"
+		} else {
+			a.Reason += fmt.Sprintf("; used in the plans of %d of the %d statements explained", used, explained)
+		}
+	}
+	return nil
+}
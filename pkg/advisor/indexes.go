@@ -0,0 +1,633 @@
+package advisor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+const (
+	DefaultMinRows        = 10000
+	DefaultMinSeqScans    = 100
+	DefaultMaxSelectivity = 0.05
+
+	// the planner's estimates for predicates on columns without statistics
+	defaultEqualitySelectivity = 0.005
+	defaultRangeSelectivity    = 1.0 / 3
+
+	// maxIndexColumns bounds the width of the suggested indexes.
+	maxIndexColumns = 3
+	// maxTestedStatements is how many of the statements behind a suggestion
+	// are explained, the most expensive first.
+	maxTestedStatements = 5
+)
+
+// IndexOptions tune the index advisor.
+type IndexOptions struct {
+	// Indexes are only suggested for tables of at least MinRows live rows
+	// scanned sequentially at least MinSeqScans times.
+	MinRows     int64
+	MinSeqScans int64
+	// MaxSelectivity is the largest estimated fraction of the rows of a
+	// table an index scan may return for the index to be suggested.
+	MaxSelectivity float64
+	// Hypothetical tests the suggestions with EXPLAIN and hypothetical
+	// indexes when the hypopg extension is installed.
+	Hypothetical bool
+}
+
+func DefaultIndexOptions() IndexOptions {
+	return IndexOptions{
+		MinRows:        DefaultMinRows,
+		MinSeqScans:    DefaultMinSeqScans,
+		MaxSelectivity: DefaultMaxSelectivity,
+		Hypothetical:   true,
+	}
+}
+
+const (
+	CreateIndex = "create"
+	DropIndex   = "drop"
+)
+
+// IndexAdvice is an index to create or to drop.
+type IndexAdvice struct {
+	Action string
+	Schema string
+	Table  string
+	// Index is the index to drop, empty for the ones to create.
+	Index   string
+	Columns []string
+	// SizeBytes is the space dropping the index frees.
+	SizeBytes int64
+	// Statements is the number of statements that would use the index to
+	// create, TotalTime their execution time in milliseconds since the
+	// statistics were reset.
+	Statements int
+	TotalTime  float64
+	Reason     string
+	// Tested is set when the index was tried with hypopg. CostBefore and
+	// CostAfter add up the planner's costs of the statements explained,
+	// without and with the index.
+	Tested     bool
+	CostBefore float64
+	CostAfter  float64
+	// SQL applies the advice.
+	SQL string
+}
+
+type tableStats struct {
+	oid        int64
+	schema     string
+	name       string
+	seqScan    int64
+	seqTupRead int64
+	idxScan    int64
+	liveRows   int64
+	sizeBytes  int64
+	// distinct values and fraction of nulls of the columns, from pg_stats
+	distinct map[string]float64
+	nullFrac map[string]float64
+	columns  map[string]bool
+}
+
+type indexInfo struct {
+	oid      int64
+	schema   string
+	table    string
+	tableOID int64
+	name     string
+	// key columns, empty for expressions
+	columns     []string
+	unique      bool
+	primary     bool
+	valid       bool
+	constraint  bool
+	scans       int64
+	sizeBytes   int64
+	method      string
+	opClasses   []string
+	expressions string
+	predicate   string
+}
+
+// candidate is an index that would serve some statements.
+type candidate struct {
+	table      *tableStats
+	equality   []string
+	rangeCol   string
+	statements []model.Statement
+	totalTime  float64
+}
+
+func (c *candidate) columns() []string {
+	columns := append([]string(nil), c.equality...)
+	if c.rangeCol != "" {
+		columns = append(columns, c.rangeCol)
+	}
+	return columns
+}
+
+// covers tells whether an index on columns serves the lookups by the
+// equality columns and the range column, that is when the equality columns
+// lead it in any order, followed by the range column.
+func covers(columns []string, equality []string, rangeCol string) bool {
+	n := len(equality)
+	if rangeCol != "" {
+		n++
+	}
+	if len(columns) < n {
+		return false
+	}
+	leading := map[string]bool{}
+	for _, c := range columns[:len(equality)] {
+		leading[c] = true
+	}
+	for _, c := range equality {
+		if !leading[c] {
+			return false
+		}
+	}
+	return rangeCol == "" || columns[len(equality)] == rangeCol
+}
+
+// AdviseIndexes suggests indexes for the statements of the database
+// connected to that read large tables sequentially, and the indexes that
+// could be dropped for being unused, invalid or duplicated. statements are
+// those of pg_stat_statements, the ones of other databases being ignored.
+func AdviseIndexes(db *sql.DB, statements []model.Statement, o IndexOptions) ([]IndexAdvice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dbOID, err := currentDatabase(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := getTableStats(ctx, db, o)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := getIndexInfo(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := findCandidates(statements, dbOID, tables, indexes, o)
+	var advice []IndexAdvice
+	for _, c := range candidates {
+		advice = append(advice, c.advice())
+	}
+	if o.Hypothetical && len(advice) > 0 {
+		installed, err := hypopgInstalled(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		if installed {
+			for i := range advice {
+				if err := testCandidate(db, candidates[i], &advice[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	var statsReset sql.NullTime
+	q := `SELECT stats_reset FROM pg_stat_database WHERE datname = current_database()`
+	if err := db.QueryRowContext(ctx, q).Scan(&statsReset); err != nil {
+		return nil, err
+	}
+	return append(advice, redundantIndexes(indexes, statsReset)...), nil
+}
+
+// getTableStats returns the tables scanned sequentially often enough to be
+// looked at, with the statistics of their columns.
+func getTableStats(ctx context.Context, db *sql.DB, o IndexOptions) (map[int64]*tableStats, error) {
+	q := `SELECT T.relid, T.schemaname, T.relname, COALESCE(T.seq_scan, 0), COALESCE(T.seq_tup_read, 0),
+				COALESCE(T.idx_scan, 0), T.n_live_tup, pg_relation_size(T.relid)
+			FROM pg_stat_user_tables AS T
+			WHERE T.n_live_tup >= $1 AND T.seq_scan >= $2`
+	rows, err := db.QueryContext(ctx, q, o.MinRows, o.MinSeqScans)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := map[int64]*tableStats{}
+	var oids []int64
+	for rows.Next() {
+		t := &tableStats{distinct: map[string]float64{}, nullFrac: map[string]float64{}, columns: map[string]bool{}}
+		err := rows.Scan(&t.oid, &t.schema, &t.name, &t.seqScan, &t.seqTupRead, &t.idxScan, &t.liveRows, &t.sizeBytes)
+		if err != nil {
+			return nil, err
+		}
+		tables[t.oid] = t
+		oids = append(oids, t.oid)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(oids) == 0 {
+		return tables, nil
+	}
+
+	// negative n_distinct are fractions of the rows
+	q = `SELECT A.attrelid, A.attname, COALESCE(S.null_frac, 0),
+				COALESCE(CASE WHEN S.n_distinct < 0 THEN -S.n_distinct * C.reltuples ELSE S.n_distinct END, 0)
+			FROM pg_attribute AS A
+			JOIN pg_class AS C ON C.oid = A.attrelid
+			JOIN pg_namespace AS N ON N.oid = C.relnamespace
+			LEFT JOIN pg_stats AS S ON S.schemaname = N.nspname AND S.tablename = C.relname AND S.attname = A.attname
+			WHERE A.attrelid = ANY($1::oid[]) AND A.attnum > 0 AND NOT A.attisdropped`
+	rows, err = db.QueryContext(ctx, q, pq.Array(oids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oid int64
+		var column string
+		var nullFrac, distinct float64
+		if err := rows.Scan(&oid, &column, &nullFrac, &distinct); err != nil {
+			return nil, err
+		}
+		t := tables[oid]
+		t.columns[column] = true
+		t.nullFrac[column] = nullFrac
+		if distinct > 0 {
+			t.distinct[column] = distinct
+		}
+	}
+	return tables, rows.Err()
+}
+
+func getIndexInfo(ctx context.Context, db *sql.DB) ([]indexInfo, error) {
+	q := `SELECT I.indexrelid, N.nspname, T.relname, I.indrelid, C.relname,
+				ARRAY(SELECT COALESCE(A.attname, '')
+					FROM unnest(I.indkey) WITH ORDINALITY AS K(attnum, ord)
+					LEFT JOIN pg_attribute AS A ON A.attrelid = I.indrelid AND A.attnum = K.attnum
+					WHERE K.ord <= I.indnkeyatts
+					ORDER BY K.ord),
+				I.indisunique, I.indisprimary, I.indisvalid,
+				EXISTS (SELECT 1 FROM pg_constraint WHERE conindid = I.indexrelid),
+				COALESCE(S.idx_scan, 0), pg_relation_size(I.indexrelid), AM.amname, I.indclass::text,
+				COALESCE(pg_get_expr(I.indexprs, I.indrelid), ''), COALESCE(pg_get_expr(I.indpred, I.indrelid), '')
+			FROM pg_index AS I
+			JOIN pg_class AS C ON C.oid = I.indexrelid
+			JOIN pg_class AS T ON T.oid = I.indrelid
+			JOIN pg_namespace AS N ON N.oid = T.relnamespace
+			JOIN pg_am AS AM ON AM.oid = C.relam
+			LEFT JOIN pg_stat_user_indexes AS S ON S.indexrelid = I.indexrelid
+			WHERE N.nspname NOT IN ('pg_catalog', 'information_schema') AND N.nspname NOT LIKE 'pg_toast%'
+			ORDER BY I.indexrelid`
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []indexInfo
+	for rows.Next() {
+		var i indexInfo
+		var opClasses string
+		err := rows.Scan(&i.oid, &i.schema, &i.table, &i.tableOID, &i.name, pq.Array(&i.columns),
+			&i.unique, &i.primary, &i.valid, &i.constraint, &i.scans, &i.sizeBytes, &i.method, &opClasses,
+			&i.expressions, &i.predicate)
+		if err != nil {
+			return nil, err
+		}
+		i.opClasses = strings.Fields(opClasses)
+		indexes = append(indexes, i)
+	}
+	return indexes, rows.Err()
+}
+
+// resolve returns the table a predicate of q is on, if it is one of tables.
+func resolve(q query, p predicate, tables map[string]*tableStats) *tableStats {
+	lookup := func(r relationRef) *tableStats {
+		if r.schema != "" {
+			return tables[r.schema+"."+r.name]
+		}
+		// the search path is unknown, public is the likeliest
+		if t, ok := tables["public."+r.name]; ok {
+			return t
+		}
+		return tables[r.name]
+	}
+
+	if p.qualifier != "" {
+		r, ok := q.relations[p.qualifier]
+		if !ok {
+			return nil
+		}
+		return lookup(r)
+	}
+	// an unqualified column belongs to the only relation that has it
+	var found *tableStats
+	for alias, r := range q.relations {
+		if strings.Contains(alias, ".") {
+			continue
+		}
+		if t := lookup(r); t != nil && t.columns[p.column] {
+			if found != nil && found != t {
+				return nil
+			}
+			found = t
+		}
+	}
+	return found
+}
+
+// selectivity estimates the fraction of the rows of t an index scan on the
+// columns returns.
+func (t *tableStats) selectivity(equality []string, rangeCol string) float64 {
+	s := 1.0
+	for _, c := range equality {
+		if d := t.distinct[c]; d > 0 {
+			s *= (1 - t.nullFrac[c]) / d
+		} else {
+			s *= defaultEqualitySelectivity
+		}
+	}
+	if rangeCol != "" {
+		s *= defaultRangeSelectivity
+	}
+	return s
+}
+
+func findCandidates(statements []model.Statement, dbOID int, tables map[int64]*tableStats, indexes []indexInfo, o IndexOptions) []*candidate {
+	byName := map[string]*tableStats{}
+	for _, t := range tables {
+		byName[t.schema+"."+t.name] = t
+		// unqualified names are only resolved when unambiguous
+		if _, ok := byName[t.name]; ok {
+			byName[t.name] = nil
+		} else {
+			byName[t.name] = t
+		}
+	}
+	for name, t := range byName {
+		if t == nil {
+			delete(byName, name)
+		}
+	}
+
+	var candidates []*candidate
+	for _, s := range statements {
+		if s.DBOID != dbOID {
+			continue
+		}
+		switch strings.ToLower(firstWord(s.Query)) {
+		case "select", "with", "update", "delete":
+		default:
+			continue
+		}
+
+		// the columns each table is looked up by
+		q := parseQuery(s.Query)
+		type lookup struct {
+			equality []string
+			ranges   []string
+		}
+		lookups := map[*tableStats]*lookup{}
+		for _, p := range q.predicates {
+			t := resolve(q, p, byName)
+			if t == nil || !t.columns[p.column] {
+				continue
+			}
+			l := lookups[t]
+			if l == nil {
+				l = &lookup{}
+				lookups[t] = l
+			}
+			if p.equality {
+				l.equality = appendNew(l.equality, p.column)
+			} else {
+				l.ranges = appendNew(l.ranges, p.column)
+			}
+		}
+
+		for t, l := range lookups {
+			c := &candidate{table: t, statements: []model.Statement{s}, totalTime: s.TotalTime}
+			// the most selective columns first
+			sort.SliceStable(l.equality, func(i, j int) bool {
+				return t.selectivity(l.equality[i:i+1], "") < t.selectivity(l.equality[j:j+1], "")
+			})
+			for _, col := range l.equality {
+				if len(c.equality) < maxIndexColumns {
+					c.equality = append(c.equality, col)
+				}
+			}
+			for _, col := range l.ranges {
+				if len(c.equality) < maxIndexColumns && !contains(c.equality, col) {
+					c.rangeCol = col
+					break
+				}
+			}
+			if t.selectivity(c.equality, c.rangeCol) <= o.MaxSelectivity {
+				candidates = append(candidates, c)
+			}
+		}
+	}
+
+	// the widest candidates first, serving the narrower ones they cover
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return len(candidates[i].columns()) > len(candidates[j].columns())
+	})
+	var merged []*candidate
+	for _, c := range candidates {
+		var into *candidate
+		for _, m := range merged {
+			if m.table == c.table && covers(m.columns(), c.equality, c.rangeCol) {
+				into = m
+				break
+			}
+		}
+		if into == nil {
+			merged = append(merged, c)
+			continue
+		}
+		into.statements = append(into.statements, c.statements...)
+		into.totalTime += c.totalTime
+	}
+
+	var kept []*candidate
+	for _, c := range merged {
+		if !coveredByIndex(c, indexes) {
+			kept = append(kept, c)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].totalTime > kept[j].totalTime })
+	return kept
+}
+
+// coveredByIndex tells whether an existing index already serves c.
+func coveredByIndex(c *candidate, indexes []indexInfo) bool {
+	for _, i := range indexes {
+		if i.tableOID == c.table.oid && i.valid && i.method == "btree" && i.predicate == "" &&
+			covers(i.columns, c.equality, c.rangeCol) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstWord(q string) string {
+	f := strings.Fields(strings.TrimLeft(q, "( \t\n"))
+	if len(f) == 0 {
+		return ""
+	}
+	return f[0]
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func appendNew(list []string, s string) []string {
+	if contains(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+func (c *candidate) advice() IndexAdvice {
+	t := c.table
+	columns := c.columns()
+	perScan := int64(0)
+	if t.seqScan > 0 {
+		perScan = t.seqTupRead / t.seqScan
+	}
+	reason := fmt.Sprintf("%d sequential scans of %d live rows, reading %d rows each on average; "+
+		"about %.0f rows per lookup by %s",
+		t.seqScan, t.liveRows, perScan,
+		t.selectivity(c.equality, c.rangeCol)*float64(t.liveRows), strings.Join(columns, ", "))
+	return IndexAdvice{
+		Action:     CreateIndex,
+		Schema:     t.schema,
+		Table:      t.name,
+		Columns:    columns,
+		Statements: len(c.statements),
+		TotalTime:  c.totalTime,
+		Reason:     reason,
+		SQL:        fmt.Sprintf("CREATE INDEX CONCURRENTLY ON %s (%s);", qualified(t.schema, t.name), quoteColumns(columns)),
+	}
+}
+
+func dropAdvice(i indexInfo, reason string) IndexAdvice {
+	return IndexAdvice{
+		Action:    DropIndex,
+		Schema:    i.schema,
+		Table:     i.table,
+		Index:     i.name,
+		Columns:   i.columns,
+		SizeBytes: i.sizeBytes,
+		Reason:    reason,
+		SQL:       fmt.Sprintf("DROP INDEX CONCURRENTLY %s;", qualified(i.schema, i.name)),
+	}
+}
+
+// keeps orders the indexes to keep of duplicates first.
+func keeps(a, b indexInfo) bool {
+	switch {
+	case a.constraint != b.constraint:
+		return a.constraint
+	case a.primary != b.primary:
+		return a.primary
+	case a.unique != b.unique:
+		return a.unique
+	case a.scans != b.scans:
+		return a.scans > b.scans
+	}
+	return a.oid < b.oid
+}
+
+// redundantIndexes returns the indexes that are invalid, duplicate another,
+// lead another or have never been scanned. Indexes enforcing a constraint or
+// uniqueness are only reported when an identical one remains.
+func redundantIndexes(indexes []indexInfo, statsReset sql.NullTime) []IndexAdvice {
+	var advice []IndexAdvice
+	dropped := map[int64]bool{}
+	drop := func(i indexInfo, reason string) {
+		if !dropped[i.oid] && !i.constraint {
+			dropped[i.oid] = true
+			advice = append(advice, dropAdvice(i, reason))
+		}
+	}
+
+	for _, i := range indexes {
+		if !i.valid {
+			drop(i, "invalid, left behind by a failed CREATE INDEX CONCURRENTLY or REINDEX CONCURRENTLY")
+		}
+	}
+
+	// identical definitions
+	groups := map[string][]indexInfo{}
+	var keys []string
+	for _, i := range indexes {
+		if !i.valid {
+			continue
+		}
+		key := fmt.Sprint(i.tableOID, i.method, i.columns, i.opClasses, i.expressions, i.predicate)
+		if groups[key] == nil {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+	for _, key := range keys {
+		g := groups[key]
+		if len(g) < 2 {
+			continue
+		}
+		sort.SliceStable(g, func(a, b int) bool { return keeps(g[a], g[b]) })
+		for _, i := range g[1:] {
+			drop(i, "duplicate of "+g[0].name)
+		}
+	}
+
+	since := "since the statistics were collected"
+	if statsReset.Valid {
+		since = "since the statistics were reset on " + statsReset.Time.Format("2006-01-02")
+	}
+	for _, i := range indexes {
+		if i.valid && !i.unique && !i.primary && i.scans == 0 {
+			drop(i, "never scanned "+since+"; scans on standbys are not counted here")
+		}
+	}
+
+	// plain btree indexes whose columns lead a wider one that is kept
+	for _, i := range indexes {
+		if !i.valid || i.unique || i.method != "btree" || i.predicate != "" || i.expressions != "" ||
+			len(i.opClasses) != len(i.columns) {
+			continue
+		}
+		for _, w := range indexes {
+			if w.oid == i.oid || w.tableOID != i.tableOID || !w.valid || w.method != "btree" || w.predicate != "" ||
+				len(w.columns) <= len(i.columns) || len(w.opClasses) < len(i.columns) || dropped[w.oid] {
+				continue
+			}
+			prefix := true
+			for k := range i.columns {
+				if i.columns[k] != w.columns[k] || i.opClasses[k] != w.opClasses[k] {
+					prefix = false
+					break
+				}
+			}
+			if prefix {
+				drop(i, fmt.Sprintf("its columns lead %s, which serves the same scans", w.name))
+				break
+			}
+		}
+	}
+	return advice
+}
@@ -0,0 +1,286 @@
+package advisor
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+func TestCovers(t *testing.T) {
+	tests := []struct {
+		columns  []string
+		equality []string
+		rangeCol string
+		want     bool
+	}{
+		{[]string{"a"}, []string{"a"}, "", true},
+		{[]string{"a", "b"}, []string{"a"}, "", true},
+		{[]string{"a", "b"}, []string{"b"}, "", false},
+		{[]string{"b", "a"}, []string{"a", "b"}, "", true},
+		{[]string{"a", "b", "c"}, []string{"b", "a"}, "c", true},
+		{[]string{"a", "c", "b"}, []string{"a", "b"}, "c", false},
+		{[]string{"a", "b"}, []string{"a"}, "b", true},
+		{[]string{"a", "b"}, []string{"a"}, "c", false},
+		{[]string{"a"}, []string{"a"}, "b", false},
+		{[]string{"a"}, nil, "a", true},
+		{nil, []string{"a"}, "", false},
+	}
+
+	for _, tt := range tests {
+		if got := covers(tt.columns, tt.equality, tt.rangeCol); got != tt.want {
+			t.Errorf("covers(%q, %q, %q) = %v, want %v", tt.columns, tt.equality, tt.rangeCol, got, tt.want)
+		}
+	}
+}
+
+func testTables() map[int64]*tableStats {
+	table := func(oid int64, schema, name string, distinct map[string]float64) *tableStats {
+		t := &tableStats{oid: oid, schema: schema, name: name, seqScan: 1000, seqTupRead: 1e8, liveRows: 100000,
+			distinct: distinct, nullFrac: map[string]float64{}, columns: map[string]bool{}}
+		for c := range distinct {
+			t.columns[c] = true
+		}
+		return t
+	}
+	return map[int64]*tableStats{
+		1: table(1, "public", "orders", map[string]float64{
+			"id": 100000, "customer_id": 5000, "status": 4, "created_at": 90000, "note": 0,
+		}),
+		2: table(2, "public", "customers", map[string]float64{"id": 5000, "email": 5000}),
+		3: table(3, "public", "events", map[string]float64{"id": 100000, "kind": 10}),
+		4: table(4, "archive", "events", map[string]float64{"id": 100000, "kind": 10}),
+	}
+}
+
+func TestFindCandidates(t *testing.T) {
+	statement := func(query string, totalTime float64) model.Statement {
+		return model.Statement{DBOID: 5, Query: query, TotalTime: totalTime}
+	}
+	o := DefaultIndexOptions()
+
+	type want struct {
+		table      string
+		columns    []string
+		statements int
+		totalTime  float64
+	}
+	tests := []struct {
+		name       string
+		statements []model.Statement
+		indexes    []indexInfo
+		want       []want
+	}{
+		{
+			name:       "selective equality",
+			statements: []model.Statement{statement("select * from orders where customer_id = $1", 10)},
+			want:       []want{{"public.orders", []string{"customer_id"}, 1, 10}},
+		},
+		{
+			name:       "most selective column first, then the range",
+			statements: []model.Statement{statement("select * from orders where status = $1 and customer_id = $2 and created_at > $3", 10)},
+			want:       []want{{"public.orders", []string{"customer_id", "status", "created_at"}, 1, 10}},
+		},
+		{
+			name:       "not selective enough",
+			statements: []model.Statement{statement("select * from orders where status = $1", 10)},
+		},
+		{
+			name:       "no statistics",
+			statements: []model.Statement{statement("select * from orders where note = $1", 10)},
+			want:       []want{{"public.orders", []string{"note"}, 1, 10}},
+		},
+		{
+			name: "candidates covering each other are merged",
+			statements: []model.Statement{
+				statement("select * from orders where customer_id = $1", 10),
+				statement("select * from orders where customer_id = $1 and created_at >= $2", 20),
+				statement("select * from orders where id = $1", 5),
+			},
+			want: []want{
+				{"public.orders", []string{"customer_id", "created_at"}, 2, 30},
+				{"public.orders", []string{"id"}, 1, 5},
+			},
+		},
+		{
+			name: "most expensive first",
+			statements: []model.Statement{
+				statement("select * from orders where customer_id = $1", 10),
+				statement("select * from customers c where c.email = $1", 50),
+			},
+			want: []want{
+				{"public.customers", []string{"email"}, 1, 50},
+				{"public.orders", []string{"customer_id"}, 1, 10},
+			},
+		},
+		{
+			name:       "existing index",
+			statements: []model.Statement{statement("select * from orders where customer_id = $1", 10)},
+			indexes: []indexInfo{
+				{tableOID: 1, columns: []string{"customer_id", "status"}, valid: true, method: "btree"},
+			},
+		},
+		{
+			name:       "partial, invalid and hash indexes do not count",
+			statements: []model.Statement{statement("select * from orders where customer_id = $1", 10)},
+			indexes: []indexInfo{
+				{tableOID: 1, columns: []string{"customer_id"}, valid: true, method: "btree", predicate: "status = 'new'"},
+				{tableOID: 1, columns: []string{"customer_id"}, valid: false, method: "btree"},
+				{tableOID: 1, columns: []string{"customer_id"}, valid: true, method: "hash"},
+			},
+			want: []want{{"public.orders", []string{"customer_id"}, 1, 10}},
+		},
+		{
+			name: "join columns of a self-join",
+			statements: []model.Statement{
+				statement("select * from orders a join orders b on b.customer_id = a.customer_id where a.id = $1", 10),
+			},
+			want: []want{{"public.orders", []string{"id"}, 1, 10}},
+		},
+		{
+			name: "unqualified names are looked up in public first",
+			statements: []model.Statement{
+				statement("select * from events where kind = $1 and id = $2", 10),
+				statement("select * from archive.events e where e.id = $1", 20),
+			},
+			want: []want{
+				{"archive.events", []string{"id"}, 1, 20},
+				{"public.events", []string{"id", "kind"}, 1, 10},
+			},
+		},
+		{
+			name: "other databases and statements",
+			statements: []model.Statement{
+				{DBOID: 6, Query: "select * from orders where customer_id = $1", TotalTime: 10},
+				statement("insert into orders select * from orders where customer_id = $1", 10),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []want
+			for _, c := range findCandidates(tt.statements, 5, testTables(), tt.indexes, o) {
+				got = append(got, want{c.table.schema + "." + c.table.name, c.columns(), len(c.statements), c.totalTime})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("candidates = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedundantIndexes(t *testing.T) {
+	btree := func(oid int64, name string, columns ...string) indexInfo {
+		i := indexInfo{oid: oid, schema: "public", table: "t", tableOID: 1, name: name, columns: columns,
+			valid: true, scans: 10, method: "btree"}
+		for range columns {
+			i.opClasses = append(i.opClasses, "1978")
+		}
+		return i
+	}
+
+	tests := []struct {
+		name    string
+		indexes []indexInfo
+		// the dropped indexes and the start of the reason
+		want map[string]string
+	}{
+		{
+			name:    "invalid",
+			indexes: []indexInfo{func() indexInfo { i := btree(1, "t_a_idx", "a"); i.valid = false; return i }()},
+			want:    map[string]string{"t_a_idx": "invalid"},
+		},
+		{
+			name:    "never scanned",
+			indexes: []indexInfo{func() indexInfo { i := btree(1, "t_a_idx", "a"); i.scans = 0; return i }()},
+			want:    map[string]string{"t_a_idx": "never scanned"},
+		},
+		{
+			name: "unique indexes are kept unscanned",
+			indexes: []indexInfo{
+				func() indexInfo { i := btree(1, "t_a_key", "a"); i.unique, i.scans = true, 0; return i }(),
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "duplicates keep the constraint",
+			indexes: []indexInfo{
+				btree(1, "t_a_idx", "a"),
+				func() indexInfo { i := btree(2, "t_a_key", "a"); i.unique, i.constraint = true, true; return i }(),
+				func() indexInfo { i := btree(3, "t_a_idx1", "a"); i.scans = 100; return i }(),
+			},
+			want: map[string]string{"t_a_idx": "duplicate of t_a_key", "t_a_idx1": "duplicate of t_a_key"},
+		},
+		{
+			name: "duplicates keep the most scanned",
+			indexes: []indexInfo{
+				btree(1, "t_a_idx", "a"),
+				func() indexInfo { i := btree(2, "t_a_idx1", "a"); i.scans = 100; return i }(),
+			},
+			want: map[string]string{"t_a_idx": "duplicate of t_a_idx1"},
+		},
+		{
+			name: "not duplicates",
+			indexes: []indexInfo{
+				btree(1, "t_a_idx", "a"),
+				func() indexInfo { i := btree(2, "t_a_idx1", "a"); i.predicate = "a > 0"; return i }(),
+				func() indexInfo { i := btree(3, "t_a_idx2", "a"); i.method, i.opClasses = "hash", nil; return i }(),
+				func() indexInfo { i := btree(4, "u_a_idx", "a"); i.table, i.tableOID = "u", 2; return i }(),
+			},
+			want: map[string]string{},
+		},
+		{
+			name:    "prefix of a wider index",
+			indexes: []indexInfo{btree(1, "t_a_idx", "a"), btree(2, "t_a_b_idx", "a", "b")},
+			want:    map[string]string{"t_a_idx": "its columns lead t_a_b_idx"},
+		},
+		{
+			name: "not a prefix",
+			indexes: []indexInfo{
+				btree(1, "t_b_idx", "b"),
+				btree(2, "t_a_b_idx", "a", "b"),
+				func() indexInfo { i := btree(3, "t_c_idx", "c"); i.unique = true; return i }(),
+				btree(4, "t_c_d_idx", "c", "d"),
+				func() indexInfo { i := btree(5, "t_e_idx", "e"); i.opClasses = []string{"3128"}; return i }(),
+				btree(6, "t_e_f_idx", "e", "f"),
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "not the prefix of a dropped index",
+			indexes: []indexInfo{
+				btree(1, "t_a_idx", "a"),
+				func() indexInfo { i := btree(2, "t_a_b_idx", "a", "b"); i.valid = false; return i }(),
+			},
+			want: map[string]string{"t_a_b_idx": "invalid"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string]string{}
+			for _, a := range redundantIndexes(tt.indexes, sql.NullTime{}) {
+				if a.Action != DropIndex {
+					t.Errorf("action = %s, want %s", a.Action, DropIndex)
+				}
+				got[a.Index] = a.Reason
+				if a.SQL != `DROP INDEX CONCURRENTLY "public".`+pq.QuoteIdentifier(a.Index)+";" {
+					t.Errorf("SQL = %s", a.SQL)
+				}
+			}
+			for name, reason := range got {
+				if want, ok := tt.want[name]; !ok || len(reason) < len(want) || reason[:len(want)] != want {
+					t.Errorf("dropped %s: %s", name, reason)
+				}
+			}
+			for name := range tt.want {
+				if _, ok := got[name]; !ok {
+					t.Errorf("kept %s", name)
+				}
+			}
+		})
+	}
+}
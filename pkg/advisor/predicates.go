@@ -0,0 +1,204 @@
+package advisor
+
+import (
+	"strings"
+
+	"github.com/pkbhowmick/pg-monitoring/pkg/sqlnorm"
+)
+
+// relationRef is a relation named in the FROM clause of a query, schema
+// being empty if the name is not qualified.
+type relationRef struct {
+	schema string
+	name   string
+}
+
+// predicate compares a column to a constant or parameter.
+type predicate struct {
+	// qualifier is the alias or relation name before the column, if any.
+	qualifier string
+	column    string
+	// equality is false for ranges: <, <=, >, >= and BETWEEN.
+	equality bool
+}
+
+// query is what the index advisor understands of a statement: the relations
+// it reads, by alias, and the predicates that could use an index.
+type query struct {
+	relations  map[string]relationRef
+	predicates []predicate
+}
+
+// aliasEnd are the words that may follow a relation in a FROM clause instead
+// of an alias.
+var aliasEnd = map[string]bool{
+	"where": true, "join": true, "on": true, "using": true, "inner": true, "left": true, "right": true,
+	"full": true, "cross": true, "natural": true, "group": true, "order": true, "limit": true,
+	"offset": true, "having": true, "window": true, "union": true, "intersect": true, "except": true,
+	"for": true, "returning": true, "set": true, "tablesample": true, "fetch": true, "lateral": true,
+	"only": true,
+}
+
+// keywords are the reserved words that may appear where a column or a
+// function name could, and are neither.
+var keywords = map[string]bool{
+	"all": true, "and": true, "any": true, "array": true, "as": true, "asc": true, "between": true,
+	"both": true, "case": true, "cast": true, "desc": true, "distinct": true, "else": true, "end": true,
+	"exists": true, "false": true, "from": true, "ilike": true, "in": true, "is": true, "isnull": true,
+	"leading": true, "like": true, "not": true, "notnull": true, "null": true, "or": true, "select": true,
+	"similar": true, "some": true, "then": true, "trailing": true, "true": true, "values": true,
+	"when": true, "with": true,
+}
+
+var rangeOperators = map[string]bool{"<": true, "<=": true, ">": true, ">=": true}
+
+func unquote(ident string) string {
+	if strings.HasPrefix(ident, `"`) && strings.HasSuffix(ident, `"`) && len(ident) >= 2 {
+		return strings.ReplaceAll(ident[1:len(ident)-1], `""`, `"`)
+	}
+	return ident
+}
+
+// isName tells whether a token is an identifier rather than a keyword,
+// constant, operator or punctuation.
+func isName(t string) bool {
+	if t == "" || t == sqlnorm.Placeholder || t == sqlnorm.ListPlaceholder || aliasEnd[t] || keywords[t] {
+		return false
+	}
+	if t[0] == '"' {
+		return true
+	}
+	c := t[0]
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 0x80
+}
+
+// parseQuery finds the relations and predicates of a statement. It reads the
+// normalized tokens and is lenient: what it does not recognize is skipped.
+func parseQuery(text string) query {
+	t := sqlnorm.Tokens(text)
+	q := query{relations: map[string]relationRef{}}
+	at := func(i int) string {
+		if i >= 0 && i < len(t) {
+			return t[i]
+		}
+		return ""
+	}
+
+	// the FROM of extract(field from ts), substring(s from n) and the like
+	// is an argument: call[i] tells whether the innermost parenthesis around
+	// token i is that of a function call
+	call := make([]bool, len(t))
+	var calls []bool
+	for i := range t {
+		if len(calls) > 0 {
+			call[i] = calls[len(calls)-1]
+		}
+		switch t[i] {
+		case "(":
+			calls = append(calls, isName(at(i-1)))
+		case ")":
+			if len(calls) > 0 {
+				calls = calls[:len(calls)-1]
+			}
+		}
+	}
+
+	for i := 0; i < len(t); i++ {
+		switch t[i] {
+		case "from", "join", "update":
+			if call[i] {
+				continue
+			}
+			for j := i + 1; ; {
+				if at(j) == "only" {
+					j++
+				}
+				if !isName(at(j)) {
+					break
+				}
+				ref := relationRef{name: unquote(at(j))}
+				j++
+				if at(j) == "." && isName(at(j+1)) {
+					ref.schema, ref.name = ref.name, unquote(at(j+1))
+					j += 2
+				}
+				// function calls are not relations, skip their arguments
+				function := at(j) == "("
+				for depth := 0; function && j < len(t); j++ {
+					if t[j] == "(" {
+						depth++
+					} else if t[j] == ")" {
+						if depth--; depth == 0 {
+							j++
+							break
+						}
+					}
+				}
+				alias := ref.name
+				if at(j) == "as" {
+					j++
+				}
+				if isName(at(j)) {
+					alias = unquote(at(j))
+					j++
+				}
+				if !function {
+					q.relations[alias] = ref
+					if ref.schema != "" {
+						q.relations[ref.schema+"."+ref.name] = ref
+					}
+				}
+				if at(j) != "," {
+					break
+				}
+				j++
+			}
+		}
+	}
+
+	// the assignments of UPDATE ... SET look like predicates
+	assigning := false
+	for i := 0; i < len(t); i++ {
+		switch t[i] {
+		case "set":
+			assigning = true
+		case "where", "from", "returning":
+			assigning = false
+		}
+		// a column, qualified or not, starting here, and not a type cast to
+		if assigning || !isName(t[i]) || at(i-1) == "." || at(i-1) == ":" {
+			continue
+		}
+		p := predicate{column: unquote(t[i])}
+		j := i + 1
+		if at(j) == "." && isName(at(j+1)) {
+			p.qualifier, p.column = p.column, unquote(at(j+1))
+			j += 2
+		}
+		if at(j) == "." {
+			// schema.table.column
+			continue
+		}
+
+		op := at(j)
+		switch {
+		case op == "=" && at(j+1) == sqlnorm.Placeholder:
+			p.equality = true
+		case op == "=" && at(j+1) == "any" && at(j+2) == "(" && at(j+3) == sqlnorm.Placeholder:
+			p.equality = true
+		case op == "in" && at(j+1) == "(" && (at(j+2) == sqlnorm.ListPlaceholder || at(j+2) == sqlnorm.Placeholder):
+			p.equality = true
+		case rangeOperators[op] && at(j+1) == sqlnorm.Placeholder:
+		case op == "between" && at(j+1) == sqlnorm.Placeholder:
+		default:
+			// the constant may come first
+			if at(i-1) == "=" && at(i-2) == sqlnorm.Placeholder {
+				p.equality = true
+			} else if !(rangeOperators[at(i-1)] && at(i-2) == sqlnorm.Placeholder) {
+				continue
+			}
+		}
+		q.predicates = append(q.predicates, p)
+	}
+	return q
+}
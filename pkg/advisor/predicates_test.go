@@ -0,0 +1,131 @@
+package advisor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		in         string
+		relations  map[string]relationRef
+		predicates []predicate
+	}{
+		{
+			name:       "equality and range",
+			in:         "SELECT * FROM orders WHERE customer_id = $1 AND created_at > $2",
+			relations:  map[string]relationRef{"orders": {name: "orders"}},
+			predicates: []predicate{{column: "customer_id", equality: true}, {column: "created_at"}},
+		},
+		{
+			name: "aliases",
+			in:   "select o.id from orders o join customers as c on c.id = o.customer_id where c.email = $1",
+			relations: map[string]relationRef{
+				"o": {name: "orders"},
+				"c": {name: "customers"},
+			},
+			predicates: []predicate{{qualifier: "c", column: "email", equality: true}},
+		},
+		{
+			name: "schema-qualified names",
+			in:   `select * from shop.orders, "Shop"."Line Items" li where orders.status = 'new' and li."Qty" >= 2`,
+			relations: map[string]relationRef{
+				"orders":          {schema: "shop", name: "orders"},
+				"shop.orders":     {schema: "shop", name: "orders"},
+				"li":              {schema: "Shop", name: "Line Items"},
+				"Shop.Line Items": {schema: "Shop", name: "Line Items"},
+			},
+			predicates: []predicate{
+				{qualifier: "orders", column: "status", equality: true},
+				{qualifier: "li", column: "Qty"},
+			},
+		},
+		{
+			name: "self-join",
+			in:   "select * from employees e join employees m on m.id = e.manager_id where e.id = $1",
+			relations: map[string]relationRef{
+				"e": {name: "employees"},
+				"m": {name: "employees"},
+			},
+			predicates: []predicate{{qualifier: "e", column: "id", equality: true}},
+		},
+		{
+			name:       "any and in-lists",
+			in:         "select * from t where a = any($1) and b in (1, 2, 3) and c in ($2)",
+			relations:  map[string]relationRef{"t": {name: "t"}},
+			predicates: []predicate{{column: "a", equality: true}, {column: "b", equality: true}, {column: "c", equality: true}},
+		},
+		{
+			name:       "constant first",
+			in:         "select * from t where $1 = a and 10 < b and $2 = any(tags)",
+			relations:  map[string]relationRef{"t": {name: "t"}},
+			predicates: []predicate{{column: "a", equality: true}, {column: "b"}},
+		},
+		{
+			name:       "between",
+			in:         "select * from t where ts between $1 and $2",
+			relations:  map[string]relationRef{"t": {name: "t"}},
+			predicates: []predicate{{column: "ts"}},
+		},
+		{
+			name:       "update assignments",
+			in:         "UPDATE accounts SET balance = $1, updated = now() WHERE id = $2",
+			relations:  map[string]relationRef{"accounts": {name: "accounts"}},
+			predicates: []predicate{{column: "id", equality: true}},
+		},
+		{
+			name:       "update from",
+			in:         "update only t set x = 1 from u where u.id = t.u_id and u.code = $1",
+			relations:  map[string]relationRef{"t": {name: "t"}, "u": {name: "u"}},
+			predicates: []predicate{{qualifier: "u", column: "code", equality: true}},
+		},
+		{
+			name:       "from inside function calls",
+			in:         "select extract(epoch from ts), substring(name from 2), trim(both from code) from events where kind = $1",
+			relations:  map[string]relationRef{"events": {name: "events"}},
+			predicates: []predicate{{column: "kind", equality: true}},
+		},
+		{
+			name:       "subqueries",
+			in:         "select * from a where a.id in (select a_id from b where b.x = $1) and exists (select 1 from c where c.y > $2)",
+			relations:  map[string]relationRef{"a": {name: "a"}, "b": {name: "b"}, "c": {name: "c"}},
+			predicates: []predicate{{qualifier: "b", column: "x", equality: true}, {qualifier: "c", column: "y"}},
+		},
+		{
+			name:       "common table expression",
+			in:         "with recent as (select * from orders where created_at >= $1) select * from recent",
+			relations:  map[string]relationRef{"orders": {name: "orders"}, "recent": {name: "recent"}},
+			predicates: []predicate{{column: "created_at"}},
+		},
+		{
+			name:       "set-returning function",
+			in:         "select * from generate_series(1, 10) g where g > $1",
+			relations:  map[string]relationRef{},
+			predicates: []predicate{{column: "g"}},
+		},
+		{
+			name:       "keywords are not columns",
+			in:         "select * from t where not deleted and $1 = any(ids) and x is not null and case when y = 1 then 1 end = $2",
+			relations:  map[string]relationRef{"t": {name: "t"}},
+			predicates: []predicate{{column: "y", equality: true}},
+		},
+		{
+			name:      "casts",
+			in:        "select * from t where created::date = $1",
+			relations: map[string]relationRef{"t": {name: "t"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := parseQuery(tt.in)
+			if !reflect.DeepEqual(q.relations, tt.relations) {
+				t.Errorf("relations = %+v, want %+v", q.relations, tt.relations)
+			}
+			if !reflect.DeepEqual(q.predicates, tt.predicates) {
+				t.Errorf("predicates = %+v, want %+v", q.predicates, tt.predicates)
+			}
+		})
+	}
+}
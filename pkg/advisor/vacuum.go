@@ -0,0 +1,347 @@
+package advisor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+const (
+	DefaultVacuumMinRows   = 10000
+	DefaultMaxDeadFraction = 0.2
+	DefaultMaxVacuumAge    = 24 * time.Hour
+	DefaultLongVacuum      = 10 * time.Minute
+	DefaultTargetDeadRows  = 100000
+
+	// maxCostLimit bounds the cost limits proposed, the largest sensible
+	// value being well below the server's maximum of 10000.
+	maxCostLimit = 5000
+	// recentVacuum is how long after a vacuum dead rows should be gone.
+	recentVacuum = time.Hour
+)
+
+// VacuumOptions tune the autovacuum advisor.
+type VacuumOptions struct {
+	// Tables of fewer live and dead rows are left alone.
+	MinRows int64
+	// MaxDeadFraction is the fraction of dead rows a table should stay
+	// below.
+	MaxDeadFraction float64
+	// Vacuums are overdue when dead rows went past the autovacuum trigger
+	// and the table has not been vacuumed for MaxVacuumAge.
+	MaxVacuumAge time.Duration
+	// LongVacuum is the duration of the autovacuum runs worth reporting.
+	LongVacuum time.Duration
+	// TargetDeadRows is the number of dead rows the autovacuum of large
+	// tables is tuned to start at.
+	TargetDeadRows int64
+}
+
+func DefaultVacuumOptions() VacuumOptions {
+	return VacuumOptions{
+		MinRows:         DefaultVacuumMinRows,
+		MaxDeadFraction: DefaultMaxDeadFraction,
+		MaxVacuumAge:    DefaultMaxVacuumAge,
+		LongVacuum:      DefaultLongVacuum,
+		TargetDeadRows:  DefaultTargetDeadRows,
+	}
+}
+
+// VacuumAdvice is a table autovacuum falls behind on, with the storage
+// parameters that would help it keep up.
+type VacuumAdvice struct {
+	Schema   string
+	Table    string
+	LiveRows int64
+	DeadRows int64
+	// Trigger is the number of dead rows autovacuum starts at.
+	Trigger    int64
+	LastVacuum *time.Time
+	Vacuums    int64
+	// LongestVacuum is the longest autovacuum of the table in the logs read,
+	// zero if none was logged.
+	LongestVacuum time.Duration
+	Issues        []string
+	// Options are the storage parameters to set, SQL the ALTER TABLE
+	// statement setting them, empty if no change is proposed.
+	Options map[string]string
+	SQL     string
+}
+
+// vacuumSettings are the autovacuum parameters in effect for a table, its
+// storage parameters overriding the server's settings.
+type vacuumSettings struct {
+	enabled     bool
+	scaleFactor float64
+	threshold   float64
+	costLimit   float64
+}
+
+func setting(s model.Settings, name string) (string, bool) {
+	for _, p := range s.Parameters {
+		if p.Name == name {
+			return p.Setting, true
+		}
+	}
+	return "", false
+}
+
+func settingFloat(s model.Settings, name string, def float64) float64 {
+	if v, ok := setting(s, name); ok {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+func serverVacuumSettings(s model.Settings) vacuumSettings {
+	v := vacuumSettings{
+		enabled:     true,
+		scaleFactor: settingFloat(s, "autovacuum_vacuum_scale_factor", 0.2),
+		threshold:   settingFloat(s, "autovacuum_vacuum_threshold", 50),
+		costLimit:   settingFloat(s, "autovacuum_vacuum_cost_limit", -1),
+	}
+	if on, ok := setting(s, "autovacuum"); ok && on == "off" {
+		v.enabled = false
+	}
+	if v.costLimit < 0 {
+		v.costLimit = settingFloat(s, "vacuum_cost_limit", 200)
+	}
+	return v
+}
+
+// withOptions applies the storage parameters of a table.
+func (v vacuumSettings) withOptions(options map[string]string) vacuumSettings {
+	parse := func(name string, into *float64) {
+		if s, ok := options[name]; ok {
+			if f, err := strconv.ParseFloat(s, 64); err == nil && f >= 0 {
+				*into = f
+			}
+		}
+	}
+	parse("autovacuum_vacuum_scale_factor", &v.scaleFactor)
+	parse("autovacuum_vacuum_threshold", &v.threshold)
+	parse("autovacuum_vacuum_cost_limit", &v.costLimit)
+	if options["autovacuum_enabled"] == "false" || options["autovacuum_enabled"] == "off" {
+		v.enabled = false
+	}
+	return v
+}
+
+// round rounds a positive number to its first significant digit, which is
+// as precise as the estimates behind it.
+func round(v float64) float64 {
+	if v <= 0 {
+		return 0
+	}
+	exp := math.Floor(math.Log10(v))
+	if exp < 0 {
+		// dividing keeps 0.3 from coming out as 0.30000000000000004
+		p := math.Pow(10, -exp)
+		return math.Round(v*p) / p
+	}
+	unit := math.Pow(10, exp)
+	return math.Round(v/unit) * unit
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+type vacuumTable struct {
+	schema     string
+	name       string
+	dbName     string
+	live       int64
+	dead       int64
+	reltuples  float64
+	lastVacuum *time.Time
+	vacuums    int64
+	options    map[string]string
+}
+
+func getVacuumTables(db *sql.DB, minRows int64) ([]vacuumTable, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	q := `SELECT T.schemaname, T.relname, current_database(), T.n_live_tup, T.n_dead_tup, C.reltuples,
+				GREATEST(T.last_vacuum, T.last_autovacuum), T.vacuum_count + T.autovacuum_count,
+				COALESCE(C.reloptions, '{}')
+			FROM pg_stat_user_tables AS T JOIN pg_class AS C ON C.oid = T.relid
+			WHERE T.n_live_tup + T.n_dead_tup >= $1
+			ORDER BY T.n_dead_tup DESC`
+	rows, err := db.QueryContext(ctx, q, minRows)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []vacuumTable
+	for rows.Next() {
+		var t vacuumTable
+		var last sql.NullTime
+		var options []string
+		err := rows.Scan(&t.schema, &t.name, &t.dbName, &t.live, &t.dead, &t.reltuples, &last, &t.vacuums,
+			pq.Array(&options))
+		if err != nil {
+			return nil, err
+		}
+		if last.Valid {
+			t.lastVacuum = &last.Time
+		}
+		t.options = map[string]string{}
+		for _, o := range options {
+			if kv := strings.SplitN(o, "=", 2); len(kv) == 2 {
+				t.options[kv[0]] = kv[1]
+			}
+		}
+		tables = append(tables, t)
+	}
+	return tables, rows.Err()
+}
+
+// AdviseVacuum flags the tables of the database connected to that
+// autovacuum falls behind on: too many dead rows, vacuums overdue or taking
+// long, and proposes the storage parameters that would let it keep up.
+// settings are those of the server, vacuums the autovacuum runs found in its
+// logs, if any.
+func AdviseVacuum(db *sql.DB, settings model.Settings, vacuums []model.MaintenanceRuns, o VacuumOptions) ([]VacuumAdvice, error) {
+	tables, err := getVacuumTables(db, o.MinRows)
+	if err != nil {
+		return nil, err
+	}
+
+	// logged as "database.schema.table"
+	logged := map[string]model.MaintenanceRuns{}
+	for _, r := range vacuums {
+		if r.Kind == "vacuum" {
+			logged[r.Table] = r
+		}
+	}
+
+	server := serverVacuumSettings(settings)
+	now := time.Now()
+	var advice []VacuumAdvice
+	for _, t := range tables {
+		a := adviseTable(t, server.withOptions(t.options), logged[t.dbName+"."+t.schema+"."+t.name], now, o)
+		if len(a.Issues) > 0 {
+			advice = append(advice, a)
+		}
+	}
+	sort.SliceStable(advice, func(i, j int) bool { return advice[i].DeadRows > advice[j].DeadRows })
+	return advice, nil
+}
+
+func adviseTable(t vacuumTable, v vacuumSettings, runs model.MaintenanceRuns, now time.Time, o VacuumOptions) VacuumAdvice {
+	rows := t.reltuples
+	// never analyzed
+	if rows < 0 {
+		rows = float64(t.live)
+	}
+	trigger := math.Max(v.threshold+v.scaleFactor*rows, 1)
+
+	a := VacuumAdvice{
+		Schema:        t.schema,
+		Table:         t.name,
+		LiveRows:      t.live,
+		DeadRows:      t.dead,
+		Trigger:       int64(trigger),
+		LastVacuum:    t.lastVacuum,
+		Vacuums:       t.vacuums,
+		LongestVacuum: time.Duration(runs.MaxSeconds * float64(time.Second)),
+		Options:       map[string]string{},
+	}
+
+	if !v.enabled {
+		a.Issues = append(a.Issues, "autovacuum is disabled for the table")
+	}
+
+	deadFraction := 0.0
+	if t.live+t.dead > 0 {
+		deadFraction = float64(t.dead) / float64(t.live+t.dead)
+	}
+	tooMany := deadFraction > o.MaxDeadFraction || float64(t.dead) > 2*trigger
+	if tooMany {
+		a.Issues = append(a.Issues, fmt.Sprintf("%d dead rows, %.0f%% of the table and %.1f times the autovacuum trigger of %d",
+			t.dead, 100*deadFraction, float64(t.dead)/trigger, a.Trigger))
+	}
+
+	overdue := false
+	if float64(t.dead) > trigger {
+		switch {
+		case t.lastVacuum == nil:
+			overdue = true
+			a.Issues = append(a.Issues, "dead rows are past the autovacuum trigger and the table was never vacuumed")
+		case now.Sub(*t.lastVacuum) > o.MaxVacuumAge:
+			overdue = true
+			a.Issues = append(a.Issues, fmt.Sprintf("dead rows are past the autovacuum trigger and the last vacuum was %s ago",
+				now.Sub(*t.lastVacuum).Round(time.Minute)))
+		}
+	}
+
+	long := a.LongestVacuum >= o.LongVacuum && o.LongVacuum > 0
+	if long {
+		a.Issues = append(a.Issues, fmt.Sprintf("the longest of %d logged autovacuums took %s, %s in total",
+			runs.Count, a.LongestVacuum.Round(time.Second), time.Duration(runs.TotalSeconds*float64(time.Second)).Round(time.Second)))
+	}
+
+	// trigger autovacuum at a fraction of the table small enough for it
+	// to finish quickly, and no later than at TargetDeadRows
+	desired := math.Min(float64(o.TargetDeadRows), o.MaxDeadFraction/2*rows)
+	lax := trigger > 1.5*desired && float64(t.dead) > desired
+	if lax && !tooMany {
+		a.Issues = append(a.Issues, fmt.Sprintf("%d dead rows, autovacuum only starting at %d on a table this size",
+			t.dead, a.Trigger))
+	}
+
+	held := tooMany && t.lastVacuum != nil && now.Sub(*t.lastVacuum) < recentVacuum
+	if held {
+		// vacuuming sooner would not help
+		a.Issues = append(a.Issues, "vacuumed recently yet dead rows remain: an old transaction, replication slot or "+
+			"standby may hold them back, see the xmin-holders command")
+	}
+
+	if (tooMany || overdue || lax) && !held && trigger > 1.5*desired && rows > 0 {
+		threshold := v.threshold
+		if threshold > desired/2 {
+			threshold = round(desired / 2)
+			a.Options["autovacuum_vacuum_threshold"] = formatFloat(threshold)
+		}
+		scale := round((desired - threshold) / rows)
+		if scale < 0.001 {
+			scale = 0.001
+		}
+		if scale < v.scaleFactor {
+			a.Options["autovacuum_vacuum_scale_factor"] = formatFloat(scale)
+		}
+	}
+
+	// vacuums that are due but do not run, or run for long, are throttled
+	if (overdue && t.lastVacuum != nil || long) && v.costLimit < maxCostLimit {
+		limit := math.Min(maxCostLimit, math.Max(1000, 2*v.costLimit))
+		a.Options["autovacuum_vacuum_cost_limit"] = formatFloat(limit)
+	}
+
+	if len(a.Options) > 0 {
+		var names []string
+		for name := range a.Options {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var set []string
+		for _, name := range names {
+			set = append(set, name+" = "+a.Options[name])
+		}
+		a.SQL = fmt.Sprintf("ALTER TABLE %s SET (%s);", qualified(t.schema, t.name), strings.Join(set, ", "))
+	}
+	return a
+}
@@ -0,0 +1,171 @@
+package advisor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		in, want float64
+	}{
+		{0, 0},
+		{-5, 0},
+		{0.3, 0.3},
+		{0.09995, 0.1},
+		{0.00234, 0.002},
+		{9.5, 10},
+		{1234, 1000},
+		{1550, 2000},
+		{99950, 100000},
+	}
+	for _, tt := range tests {
+		if got := round(tt.in); got != tt.want {
+			t.Errorf("round(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAdviseTable(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) *time.Time {
+		t := now.Add(-d)
+		return &t
+	}
+	table := func(live, dead int64, reltuples float64, lastVacuum *time.Time) vacuumTable {
+		return vacuumTable{schema: "public", name: "t", dbName: "db", live: live, dead: dead,
+			reltuples: reltuples, lastVacuum: lastVacuum, vacuums: 10}
+	}
+	server := vacuumSettings{enabled: true, scaleFactor: 0.2, threshold: 50, costLimit: 200}
+	withCostLimit := func(limit float64) vacuumSettings {
+		v := server
+		v.costLimit = limit
+		return v
+	}
+
+	tests := []struct {
+		name     string
+		table    vacuumTable
+		settings vacuumSettings
+		runs     model.MaintenanceRuns
+		// the start of each issue
+		issues  []string
+		options map[string]string
+	}{
+		{
+			name:     "keeping up",
+			table:    table(1000000, 1000, 1000000, ago(2*time.Hour)),
+			settings: server,
+			options:  map[string]string{},
+		},
+		{
+			name:     "too many dead rows",
+			table:    table(1000000, 500000, 1000000, ago(3*time.Hour)),
+			settings: server,
+			issues:   []string{"500000 dead rows, 33% of the table and 2.5 times the autovacuum trigger of 200050"},
+			options:  map[string]string{"autovacuum_vacuum_scale_factor": "0.1"},
+		},
+		{
+			name:     "overdue",
+			table:    table(1000000, 250000, 1000000, ago(48*time.Hour)),
+			settings: server,
+			issues: []string{
+				"dead rows are past the autovacuum trigger and the last vacuum was 48h0m0s ago",
+				"250000 dead rows, autovacuum only starting at 200050",
+			},
+			options: map[string]string{
+				"autovacuum_vacuum_scale_factor": "0.1",
+				"autovacuum_vacuum_cost_limit":   "1000",
+			},
+		},
+		{
+			name:     "never vacuumed nor analyzed",
+			table:    table(20000, 10000, -1, nil),
+			settings: server,
+			issues: []string{
+				"10000 dead rows, 33% of the table",
+				"dead rows are past the autovacuum trigger and the table was never vacuumed",
+			},
+			options: map[string]string{"autovacuum_vacuum_scale_factor": "0.1"},
+		},
+		{
+			name:     "vacuumed recently, something holds them back",
+			table:    table(1000000, 500000, 1000000, ago(10*time.Minute)),
+			settings: server,
+			issues:   []string{"500000 dead rows", "vacuumed recently yet dead rows remain"},
+			options:  map[string]string{},
+		},
+		{
+			name:     "long vacuums",
+			table:    table(1000000, 1000, 1000000, ago(2*time.Hour)),
+			settings: server,
+			runs:     model.MaintenanceRuns{Count: 3, MaxSeconds: 1800, TotalSeconds: 3600},
+			issues:   []string{"the longest of 3 logged autovacuums took 30m0s, 1h0m0s in total"},
+			options:  map[string]string{"autovacuum_vacuum_cost_limit": "1000"},
+		},
+		{
+			name:     "cost limit capped",
+			table:    table(1000000, 1000, 1000000, ago(2*time.Hour)),
+			settings: withCostLimit(3000),
+			runs:     model.MaintenanceRuns{Count: 1, MaxSeconds: 1800, TotalSeconds: 1800},
+			issues:   []string{"the longest of 1 logged autovacuums"},
+			options:  map[string]string{"autovacuum_vacuum_cost_limit": "5000"},
+		},
+		{
+			name:     "cost limit at the cap already",
+			table:    table(1000000, 1000, 1000000, ago(2*time.Hour)),
+			settings: withCostLimit(5000),
+			runs:     model.MaintenanceRuns{Count: 1, MaxSeconds: 1800, TotalSeconds: 1800},
+			issues:   []string{"the longest of 1 logged autovacuums"},
+			options:  map[string]string{},
+		},
+		{
+			name:     "scale factor floor",
+			table:    table(1000000000, 50000000, 1000000000, ago(2*time.Hour)),
+			settings: server,
+			issues:   []string{"50000000 dead rows, autovacuum only starting at 200000050 on a table this size"},
+			options:  map[string]string{"autovacuum_vacuum_scale_factor": "0.001"},
+		},
+		{
+			name:     "threshold too high",
+			table:    table(20000, 3000, 20000, ago(2*time.Hour)),
+			settings: vacuumSettings{enabled: true, scaleFactor: 0.2, threshold: 5000, costLimit: 200},
+			issues:   []string{"3000 dead rows, autovacuum only starting at 9000"},
+			options: map[string]string{
+				"autovacuum_vacuum_threshold":    "1000",
+				"autovacuum_vacuum_scale_factor": "0.05",
+			},
+		},
+		{
+			name:     "disabled",
+			table:    table(1000000, 1000, 1000000, ago(2*time.Hour)),
+			settings: vacuumSettings{scaleFactor: 0.2, threshold: 50, costLimit: 200},
+			issues:   []string{"autovacuum is disabled for the table"},
+			options:  map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := adviseTable(tt.table, tt.settings, tt.runs, now, DefaultVacuumOptions())
+			if len(a.Issues) != len(tt.issues) {
+				t.Fatalf("issues = %q, want %q", a.Issues, tt.issues)
+			}
+			for i, want := range tt.issues {
+				if !strings.HasPrefix(a.Issues[i], want) {
+					t.Errorf("issue %q, want %q", a.Issues[i], want)
+				}
+			}
+			if !reflect.DeepEqual(a.Options, tt.options) {
+				t.Errorf("options = %v, want %v", a.Options, tt.options)
+			}
+			if (len(a.Options) > 0) != strings.HasPrefix(a.SQL, `ALTER TABLE "public"."t" SET (`) {
+				t.Errorf("SQL = %q with options %v", a.SQL, a.Options)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+// Package aggregator stores the snapshots of many agents, read from a
+// JetStream stream, in the normalized tables of a PostgreSQL (or
+// TimescaleDB) history database.
+package aggregator
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	DefaultDurable       = "pg-monitoring-aggregator"
+	DefaultBatchSize     = 100
+	DefaultFlushInterval = 5 * time.Second
+	DefaultRetention     = 30 * 24 * time.Hour
+
+	// how often old data is removed
+	pruneInterval = time.Hour
+)
+
+type Config struct {
+	// DBURL is the history database.
+	DBURL   string
+	Stream  string
+	Subject string
+	// durable pull consumer, so that messages are acknowledged only once
+	// they are stored
+	Durable       string
+	BatchSize     int
+	FlushInterval time.Duration
+	// Retention is how long the data is kept, forever if zero.
+	Retention time.Duration
+}
+
+// LoadConfig reads the [AGGREGATOR] section of f. Stream and Subject are
+// left to the caller.
+func LoadConfig(f *ini.File) Config {
+	sec := f.Section("AGGREGATOR")
+	return Config{
+		DBURL:         sec.Key("DB_URL").String(),
+		Stream:        sec.Key("STREAM").String(),
+		Durable:       sec.Key("DURABLE").MustString(DefaultDurable),
+		BatchSize:     sec.Key("BATCH_SIZE").MustInt(DefaultBatchSize),
+		FlushInterval: sec.Key("FLUSH_INTERVAL").MustDuration(DefaultFlushInterval),
+		Retention:     sec.Key("RETENTION").MustDuration(DefaultRetention),
+	}
+}
+
+// msgID identifies a message: by the ID the publisher gave it, else by its
+// position in the stream.
+func msgID(stream string, msg *nats.Msg) (string, error) {
+	if id := msg.Header.Get(nats.MsgIdHdr); id != "" {
+		return id, nil
+	}
+	md, err := msg.Metadata()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d", stream, md.Sequence.Stream), nil
+}
+
+// Run stores the snapshots of the stream in db until the connection to NATS
+// is closed.
+func Run(nc *nats.Conn, db *sql.DB, c Config) error {
+	if err := Migrate(db); err != nil {
+		return err
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return err
+	}
+	sub, err := js.PullSubscribe(c.Subject, c.Durable, nats.BindStream(c.Stream), nats.ManualAck())
+	if err != nil {
+		return err
+	}
+
+	var lastPrune time.Time
+	for !nc.IsClosed() {
+		if c.Retention > 0 && time.Since(lastPrune) >= pruneInterval {
+			removed, err := Prune(db, time.Now().Add(-c.Retention))
+			if err != nil {
+				log.Printf("could not remove old history: %s\n", err)
+			} else if removed > 0 {
+				log.Printf("removed %d snapshots older than %s\n", removed, c.Retention)
+			}
+			lastPrune = time.Now()
+		}
+
+		msgs, err := sub.Fetch(c.BatchSize, nats.MaxWait(c.FlushInterval))
+		if err == nats.ErrTimeout {
+			continue
+		}
+		if err != nil {
+			if nc.IsClosed() {
+				break
+			}
+			log.Printf("could not fetch snapshots: %s\n", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		var batch []Snapshot
+		for _, msg := range msgs {
+			id, err := msgID(c.Stream, msg)
+			if err != nil {
+				log.Printf("could not identify message: %s\n", err)
+				msg.Term()
+				continue
+			}
+			var m model.Model
+			if err := json.Unmarshal(msg.Data, &m); err != nil {
+				log.Printf("could not decode snapshot %s: %s\n", id, err)
+				// redelivering it would not help
+				msg.Term()
+				continue
+			}
+			batch = append(batch, Snapshot{MsgID: id, Model: m})
+		}
+
+		if len(batch) == 0 {
+			continue
+		}
+		n, err := Write(db, batch)
+		if err != nil {
+			log.Printf("could not store %d snapshots: %s\n", len(batch), err)
+			for _, msg := range msgs {
+				msg.Nak()
+			}
+			continue
+		}
+		for _, msg := range msgs {
+			msg.Ack()
+		}
+		if skipped := len(batch) - n; skipped > 0 {
+			log.Printf("skipped %d snapshots already stored\n", skipped)
+		}
+	}
+	return nil
+}
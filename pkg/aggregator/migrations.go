@@ -0,0 +1,198 @@
+package aggregator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Schema is the schema of the history database the tables are created in.
+const Schema = "pgmon_history"
+
+// migrations create and evolve the history schema. They are applied in order
+// and each only once; never edit one that was released, add a new one.
+var migrations = []string{
+	// 1: snapshots and the lists they carry
+	`CREATE SCHEMA IF NOT EXISTS pgmon_history;
+
+	CREATE TABLE pgmon_history.snapshots (
+		id            bigserial PRIMARY KEY,
+		msg_id        text NOT NULL UNIQUE,
+		target        text NOT NULL,
+		collected_at  timestamptz NOT NULL,
+		received_at   timestamptz NOT NULL DEFAULT now(),
+		settings_hash text NOT NULL DEFAULT '',
+		labels        jsonb
+	);
+	CREATE INDEX ON pgmon_history.snapshots (target, collected_at);
+
+	CREATE TABLE pgmon_history.statements (
+		snapshot_id       bigint NOT NULL,
+		target            text NOT NULL,
+		collected_at      timestamptz NOT NULL,
+		db_oid            bigint NOT NULL,
+		user_oid          bigint NOT NULL,
+		query_id          bigint NOT NULL,
+		db_name           text,
+		user_name         text,
+		fingerprint       text,
+		text_hash         text,
+		query             text,
+		calls             bigint NOT NULL,
+		total_time        double precision NOT NULL,
+		min_time          double precision NOT NULL,
+		max_time          double precision NOT NULL,
+		rows              bigint NOT NULL,
+		shared_blks_hit   bigint NOT NULL,
+		shared_blks_read  bigint NOT NULL,
+		temp_blks_written bigint NOT NULL
+	);
+	CREATE INDEX ON pgmon_history.statements (target, query_id, collected_at);
+	CREATE INDEX ON pgmon_history.statements (collected_at);
+
+	CREATE TABLE pgmon_history.databases (
+		snapshot_id   bigint NOT NULL,
+		target        text NOT NULL,
+		collected_at  timestamptz NOT NULL,
+		name          text NOT NULL,
+		num_backends  integer NOT NULL,
+		xact_commit   bigint NOT NULL,
+		xact_rollback bigint NOT NULL,
+		blks_read     bigint NOT NULL,
+		blks_hit      bigint NOT NULL,
+		tup_returned  bigint NOT NULL,
+		tup_fetched   bigint NOT NULL,
+		tup_inserted  bigint NOT NULL,
+		tup_updated   bigint NOT NULL,
+		tup_deleted   bigint NOT NULL,
+		temp_files    bigint NOT NULL,
+		temp_bytes    bigint NOT NULL,
+		deadlocks     bigint NOT NULL
+	);
+	CREATE INDEX ON pgmon_history.databases (target, name, collected_at);
+	CREATE INDEX ON pgmon_history.databases (collected_at);
+
+	CREATE TABLE pgmon_history.tables (
+		snapshot_id    bigint NOT NULL,
+		target         text NOT NULL,
+		collected_at   timestamptz NOT NULL,
+		db_name        text NOT NULL,
+		schema_name    text NOT NULL,
+		name           text NOT NULL,
+		rows_inserted  bigint NOT NULL,
+		rows_updated   bigint NOT NULL,
+		rows_deleted   bigint NOT NULL,
+		rows_live      bigint NOT NULL,
+		rows_dead      bigint NOT NULL,
+		seq_scan       bigint NOT NULL,
+		idx_scan       bigint NOT NULL,
+		heap_blks_read bigint NOT NULL,
+		heap_blks_hit  bigint NOT NULL
+	);
+	CREATE INDEX ON pgmon_history.tables (target, db_name, schema_name, name, collected_at);
+	CREATE INDEX ON pgmon_history.tables (collected_at);
+
+	CREATE TABLE pgmon_history.sequences (
+		snapshot_id  bigint NOT NULL,
+		target       text NOT NULL,
+		collected_at timestamptz NOT NULL,
+		schema_name  text NOT NULL,
+		name         text NOT NULL,
+		last_value   bigint,
+		percent_used double precision NOT NULL
+	);
+	CREATE INDEX ON pgmon_history.sequences (collected_at);
+
+	CREATE TABLE pgmon_history.standbys (
+		snapshot_id        bigint NOT NULL,
+		target             text NOT NULL,
+		collected_at       timestamptz NOT NULL,
+		application_name   text NOT NULL,
+		client_addr        text NOT NULL,
+		state              text NOT NULL,
+		sync_state         text NOT NULL,
+		replay_lag_seconds double precision NOT NULL,
+		replay_lag_bytes   bigint NOT NULL
+	);
+	CREATE INDEX ON pgmon_history.standbys (collected_at);
+
+	-- settings are kept once per configuration
+	CREATE TABLE pgmon_history.settings (
+		target          text NOT NULL,
+		settings_hash   text NOT NULL,
+		name            text NOT NULL,
+		setting         text NOT NULL,
+		unit            text,
+		source          text,
+		first_seen      timestamptz NOT NULL,
+		PRIMARY KEY (target, settings_hash, name)
+	);`,
+
+	// 2: turn the lists into hypertables where TimescaleDB is installed
+	`DO $$
+	DECLARE
+		t text;
+	BEGIN
+		IF EXISTS (SELECT FROM pg_extension WHERE extname = 'timescaledb') THEN
+			FOREACH t IN ARRAY ARRAY['statements', 'databases', 'tables', 'sequences', 'standbys'] LOOP
+				PERFORM create_hypertable('pgmon_history.' || t, 'collected_at', migrate_data => true);
+			END LOOP;
+		END IF;
+	END
+	$$;`,
+}
+
+// Migrate brings the history schema up to date.
+func Migrate(db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS public.pgmon_schema_migrations (
+		version    integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	for i, m := range migrations {
+		version := i + 1
+		if err := migrate(ctx, db, version, m); err != nil {
+			return fmt.Errorf("could not apply migration %d: %v", version, err)
+		}
+	}
+	return nil
+}
+
+func migrate(ctx context.Context, db *sql.DB, version int, stmt string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// serializes aggregators starting together
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE public.pgmon_schema_migrations IN EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+
+	var applied bool
+	q := `SELECT EXISTS (SELECT FROM public.pgmon_schema_migrations WHERE version = $1)`
+	if err := tx.QueryRowContext(ctx, q, version).Scan(&applied); err != nil {
+		return err
+	}
+	if applied {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO public.pgmon_schema_migrations (version) VALUES ($1)`, version); err != nil {
+		return err
+	}
+	log.Printf("applied history schema migration %d\n", version)
+	return tx.Commit()
+}
@@ -0,0 +1,219 @@
+package aggregator
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+// Snapshot is a snapshot to write along with the ID of the message it came
+// in, which makes writing it again a no-op.
+type Snapshot struct {
+	MsgID string
+	Model model.Model
+}
+
+// copyTable is a table filled with COPY, with the rows each snapshot adds.
+type copyTable struct {
+	name    string
+	columns []string
+	rows    func(id int64, m model.Model) [][]interface{}
+}
+
+var copyTables = []copyTable{
+	{
+		name: "statements",
+		columns: []string{"snapshot_id", "target", "collected_at", "db_oid", "user_oid", "query_id",
+			"db_name", "user_name", "fingerprint", "text_hash", "query",
+			"calls", "total_time", "min_time", "max_time", "rows",
+			"shared_blks_hit", "shared_blks_read", "temp_blks_written"},
+		rows: func(id int64, m model.Model) [][]interface{} {
+			var rows [][]interface{}
+			for _, s := range m.Statements {
+				rows = append(rows, []interface{}{id, m.Target, m.UpdatedAt, s.DBOID, s.UserOID, s.QueryID,
+					s.DBName, s.UserName, s.Fingerprint, s.TextHash, s.Query,
+					s.Calls, s.TotalTime, s.MinTime, s.MaxTime, s.Rows,
+					s.SharedBlksHit, s.SharedBlksRead, s.TempBlksWritten})
+			}
+			return rows
+		},
+	},
+	{
+		name: "databases",
+		columns: []string{"snapshot_id", "target", "collected_at", "name", "num_backends",
+			"xact_commit", "xact_rollback", "blks_read", "blks_hit",
+			"tup_returned", "tup_fetched", "tup_inserted", "tup_updated", "tup_deleted",
+			"temp_files", "temp_bytes", "deadlocks"},
+		rows: func(id int64, m model.Model) [][]interface{} {
+			var rows [][]interface{}
+			for _, d := range m.Databases {
+				rows = append(rows, []interface{}{id, m.Target, m.UpdatedAt, d.Name, d.NumBackends,
+					d.XactCommit, d.XactRollback, d.BlksRead, d.BlksHit,
+					d.TupReturned, d.TupFetched, d.TupInserted, d.TupUpdated, d.TupDeleted,
+					d.TempFiles, d.TempBytes, d.Deadlocks})
+			}
+			return rows
+		},
+	},
+	{
+		name: "tables",
+		columns: []string{"snapshot_id", "target", "collected_at", "db_name", "schema_name", "name",
+			"rows_inserted", "rows_updated", "rows_deleted", "rows_live", "rows_dead",
+			"seq_scan", "idx_scan", "heap_blks_read", "heap_blks_hit"},
+		rows: func(id int64, m model.Model) [][]interface{} {
+			var rows [][]interface{}
+			for _, t := range m.Tables {
+				rows = append(rows, []interface{}{id, m.Target, m.UpdatedAt, t.DBName, t.SchemaName, t.Name,
+					t.RowsInserted, t.RowsUpdated, t.RowsDeleted, t.RowsLive, t.RowsDead,
+					t.SeqScan, t.IdxScan, t.HeapBlksRead, t.HeapBlksHit})
+			}
+			return rows
+		},
+	},
+	{
+		name:    "sequences",
+		columns: []string{"snapshot_id", "target", "collected_at", "schema_name", "name", "last_value", "percent_used"},
+		rows: func(id int64, m model.Model) [][]interface{} {
+			var rows [][]interface{}
+			for _, s := range m.Sequences {
+				var last interface{}
+				if s.LastValue != nil {
+					last = *s.LastValue
+				}
+				rows = append(rows, []interface{}{id, m.Target, m.UpdatedAt, s.SchemaName, s.Name, last, s.PercentUsed})
+			}
+			return rows
+		},
+	},
+	{
+		name: "standbys",
+		columns: []string{"snapshot_id", "target", "collected_at", "application_name", "client_addr",
+			"state", "sync_state", "replay_lag_seconds", "replay_lag_bytes"},
+		rows: func(id int64, m model.Model) [][]interface{} {
+			var rows [][]interface{}
+			for _, s := range m.Replication.Standbys {
+				rows = append(rows, []interface{}{id, m.Target, m.UpdatedAt, s.ApplicationName, s.ClientAddr,
+					s.State, s.SyncState, s.ReplayLagSeconds, s.ReplayLagBytes})
+			}
+			return rows
+		},
+	},
+}
+
+// Write stores a batch of snapshots in a single transaction. Snapshots whose
+// message ID is already stored are skipped, so that redelivered messages are
+// harmless. It returns the number of snapshots written.
+func Write(db *sql.DB, batch []Snapshot) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	type written struct {
+		id int64
+		m  model.Model
+	}
+	var snapshots []written
+
+	q := `INSERT INTO pgmon_history.snapshots (msg_id, target, collected_at, settings_hash, labels)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (msg_id) DO NOTHING
+			RETURNING id`
+	for _, s := range batch {
+		labels, err := json.Marshal(s.Model.Labels)
+		if err != nil {
+			return 0, err
+		}
+
+		var id int64
+		err = tx.QueryRowContext(ctx, q, s.MsgID, s.Model.Target, s.Model.UpdatedAt, s.Model.Settings.Hash, string(labels)).Scan(&id)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		snapshots = append(snapshots, written{id, s.Model})
+	}
+
+	for _, t := range copyTables {
+		stmt, err := tx.PrepareContext(ctx, pq.CopyInSchema(Schema, t.name, t.columns...))
+		if err != nil {
+			return 0, err
+		}
+		for _, s := range snapshots {
+			for _, row := range t.rows(s.id, s.m) {
+				if _, err := stmt.ExecContext(ctx, row...); err != nil {
+					stmt.Close()
+					return 0, err
+				}
+			}
+		}
+		if _, err := stmt.ExecContext(ctx); err != nil {
+			stmt.Close()
+			return 0, err
+		}
+		if err := stmt.Close(); err != nil {
+			return 0, err
+		}
+	}
+
+	q = `INSERT INTO pgmon_history.settings (target, settings_hash, name, setting, unit, source, first_seen)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT DO NOTHING`
+	for _, s := range snapshots {
+		if s.m.Settings.Hash == "" {
+			continue
+		}
+		var known bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT FROM pgmon_history.settings WHERE target = $1 AND settings_hash = $2)`,
+			s.m.Target, s.m.Settings.Hash).Scan(&known)
+		if err != nil {
+			return 0, err
+		}
+		if known {
+			continue
+		}
+		for _, p := range s.m.Settings.Parameters {
+			_, err := tx.ExecContext(ctx, q, s.m.Target, s.m.Settings.Hash, p.Name, p.Setting, p.Unit, p.Source, s.m.UpdatedAt)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return len(snapshots), tx.Commit()
+}
+
+// Prune removes the data collected before t.
+func Prune(db *sql.DB, t time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	tables := []string{"statements", "databases", "tables", "sequences", "standbys", "snapshots"}
+	var removed int64
+	for _, name := range tables {
+		res, err := db.ExecContext(ctx, `DELETE FROM `+pq.QuoteIdentifier(Schema)+`.`+pq.QuoteIdentifier(name)+
+			` WHERE collected_at < $1`, t)
+		if err != nil {
+			return removed, err
+		}
+		if name == "snapshots" {
+			removed, _ = res.RowsAffected()
+		}
+	}
+
+	// configurations no longer referenced by any snapshot
+	_, err := db.ExecContext(ctx, `DELETE FROM pgmon_history.settings AS s
+		WHERE NOT EXISTS (SELECT FROM pgmon_history.snapshots AS n
+			WHERE n.target = s.target AND n.settings_hash = s.settings_hash)`)
+	return removed, err
+}
@@ -0,0 +1,263 @@
+package alert
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// scopeKeys lists the fields identifying the elements of each scope. They
+// become labels of the alerts and are used to find an element in the
+// previous snapshot for rates. Elements of other scopes are identified by all
+// their string fields.
+var scopeKeys = map[string][]string{
+	"statements":              {"query_id", "db_oid", "user_oid"},
+	"databases":               {"name"},
+	"tables":                  {"db_name", "schema_name", "name"},
+	"sequences":               {"schema_name", "name"},
+	"table_spaces":            {"name"},
+	"wraparound.databases":    {"db_name"},
+	"wraparound.tables":       {"db_name", "schema_name", "name"},
+	"wraparound.xmin_holders": {"kind", "name"},
+	"replication.standbys":    {"application_name", "client_addr"},
+	"settings.parameters":     {"name"},
+}
+
+// Engine evaluates rules against the consecutive snapshots of a target.
+type Engine struct {
+	rules    []Rule
+	prevRoot map[string]interface{}
+	prevTime time.Time
+	// pending and firing alerts by ID
+	active map[string]*model.Alert
+}
+
+func NewEngine(rules []Rule) *Engine {
+	return &Engine{rules: rules, active: map[string]*model.Alert{}}
+}
+
+// toMap turns m into the JSON objects the expressions are evaluated against.
+// Numbers are kept as json.Number, as a float64 cannot hold every 64-bit
+// query id exactly.
+func toMap(m model.Model) (map[string]interface{}, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var root map[string]interface{}
+	return root, d.Decode(&root)
+}
+
+func itemLabels(scope string, item map[string]interface{}) map[string]string {
+	labels := map[string]string{}
+	keys, ok := scopeKeys[scope]
+	if !ok {
+		for k, v := range item {
+			if s, ok := v.(string); ok {
+				labels[k] = s
+			}
+		}
+		return labels
+	}
+
+	for _, k := range keys {
+		switch v := item[k].(type) {
+		case string:
+			labels[k] = v
+		case json.Number:
+			labels[k] = v.String()
+		}
+	}
+	return labels
+}
+
+func labelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%q,", k, labels[k])
+	}
+	return b.String()
+}
+
+func items(root map[string]interface{}, scope string) []map[string]interface{} {
+	list, _ := lookup(root, strings.Split(scope, ".")).([]interface{})
+	var out []map[string]interface{}
+	for _, el := range list {
+		if obj, ok := el.(map[string]interface{}); ok {
+			out = append(out, obj)
+		}
+	}
+	return out
+}
+
+type instance struct {
+	labels map[string]string
+	env    *env
+}
+
+// instances returns what a rule is evaluated for: the snapshot, or each
+// element of its scope.
+func (e *Engine) instances(r Rule, root map[string]interface{}, elapsed float64) []instance {
+	if r.Scope == "" {
+		return []instance{{
+			labels: map[string]string{},
+			env:    &env{root: root, prevRoot: e.prevRoot, elapsed: elapsed},
+		}}
+	}
+
+	prevItems := map[string]map[string]interface{}{}
+	if e.prevRoot != nil {
+		for _, item := range items(e.prevRoot, r.Scope) {
+			prevItems[labelsKey(itemLabels(r.Scope, item))] = item
+		}
+	}
+
+	var insts []instance
+	for _, item := range items(root, r.Scope) {
+		labels := itemLabels(r.Scope, item)
+		insts = append(insts, instance{
+			labels: labels,
+			env: &env{
+				root:     root,
+				item:     item,
+				prevRoot: e.prevRoot,
+				prevItem: prevItems[labelsKey(labels)],
+				elapsed:  elapsed,
+			},
+		})
+	}
+	return insts
+}
+
+func alertID(name string, labels map[string]string) string {
+	sum := sha256.Sum256([]byte(name + "\x00" + labelsKey(labels)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func summary(tmpl string, labels map[string]string, value float64) string {
+	s := strings.ReplaceAll(tmpl, "{{value}}", strconv.FormatFloat(value, 'g', 6, 64))
+	for k, v := range labels {
+		s = strings.ReplaceAll(s, "{{"+k+"}}", v)
+	}
+	return s
+}
+
+// Evaluate evaluates the rules against snapshot m and returns the alerts
+// that started firing or got resolved. Alerts move from pending to firing
+// once their expression held for the duration of the rule; pending alerts
+// whose expression stops holding are dropped without notice.
+func (e *Engine) Evaluate(m model.Model) ([]model.Alert, error) {
+	root, err := toMap(m)
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.UpdatedAt
+	if now.IsZero() {
+		now = time.Now()
+	}
+	elapsed := 0.0
+	if !e.prevTime.IsZero() {
+		elapsed = now.Sub(e.prevTime).Seconds()
+	}
+
+	var changes []model.Alert
+	seen := map[string]bool{}
+
+	for _, r := range e.rules {
+		for _, inst := range e.instances(r, root, elapsed) {
+			if !truthy(r.Expr.eval(inst.env)) {
+				continue
+			}
+
+			labels := map[string]string{"alertname": r.Name, "severity": r.Severity}
+			if m.Target != "" {
+				labels["target"] = m.Target
+			}
+			for k, v := range r.Labels {
+				labels[k] = v
+			}
+			for k, v := range inst.labels {
+				labels[k] = v
+			}
+
+			id := alertID(r.Name, labels)
+			seen[id] = true
+
+			a, ok := e.active[id]
+			if !ok {
+				a = &model.Alert{
+					ID:       id,
+					Name:     r.Name,
+					Status:   StatusPending,
+					Severity: r.Severity,
+					Target:   m.Target,
+					Labels:   labels,
+					Expr:     r.Expr.String(),
+					StartsAt: now,
+				}
+				e.active[id] = a
+			}
+			a.Value, _ = r.Expr.value(inst.env)
+			a.Summary = summary(r.Summary, labels, a.Value)
+
+			if a.Status == StatusPending && now.Sub(a.StartsAt) >= r.For {
+				a.Status = StatusFiring
+				changes = append(changes, *a)
+			}
+		}
+	}
+
+	for id, a := range e.active {
+		if seen[id] {
+			continue
+		}
+		if a.Status == StatusFiring {
+			resolved := *a
+			resolved.Status = StatusResolved
+			resolved.EndsAt = &now
+			changes = append(changes, resolved)
+		}
+		delete(e.active, id)
+	}
+
+	e.prevRoot = root
+	e.prevTime = now
+	return changes, nil
+}
+
+// Firing returns the alerts currently firing.
+func (e *Engine) Firing() []model.Alert {
+	var firing []model.Alert
+	for _, a := range e.active {
+		if a.Status == StatusFiring {
+			firing = append(firing, *a)
+		}
+	}
+	sort.Slice(firing, func(i, j int) bool {
+		return firing[i].StartsAt.Before(firing[j].StartsAt)
+	})
+	return firing
+}
@@ -0,0 +1,122 @@
+package alert
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+func mustCompile(t *testing.T, src string) *Expr {
+	t.Helper()
+	x, err := Compile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+func TestEvaluateStatementLabels(t *testing.T) {
+	// above 2^53, and one apart: the same float64
+	const id1, id2 = 8437593248723948723, 8437593248723948724
+
+	e := NewEngine([]Rule{{
+		Name:     "busy_statement",
+		Scope:    "statements",
+		Expr:     mustCompile(t, "rate(calls) > 1"),
+		Severity: "warning",
+	}})
+
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	snapshot := func(at time.Time, calls1, calls2 int64) model.Model {
+		return model.Model{
+			Target:    "db1",
+			UpdatedAt: at,
+			Statements: []model.Statement{
+				{QueryID: id1, DBOID: 16384, UserOID: 10, Calls: calls1},
+				{QueryID: id2, DBOID: 16384, UserOID: 10, Calls: calls2},
+			},
+		}
+	}
+
+	if _, err := e.Evaluate(snapshot(t0, 100, 100)); err != nil {
+		t.Fatal(err)
+	}
+	alerts, err := e.Evaluate(snapshot(t0.Add(time.Minute), 1000, 10000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(alerts) != 2 {
+		t.Fatalf("got %d alerts, want one per statement: %+v", len(alerts), alerts)
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Value < alerts[j].Value })
+
+	for i, want := range []struct {
+		queryID string
+		value   float64
+	}{
+		{"8437593248723948723", 15},
+		{"8437593248723948724", 165},
+	} {
+		a := alerts[i]
+		if a.Labels["query_id"] != want.queryID {
+			t.Errorf("query_id label = %s, want %s", a.Labels["query_id"], want.queryID)
+		}
+		if a.Value != want.value {
+			t.Errorf("value of %s = %v, want %v", want.queryID, a.Value, want.value)
+		}
+		if a.Labels["db_oid"] != "16384" || a.Labels["user_oid"] != "10" {
+			t.Errorf("labels = %v", a.Labels)
+		}
+	}
+	if alerts[0].ID == alerts[1].ID {
+		t.Errorf("both statements raised alert %s", alerts[0].ID)
+	}
+}
+
+func TestEvaluateLifecycle(t *testing.T) {
+	e := NewEngine([]Rule{{
+		Name:     "lag",
+		Expr:     mustCompile(t, "replication.replay_lag_seconds > 1m"),
+		For:      2 * time.Minute,
+		Severity: "critical",
+		Summary:  "{{target}} is {{value}}s behind",
+	}})
+
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		after  time.Duration
+		lag    float64
+		status string
+	}{
+		{0, 90, ""},
+		{time.Minute, 120, ""},
+		{2 * time.Minute, 150, StatusFiring},
+		{3 * time.Minute, 180, ""},
+		{4 * time.Minute, 10, StatusResolved},
+		{5 * time.Minute, 10, ""},
+	}
+
+	for _, tt := range tests {
+		m := model.Model{Target: "db1", UpdatedAt: t0.Add(tt.after)}
+		m.Replication.ReplayLagSeconds = tt.lag
+
+		alerts, err := e.Evaluate(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var status string
+		if len(alerts) > 0 {
+			status = alerts[0].Status
+		}
+		if len(alerts) > 1 || status != tt.status {
+			t.Errorf("at +%s: got %+v, want status %q", tt.after, alerts, tt.status)
+			continue
+		}
+		if status == StatusFiring && alerts[0].Summary != "db1 is 150s behind" {
+			t.Errorf("summary = %q", alerts[0].Summary)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// env is what an expression is evaluated against: the snapshot (root) and,
+// for rules with a scope, the current element of the scope (item), along with
+// their counterparts in the previous snapshot for rates.
+type env struct {
+	root, item         map[string]interface{}
+	prevRoot, prevItem map[string]interface{}
+	// seconds elapsed between the previous snapshot and this one
+	elapsed float64
+}
+
+// functions lists the functions and their number of arguments.
+var functions = map[string]int{
+	"rate":    1,
+	"delta":   1,
+	"setting": 1,
+	"sum":     1,
+	"avg":     1,
+	"min":     1,
+	"max":     1,
+	"count":   1,
+	"abs":     1,
+}
+
+func checkCall(c callNode) error {
+	n, ok := functions[c.name]
+	if !ok {
+		return fmt.Errorf("unknown function %s", c.name)
+	}
+	if len(c.args) != n {
+		return fmt.Errorf("%s takes %d argument(s), got %d", c.name, n, len(c.args))
+	}
+	switch c.name {
+	case "rate", "delta", "sum", "avg", "min", "max", "count":
+		if _, ok := c.args[0].(pathNode); !ok {
+			return fmt.Errorf("the argument of %s must be a field", c.name)
+		}
+	}
+	return nil
+}
+
+// eval evaluates the expression. A nil result means there is no data, for
+// instance because a field is missing or a rate has no previous value.
+func (x *Expr) eval(e *env) interface{} {
+	return x.root.eval(e)
+}
+
+func (n numberNode) eval(e *env) interface{} {
+	return float64(n)
+}
+
+func (n stringNode) eval(e *env) interface{} {
+	return string(n)
+}
+
+func lookup(m map[string]interface{}, path []string) interface{} {
+	var v interface{} = m
+	for _, p := range path {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[p]
+	}
+	return v
+}
+
+// resolve looks a path up in the scope element first, then in the snapshot.
+func resolve(item, root map[string]interface{}, path []string) interface{} {
+	if item != nil {
+		if v := lookup(item, path); v != nil {
+			return v
+		}
+	}
+	return lookup(root, path)
+}
+
+func (n pathNode) eval(e *env) interface{} {
+	return scalar(resolve(e.item, e.root, n))
+}
+
+// scalar turns JSON values into the float64, string or nil the expressions
+// work with. Booleans become 1 or 0.
+func scalar(v interface{}) interface{} {
+	switch v := v.(type) {
+	case float64, string:
+		return v
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return nil
+		}
+		return f
+	case bool:
+		if v {
+			return 1.0
+		}
+		return 0.0
+	}
+	return nil
+}
+
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return false
+}
+
+func (n callNode) eval(e *env) interface{} {
+	switch n.name {
+	case "rate", "delta":
+		path := n.args[0].(pathNode)
+		cur, ok := number(scalar(resolve(e.item, e.root, path)))
+		// nothing to compare with, or an element that is new in the scope
+		if !ok || e.prevRoot == nil || e.item != nil && e.prevItem == nil {
+			return nil
+		}
+		prev, ok := number(scalar(resolve(e.prevItem, e.prevRoot, path)))
+		if !ok {
+			return nil
+		}
+		if n.name == "delta" {
+			return cur - prev
+		}
+		if e.elapsed <= 0 {
+			return nil
+		}
+		return (cur - prev) / e.elapsed
+
+	case "setting":
+		name, ok := n.args[0].eval(e).(string)
+		if !ok {
+			return nil
+		}
+		params, _ := lookup(e.root, []string{"settings", "parameters"}).([]interface{})
+		for _, p := range params {
+			if p, ok := p.(map[string]interface{}); ok && p["name"] == name {
+				return scalar(p["setting"])
+			}
+		}
+		return nil
+
+	case "sum", "avg", "min", "max", "count":
+		values, ok := collect(e.root, n.args[0].(pathNode))
+		if !ok {
+			return nil
+		}
+		return aggregate(n.name, values)
+
+	case "abs":
+		v, ok := number(n.args[0].eval(e))
+		if !ok {
+			return nil
+		}
+		return math.Abs(v)
+	}
+	return nil
+}
+
+// collect returns the values of the field at the end of path for each element
+// of the list found along it, e.g. databases.num_backends.
+func collect(root map[string]interface{}, path []string) ([]float64, bool) {
+	for i := range path {
+		list, ok := lookup(root, path[:i+1]).([]interface{})
+		if !ok {
+			continue
+		}
+		var values []float64
+		for _, el := range list {
+			obj, ok := el.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if v, ok := number(scalar(lookup(obj, path[i+1:]))); ok {
+				values = append(values, v)
+			}
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+func aggregate(fn string, values []float64) interface{} {
+	if fn == "count" {
+		return float64(len(values))
+	}
+	if len(values) == 0 {
+		if fn == "sum" {
+			return 0.0
+		}
+		return nil
+	}
+
+	min, max, sum := values[0], values[0], 0.0
+	for _, v := range values {
+		sum += v
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+	switch fn {
+	case "sum":
+		return sum
+	case "avg":
+		return sum / float64(len(values))
+	case "min":
+		return min
+	}
+	return max
+}
+
+func (n unaryNode) eval(e *env) interface{} {
+	v := n.x.eval(e)
+	if v == nil {
+		return nil
+	}
+	if n.op == "not" {
+		return boolValue(!truthy(v))
+	}
+	f, ok := number(v)
+	if !ok {
+		return nil
+	}
+	return -f
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (n binaryNode) eval(e *env) interface{} {
+	l := n.l.eval(e)
+
+	// short-circuit so that missing data on one side does not hide the other
+	switch n.op {
+	case "and":
+		if l != nil && !truthy(l) {
+			return 0.0
+		}
+		r := n.r.eval(e)
+		if l == nil || r == nil {
+			return nil
+		}
+		return boolValue(truthy(r))
+	case "or":
+		if l != nil && truthy(l) {
+			return 1.0
+		}
+		r := n.r.eval(e)
+		if r != nil && truthy(r) {
+			return 1.0
+		}
+		if l == nil || r == nil {
+			return nil
+		}
+		return 0.0
+	}
+
+	r := n.r.eval(e)
+	if l == nil || r == nil {
+		return nil
+	}
+
+	if n.op == "==" || n.op == "!=" {
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if lok || rok {
+			if !lok {
+				ls = fmt.Sprint(l)
+			}
+			if !rok {
+				rs = fmt.Sprint(r)
+			}
+			return boolValue((ls == rs) == (n.op == "=="))
+		}
+	}
+
+	lf, lok := number(l)
+	rf, rok := number(r)
+	if !lok || !rok {
+		return nil
+	}
+
+	switch n.op {
+	case "+":
+		return lf + rf
+	case "-":
+		return lf - rf
+	case "*":
+		return lf * rf
+	case "/":
+		if rf == 0 {
+			return nil
+		}
+		return lf / rf
+	case "<":
+		return boolValue(lf < rf)
+	case "<=":
+		return boolValue(lf <= rf)
+	case ">":
+		return boolValue(lf > rf)
+	case ">=":
+		return boolValue(lf >= rf)
+	case "==":
+		return boolValue(lf == rf)
+	case "!=":
+		return boolValue(lf != rf)
+	}
+	return nil
+}
+
+// value returns the value an alert reports: the left-hand side of the
+// comparison the expression ends in, or else the result of the expression.
+func (x *Expr) value(e *env) (float64, bool) {
+	n := x.root
+	for {
+		b, ok := n.(binaryNode)
+		if !ok {
+			return number(n.eval(e))
+		}
+		switch b.op {
+		case "<", "<=", ">", ">=", "==", "!=":
+			return number(b.l.eval(e))
+		case "and", "or":
+			n = b.l
+		default:
+			return number(n.eval(e))
+		}
+	}
+}
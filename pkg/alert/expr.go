@@ -0,0 +1,345 @@
+package alert
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The expressions of the rules are made of numbers, strings, durations (30s,
+// 5m, 1h30m, 2d, read as seconds), field paths (n_dead_tup, settings.hash),
+// function calls, the arithmetic operators + - * /, the comparisons < <= > >=
+// == != and the boolean operators and, or and not.
+
+type node interface {
+	eval(e *env) interface{}
+}
+
+type (
+	numberNode float64
+	stringNode string
+	pathNode   []string
+	callNode   struct {
+		name string
+		args []node
+	}
+	unaryNode struct {
+		op string
+		x  node
+	}
+	binaryNode struct {
+		op   string
+		l, r node
+	}
+)
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+// Expr is a compiled rule expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+func (x *Expr) String() string {
+	return x.src
+}
+
+// Compile parses the expression src.
+func Compile(src string) (*Expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in expression %q", p.tokens[p.pos], src)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+func tokenize(src string) ([]string, error) {
+	var tokens []string
+	rs := []rune(src)
+
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r) || r == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1]):
+			j := i
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.' || rs[j] == 'e' ||
+				(rs[j] == '-' || rs[j] == '+') && rs[j-1] == 'e') {
+				j++
+			}
+			if k, ok := durationEnd(rs, j); ok {
+				j = k
+			}
+			tokens = append(tokens, string(rs[i:j]))
+			i = j
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(rs) && rs[j] != r {
+				j++
+			}
+			if j == len(rs) {
+				return nil, fmt.Errorf("unterminated string in expression %q", src)
+			}
+			tokens = append(tokens, string(rs[i:j+1]))
+			i = j + 1
+		case isIdentChar(r):
+			j := i
+			for j < len(rs) && (isIdentChar(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, string(rs[i:j]))
+			i = j
+		case strings.ContainsRune("<>=!&|", r) && i+1 < len(rs) && strings.ContainsRune("=&|", rs[i+1]):
+			tokens = append(tokens, string(rs[i:i+2]))
+			i += 2
+		case strings.ContainsRune("+-*/()<>,", r):
+			tokens = append(tokens, string(r))
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q in expression %q", r, src)
+		}
+	}
+	return tokens, nil
+}
+
+// durationEnd returns the end of the units of the duration whose first
+// number ends at rs[i], such as the "h30m" of "1h30m", if there are any.
+func durationEnd(rs []rune, i int) (int, bool) {
+	unit := func(i int) int {
+		switch {
+		case i+1 < len(rs) && rs[i] == 'm' && rs[i+1] == 's':
+			return 2
+		case i < len(rs) && strings.ContainsRune("smhd", rs[i]):
+			return 1
+		}
+		return 0
+	}
+
+	n := unit(i)
+	if n == 0 {
+		return 0, false
+	}
+	for i += n; i < len(rs) && unicode.IsDigit(rs[i]); i += n {
+		for i < len(rs) && (unicode.IsDigit(rs[i]) || rs[i] == '.') {
+			i++
+		}
+		if n = unit(i); n == 0 {
+			return 0, false
+		}
+	}
+	return i, i == len(rs) || !isIdentChar(rs[i])
+}
+
+func isIdentChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) expect(t string) error {
+	if got := p.next(); got != t {
+		return fmt.Errorf("expected %q, got %q", t, got)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" || p.peek() == "||" {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{"or", l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" || p.peek() == "&&" {
+		p.next()
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{"and", l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.peek() == "not" {
+		p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{"not", x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	l, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.peek(); op {
+	case "<", "<=", ">", ">=", "==", "!=":
+		p.next()
+		r, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return binaryNode{op, l, r}, nil
+	}
+	return l, nil
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	l, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.next()
+		r, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{op, l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "*" || p.peek() == "/" {
+		op := p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{op, l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek() == "-" {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{"-", x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case t == "(":
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case t[0] == '"' || t[0] == '\'':
+		return stringNode(t[1 : len(t)-1]), nil
+	case unicode.IsDigit(rune(t[0])) || t[0] == '.':
+		return parseNumber(t)
+	case t == "true":
+		return numberNode(1), nil
+	case t == "false":
+		return numberNode(0), nil
+	case isIdentChar(rune(t[0])):
+		if p.peek() != "(" {
+			return pathNode(strings.Split(t, ".")), nil
+		}
+		p.next()
+		call := callNode{name: t}
+		for p.peek() != ")" {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return call, checkCall(call)
+	}
+	return nil, fmt.Errorf("unexpected %q", t)
+}
+
+func parseNumber(t string) (node, error) {
+	if f, err := strconv.ParseFloat(t, 64); err == nil {
+		return numberNode(f), nil
+	}
+
+	// days, which time.ParseDuration lacks, come first
+	var days float64
+	rest := t
+	if i := strings.IndexByte(t, 'd'); i >= 0 {
+		var err error
+		if days, err = strconv.ParseFloat(t[:i], 64); err != nil {
+			return nil, fmt.Errorf("invalid number %q", t)
+		}
+		rest = t[i+1:]
+	}
+	var d time.Duration
+	if rest != "" {
+		var err error
+		if d, err = time.ParseDuration(rest); err != nil {
+			return nil, fmt.Errorf("invalid number %q", t)
+		}
+	}
+	return numberNode(days*24*3600 + d.Seconds()), nil
+}
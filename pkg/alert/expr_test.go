@@ -0,0 +1,196 @@
+package alert
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"n_dead_tup > 1000", []string{"n_dead_tup", ">", "1000"}},
+		{"a>=1&&b!=2||!c", nil},
+		{"rate(xact_commit) <= 1.5e3", []string{"rate", "(", "xact_commit", ")", "<=", "1.5e3"}},
+		{"settings.hash != 'abc' and x == \"y\"", []string{"settings.hash", "!=", "'abc'", "and", "x", "==", `"y"`}},
+		{"age > 30s", []string{"age", ">", "30s"}},
+		{"age > 1h30m", []string{"age", ">", "1h30m"}},
+		{"age > 2d12h", []string{"age", ">", "2d12h"}},
+		{"lag > 1m30.5s", []string{"lag", ">", "1m30.5s"}},
+		{"lag > 250ms", []string{"lag", ">", "250ms"}},
+		{"(1h+5m)*2", []string{"(", "1h", "+", "5m", ")", "*", "2"}},
+		// not durations: the letters start a field name
+		{"5 min", []string{"5", "min"}},
+		{"5min", []string{"5", "min"}},
+		{"1h30", []string{"1", "h30"}},
+		{"1h30x", []string{"1", "h30x"}},
+	}
+
+	for _, tt := range tests {
+		got, err := tokenize(tt.in)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("tokenize(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("tokenize(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDurations(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"30s", 30},
+		{"5m", 300},
+		{"1h", 3600},
+		{"2d", 2 * 86400},
+		{"1h30m", 5400},
+		{"1m30.5s", 90.5},
+		{"2d12h", 2.5 * 86400},
+		{"1.5d", 1.5 * 86400},
+		{"250ms", 0.25},
+		{"1h30m + 30m", 7200},
+	}
+
+	for _, tt := range tests {
+		x, err := Compile(tt.in)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.in, err)
+			continue
+		}
+		if got := x.eval(&env{}); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"a >",
+		"(a > 1",
+		"a > 1)",
+		"a b",
+		"'unterminated",
+		"a # 1",
+		"nope(a)",
+		"rate(a, b)",
+		"rate(1)",
+		"sum('x')",
+		"12h1d",
+	} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("Compile(%q) succeeded", src)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	root := map[string]interface{}{
+		"in_recovery":  true,
+		"xact_commit":  1300.0,
+		"server":       map[string]interface{}{"version": "14.2"},
+		"replay_lag":   90.0,
+		"missing_null": nil,
+		"databases": []interface{}{
+			map[string]interface{}{"name": "a", "num_backends": 10.0},
+			map[string]interface{}{"name": "b", "num_backends": 30.0},
+		},
+		"settings": map[string]interface{}{
+			"parameters": []interface{}{
+				map[string]interface{}{"name": "max_connections", "setting": "100"},
+			},
+		},
+	}
+	prev := map[string]interface{}{"xact_commit": 1000.0}
+
+	tests := []struct {
+		expr string
+		want interface{}
+	}{
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"-2 - -3", 1.0},
+		{"10 / 4", 2.5},
+		{"10 / 0", nil},
+		{"replay_lag > 1m", 1.0},
+		{"replay_lag > 1m30s", 0.0},
+		{"replay_lag >= 1m30s", 1.0},
+		{"in_recovery", 1.0},
+		{"not in_recovery", 0.0},
+		{"in_recovery and replay_lag > 60", 1.0},
+		{"true or nothing", 1.0},
+		{"false and nothing", 0.0},
+		{"nothing and true", nil},
+		{"nothing > 1", nil},
+		{"server.version == '14.2'", 1.0},
+		{"server.version != \"14.2\"", 0.0},
+		{"delta(xact_commit)", 300.0},
+		{"rate(xact_commit)", 5.0},
+		{"sum(databases.num_backends)", 40.0},
+		{"avg(databases.num_backends)", 20.0},
+		{"max(databases.num_backends)", 30.0},
+		{"count(databases.num_backends)", 2.0},
+		{"sum(databases.missing)", 0.0},
+		{"avg(databases.missing)", nil},
+		{"sum(databases.num_backends) / setting('max_connections') > 0.3", 1.0},
+		{"setting('work_mem')", nil},
+		{"abs(-3)", 3.0},
+	}
+
+	for _, tt := range tests {
+		x, err := Compile(tt.expr)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.expr, err)
+			continue
+		}
+		got := x.eval(&env{root: root, prevRoot: prev, elapsed: 60})
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestEvalRateWithoutPrevious(t *testing.T) {
+	x, err := Compile("rate(xact_commit) > 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := x.eval(&env{root: map[string]interface{}{"xact_commit": 10.0}}); got != nil {
+		t.Errorf("rate without a previous snapshot = %v, want nil", got)
+	}
+}
+
+func TestValue(t *testing.T) {
+	root := map[string]interface{}{"replay_lag": 90.0, "in_recovery": true}
+
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"replay_lag > 60", 90},
+		{"replay_lag / 60 > 1", 1.5},
+		{"replay_lag > 60 and in_recovery", 90},
+		{"replay_lag * 2", 180},
+	}
+	for _, tt := range tests {
+		x, err := Compile(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := x.value(&env{root: root})
+		if !ok || got != tt.want {
+			t.Errorf("value of %s = %v, %v, want %v", tt.expr, got, ok, tt.want)
+		}
+	}
+}
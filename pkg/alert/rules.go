@@ -0,0 +1,76 @@
+// Package alert evaluates alerting rules against the snapshots collected by
+// the agent and tracks the lifecycle of the alerts they raise.
+package alert
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+const ruleSectionPrefix = "RULE."
+
+// Rule is an alerting rule. A rule without a scope is evaluated once per
+// snapshot. A rule with a scope, such as "tables" or "replication.standbys",
+// is evaluated for every element of that list, and its fields can be used in
+// the expression directly.
+type Rule struct {
+	Name  string
+	Scope string
+	Expr  *Expr
+	// For is how long the expression must hold before the alert fires.
+	For      time.Duration
+	Severity string
+	Labels   map[string]string
+	// Summary may refer to the alert's labels as {{label}} and to its value
+	// as {{value}}.
+	Summary string
+}
+
+// LoadRules reads the rules of an ini file, one [RULE.<name>] section per
+// rule:
+//
+//	[RULE.dead_tuples]
+//	SCOPE = tables
+//	EXPR = rows_dead / (rows_live + rows_dead) > 0.2 and rows_dead > 10000
+//	FOR = 10m
+//	SEVERITY = warning
+//	LABELS = team=dba
+//	SUMMARY = {{schema_name}}.{{name}} has {{value}} dead tuples
+func LoadRules(path string) ([]Rule, error) {
+	f, err := ini.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	for _, sec := range f.Sections() {
+		if !strings.HasPrefix(sec.Name(), ruleSectionPrefix) {
+			continue
+		}
+
+		r := Rule{
+			Name:     strings.TrimPrefix(sec.Name(), ruleSectionPrefix),
+			Scope:    sec.Key("SCOPE").String(),
+			For:      sec.Key("FOR").MustDuration(0),
+			Severity: sec.Key("SEVERITY").MustString("warning"),
+			Labels:   map[string]string{},
+			Summary:  sec.Key("SUMMARY").String(),
+		}
+		for _, pair := range strings.Split(sec.Key("LABELS").String(), ",") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) == 2 && kv[0] != "" {
+				r.Labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			}
+		}
+
+		r.Expr, err = Compile(sec.Key("EXPR").String())
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", r.Name, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
@@ -0,0 +1,391 @@
+// Package api serves the history of the collected snapshots as an HTTP JSON
+// API:
+//
+//	GET /api/v1/targets                                   targets with data
+//	GET /api/v1/targets/<target>/latest                   latest snapshot
+//	GET /api/v1/targets/<target>/series/<metric>          time series of a metric
+//	GET /api/v1/targets/<target>/statements               top statements of a window
+//	GET /api/v1/targets/<target>/statements/<queryid>     history of a statement
+//	GET /api/v1/metrics                                   metrics series exist for
+//	GET /api/v1/overview                                  state of each target
+//	GET /api/v1/alerts                                    firing alerts
+//	GET /api/v1/targets/<target>/sessions                 live backends
+//	GET /api/v1/targets/<target>/locks                    live lock waits
+//	GET /api/v1/targets/<target>/indexes                  live index usage
+//
+// Alerts and the freshest snapshots come from a Cache fed from NATS, the live
+// endpoints query the targets themselves; both are optional.
+//
+// The time range is given by the from and to parameters, each an RFC 3339
+// time or a duration before now such as 6h; it defaults to the last hour.
+// resolution picks the tier of the history store: auto, raw, 5m or 1h. Lists
+// are paginated with limit and offset. Responses carry an ETag and requests
+// sending it back in If-None-Match get a 304 while the data is unchanged.
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/history"
+)
+
+const (
+	// Prefix is the path the API is served under.
+	Prefix = "/api/v1/"
+
+	DefaultRange = time.Hour
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Server is the HTTP handler of the API.
+type Server struct {
+	store *history.Store
+	// Cache and Live are nil unless enabled.
+	Cache *Cache
+	Live  *Live
+}
+
+// NewServer returns the API over the snapshots of store.
+func NewServer(store *history.Store) *Server {
+	return &Server{store: store}
+}
+
+// httpError is an error along with the status it is answered with.
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e httpError) Error() string { return e.err.Error() }
+
+func badRequest(format string, args ...interface{}) error {
+	return httpError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return httpError{http.StatusNotFound, fmt.Errorf(format, args...)}
+}
+
+func unavailable(format string, args ...interface{}) error {
+	return httpError{http.StatusServiceUnavailable, fmt.Errorf(format, args...)}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, httpError{http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)})
+		return
+	}
+
+	v, err := s.route(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, r, v)
+}
+
+func (s *Server) route(r *http.Request) (interface{}, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, Prefix), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "targets":
+		return s.targets()
+	case len(parts) == 1 && parts[0] == "metrics":
+		return Metrics(), nil
+	case len(parts) == 1 && parts[0] == "overview":
+		return s.overview()
+	case len(parts) == 1 && parts[0] == "alerts":
+		return s.alerts(), nil
+	case len(parts) < 3 || parts[0] != "targets":
+		return nil, notFound("no such endpoint %s", r.URL.Path)
+	}
+
+	target, rest := parts[1], parts[2:]
+	switch {
+	case len(rest) == 1 && rest[0] == "latest":
+		return s.latest(target)
+	case len(rest) == 2 && rest[0] == "series":
+		return s.series(r, target, rest[1])
+	case len(rest) == 1 && rest[0] == "statements":
+		return s.statements(r, target)
+	case len(rest) == 2 && rest[0] == "statements":
+		return s.statement(r, target, rest[1])
+	case len(rest) == 1 && rest[0] == "sessions":
+		return s.Live.sessions(target)
+	case len(rest) == 1 && rest[0] == "locks":
+		return s.Live.locks(target)
+	case len(rest) == 1 && rest[0] == "indexes":
+		return s.Live.indexes(target)
+	}
+	return nil, notFound("no such endpoint %s", r.URL.Path)
+}
+
+func (s *Server) targets() ([]string, error) {
+	targets, err := s.store.Targets()
+	if err != nil {
+		return nil, err
+	}
+	if s.Cache != nil {
+		seen := map[string]bool{}
+		for _, t := range targets {
+			seen[t] = true
+		}
+		s.Cache.mu.Lock()
+		for t := range s.Cache.latest {
+			if !seen[t] {
+				targets = append(targets, t)
+			}
+		}
+		s.Cache.mu.Unlock()
+		sort.Strings(targets)
+	}
+	if targets == nil {
+		targets = []string{}
+	}
+	return targets, nil
+}
+
+// latestSnapshot returns the freshest snapshot of target, from the cache or
+// the store.
+func (s *Server) latestSnapshot(target string) (model.Model, error) {
+	m, err := s.store.Latest(target)
+	if err != nil && err != history.ErrNotFound {
+		return m, err
+	}
+	if s.Cache != nil {
+		if c, ok := s.Cache.Latest(target); ok && (err != nil || c.UpdatedAt.After(m.UpdatedAt)) {
+			return c, nil
+		}
+	}
+	if err == history.ErrNotFound {
+		return m, notFound("no snapshot of target %s", target)
+	}
+	return m, nil
+}
+
+func (s *Server) latest(target string) (interface{}, error) {
+	return s.latestSnapshot(target)
+}
+
+func (s *Server) alerts() []model.Alert {
+	if s.Cache == nil {
+		return []model.Alert{}
+	}
+	return s.Cache.Alerts()
+}
+
+func (s *Server) overview() (interface{}, error) {
+	targets, err := s.targets()
+	if err != nil {
+		return nil, err
+	}
+	alerts := s.alerts()
+
+	overviews := []Overview{}
+	for _, t := range targets {
+		cur, err := s.latestSnapshot(t)
+		if err != nil {
+			continue
+		}
+		prev, err := s.store.At(t, cur.UpdatedAt.Add(-time.Nanosecond))
+		if err != nil && err != history.ErrNotFound {
+			return nil, err
+		}
+		o := overview(prev, cur, alerts)
+		o.Target = t
+		overviews = append(overviews, o)
+	}
+	return overviews, nil
+}
+
+// snapshots returns the snapshots of target in the time range of the request.
+func (s *Server) snapshots(r *http.Request, target string) ([]model.Model, error) {
+	q := r.URL.Query()
+	from, err := parseTime(q.Get("from"), time.Now().Add(-DefaultRange))
+	if err != nil {
+		return nil, badRequest("from: %v", err)
+	}
+	to, err := parseTime(q.Get("to"), time.Time{})
+	if err != nil {
+		return nil, badRequest("to: %v", err)
+	}
+	res, err := parseResolution(q.Get("resolution"))
+	if err != nil {
+		return nil, err
+	}
+	return s.store.Query(target, from, to, res)
+}
+
+func (s *Server) series(r *http.Request, target, name string) (interface{}, error) {
+	if _, ok := metrics[name]; !ok {
+		return nil, notFound("unknown metric %s", name)
+	}
+	snapshots, err := s.snapshots(r, target)
+	if err != nil {
+		return nil, err
+	}
+
+	q := r.URL.Query()
+	series := newSeries(snapshots, name, q.Get("db"), q.Get("rate") == "true" || q.Get("rate") == "1")
+	series.Target = target
+	return series, nil
+}
+
+func (s *Server) statements(r *http.Request, target string) (interface{}, error) {
+	snapshots, err := s.snapshots(r, target)
+	if err != nil {
+		return nil, err
+	}
+	by := r.URL.Query().Get("sort")
+	if by == "" {
+		by = "total_time"
+	}
+	deltas, err := topStatements(snapshots, by)
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+
+	start, end, p, err := paginate(r, len(deltas))
+	if err != nil {
+		return nil, err
+	}
+	p.Items = deltas[start:end]
+	return p, nil
+}
+
+func (s *Server) statement(r *http.Request, target, id string) (interface{}, error) {
+	queryID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, badRequest("invalid query id %q", id)
+	}
+	snapshots, err := s.snapshots(r, target)
+	if err != nil {
+		return nil, err
+	}
+
+	h := statementHistory(snapshots, queryID, r.URL.Query().Get("db"))
+	h.Target = target
+	start, end, p, err := paginate(r, len(h.Points))
+	if err != nil {
+		return nil, err
+	}
+	h.Points = h.Points[start:end]
+	p.Items = h
+	return p, nil
+}
+
+// Page is a page of a list.
+type Page struct {
+	Items  interface{} `json:"items"`
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	// NextOffset is the offset of the next page, if any.
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+// paginate returns the bounds of the page of a list of n items the request
+// asks for.
+func paginate(r *http.Request, n int) (start, end int, p Page, err error) {
+	q := r.URL.Query()
+	p = Page{Total: n, Limit: DefaultLimit}
+	if v := q.Get("limit"); v != "" {
+		if p.Limit, err = strconv.Atoi(v); err != nil || p.Limit <= 0 {
+			return 0, 0, p, badRequest("invalid limit %q", v)
+		}
+		if p.Limit > MaxLimit {
+			p.Limit = MaxLimit
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if p.Offset, err = strconv.Atoi(v); err != nil || p.Offset < 0 {
+			return 0, 0, p, badRequest("invalid offset %q", v)
+		}
+	}
+
+	// clamped before adding, as offsets near the largest int would overflow
+	start, end = p.Offset, n
+	if start > n {
+		start = n
+	}
+	if p.Limit < n-start {
+		end = start + p.Limit
+		p.NextOffset = &end
+	}
+	return start, end, p, nil
+}
+
+// parseTime parses an RFC 3339 time or a duration before now, def if empty.
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func parseResolution(s string) (history.Resolution, error) {
+	switch s {
+	case "", "auto":
+		return history.Auto, nil
+	case "raw":
+		return history.Raw, nil
+	case "5m":
+		return history.FiveMinutes, nil
+	case "1h":
+		return history.Hour, nil
+	}
+	return 0, badRequest("unknown resolution %q", s)
+}
+
+// writeJSON answers with v, or with 304 if the client has it already.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			if t := strings.TrimSpace(tag); t == etag || t == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(data); err != nil {
+		log.Printf("could not send response: %s\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(httpError); ok {
+		status = e.status
+	} else {
+		log.Printf("could not answer API request: %s\n", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/alert"
+	"github.com/pkbhowmick/pg-monitoring/pkg/consumer"
+	"github.com/pkbhowmick/pg-monitoring/pkg/producer"
+	"github.com/pkbhowmick/pg-monitoring/pkg/report"
+	"github.com/pkbhowmick/pg-monitoring/pkg/sqlnorm"
+)
+
+// Cache keeps what the agents publish that the history does not hold: the
+// latest snapshots, possibly fresher than those stored, and the alerts
+// firing since the cache was started.
+type Cache struct {
+	mu     sync.Mutex
+	latest map[string]model.Model
+	alerts map[string]model.Alert
+}
+
+func NewCache() *Cache {
+	return &Cache{latest: map[string]model.Model{}, alerts: map[string]model.Alert{}}
+}
+
+// Update records a message received with the consumer package.
+func (c *Cache) Update(msg consumer.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case msg.Metrics != nil:
+		if m, ok := c.latest[msg.Metrics.Target]; !ok || msg.Metrics.UpdatedAt.After(m.UpdatedAt) {
+			c.latest[msg.Metrics.Target] = *msg.Metrics
+		}
+	case msg.Alert != nil:
+		if msg.Alert.Status == alert.StatusResolved {
+			delete(c.alerts, msg.Alert.ID)
+		} else {
+			c.alerts[msg.Alert.ID] = *msg.Alert
+		}
+	}
+}
+
+// Latest returns the latest snapshot of target received.
+func (c *Cache) Latest(target string) (model.Model, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.latest[target]
+	return m, ok
+}
+
+var severityRank = map[string]int{"critical": 0, "warning": 1, "info": 2}
+
+// Alerts returns the firing alerts, the most severe and oldest first.
+func (c *Cache) Alerts() []model.Alert {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	alerts := []model.Alert{}
+	for _, a := range c.alerts {
+		alerts = append(alerts, a)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		a, b := alerts[i], alerts[j]
+		if severityRank[a.Severity] != severityRank[b.Severity] {
+			return severityRank[a.Severity] < severityRank[b.Severity]
+		}
+		return a.StartsAt.Before(b.StartsAt)
+	})
+	return alerts
+}
+
+// Live queries the targets themselves, for what snapshots do not carry.
+type Live struct {
+	dbs       map[string]*sql.DB
+	sqlLength uint
+	mode      sqlnorm.Mode
+}
+
+// NewLive returns a live source whose query texts are cut at sqlLength
+// characters and transformed according to mode.
+func NewLive(sqlLength uint, mode sqlnorm.Mode) *Live {
+	return &Live{dbs: map[string]*sql.DB{}, sqlLength: sqlLength, mode: mode}
+}
+
+// Add makes target queried through db.
+func (l *Live) Add(target string, db *sql.DB) {
+	l.dbs[target] = db
+}
+
+func (l *Live) db(target string) (*sql.DB, error) {
+	if l == nil {
+		return nil, unavailable("live data is not enabled")
+	}
+	db, ok := l.dbs[target]
+	if !ok {
+		return nil, notFound("no live connection to target %s", target)
+	}
+	return db, nil
+}
+
+func (l *Live) sessions(target string) (interface{}, error) {
+	db, err := l.db(target)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := producer.GetSessions(db, l.sqlLength, l.mode)
+	if sessions == nil {
+		sessions = []model.Session{}
+	}
+	return sessions, err
+}
+
+func (l *Live) locks(target string) (interface{}, error) {
+	db, err := l.db(target)
+	if err != nil {
+		return nil, err
+	}
+	locks, err := producer.GetLocks(db, l.sqlLength, l.mode)
+	if locks == nil {
+		locks = []model.Lock{}
+	}
+	return locks, err
+}
+
+func (l *Live) indexes(target string) (interface{}, error) {
+	db, err := l.db(target)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := producer.GetIndexes(db)
+	if indexes == nil {
+		indexes = []model.Index{}
+	}
+	return indexes, err
+}
+
+// Overview sums up the state of a target.
+type Overview struct {
+	Target    string            `json:"target"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	UpdatedAt time.Time         `json:"updated_at"`
+
+	InRecovery       bool    `json:"in_recovery"`
+	ReplayLagSeconds float64 `json:"replay_lag_seconds"`
+	Standbys         int     `json:"standbys"`
+	Backends         int     `json:"backends"`
+	Databases        int     `json:"databases"`
+	// activity since the previous snapshot
+	CommitsPerSec   float64 `json:"commits_per_sec"`
+	RollbacksPerSec float64 `json:"rollbacks_per_sec"`
+	HitRatio        float64 `json:"hit_ratio"`
+	// MaxWraparoundPercent is how far the oldest database is from
+	// transaction ID wraparound.
+	MaxWraparoundPercent float64 `json:"max_wraparound_percent"`
+	FiringAlerts         int     `json:"firing_alerts"`
+}
+
+// overview sums up the state of target from its two latest snapshots.
+func overview(prev, cur model.Model, alerts []model.Alert) Overview {
+	o := Overview{
+		Target:           cur.Target,
+		Labels:           cur.Labels,
+		UpdatedAt:        cur.UpdatedAt,
+		InRecovery:       cur.Replication.InRecovery,
+		ReplayLagSeconds: cur.Replication.ReplayLagSeconds,
+		Standbys:         len(cur.Replication.Standbys),
+		Databases:        len(cur.Databases),
+	}
+	for _, d := range cur.Databases {
+		o.Backends += d.NumBackends
+	}
+	for _, d := range cur.Wraparound.Databases {
+		if d.WraparoundPercent > o.MaxWraparoundPercent {
+			o.MaxWraparoundPercent = d.WraparoundPercent
+		}
+	}
+	for _, a := range alerts {
+		if a.Target == cur.Target {
+			o.FiringAlerts++
+		}
+	}
+
+	seconds := cur.UpdatedAt.Sub(prev.UpdatedAt).Seconds()
+	if prev.UpdatedAt.IsZero() || seconds <= 0 {
+		return o
+	}
+	var hit, read int64
+	for _, d := range report.DatabaseDeltas(prev, cur, seconds) {
+		o.CommitsPerSec += d.CommitsPerSec
+		o.RollbacksPerSec += float64(d.XactRollback) / seconds
+		hit += d.BlksHit
+		read += d.BlksRead
+	}
+	if hit+read > 0 {
+		o.HitRatio = float64(hit) / float64(hit+read)
+	}
+	return o
+}
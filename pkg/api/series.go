@@ -0,0 +1,146 @@
+package api
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+// Point is a value of a series.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Series is the time series of a metric of a target.
+type Series struct {
+	Target string `json:"target"`
+	Metric string `json:"metric"`
+	// Database restricts the database metrics to a database.
+	Database string `json:"database,omitempty"`
+	// Counter metrics grow until the statistics are reset. With Rate they
+	// are turned into their increase per second.
+	Counter bool    `json:"counter"`
+	Rate    bool    `json:"rate"`
+	Points  []Point `json:"points"`
+}
+
+// metric extracts a value from a snapshot. ok is false if the snapshot does
+// not have it.
+type metric struct {
+	counter bool
+	value   func(m model.Model, db string) (v float64, ok bool)
+}
+
+// databaseSum sums a field over the databases, or takes that of database db.
+func databaseSum(field func(d model.Database) int64) func(model.Model, string) (float64, bool) {
+	return func(m model.Model, db string) (float64, bool) {
+		var sum int64
+		found := false
+		for _, d := range m.Databases {
+			if db == "" || d.Name == db {
+				sum += field(d)
+				found = true
+			}
+		}
+		return float64(sum), found
+	}
+}
+
+var metrics = map[string]metric{
+	"backends":      {value: databaseSum(func(d model.Database) int64 { return int64(d.NumBackends) })},
+	"xact_commit":   {counter: true, value: databaseSum(func(d model.Database) int64 { return d.XactCommit })},
+	"xact_rollback": {counter: true, value: databaseSum(func(d model.Database) int64 { return d.XactRollback })},
+	"blks_read":     {counter: true, value: databaseSum(func(d model.Database) int64 { return d.BlksRead })},
+	"blks_hit":      {counter: true, value: databaseSum(func(d model.Database) int64 { return d.BlksHit })},
+	"tup_returned":  {counter: true, value: databaseSum(func(d model.Database) int64 { return d.TupReturned })},
+	"tup_fetched":   {counter: true, value: databaseSum(func(d model.Database) int64 { return d.TupFetched })},
+	"tup_inserted":  {counter: true, value: databaseSum(func(d model.Database) int64 { return d.TupInserted })},
+	"tup_updated":   {counter: true, value: databaseSum(func(d model.Database) int64 { return d.TupUpdated })},
+	"tup_deleted":   {counter: true, value: databaseSum(func(d model.Database) int64 { return d.TupDeleted })},
+	"temp_files":    {counter: true, value: databaseSum(func(d model.Database) int64 { return d.TempFiles })},
+	"temp_bytes":    {counter: true, value: databaseSum(func(d model.Database) int64 { return d.TempBytes })},
+	"deadlocks":     {counter: true, value: databaseSum(func(d model.Database) int64 { return d.Deadlocks })},
+	"replay_lag_seconds": {value: func(m model.Model, _ string) (float64, bool) {
+		if !m.Replication.InRecovery {
+			return 0, false
+		}
+		return m.Replication.ReplayLagSeconds, true
+	}},
+	"standby_max_replay_lag_bytes": {value: func(m model.Model, _ string) (float64, bool) {
+		var max int64
+		for _, s := range m.Replication.Standbys {
+			if s.ReplayLagBytes > max {
+				max = s.ReplayLagBytes
+			}
+		}
+		return float64(max), len(m.Replication.Standbys) > 0
+	}},
+	"checkpoints_timed": {counter: true, value: func(m model.Model, _ string) (float64, bool) {
+		return float64(m.Checkpointer.CheckpointsTimed), true
+	}},
+	"checkpoints_requested": {counter: true, value: func(m model.Model, _ string) (float64, bool) {
+		return float64(m.Checkpointer.CheckpointsRequested), true
+	}},
+	"buffers_checkpoint": {counter: true, value: func(m model.Model, _ string) (float64, bool) {
+		return float64(m.Checkpointer.BuffersCheckpoint), true
+	}},
+	"buffers_backend": {counter: true, value: func(m model.Model, _ string) (float64, bool) {
+		return float64(m.Checkpointer.BuffersBackend), true
+	}},
+	"wal_bytes": {counter: true, value: func(m model.Model, _ string) (float64, bool) {
+		if m.WAL == nil {
+			return 0, false
+		}
+		return float64(m.WAL.Bytes), true
+	}},
+	"dead_rows": {value: func(m model.Model, db string) (float64, bool) {
+		var sum int
+		for _, t := range m.Tables {
+			if db == "" || t.DBName == db {
+				sum += t.RowsDead
+			}
+		}
+		return float64(sum), true
+	}},
+}
+
+// Metrics returns the names of the metrics series can be built for.
+func Metrics() []string {
+	var names []string
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newSeries builds the series of a metric over snapshots. The counters
+// turned into rates skip the intervals the statistics were reset in.
+func newSeries(snapshots []model.Model, name, db string, rate bool) Series {
+	mt := metrics[name]
+	s := Series{Metric: name, Database: db, Counter: mt.counter, Rate: rate && mt.counter, Points: []Point{}}
+
+	var prev *Point
+	for _, m := range snapshots {
+		s.Target = m.Target
+		v, ok := mt.value(m, db)
+		if !ok {
+			continue
+		}
+		p := Point{Time: m.UpdatedAt, Value: v}
+		if !s.Rate {
+			s.Points = append(s.Points, p)
+			continue
+		}
+
+		if prev != nil && p.Value >= prev.Value {
+			if secs := p.Time.Sub(prev.Time).Seconds(); secs > 0 {
+				s.Points = append(s.Points, Point{Time: p.Time, Value: (p.Value - prev.Value) / secs})
+			}
+		}
+		prev = &p
+	}
+	return s
+}
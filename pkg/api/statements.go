@@ -0,0 +1,113 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/report"
+)
+
+// statementSorts are the orders top statements can be listed in, biggest
+// first.
+var statementSorts = map[string]func(a, b report.StatementDelta) bool{
+	"total_time": func(a, b report.StatementDelta) bool { return a.TotalTime > b.TotalTime },
+	"calls":      func(a, b report.StatementDelta) bool { return a.Calls > b.Calls },
+	"mean_time":  func(a, b report.StatementDelta) bool { return a.MeanTime > b.MeanTime },
+	"rows":       func(a, b report.StatementDelta) bool { return a.Rows > b.Rows },
+	"io": func(a, b report.StatementDelta) bool {
+		return a.SharedBlksRead > b.SharedBlksRead
+	},
+	"temp": func(a, b report.StatementDelta) bool {
+		return a.TempBlksWritten > b.TempBlksWritten
+	},
+}
+
+// topStatements returns what the statements did between the first and the
+// last of snapshots, in the order named by by. Unless the first snapshot
+// holds every statement, the statements missing from it are left out rather
+// than credited with their lifetime counters.
+func topStatements(snapshots []model.Model, by string) ([]report.StatementDelta, error) {
+	less, ok := statementSorts[by]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %q", by)
+	}
+	if len(snapshots) < 2 {
+		return []report.StatementDelta{}, nil
+	}
+
+	deltas := report.StatementDeltas(snapshots[0], snapshots[len(snapshots)-1])
+	sort.SliceStable(deltas, func(i, j int) bool { return less(deltas[i], deltas[j]) })
+	if deltas == nil {
+		deltas = []report.StatementDelta{}
+	}
+	return deltas, nil
+}
+
+// StatementPoint is the activity of a statement between a snapshot and the
+// previous one.
+type StatementPoint struct {
+	Time        time.Time `json:"time"`
+	Seconds     float64   `json:"seconds"`
+	Calls       int64     `json:"calls"`
+	CallsPerSec float64   `json:"calls_per_sec"`
+	TotalTime   float64   `json:"total_time"`
+	MeanTime    float64   `json:"mean_time"`
+	Rows        int64     `json:"rows"`
+	HitRatio    float64   `json:"hit_ratio"`
+}
+
+// StatementHistory is the activity of a statement over time, summed over the
+// databases and users running it unless restricted to a database.
+type StatementHistory struct {
+	Target      string           `json:"target"`
+	QueryID     int64            `json:"query_id"`
+	Database    string           `json:"database,omitempty"`
+	Fingerprint string           `json:"fingerprint,omitempty"`
+	Query       string           `json:"query,omitempty"`
+	Points      []StatementPoint `json:"points"`
+}
+
+// statementHistory follows statement queryID through snapshots. The
+// intervals the statement was not in both snapshots of are left out.
+func statementHistory(snapshots []model.Model, queryID int64, db string) StatementHistory {
+	h := StatementHistory{QueryID: queryID, Database: db, Points: []StatementPoint{}}
+
+	for i := 1; i < len(snapshots); i++ {
+		a, b := snapshots[i-1], snapshots[i]
+		h.Target = b.Target
+
+		var (
+			p         = StatementPoint{Time: b.UpdatedAt, Seconds: b.UpdatedAt.Sub(a.UpdatedAt).Seconds()}
+			hit, read int64
+			found     bool
+		)
+		for _, d := range report.StatementDeltas(a, b) {
+			if d.QueryID != queryID || d.New || db != "" && d.DBName != db {
+				continue
+			}
+			found = true
+			h.Fingerprint, h.Query = d.Fingerprint, d.Query
+			p.Calls += d.Calls
+			p.TotalTime += d.TotalTime
+			p.Rows += d.Rows
+			hit += d.SharedBlksHit
+			read += d.SharedBlksRead
+		}
+		if !found {
+			continue
+		}
+		if p.Calls > 0 {
+			p.MeanTime = p.TotalTime / float64(p.Calls)
+		}
+		if p.Seconds > 0 {
+			p.CallsPerSec = float64(p.Calls) / p.Seconds
+		}
+		if hit+read > 0 {
+			p.HitRatio = float64(hit) / float64(hit+read)
+		}
+		h.Points = append(h.Points, p)
+	}
+	return h
+}
@@ -0,0 +1,320 @@
+// Package consumer receives what the agent publishes on NATS and decodes it
+// into the types of the model package.
+//
+//	c, err := consumer.Subscribe(nc, consumer.Options{Targets: []string{"db1"}},
+//		func(msg consumer.Message) {
+//			if msg.Metrics != nil {
+//				fmt.Println(msg.Metrics.UpdatedAt, len(msg.Metrics.Statements))
+//			}
+//		})
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/subjects"
+)
+
+// DefaultSubjects are the subjects everything the agent publishes goes to.
+// They are listed one by one, as a wildcard under metrics.postgres would also
+// match the requests of the request/reply subjects.
+var DefaultSubjects = []string{
+	subjects.Metrics,
+	subjects.Progress,
+	subjects.SettingsChange,
+	subjects.Drift,
+	subjects.PlanChange,
+	subjects.QueryText,
+	subjects.Anomaly,
+	subjects.AlertFiring,
+	subjects.AlertResolved,
+}
+
+// Message is a decoded message. Exactly one of the typed fields is set,
+// depending on the subject.
+type Message struct {
+	Subject string
+	// Sequence and Time are those of the stream when the message was read
+	// from JetStream, else Time is the time of reception.
+	Sequence uint64
+	Time     time.Time
+	Data     []byte
+
+	Metrics        *model.Model
+	Progress       []model.Progress
+	SettingsChange *model.SettingsChange
+	Drift          *model.DriftReport
+	PlanChange     *model.PlanChange
+	QueryTexts     []model.QueryText
+	Anomalies      []model.Anomaly
+	Alert          *model.Alert
+}
+
+// Value returns the typed field that is set.
+func (m Message) Value() interface{} {
+	switch {
+	case m.Metrics != nil:
+		return m.Metrics
+	case m.Progress != nil:
+		return m.Progress
+	case m.SettingsChange != nil:
+		return m.SettingsChange
+	case m.Drift != nil:
+		return m.Drift
+	case m.PlanChange != nil:
+		return m.PlanChange
+	case m.QueryTexts != nil:
+		return m.QueryTexts
+	case m.Anomalies != nil:
+		return m.Anomalies
+	case m.Alert != nil:
+		return m.Alert
+	}
+	return nil
+}
+
+// Targets returns the targets the message is about. Drift reports span
+// several targets.
+func (m Message) Targets() []string {
+	var targets []string
+	switch {
+	case m.Metrics != nil:
+		targets = append(targets, m.Metrics.Target)
+	case m.SettingsChange != nil:
+		targets = append(targets, m.SettingsChange.Target)
+	case m.PlanChange != nil:
+		targets = append(targets, m.PlanChange.Target)
+	case m.Alert != nil:
+		targets = append(targets, m.Alert.Target)
+	case m.Drift != nil:
+		for _, g := range m.Drift.Groups {
+			targets = append(targets, g.Targets...)
+		}
+	}
+	for _, p := range m.Progress {
+		targets = append(targets, p.Target)
+	}
+	for _, t := range m.QueryTexts {
+		targets = append(targets, t.Target)
+	}
+	for _, a := range m.Anomalies {
+		targets = append(targets, a.Target)
+	}
+	return targets
+}
+
+// Decode decodes the data of a message received on subject. The agent
+// publishes JSON.
+func Decode(subject string, data []byte) (Message, error) {
+	m := Message{Subject: subject, Data: data, Time: time.Now()}
+
+	var v interface{}
+	switch {
+	case subject == subjects.Metrics:
+		m.Metrics = &model.Model{}
+		v = m.Metrics
+	case subject == subjects.Progress:
+		v = &m.Progress
+	case subject == subjects.SettingsChange:
+		m.SettingsChange = &model.SettingsChange{}
+		v = m.SettingsChange
+	case subject == subjects.Drift:
+		m.Drift = &model.DriftReport{}
+		v = m.Drift
+	case subject == subjects.PlanChange:
+		m.PlanChange = &model.PlanChange{}
+		v = m.PlanChange
+	case subject == subjects.QueryText:
+		v = &m.QueryTexts
+	case subject == subjects.Anomaly:
+		v = &m.Anomalies
+	case strings.HasPrefix(subject, "alerts.postgres."):
+		m.Alert = &model.Alert{}
+		v = m.Alert
+	default:
+		return m, fmt.Errorf("unknown subject %s", subject)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return m, fmt.Errorf("could not decode message on %s: %v", subject, err)
+	}
+	return m, nil
+}
+
+// Options selects what is received.
+type Options struct {
+	// Subjects defaults to DefaultSubjects.
+	Subjects []string
+	// Targets keeps the messages about these targets only, all if empty.
+	Targets []string
+	// Stream reads from a JetStream stream instead of core NATS, through a
+	// durable consumer if Durable is set, so that a restarted subscriber
+	// resumes where it stopped. Subjects then defaults to the snapshots
+	// only, and must be captured by the stream. DeliverAll replays the whole
+	// stream to a new consumer instead of starting with new messages,
+	// DeliverLast starts with the last message.
+	Stream      string
+	Durable     string
+	DeliverAll  bool
+	DeliverLast bool
+	// Errors receives the messages that could not be decoded; they are
+	// dropped if nil.
+	Errors func(subject string, err error)
+}
+
+func (o Options) wants(m Message) bool {
+	if len(o.Targets) == 0 {
+		return true
+	}
+	for _, t := range m.Targets() {
+		for _, want := range o.Targets {
+			if t == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Consumer is a running subscription.
+type Consumer struct {
+	subs []*nats.Subscription
+}
+
+// Subscribe calls handler for each message matching opts until the consumer
+// is closed. The handler is called from the goroutines of the NATS client.
+func Subscribe(nc *nats.Conn, opts Options, handler func(Message)) (*Consumer, error) {
+	list := opts.Subjects
+	if len(list) == 0 {
+		list = DefaultSubjects
+		// streams usually capture the snapshots only
+		if opts.Stream != "" {
+			list = []string{subjects.Metrics}
+		}
+	}
+
+	cb := func(msg *nats.Msg) {
+		m, err := Decode(msg.Subject, msg.Data)
+		if err != nil {
+			if opts.Errors != nil {
+				opts.Errors(msg.Subject, err)
+			}
+		} else {
+			if md, err := msg.Metadata(); err == nil {
+				m.Sequence = md.Sequence.Stream
+				m.Time = md.Timestamp
+			}
+			if opts.wants(m) {
+				handler(m)
+			}
+		}
+		if opts.Stream != "" {
+			msg.Ack()
+		}
+	}
+
+	c := &Consumer{}
+	if opts.Stream == "" {
+		for _, subject := range list {
+			sub, err := nc.Subscribe(subject, cb)
+			if err != nil {
+				c.Close()
+				return nil, err
+			}
+			c.subs = append(c.subs, sub)
+		}
+		return c, nil
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	for _, subject := range list {
+		subOpts := []nats.SubOpt{nats.BindStream(opts.Stream), nats.ManualAck()}
+		// a durable consumer follows a single subject
+		if opts.Durable != "" {
+			name := opts.Durable
+			if len(list) > 1 {
+				name += "_" + durableSuffix.Replace(subject)
+			}
+			subOpts = append(subOpts, nats.Durable(name))
+		}
+		switch {
+		case opts.DeliverAll:
+			subOpts = append(subOpts, nats.DeliverAll())
+		case opts.DeliverLast:
+			subOpts = append(subOpts, nats.DeliverLast())
+		default:
+			subOpts = append(subOpts, nats.DeliverNew())
+		}
+
+		sub, err := js.Subscribe(subject, cb, subOpts...)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.subs = append(c.subs, sub)
+	}
+	return c, nil
+}
+
+// durableSuffix turns a subject into something usable in a consumer name.
+var durableSuffix = strings.NewReplacer(".", "_", "*", "any", ">", "all")
+
+// Close stops the consumer once the messages already received are handled.
+// A durable JetStream consumer is kept on the server to be resumed.
+func (c *Consumer) Close() error {
+	var first error
+	for _, sub := range c.subs {
+		// unsubscribing would delete the durable consumer
+		if err := sub.Drain(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Sections returns the named top-level sections of a snapshot, such as
+// "statements" or "wraparound", along with its target and time. It fails on
+// unknown sections.
+func Sections(m *model.Model, sections []string) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	selected := map[string]json.RawMessage{"updated_at": all["updated_at"]}
+	if v, ok := all["target"]; ok {
+		selected["target"] = v
+	}
+	for _, s := range sections {
+		v, ok := all[s]
+		if !ok && !isOmittedSection(s) {
+			return nil, fmt.Errorf("unknown section %q", s)
+		}
+		if ok {
+			selected[s] = v
+		}
+	}
+	return selected, nil
+}
+
+// isOmittedSection tells whether s is a section left out of snapshots that do
+// not have it.
+func isOmittedSection(s string) bool {
+	switch s {
+	case "labels", "logs", "ash", "wal":
+		return true
+	}
+	return false
+}
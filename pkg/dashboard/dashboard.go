@@ -0,0 +1,24 @@
+// Package dashboard is the web dashboard of the serve command. It is a
+// static page embedded in the binary, drawing everything from the JSON API
+// of the api package, so that no other service is needed to look at the
+// collected data.
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the dashboard.
+func Handler() http.Handler {
+	sub, err := fs.Sub(static, "static")
+	if err != nil {
+		// the embedded directory is known to exist
+		panic(err)
+	}
+	return http.FileServer(http.FS(sub))
+}
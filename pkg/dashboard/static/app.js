@@ -0,0 +1,303 @@
+"use strict";
+
+// The dashboard polls the JSON API, sending back the ETag of each answer so
+// that unchanged data costs a 304.
+
+const API = "/api/v1/";
+const cache = new Map();
+
+async function api(path) {
+	const prev = cache.get(path);
+	const headers = prev ? { "If-None-Match": prev.etag } : {};
+	const resp = await fetch(API + path, { headers });
+	if (resp.status === 304 && prev) {
+		return prev.data;
+	}
+	const data = await resp.json();
+	if (!resp.ok) {
+		throw new Error(data.error || resp.statusText);
+	}
+	cache.set(path, { etag: resp.headers.get("ETag"), data });
+	return data;
+}
+
+const $ = (id) => document.getElementById(id);
+
+function esc(s) {
+	return String(s ?? "").replace(/[&<>"']/g, (c) => ({
+		"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;",
+	}[c]));
+}
+
+function num(v, digits = 0) {
+	if (v === undefined || v === null || isNaN(v)) {
+		return "";
+	}
+	return Number(v).toLocaleString(undefined, { maximumFractionDigits: digits });
+}
+
+function bytes(n) {
+	const units = ["B", "kB", "MB", "GB", "TB"];
+	let i = 0;
+	while (n >= 1024 && i < units.length - 1) {
+		n /= 1024;
+		i++;
+	}
+	return num(n, i ? 1 : 0) + " " + units[i];
+}
+
+function percent(v) {
+	return num(100 * v, 1) + " %";
+}
+
+// table renders rows with columns of {title, value, num, cls, html}.
+function table(columns, rows, empty) {
+	if (!rows || rows.length === 0) {
+		return `<p class="empty">${esc(empty || "Nothing to show.")}</p>`;
+	}
+	const head = columns.map((c) => `<th>${esc(c.title)}</th>`).join("");
+	const body = rows.map((r) => "<tr>" + columns.map((c) => {
+		const v = c.value(r);
+		const cls = [c.num ? "num" : "", c.cls ? c.cls(r) : ""].join(" ").trim();
+		return `<td class="${cls}">${c.html ? v : esc(v)}</td>`;
+	}).join("") + "</tr>").join("");
+	return `<table><thead><tr>${head}</tr></thead><tbody>${body}</tbody></table>`;
+}
+
+function sparkline(values, width = 120, height = 24) {
+	if (values.length < 2) {
+		return "";
+	}
+	const max = Math.max(...values);
+	const min = Math.min(...values);
+	const span = max - min || 1;
+	const points = values.map((v, i) => {
+		const x = (i / (values.length - 1)) * width;
+		const y = height - 2 - ((v - min) / span) * (height - 4);
+		return x.toFixed(1) + "," + y.toFixed(1);
+	}).join(" ");
+	return `<svg class="spark" width="${width}" height="${height}"><polyline points="${points}"/></svg>`;
+}
+
+function failed(id, err) {
+	$(id).innerHTML = `<p class="empty">${esc(err.message)}</p>`;
+}
+
+const state = {
+	target: new URLSearchParams(location.search).get("target") || "",
+	window: "1h",
+	sort: "total_time",
+	latest: null,
+};
+
+async function loadTargets() {
+	const targets = await api("targets");
+	if (!state.target && targets.length) {
+		state.target = targets[0];
+	}
+	$("target").innerHTML = targets.map((t) =>
+		`<option ${t === state.target ? "selected" : ""}>${esc(t)}</option>`).join("");
+}
+
+async function loadAlerts() {
+	try {
+		const alerts = await api("alerts");
+		$("alerts").innerHTML = alerts.length === 0
+			? `<p class="empty">No alert is firing.</p>`
+			: alerts.map((a) => `<div class="alert ${esc(a.severity)}">
+				<strong>${esc(a.severity)}</strong> ${esc(a.target)} ${esc(a.name)}:
+				${esc(a.summary || a.expr)} (${num(a.value, 2)}) since ${esc(new Date(a.starts_at).toLocaleString())}
+			</div>`).join("");
+	} catch (err) {
+		failed("alerts", err);
+	}
+}
+
+async function loadOverview() {
+	try {
+		const overview = await api("overview");
+		$("overview").innerHTML = overview.map((o) => `
+			<div class="card ${o.target === state.target ? "selected" : ""}" data-target="${esc(o.target)}">
+				<h3>${esc(o.target)} ${o.in_recovery ? "(replica)" : ""}</h3>
+				<dl>
+					<dt>Backends</dt><dd>${num(o.backends)}</dd>
+					<dt>Commits/s</dt><dd>${num(o.commits_per_sec, 1)}</dd>
+					<dt>Rollbacks/s</dt><dd>${num(o.rollbacks_per_sec, 1)}</dd>
+					<dt>Cache hit</dt><dd>${percent(o.hit_ratio)}</dd>
+					${o.in_recovery ? `<dt>Replay lag</dt><dd>${num(o.replay_lag_seconds, 1)} s</dd>` : `<dt>Standbys</dt><dd>${o.standbys}</dd>`}
+					<dt>Wraparound</dt><dd class="${o.max_wraparound_percent > 50 ? "bad" : ""}">${num(o.max_wraparound_percent, 1)} %</dd>
+					<dt>Alerts</dt><dd class="${o.firing_alerts ? "bad" : ""}">${o.firing_alerts}</dd>
+				</dl>
+			</div>`).join("") || `<p class="empty">No target has data yet.</p>`;
+		document.querySelectorAll(".card").forEach((card) => {
+			card.onclick = () => selectTarget(card.dataset.target);
+		});
+		const o = overview.find((o) => o.target === state.target);
+		if (o) {
+			$("updated").textContent = "updated " + new Date(o.updated_at).toLocaleTimeString();
+		}
+	} catch (err) {
+		failed("overview", err);
+	}
+}
+
+function targetPath(path) {
+	return "targets/" + encodeURIComponent(state.target) + "/" + path;
+}
+
+async function loadStatements() {
+	try {
+		const page = await api(targetPath(`statements?from=${state.window}&sort=${state.sort}&limit=15`));
+		const rows = page.items;
+		const histories = await Promise.all(rows.map((s) =>
+			api(targetPath(`statements/${s.query_id}?from=${state.window}&db=${encodeURIComponent(s.db_name || "")}&limit=1000`))
+				.then((p) => p.items.points)
+				.catch(() => [])));
+		rows.forEach((s, i) => { s.points = histories[i]; });
+
+		$("statements").innerHTML = table([
+			{ title: "Query", value: (s) => s.query || s.fingerprint, cls: () => "query" },
+			{ title: "Database", value: (s) => s.db_name },
+			{ title: "Calls", value: (s) => num(s.calls), num: true },
+			{ title: "Calls/s", value: (s) => sparkline(s.points.map((p) => p.calls_per_sec)), html: true },
+			{ title: "Total ms", value: (s) => num(s.total_time), num: true },
+			{ title: "Mean ms", value: (s) => num(s.mean_time, 2), num: true },
+			{ title: "Mean ms over time", value: (s) => sparkline(s.points.map((p) => p.mean_time)), html: true },
+			{ title: "% time", value: (s) => num(s.percent_of_time, 1), num: true },
+			{ title: "Hit ratio", value: (s) => percent(s.hit_ratio), num: true },
+		], rows, "No statement ran in this window.");
+	} catch (err) {
+		failed("statements", err);
+	}
+}
+
+async function loadActivity() {
+	try {
+		const sessions = (await api(targetPath("sessions")))
+			.filter((s) => s.state && s.state !== "idle");
+		$("sessions").innerHTML = table([
+			{ title: "PID", value: (s) => s.pid, num: true },
+			{ title: "Database", value: (s) => s.db_name },
+			{ title: "User", value: (s) => s.user_name },
+			{ title: "Application", value: (s) => s.application },
+			{ title: "State", value: (s) => s.state },
+			{ title: "Wait", value: (s) => [s.wait_event_type, s.wait_event].filter(Boolean).join(": ") },
+			{ title: "Running", value: (s) => num(s.query_seconds, 1) + " s", num: true },
+			{ title: "Blocked by", value: (s) => (s.blocked_by || []).join(", "), cls: (s) => s.blocked_by ? "bad" : "" },
+			{ title: "Query", value: (s) => s.query, cls: () => "query" },
+		], sessions, "No session is active.");
+	} catch (err) {
+		failed("sessions", err);
+	}
+	try {
+		const locks = await api(targetPath("locks"));
+		$("locks").innerHTML = table([
+			{ title: "PID", value: (l) => l.pid, num: true },
+			{ title: "Granted", value: (l) => l.granted ? "yes" : "waiting", cls: (l) => l.granted ? "" : "bad" },
+			{ title: "Lock", value: (l) => l.lock_type },
+			{ title: "Mode", value: (l) => l.mode },
+			{ title: "Relation", value: (l) => l.relation },
+			{ title: "Waiting", value: (l) => l.granted ? "" : num(l.wait_seconds, 1) + " s", num: true },
+			{ title: "Blocked by", value: (l) => (l.blocked_by || []).join(", ") },
+			{ title: "Query", value: (l) => l.query, cls: () => "query" },
+		], locks, "No session waits for a lock.");
+	} catch (err) {
+		failed("locks", err);
+	}
+}
+
+async function loadLatest() {
+	try {
+		state.latest = await api(targetPath("latest"));
+	} catch (err) {
+		failed("replication", err);
+		failed("tables", err);
+		return;
+	}
+	const m = state.latest;
+
+	const r = m.replication || {};
+	let html = r.in_recovery
+		? `<p>Replica, replaying ${num(r.replay_lag_seconds, 1)} s behind its primary.</p>`
+		: `<p>Primary with ${(r.standbys || []).length} standby(s).</p>`;
+	html += table([
+		{ title: "Standby", value: (s) => s.application_name },
+		{ title: "Address", value: (s) => s.client_addr },
+		{ title: "State", value: (s) => s.state },
+		{ title: "Sync", value: (s) => s.sync_state },
+		{ title: "Write lag", value: (s) => num(s.write_lag_seconds, 2) + " s", num: true },
+		{ title: "Flush lag", value: (s) => num(s.flush_lag_seconds, 2) + " s", num: true },
+		{ title: "Replay lag", value: (s) => num(s.replay_lag_seconds, 2) + " s", num: true },
+		{ title: "Replay lag bytes", value: (s) => bytes(s.replay_lag_bytes), num: true },
+	], r.standbys, r.in_recovery ? " " : "No standby is connected.");
+	$("replication").innerHTML = html;
+
+	const tables = (m.tables || []).slice().sort((a, b) => b.rows_dead - a.rows_dead).slice(0, 20);
+	$("tables").innerHTML = table([
+		{ title: "Table", value: (t) => `${t.db_name}.${t.schema_name}.${t.name}` },
+		{ title: "Live rows", value: (t) => num(t.rows_live), num: true },
+		{ title: "Dead rows", value: (t) => num(t.rows_dead), num: true },
+		{
+			title: "Dead %", num: true,
+			value: (t) => num(100 * t.rows_dead / Math.max(1, t.rows_live + t.rows_dead), 1),
+			cls: (t) => t.rows_dead > 0.2 * (t.rows_live + t.rows_dead) && t.rows_dead > 1000 ? "bad" : "",
+		},
+		{ title: "Seq scans", value: (t) => num(t.seq_scan), num: true },
+		{ title: "Index scans", value: (t) => num(t.idx_scan), num: true },
+		{ title: "Hit ratio", value: (t) => percent(t.heap_blks_hit / Math.max(1, t.heap_blks_hit + t.heap_blks_read)), num: true },
+	], tables, "No table statistics.");
+}
+
+async function loadIndexes() {
+	try {
+		const indexes = (await api(targetPath("indexes")))
+			.sort((a, b) => (a.idx_scan - b.idx_scan) || (b.size_bytes - a.size_bytes))
+			.slice(0, 20);
+		$("indexes").innerHTML = table([
+			{ title: "Index", value: (i) => `${i.schema_name}.${i.name}` },
+			{ title: "Table", value: (i) => i.table_name },
+			{ title: "Scans", value: (i) => num(i.idx_scan), num: true, cls: (i) => i.idx_scan === 0 && !i.unique ? "bad" : "" },
+			{ title: "Size", value: (i) => bytes(i.size_bytes), num: true },
+			{ title: "Kind", value: (i) => [i.primary && "primary", i.unique && "unique", !i.valid && "INVALID"].filter(Boolean).join(", ") },
+		], indexes, "No user index.");
+	} catch (err) {
+		failed("indexes", err);
+	}
+}
+
+function refreshTarget() {
+	if (!state.target) {
+		return;
+	}
+	loadStatements();
+	loadLatest();
+	loadActivity();
+	loadIndexes();
+}
+
+function selectTarget(target) {
+	state.target = target;
+	$("target").value = target;
+	history.replaceState(null, "", "?target=" + encodeURIComponent(target));
+	loadOverview();
+	refreshTarget();
+}
+
+$("target").onchange = (e) => selectTarget(e.target.value);
+$("window").onchange = (e) => { state.window = e.target.value; loadStatements(); };
+$("sort").onchange = (e) => { state.sort = e.target.value; loadStatements(); };
+
+(async function start() {
+	try {
+		await loadTargets();
+	} catch (err) {
+		failed("overview", err);
+	}
+	loadAlerts();
+	loadOverview();
+	refreshTarget();
+
+	setInterval(() => { loadAlerts(); loadOverview(); }, 10000);
+	setInterval(() => { if (state.target) loadActivity(); }, 5000);
+	setInterval(() => { loadTargets().catch(() => {}); if (state.target) { loadStatements(); loadLatest(); loadIndexes(); } }, 30000);
+})();
@@ -0,0 +1,140 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+// watermarkFile records, in the directory of a rollup tier, the time up to
+// which the finer tier was rolled up.
+const watermarkFile = "compacted"
+
+// rollups lists the rollup tiers along with the tier each is built from.
+var rollups = []struct{ to, from Resolution }{
+	{FiveMinutes, Raw},
+	{Hour, FiveMinutes},
+}
+
+// Compact rolls up the intervals that ended before now and removes the
+// segments past the retention of their tier. Snapshots may be appended
+// meanwhile.
+func (s *Store) Compact(now time.Time) error {
+	targets, err := s.Targets()
+	if err != nil {
+		return err
+	}
+
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+	for _, target := range targets {
+		for _, r := range rollups {
+			if err := s.rollup(target, r.to, r.from, now); err != nil {
+				return err
+			}
+		}
+		for _, r := range []Resolution{Raw, FiveMinutes, Hour} {
+			if err := s.prune(target, r, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rollup appends to tier r the last snapshot of each interval of tier src
+// that ended since the last compaction.
+func (s *Store) rollup(target string, r, src Resolution, now time.Time) error {
+	step := tiers[r].step
+	dir := s.tierDir(r, target)
+	done, err := readWatermark(dir)
+	if err != nil {
+		return err
+	}
+	// leave a late snapshot of the current interval some time to arrive
+	until := now.Add(-time.Minute).Truncate(step)
+	if !until.After(done) {
+		return nil
+	}
+
+	var (
+		last   *model.Model
+		bucket time.Time
+	)
+	flush := func() error {
+		if last == nil {
+			return nil
+		}
+		// instantaneous samples mean nothing for a whole interval
+		last.ASH = nil
+		return s.append(r, *last)
+	}
+	err = s.scan(src, target, done, until, func(m model.Model) error {
+		if !m.UpdatedAt.Before(until) {
+			return nil
+		}
+		if b := m.UpdatedAt.Truncate(step); !b.Equal(bucket) {
+			if err := flush(); err != nil {
+				return err
+			}
+			bucket = b
+		}
+		last = &m
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return writeWatermark(dir, until)
+}
+
+// prune removes the segments of tier r that only hold snapshots past its
+// retention.
+func (s *Store) prune(target string, r Resolution, now time.Time) error {
+	retention := s.opts.retention(r)
+	if retention <= 0 {
+		return nil
+	}
+	segs, err := segments(s.tierDir(r, target))
+	if err != nil {
+		return err
+	}
+	for _, seg := range segs {
+		if seg.start.Add(tiers[r].span).After(now.Add(-retention)) {
+			break
+		}
+		if err := os.Remove(seg.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readWatermark(dir string) (time.Time, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, watermarkFile))
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+}
+
+func writeWatermark(dir string, t time.Time) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, watermarkFile)
+	if err := ioutil.WriteFile(path+".tmp", []byte(t.UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,245 @@
+// Package history is an embedded time-series store of the snapshots of the
+// agent, for deployments that do not run a history database.
+//
+// Snapshots are kept raw for a few hours, then as 5 minute and 1 hour
+// rollups for weeks. Each tier is a directory of append-only segment files
+// per target, one JSON snapshot per line:
+//
+//	<dir>/raw/<target>/20060102T15Z.jsonl   one file per hour
+//	<dir>/5m/<target>/20060102T15Z.jsonl    one file per day
+//	<dir>/1h/<target>/20060102T15Z.jsonl    one file per week
+//
+// A rollup is the last snapshot of its interval. The counters of the
+// snapshots being cumulative, the activity between any two rollups is kept
+// exactly; the gauges are those of the end of the interval.
+//
+// The store is plain files rather than an embedded key-value database or
+// SQLite, neither being a dependency of the module: snapshots are only ever
+// appended and read back by time range, which segment files named after
+// their time span serve without an index.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/snapshot"
+	"gopkg.in/ini.v1"
+)
+
+// Resolution selects the tier snapshots are read from.
+type Resolution int
+
+const (
+	// Auto reads every tier, each covering the part of the time range the
+	// finer ones no longer hold.
+	Auto Resolution = iota
+	Raw
+	FiveMinutes
+	Hour
+)
+
+const (
+	DefaultRawRetention         = 24 * time.Hour
+	DefaultFiveMinutesRetention = 14 * 24 * time.Hour
+	DefaultHourRetention        = 90 * 24 * time.Hour
+)
+
+// ErrNotFound is returned when no snapshot matches.
+var ErrNotFound = errors.New("no snapshot found")
+
+// tier is a level of the store.
+type tier struct {
+	name string
+	// interval of the rollups, zero for the raw snapshots
+	step time.Duration
+	// time span of a segment file
+	span time.Duration
+}
+
+var tiers = map[Resolution]tier{
+	Raw:         {name: "raw", span: time.Hour},
+	FiveMinutes: {name: "5m", step: 5 * time.Minute, span: 24 * time.Hour},
+	Hour:        {name: "1h", step: time.Hour, span: 7 * 24 * time.Hour},
+}
+
+// Options sets how long each tier is kept. A zero retention keeps the tier
+// forever.
+type Options struct {
+	RawRetention         time.Duration
+	FiveMinutesRetention time.Duration
+	HourRetention        time.Duration
+}
+
+// LoadConfig reads the [HISTORY] section of f. dir is empty unless the store
+// is enabled.
+func LoadConfig(f *ini.File) (dir string, o Options) {
+	sec := f.Section("HISTORY")
+	o = Options{
+		RawRetention:         sec.Key("RAW_RETENTION").MustDuration(DefaultRawRetention),
+		FiveMinutesRetention: sec.Key("RETENTION_5M").MustDuration(DefaultFiveMinutesRetention),
+		HourRetention:        sec.Key("RETENTION_1H").MustDuration(DefaultHourRetention),
+	}
+	return sec.Key("DIR").String(), o
+}
+
+func (o Options) retention(r Resolution) time.Duration {
+	switch r {
+	case Raw:
+		return o.RawRetention
+	case FiveMinutes:
+		return o.FiveMinutesRetention
+	default:
+		return o.HourRetention
+	}
+}
+
+// Store is a history store rooted at a directory. It is safe for concurrent
+// use; several processes may read it while one writes.
+type Store struct {
+	dir  string
+	opts Options
+	// mu serializes the appends of raw snapshots, compactMu the compactions,
+	// which only write to the rollup tiers so that appends need not wait for
+	// them.
+	mu        sync.Mutex
+	compactMu sync.Mutex
+}
+
+// Open opens the store in dir, creating it if needed.
+func Open(dir string, opts Options) (*Store, error) {
+	for _, t := range tiers {
+		if err := os.MkdirAll(filepath.Join(dir, t.name), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &Store{dir: dir, opts: opts}, nil
+}
+
+func targetName(target string) string {
+	if target == "" {
+		return snapshot.DefaultTarget
+	}
+	return target
+}
+
+func (s *Store) tierDir(r Resolution, target string) string {
+	return filepath.Join(s.dir, tiers[r].name, targetName(target))
+}
+
+// Append stores a raw snapshot.
+func (s *Store) Append(m model.Model) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.append(Raw, m)
+}
+
+func (s *Store) append(r Resolution, m model.Model) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	dir := s.tierDir(r, m.Target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return appendLine(segmentPath(dir, tiers[r], m.UpdatedAt), data)
+}
+
+// Targets returns the targets that have snapshots in the store.
+func (s *Store) Targets() ([]string, error) {
+	seen := map[string]bool{}
+	for _, t := range tiers {
+		entries, err := ioutil.ReadDir(filepath.Join(s.dir, t.name))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				seen[e.Name()] = true
+			}
+		}
+	}
+
+	var targets []string
+	for t := range seen {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
+
+// Scan calls fn with the snapshots of target taken between from and to at
+// resolution r, oldest first, until fn returns an error. A zero from or to
+// leaves that end of the range open. Auto goes through the coarser tiers
+// first, each stopping where the next finer one starts.
+func (s *Store) Scan(target string, from, to time.Time, r Resolution, fn func(model.Model) error) error {
+	if r != Auto {
+		return s.scan(r, target, from, to, fn)
+	}
+
+	// the start of each tier, finest first, so that coarser tiers stop
+	// where finer ones take over
+	order := []Resolution{Raw, FiveMinutes, Hour}
+	ends := make([]time.Time, len(order))
+	end := to
+	for i, r := range order {
+		ends[i] = end
+		first, err := s.first(r, target)
+		if err != nil {
+			return err
+		}
+		if !first.IsZero() && (end.IsZero() || first.Before(end)) {
+			end = first
+		}
+	}
+
+	for i := len(order) - 1; i >= 0; i-- {
+		r, until := order[i], ends[i]
+		err := s.scan(r, target, from, until, func(m model.Model) error {
+			// finer tiers own their start
+			if r != Raw && !until.IsZero() && !m.UpdatedAt.Before(until) {
+				return nil
+			}
+			return fn(m)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query returns the snapshots Scan goes through.
+func (s *Store) Query(target string, from, to time.Time, r Resolution) ([]model.Model, error) {
+	var snapshots []model.Model
+	err := s.Scan(target, from, to, r, func(m model.Model) error {
+		snapshots = append(snapshots, m)
+		return nil
+	})
+	return snapshots, err
+}
+
+// At returns the last snapshot of target taken at or before t, in the finest
+// tier holding one. A zero t returns the latest snapshot.
+func (s *Store) At(target string, t time.Time) (model.Model, error) {
+	for _, r := range []Resolution{Raw, FiveMinutes, Hour} {
+		m, err := s.last(r, target, t)
+		if err == nil || err != ErrNotFound {
+			return m, err
+		}
+	}
+	return model.Model{}, ErrNotFound
+}
+
+// Latest returns the latest snapshot of target.
+func (s *Store) Latest(target string) (model.Model, error) {
+	return s.At(target, time.Time{})
+}
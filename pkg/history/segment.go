@@ -0,0 +1,184 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+const (
+	segmentTimeFormat = "20060102T15Z"
+	segmentExt        = ".jsonl"
+	// longest line read back, snapshots with long query texts are big
+	maxLineSize = 64 << 20
+)
+
+// segment is a segment file, holding the snapshots taken from start to
+// start + the span of its tier.
+type segment struct {
+	path  string
+	start time.Time
+}
+
+func segmentPath(dir string, t tier, at time.Time) string {
+	start := at.UTC().Truncate(t.span)
+	return filepath.Join(dir, start.Format(segmentTimeFormat)+segmentExt)
+}
+
+// segments returns the segments of dir, oldest first. A missing directory has
+// none.
+func segments(dir string) ([]segment, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var segs []segment
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		start, err := time.Parse(segmentTimeFormat, strings.TrimSuffix(name, segmentExt))
+		if err != nil {
+			continue
+		}
+		segs = append(segs, segment{path: filepath.Join(dir, name), start: start})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].start.Before(segs[j].start) })
+	return segs, nil
+}
+
+func appendLine(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	// a single write, so that readers never see half a line but at the end
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readSegment calls fn with the snapshots of a segment file. A last line that
+// is being written is skipped.
+func readSegment(path string, fn func(model.Model) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// removed by a compaction meanwhile
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, maxLineSize)
+	for sc.Scan() {
+		var m model.Model
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			continue
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// scan goes through the snapshots of target in tier r taken between from and
+// to.
+func (s *Store) scan(r Resolution, target string, from, to time.Time, fn func(model.Model) error) error {
+	t := tiers[r]
+	segs, err := segments(s.tierDir(r, target))
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range segs {
+		if !from.IsZero() && !seg.start.Add(t.span).After(from) || !to.IsZero() && seg.start.After(to) {
+			continue
+		}
+		err := readSegment(seg.path, func(m model.Model) error {
+			if !from.IsZero() && m.UpdatedAt.Before(from) || !to.IsZero() && m.UpdatedAt.After(to) {
+				return nil
+			}
+			return fn(m)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// first returns the time of the oldest snapshot of target in tier r, zero if
+// there is none.
+func (s *Store) first(r Resolution, target string) (time.Time, error) {
+	segs, err := segments(s.tierDir(r, target))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var first time.Time
+	for _, seg := range segs {
+		err := readSegment(seg.path, func(m model.Model) error {
+			first = m.UpdatedAt
+			return errStop
+		})
+		if err != nil && err != errStop {
+			return first, err
+		}
+		if !first.IsZero() {
+			break
+		}
+	}
+	return first, nil
+}
+
+// last returns the last snapshot of target in tier r taken at or before t,
+// or the latest one if t is zero.
+func (s *Store) last(r Resolution, target string, t time.Time) (model.Model, error) {
+	segs, err := segments(s.tierDir(r, target))
+	if err != nil {
+		return model.Model{}, err
+	}
+
+	for i := len(segs) - 1; i >= 0; i-- {
+		if !t.IsZero() && segs[i].start.After(t) {
+			continue
+		}
+		var found *model.Model
+		err := readSegment(segs[i].path, func(m model.Model) error {
+			if !t.IsZero() && m.UpdatedAt.After(t) {
+				return errStop
+			}
+			found = &m
+			return nil
+		})
+		if err != nil && err != errStop {
+			return model.Model{}, err
+		}
+		if found != nil {
+			return *found, nil
+		}
+	}
+	return model.Model{}, ErrNotFound
+}
+
+// errStop stops reading a segment early.
+var errStop = errors.New("stop")
@@ -0,0 +1,246 @@
+package logs
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+// maxSlowStatements is the number of slowest statements kept per window.
+const maxSlowStatements = 20
+
+var (
+	durationRe   = regexp.MustCompile(`(?s)^duration: ([\d.]+) ms\s+(?:statement|execute [^:]*|bind [^:]*|parse [^:]*): (.*)$`)
+	autovacuumRe = regexp.MustCompile(`^automatic (?:aggressive )?(vacuum|analyze)(?: to prevent wraparound)? of table "([^"]+)"`)
+	elapsedRe    = regexp.MustCompile(`elapsed: ([\d.]+) s`)
+	ckptStartRe  = regexp.MustCompile(`^(?:checkpoint|restartpoint) starting:(.*)$`)
+	ckptDoneRe   = regexp.MustCompile(`^(?:checkpoint|restartpoint) complete: wrote (\d+) buffers.*total=([\d.]+) s`)
+	lockWaitRe   = regexp.MustCompile(`still waiting for .* after [\d.]+ ms`)
+	tempFileRe   = regexp.MustCompile(`^temporary file: path "[^"]+", size (\d+)`)
+)
+
+type errorKey struct {
+	severity string
+	sqlState string
+}
+
+type maintenanceKey struct {
+	kind  string
+	table string
+}
+
+// bucket holds the aggregates of one minute of log entries.
+type bucket struct {
+	minute      time.Time
+	errors      map[errorKey]*model.LogErrorCount
+	slowCount   int
+	slow        []model.SlowStatement
+	maintenance map[maintenanceKey]*model.MaintenanceRuns
+	checkpoints model.CheckpointLogStats
+	lockWaits   int
+	deadlocks   int
+	tempFiles   int
+	tempBytes   int64
+}
+
+func newBucket(minute time.Time) *bucket {
+	return &bucket{
+		minute:      minute,
+		errors:      map[errorKey]*model.LogErrorCount{},
+		maintenance: map[maintenanceKey]*model.MaintenanceRuns{},
+	}
+}
+
+// Aggregator sums up log entries over a sliding window of whole minutes.
+type Aggregator struct {
+	span    time.Duration
+	buckets map[time.Time]*bucket
+}
+
+func NewAggregator(span time.Duration) *Aggregator {
+	return &Aggregator{span: span, buckets: map[time.Time]*bucket{}}
+}
+
+func (a *Aggregator) bucket(t time.Time) *bucket {
+	minute := t.Truncate(time.Minute)
+	b, ok := a.buckets[minute]
+	if !ok {
+		b = newBucket(minute)
+		a.buckets[minute] = b
+	}
+	return b
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// Add accounts for a log entry. Entries without a timestamp are accounted to
+// the current minute.
+func (a *Aggregator) Add(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b := a.bucket(e.Time)
+
+	if e.IsError() {
+		k := errorKey{e.Severity, e.SQLState}
+		c, ok := b.errors[k]
+		if !ok {
+			c = &model.LogErrorCount{Severity: e.Severity, SQLState: e.SQLState}
+			b.errors[k] = c
+		}
+		c.Count++
+		c.Example = e.Message
+
+		if e.SQLState == "40P01" || e.Message == "deadlock detected" {
+			b.deadlocks++
+		}
+		return
+	}
+
+	msg := e.Message
+	switch {
+	case strings.HasPrefix(msg, "duration: "):
+		if m := durationRe.FindStringSubmatch(msg); m != nil {
+			b.slowCount++
+			b.slow = append(b.slow, model.SlowStatement{
+				Time:       e.Time,
+				User:       e.User,
+				DBName:     e.Database,
+				DurationMs: parseFloat(m[1]),
+				Query:      m[2],
+			})
+			b.slow = topSlow(b.slow)
+		}
+	case strings.HasPrefix(msg, "automatic "):
+		if m := autovacuumRe.FindStringSubmatch(msg); m != nil {
+			k := maintenanceKey{m[1], m[2]}
+			r, ok := b.maintenance[k]
+			if !ok {
+				r = &model.MaintenanceRuns{Kind: m[1], Table: m[2]}
+				b.maintenance[k] = r
+			}
+			r.Count++
+			if el := elapsedRe.FindStringSubmatch(msg); el != nil {
+				s := parseFloat(el[1])
+				r.TotalSeconds += s
+				if s > r.MaxSeconds {
+					r.MaxSeconds = s
+				}
+			}
+		}
+	case ckptStartRe.MatchString(msg):
+		reason := ckptStartRe.FindStringSubmatch(msg)[1]
+		if strings.Contains(reason, "time") {
+			b.checkpoints.Timed++
+		} else {
+			b.checkpoints.Requested++
+		}
+	case ckptDoneRe.MatchString(msg):
+		m := ckptDoneRe.FindStringSubmatch(msg)
+		s := parseFloat(m[2])
+		buffers, _ := strconv.ParseInt(m[1], 10, 64)
+		b.checkpoints.Completed++
+		b.checkpoints.BuffersWritten += buffers
+		b.checkpoints.TotalSeconds += s
+		if s > b.checkpoints.MaxSeconds {
+			b.checkpoints.MaxSeconds = s
+		}
+	case lockWaitRe.MatchString(msg):
+		b.lockWaits++
+	case tempFileRe.MatchString(msg):
+		size, _ := strconv.ParseInt(tempFileRe.FindStringSubmatch(msg)[1], 10, 64)
+		b.tempFiles++
+		b.tempBytes += size
+	}
+}
+
+func topSlow(slow []model.SlowStatement) []model.SlowStatement {
+	sort.Slice(slow, func(i, j int) bool {
+		return slow[i].DurationMs > slow[j].DurationMs
+	})
+	if len(slow) > maxSlowStatements {
+		slow = slow[:maxSlowStatements]
+	}
+	return slow
+}
+
+// Summary merges the buckets of the window ending at now, and forgets the
+// ones that fell out of it.
+func (a *Aggregator) Summary(now time.Time) model.LogSummary {
+	start := now.Add(-a.span)
+	s := model.LogSummary{WindowStart: start, WindowEnd: now}
+
+	errors := map[errorKey]*model.LogErrorCount{}
+	maintenance := map[maintenanceKey]*model.MaintenanceRuns{}
+
+	for minute, b := range a.buckets {
+		if !minute.Add(time.Minute).After(start) {
+			delete(a.buckets, minute)
+			continue
+		}
+		// entries logged with a clock ahead of ours
+		if minute.After(now) {
+			continue
+		}
+
+		for k, c := range b.errors {
+			if e, ok := errors[k]; ok {
+				e.Count += c.Count
+				e.Example = c.Example
+			} else {
+				copied := *c
+				errors[k] = &copied
+			}
+		}
+		for k, r := range b.maintenance {
+			m, ok := maintenance[k]
+			if !ok {
+				m = &model.MaintenanceRuns{Kind: r.Kind, Table: r.Table}
+				maintenance[k] = m
+			}
+			m.Count += r.Count
+			m.TotalSeconds += r.TotalSeconds
+			if r.MaxSeconds > m.MaxSeconds {
+				m.MaxSeconds = r.MaxSeconds
+			}
+		}
+
+		s.SlowStatementCount += b.slowCount
+		s.SlowStatements = topSlow(append(s.SlowStatements, b.slow...))
+
+		s.Checkpoints.Timed += b.checkpoints.Timed
+		s.Checkpoints.Requested += b.checkpoints.Requested
+		s.Checkpoints.Completed += b.checkpoints.Completed
+		s.Checkpoints.BuffersWritten += b.checkpoints.BuffersWritten
+		s.Checkpoints.TotalSeconds += b.checkpoints.TotalSeconds
+		if b.checkpoints.MaxSeconds > s.Checkpoints.MaxSeconds {
+			s.Checkpoints.MaxSeconds = b.checkpoints.MaxSeconds
+		}
+
+		s.LockWaits += b.lockWaits
+		s.Deadlocks += b.deadlocks
+		s.TempFiles += b.tempFiles
+		s.TempFileBytes += b.tempBytes
+	}
+
+	for _, e := range errors {
+		s.Errors = append(s.Errors, *e)
+	}
+	sort.Slice(s.Errors, func(i, j int) bool {
+		return s.Errors[i].Count > s.Errors[j].Count
+	})
+	for _, m := range maintenance {
+		s.Maintenance = append(s.Maintenance, *m)
+	}
+	sort.Slice(s.Maintenance, func(i, j int) bool {
+		return s.Maintenance[i].TotalSeconds > s.Maintenance[j].TotalSeconds
+	})
+	return s
+}
@@ -0,0 +1,31 @@
+package logs
+
+import "time"
+
+// Entry is a single message of the Postgres server log, regardless of the
+// log_destination it was read from. Secondary lines of stderr logs (DETAIL,
+// HINT, CONTEXT, STATEMENT) are folded into the entry they belong to.
+type Entry struct {
+	Time        time.Time
+	PID         int
+	User        string
+	Database    string
+	Application string
+	Severity    string
+	SQLState    string
+	Message     string
+	Detail      string
+	Hint        string
+	Context     string
+	Statement   string
+	QueryID     int64
+}
+
+// IsError reports whether the entry is a warning or an error.
+func (e Entry) IsError() bool {
+	switch e.Severity {
+	case "WARNING", "ERROR", "FATAL", "PANIC":
+		return true
+	}
+	return false
+}
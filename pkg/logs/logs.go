@@ -0,0 +1,72 @@
+// Package logs reads the Postgres server log and aggregates it into the
+// figures published with each snapshot: errors, slow statements, autovacuum
+// runs, checkpoints, lock waits, deadlocks and temporary files.
+package logs
+
+import (
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+// Collector tails the server log and keeps the aggregates of its last span.
+type Collector struct {
+	tailer     *Tailer
+	aggregator *Aggregator
+}
+
+// NewCollector follows file, or every log file of dir if file is empty.
+// linePrefix is the server's log_line_prefix, used for stderr logs, and loc
+// its log_timezone, UTC if nil.
+func NewCollector(dir, file string, span time.Duration, linePrefix string, loc *time.Location) (*Collector, error) {
+	prefix, err := CompilePrefix(linePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Collector{
+		tailer:     NewTailer(dir, file, prefix, loc),
+		aggregator: NewAggregator(span),
+	}, nil
+}
+
+// Collect reads what was logged since the previous call and returns the
+// aggregates of the window ending now, along with the entries just read.
+func (c *Collector) Collect() (model.LogSummary, []Entry, error) {
+	entries, err := c.tailer.Poll()
+	for _, e := range entries {
+		c.aggregator.Add(e)
+	}
+	return c.aggregator.Summary(time.Now()), entries, err
+}
+
+// Read returns the aggregates of the span ending now of what the log files
+// hold, read from their start rather than tailed.
+func Read(dir, file string, span time.Duration, linePrefix string, loc *time.Location) (model.LogSummary, error) {
+	prefix, err := CompilePrefix(linePrefix)
+	if err != nil {
+		return model.LogSummary{}, err
+	}
+
+	t := NewTailer(dir, file, prefix, loc)
+	// the first poll would otherwise start at the end of the files
+	t.started = true
+	a := NewAggregator(span)
+	// a poll reads a bounded part of each file
+	for {
+		entries, err := t.Poll()
+		for _, e := range entries {
+			a.Add(e)
+		}
+		if err != nil {
+			return a.Summary(time.Now()), err
+		}
+		if !t.advanced {
+			break
+		}
+	}
+	for _, e := range t.Flush() {
+		a.Add(e)
+	}
+	return a.Summary(time.Now()), nil
+}
@@ -0,0 +1,240 @@
+package logs
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is the log_destination a log file was written by.
+type Format int
+
+const (
+	Stderr Format = iota
+	CSVLog
+	JSONLog
+)
+
+// FormatOf guesses the format of a log file from its extension, the way
+// Postgres names the files of each log_destination.
+func FormatOf(path string) Format {
+	switch filepath.Ext(path) {
+	case ".csv":
+		return CSVLog
+	case ".json":
+		return JSONLog
+	}
+	return Stderr
+}
+
+// decoder turns the complete lines of a log file into entries. Entries may
+// span several lines, so an entry is only returned once the line following it
+// has been seen, or on Flush.
+type decoder interface {
+	Feed(line string) []Entry
+	Flush() []Entry
+}
+
+func newDecoder(f Format, prefix *Prefix, loc *time.Location) decoder {
+	switch f {
+	case CSVLog:
+		return &csvDecoder{loc: loc}
+	case JSONLog:
+		return &jsonDecoder{loc: loc}
+	}
+	return &stderrDecoder{prefix: prefix, loc: loc}
+}
+
+type stderrDecoder struct {
+	prefix  *Prefix
+	loc     *time.Location
+	pending *Entry
+	// field the continuation lines are appended to
+	last *string
+}
+
+func (d *stderrDecoder) Feed(line string) []Entry {
+	e, ok := d.prefix.Parse(line, d.loc)
+	if !ok {
+		// continuation of a multi-line message starts with a tab
+		if d.last != nil {
+			*d.last += "\n" + strings.TrimPrefix(line, "\t")
+		}
+		return nil
+	}
+
+	if d.pending != nil {
+		var field *string
+		switch e.Severity {
+		case "DETAIL":
+			field = &d.pending.Detail
+		case "HINT":
+			field = &d.pending.Hint
+		case "CONTEXT":
+			field = &d.pending.Context
+		case "STATEMENT", "QUERY":
+			field = &d.pending.Statement
+		}
+		if field != nil && (e.PID == 0 || e.PID == d.pending.PID) {
+			*field = e.Message
+			d.last = field
+			return nil
+		}
+	}
+
+	out := d.Flush()
+	d.pending = &e
+	d.last = &d.pending.Message
+	return out
+}
+
+func (d *stderrDecoder) Flush() []Entry {
+	if d.pending == nil {
+		return nil
+	}
+	e := *d.pending
+	d.pending, d.last = nil, nil
+	return []Entry{e}
+}
+
+// csvlog columns, as documented for Postgres 14
+const (
+	csvLogTime = iota
+	csvUserName
+	csvDatabaseName
+	csvProcessID
+	csvConnectionFrom
+	csvSessionID
+	csvSessionLineNum
+	csvCommandTag
+	csvSessionStartTime
+	csvVirtualTransactionID
+	csvTransactionID
+	csvErrorSeverity
+	csvSQLStateCode
+	csvMessage
+	csvDetail
+	csvHint
+	csvInternalQuery
+	csvInternalQueryPos
+	csvContext
+	csvQuery
+	csvQueryPos
+	csvLocation
+	csvApplicationName
+	csvBackendType
+	csvLeaderPID
+	csvQueryID
+)
+
+type csvDecoder struct {
+	loc *time.Location
+	buf strings.Builder
+	// whether the buffered text ends inside a quoted field
+	quoted bool
+}
+
+func (d *csvDecoder) Feed(line string) []Entry {
+	if d.buf.Len() > 0 {
+		d.buf.WriteByte('\n')
+	}
+	d.buf.WriteString(line)
+	if strings.Count(line, `"`)%2 == 1 {
+		d.quoted = !d.quoted
+	}
+	if d.quoted {
+		return nil
+	}
+	return d.Flush()
+}
+
+func (d *csvDecoder) Flush() []Entry {
+	if d.buf.Len() == 0 {
+		return nil
+	}
+	r := csv.NewReader(strings.NewReader(d.buf.String()))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	d.buf.Reset()
+	d.quoted = false
+
+	rec, err := r.Read()
+	if err != nil || len(rec) <= csvApplicationName {
+		return nil
+	}
+
+	field := func(i int) string {
+		if i < len(rec) {
+			return rec[i]
+		}
+		return ""
+	}
+
+	e := Entry{
+		User:        field(csvUserName),
+		Database:    field(csvDatabaseName),
+		Application: field(csvApplicationName),
+		Severity:    field(csvErrorSeverity),
+		SQLState:    field(csvSQLStateCode),
+		Message:     field(csvMessage),
+		Detail:      field(csvDetail),
+		Hint:        field(csvHint),
+		Context:     field(csvContext),
+		Statement:   field(csvQuery),
+	}
+	e.Time, _ = parseLogTime(field(csvLogTime), d.loc)
+	e.PID, _ = strconv.Atoi(field(csvProcessID))
+	e.QueryID, _ = strconv.ParseInt(field(csvQueryID), 10, 64)
+	return []Entry{e}
+}
+
+type jsonDecoder struct {
+	loc *time.Location
+}
+
+type jsonLogLine struct {
+	Timestamp   string `json:"timestamp"`
+	User        string `json:"user"`
+	DBName      string `json:"dbname"`
+	PID         int    `json:"pid"`
+	Severity    string `json:"error_severity"`
+	StateCode   string `json:"state_code"`
+	Message     string `json:"message"`
+	Detail      string `json:"detail"`
+	Hint        string `json:"hint"`
+	Context     string `json:"context"`
+	Statement   string `json:"statement"`
+	Application string `json:"application_name"`
+	QueryID     int64  `json:"query_id"`
+}
+
+func (d *jsonDecoder) Feed(line string) []Entry {
+	var l jsonLogLine
+	if err := json.Unmarshal([]byte(line), &l); err != nil {
+		return nil
+	}
+
+	e := Entry{
+		PID:         l.PID,
+		User:        l.User,
+		Database:    l.DBName,
+		Application: l.Application,
+		Severity:    l.Severity,
+		SQLState:    l.StateCode,
+		Message:     l.Message,
+		Detail:      l.Detail,
+		Hint:        l.Hint,
+		Context:     l.Context,
+		Statement:   l.Statement,
+		QueryID:     l.QueryID,
+	}
+	e.Time, _ = parseLogTime(l.Timestamp, d.loc)
+	return []Entry{e}
+}
+
+func (d *jsonDecoder) Flush() []Entry {
+	return nil
+}
@@ -0,0 +1,105 @@
+package logs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var planRe = regexp.MustCompile(`(?s)^duration: ([\d.]+) ms\s+plan:\s*(\{.*\})\s*$`)
+
+// Plan is an execution plan logged by auto_explain with
+// auto_explain.log_format = json.
+type Plan struct {
+	Time       time.Time
+	User       string
+	Database   string
+	DurationMs float64
+	QueryText  string
+	// QueryID is only logged by Postgres 14 and later with
+	// auto_explain.log_verbose and compute_query_id enabled.
+	QueryID int64
+	// Shape describes the plan tree: node types, the relations and indexes
+	// they access and how they are joined, but none of the estimates or
+	// actual figures.
+	Shape       string
+	Fingerprint string
+}
+
+type planNode struct {
+	NodeType     string     `json:"Node Type"`
+	Strategy     string     `json:"Strategy"`
+	JoinType     string     `json:"Join Type"`
+	RelationName string     `json:"Relation Name"`
+	IndexName    string     `json:"Index Name"`
+	CTEName      string     `json:"CTE Name"`
+	Plans        []planNode `json:"Plans"`
+}
+
+type explainOutput struct {
+	QueryText string   `json:"Query Text"`
+	QueryID   int64    `json:"Query Identifier"`
+	Plan      planNode `json:"Plan"`
+}
+
+// ParsePlan extracts the plan logged in e, if e is an auto_explain entry in
+// JSON format.
+func ParsePlan(e Entry) (Plan, bool) {
+	m := planRe.FindStringSubmatch(e.Message)
+	if m == nil {
+		return Plan{}, false
+	}
+
+	var out explainOutput
+	if err := json.Unmarshal([]byte(m[2]), &out); err != nil || out.Plan.NodeType == "" {
+		return Plan{}, false
+	}
+
+	p := Plan{
+		Time:       e.Time,
+		User:       e.User,
+		Database:   e.Database,
+		DurationMs: parseFloat(m[1]),
+		QueryText:  out.QueryText,
+		QueryID:    out.QueryID,
+	}
+	if p.QueryID == 0 {
+		p.QueryID = e.QueryID
+	}
+
+	var b strings.Builder
+	writeShape(&b, out.Plan)
+	p.Shape = b.String()
+
+	sum := sha256.Sum256([]byte(p.Shape))
+	p.Fingerprint = hex.EncodeToString(sum[:8])
+	return p, true
+}
+
+func writeShape(b *strings.Builder, n planNode) {
+	b.WriteString(n.NodeType)
+
+	var attrs []string
+	for _, a := range []string{n.Strategy, n.JoinType, n.RelationName, n.IndexName, n.CTEName} {
+		if a != "" {
+			attrs = append(attrs, a)
+		}
+	}
+	if len(attrs) > 0 {
+		b.WriteString("[" + strings.Join(attrs, ",") + "]")
+	}
+
+	if len(n.Plans) > 0 {
+		b.WriteString("(")
+		for i, c := range n.Plans {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			writeShape(b, c)
+		}
+		b.WriteString(")")
+	}
+}
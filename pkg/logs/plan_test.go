@@ -0,0 +1,134 @@
+package logs
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const hashJoinPlan = `duration: 12.345 ms  plan:
+	{
+	  "Query Text": "select * from orders o join customers c on c.id = o.customer_id where o.id = $1",
+	  "Query Identifier": 4242,
+	  "Plan": {
+	    "Node Type": "Hash Join",
+	    "Join Type": "Inner",
+	    "Startup Cost": 1.05,
+	    "Total Cost": 42.10,
+	    "Plans": [
+	      {"Node Type": "Index Scan", "Relation Name": "orders", "Index Name": "orders_pkey", "Total Cost": 8.3},
+	      {"Node Type": "Hash", "Plans": [{"Node Type": "Seq Scan", "Relation Name": "customers", "Plan Rows": 100}]}
+	    ]
+	  }
+	}`
+
+func TestParsePlan(t *testing.T) {
+	at := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		entry       Entry
+		ok          bool
+		shape       string
+		durationMs  float64
+		queryID     int64
+		queryPrefix string
+	}{
+		{
+			name:        "nested plan",
+			entry:       Entry{Time: at, User: "app", Database: "shop", Message: hashJoinPlan},
+			ok:          true,
+			shape:       "Hash Join[Inner](Index Scan[orders,orders_pkey],Hash(Seq Scan[customers]))",
+			durationMs:  12.345,
+			queryID:     4242,
+			queryPrefix: "select * from orders",
+		},
+		{
+			name: "aggregate and CTE",
+			entry: Entry{QueryID: 7, Message: `duration: 3 ms  plan:
+{"Query Text": "with x as (select 1) select count(*) from x",
+ "Plan": {"Node Type": "Aggregate", "Strategy": "Plain",
+  "Plans": [{"Node Type": "CTE Scan", "CTE Name": "x"}]}}`},
+			ok:          true,
+			shape:       "Aggregate[Plain](CTE Scan[x])",
+			durationMs:  3,
+			queryID:     7,
+			queryPrefix: "with x",
+		},
+		{
+			name:  "text format",
+			entry: Entry{Message: "duration: 1.2 ms  plan:\nQuery Text: select 1\nResult  (cost=0.00..0.01 rows=1 width=4)"},
+		},
+		{
+			name:  "invalid JSON",
+			entry: Entry{Message: `duration: 1.2 ms  plan: {"Plan": {"Node Type": "Result"}`},
+		},
+		{
+			name:  "no plan",
+			entry: Entry{Message: `duration: 1.2 ms  plan: {"Query Text": "select 1"}`},
+		},
+		{
+			name:  "statement duration",
+			entry: Entry{Message: "duration: 1.2 ms  statement: select 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := ParsePlan(tt.entry)
+			if ok != tt.ok {
+				t.Fatalf("ParsePlan ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if p.Shape != tt.shape {
+				t.Errorf("Shape = %q, want %q", p.Shape, tt.shape)
+			}
+			if p.DurationMs != tt.durationMs {
+				t.Errorf("DurationMs = %v, want %v", p.DurationMs, tt.durationMs)
+			}
+			if p.QueryID != tt.queryID {
+				t.Errorf("QueryID = %d, want %d", p.QueryID, tt.queryID)
+			}
+			if !strings.HasPrefix(p.QueryText, tt.queryPrefix) {
+				t.Errorf("QueryText = %q, want it to start with %q", p.QueryText, tt.queryPrefix)
+			}
+			if !p.Time.Equal(tt.entry.Time) || p.User != tt.entry.User || p.Database != tt.entry.Database {
+				t.Errorf("entry fields not carried over: %+v", p)
+			}
+			if len(p.Fingerprint) != 16 {
+				t.Errorf("Fingerprint = %q, want 16 hex characters", p.Fingerprint)
+			}
+		})
+	}
+}
+
+func TestPlanFingerprint(t *testing.T) {
+	parse := func(msg string) Plan {
+		t.Helper()
+		p, ok := ParsePlan(Entry{Message: msg})
+		if !ok {
+			t.Fatalf("could not parse %s", msg)
+		}
+		return p
+	}
+
+	base := parse(`duration: 5 ms plan: {"Plan": {"Node Type": "Index Scan", "Relation Name": "t", "Index Name": "t_a_idx", "Total Cost": 8.3, "Actual Rows": 1}}`)
+
+	// estimates and actual figures are not part of the shape
+	same := parse(`duration: 900 ms plan: {"Plan": {"Node Type": "Index Scan", "Relation Name": "t", "Index Name": "t_a_idx", "Total Cost": 1200, "Actual Rows": 50000}}`)
+	if same.Fingerprint != base.Fingerprint {
+		t.Errorf("plans differing in figures only have fingerprints %s and %s", base.Fingerprint, same.Fingerprint)
+	}
+
+	for _, msg := range []string{
+		`duration: 5 ms plan: {"Plan": {"Node Type": "Index Scan", "Relation Name": "t", "Index Name": "t_b_idx"}}`,
+		`duration: 5 ms plan: {"Plan": {"Node Type": "Seq Scan", "Relation Name": "t"}}`,
+		`duration: 5 ms plan: {"Plan": {"Node Type": "Bitmap Heap Scan", "Relation Name": "t", "Plans": [{"Node Type": "Bitmap Index Scan", "Index Name": "t_a_idx"}]}}`,
+	} {
+		if p := parse(msg); p.Fingerprint == base.Fingerprint {
+			t.Errorf("plan %s has the fingerprint of %s", p.Shape, base.Shape)
+		}
+	}
+}
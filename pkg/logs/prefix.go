@@ -0,0 +1,169 @@
+package logs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLinePrefix is the default log_line_prefix since Postgres 10.
+const DefaultLinePrefix = "%m [%p] "
+
+// prefixEscapes maps the log_line_prefix escapes to the pattern matching
+// their expansion. Escapes with a name are captured into the entry.
+var prefixEscapes = map[byte]struct {
+	name    string
+	pattern string
+}{
+	'a': {"application", `.*?`},
+	'u': {"user", `.*?`},
+	'd': {"database", `.*?`},
+	'r': {"", `\S*`},
+	'h': {"", `\S*`},
+	'b': {"", `.*?`},
+	'p': {"pid", `\d+`},
+	'P': {"", `\d*`},
+	't': {"time", `\d{4}-\d\d-\d\d \d\d:\d\d:\d\d(?: \S+)?`},
+	'm': {"time", `\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\.\d+(?: \S+)?`},
+	'n': {"epoch", `\d+(?:\.\d+)?`},
+	'i': {"", `.*?`},
+	'e': {"sqlstate", `[0-9A-Z]{5}`},
+	'c': {"", `[0-9a-f]+\.[0-9a-f]+`},
+	'l': {"", `\d+`},
+	's': {"", `\d{4}-\d\d-\d\d \d\d:\d\d:\d\d(?: \S+)?`},
+	'v': {"", `\S*`},
+	'x': {"", `\d+`},
+	'Q': {"queryid", `-?\d+`},
+}
+
+// Prefix parses the lines of a stderr log written with a given
+// log_line_prefix.
+type Prefix struct {
+	re *regexp.Regexp
+}
+
+// CompilePrefix builds a parser for log_line_prefix. The part of the prefix
+// following %q is optional, as Postgres omits it for non-session processes.
+func CompilePrefix(prefix string) (*Prefix, error) {
+	var b strings.Builder
+	b.WriteString(`^`)
+
+	seen := map[string]bool{}
+	optional := false
+
+	for i := 0; i < len(prefix); i++ {
+		c := prefix[i]
+		if c != '%' {
+			b.WriteString(regexp.QuoteMeta(string(c)))
+			continue
+		}
+
+		// skip the padding, e.g. %-10u
+		i++
+		for i < len(prefix) && (prefix[i] == '-' || (prefix[i] >= '0' && prefix[i] <= '9')) {
+			i++
+		}
+		if i >= len(prefix) {
+			break
+		}
+
+		switch esc := prefix[i]; esc {
+		case '%':
+			b.WriteString(`%`)
+		case 'q':
+			if !optional {
+				b.WriteString(`(?:`)
+				optional = true
+			}
+		default:
+			e, ok := prefixEscapes[esc]
+			if !ok {
+				return nil, fmt.Errorf("unsupported escape %%%c in log_line_prefix", esc)
+			}
+			b.WriteString(`\s*`)
+			if e.name != "" && !seen[e.name] {
+				seen[e.name] = true
+				fmt.Fprintf(&b, `(?P<%s>%s)`, e.name, e.pattern)
+			} else {
+				fmt.Fprintf(&b, `(?:%s)`, e.pattern)
+			}
+		}
+	}
+	if optional {
+		b.WriteString(`)?`)
+	}
+	b.WriteString(`(?P<severity>[A-Z][A-Z0-9]*):\s+(?P<message>.*)$`)
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, err
+	}
+	return &Prefix{re: re}, nil
+}
+
+// numeric offsets first, as MST takes +05 for an abbreviation of no offset
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999 -07",
+	"2006-01-02 15:04:05.999999999 MST",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// parseLogTime parses a time of the log, written in the server's
+// log_timezone loc: zone abbreviations such as CEST only have an offset in
+// the location they are used in, and times without a zone are local to it.
+func parseLogTime(s string, loc *time.Location) (time.Time, bool) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Parse splits a log line into its prefix fields, severity and message, the
+// times being in log_timezone loc. ok is false if the line does not start
+// with the prefix, which is the case for the continuation lines of
+// multi-line messages.
+func (p *Prefix) Parse(line string, loc *time.Location) (e Entry, ok bool) {
+	match := p.re.FindStringSubmatch(line)
+	if match == nil {
+		return e, false
+	}
+
+	for i, name := range p.re.SubexpNames() {
+		v := match[i]
+		if v == "" {
+			continue
+		}
+		switch name {
+		case "application":
+			e.Application = v
+		case "user":
+			e.User = v
+		case "database":
+			e.Database = v
+		case "pid":
+			e.PID, _ = strconv.Atoi(v)
+		case "time":
+			e.Time, _ = parseLogTime(v, loc)
+		case "epoch":
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				e.Time = time.Unix(0, int64(f*float64(time.Second)))
+			}
+		case "sqlstate":
+			e.SQLState = v
+		case "queryid":
+			e.QueryID, _ = strconv.ParseInt(v, 10, 64)
+		case "severity":
+			e.Severity = v
+		case "message":
+			e.Message = v
+		}
+	}
+	return e, true
+}
@@ -0,0 +1,181 @@
+package logs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// maxReadPerPoll caps how much of a single file is read per poll, so that a
+// burst of logging does not stall the agent.
+const maxReadPerPoll = 16 << 20
+
+type tailedFile struct {
+	info    os.FileInfo
+	offset  int64
+	partial []byte
+	dec     decoder
+}
+
+// Tailer follows the log files of a directory, or a single log file, and
+// decodes the lines appended to them since the previous poll. Files that
+// exist when the tailer starts are read from their end, files that show up
+// later (after a rotation) from their beginning. A file that was truncated or
+// replaced under the same name is read again from the beginning.
+//
+// Entries may span several lines, which a poll can split: the decoders keep
+// the entry being read across polls, and only let it go once the file was
+// rotated, removed, or did not grow for a whole poll.
+type Tailer struct {
+	dir    string
+	file   string
+	prefix *Prefix
+	// log_timezone of the server
+	loc   *time.Location
+	files map[string]*tailedFile
+	// whether the first poll has happened
+	started bool
+	// whether the last poll read anything
+	advanced bool
+}
+
+// NewTailer tails file if it is set, or else every *.log, *.csv and *.json
+// file of dir. loc is the log_timezone of the server, UTC if nil.
+func NewTailer(dir, file string, prefix *Prefix, loc *time.Location) *Tailer {
+	return &Tailer{
+		dir:    dir,
+		file:   file,
+		prefix: prefix,
+		loc:    loc,
+		files:  map[string]*tailedFile{},
+	}
+}
+
+func (t *Tailer) paths() ([]string, error) {
+	if t.file != "" {
+		return []string{t.file}, nil
+	}
+
+	var paths []string
+	for _, pattern := range []string{"*.log", "*.csv", "*.json"} {
+		matches, err := filepath.Glob(filepath.Join(t.dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// Poll returns the entries appended to the log files since the last poll.
+func (t *Tailer) Poll() ([]Entry, error) {
+	paths, err := t.paths()
+	if err != nil {
+		return nil, err
+	}
+
+	type stat struct {
+		path string
+		info os.FileInfo
+	}
+	var stats []stat
+	seen := map[string]bool{}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		stats = append(stats, stat{p, info})
+		seen[p] = true
+	}
+	// read older files first so entries come out roughly in order
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].info.ModTime().Before(stats[j].info.ModTime())
+	})
+
+	t.advanced = false
+	var entries []Entry
+	for p, f := range t.files {
+		if !seen[p] {
+			entries = append(entries, f.dec.Flush()...)
+			delete(t.files, p)
+		}
+	}
+
+	for _, s := range stats {
+		f, ok := t.files[s.path]
+		switch {
+		case !ok:
+			f = &tailedFile{dec: newDecoder(FormatOf(s.path), t.prefix, t.loc)}
+			if !t.started {
+				f.offset = s.info.Size()
+			}
+			t.files[s.path] = f
+		case !os.SameFile(f.info, s.info) || s.info.Size() < f.offset:
+			entries = append(entries, f.dec.Flush()...)
+			f.offset = 0
+			f.partial = nil
+			f.dec = newDecoder(FormatOf(s.path), t.prefix, t.loc)
+		}
+		f.info = s.info
+
+		if s.info.Size() == f.offset {
+			// the entry being read is complete once the file stops growing
+			entries = append(entries, f.dec.Flush()...)
+			continue
+		}
+		read, err := t.read(s.path, f)
+		if err != nil {
+			return entries, err
+		}
+		t.advanced = true
+		entries = append(entries, read...)
+	}
+
+	t.started = true
+	return entries, nil
+}
+
+func (t *Tailer) read(path string, f *tailedFile) ([]Entry, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	if _, err := fd.Seek(f.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(fd, maxReadPerPoll))
+	if err != nil {
+		return nil, err
+	}
+	f.offset += int64(len(data))
+
+	data = append(f.partial, data...)
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		f.partial = data
+		return nil, nil
+	}
+	f.partial = append([]byte(nil), data[end+1:]...)
+
+	var entries []Entry
+	for _, line := range bytes.Split(data[:end], []byte{'\n'}) {
+		entries = append(entries, f.dec.Feed(string(bytes.TrimSuffix(line, []byte{'\r'})))...)
+	}
+	return entries, nil
+}
+
+// Flush returns the entries still being read, for when the files are not
+// polled again.
+func (t *Tailer) Flush() []Entry {
+	var entries []Entry
+	for _, f := range t.files {
+		entries = append(entries, f.dec.Flush()...)
+	}
+	return entries
+}
@@ -0,0 +1,190 @@
+package notify
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+type group struct {
+	labels map[string]string
+	// alerts by ID; resolved alerts are dropped once sent
+	alerts  map[string]model.Alert
+	created time.Time
+	// firing alerts last sent, to send only changes and repeats
+	lastKey  string
+	lastSent time.Time
+	// alerts whose firing was sent, the only ones whose resolution is sent
+	notified map[string]bool
+}
+
+// key identifies the firing alerts of the group.
+func (g *group) key() string {
+	var ids []string
+	for id, a := range g.alerts {
+		if a.Status != "resolved" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func (g *group) notification() Notification {
+	n := Notification{Status: "resolved", GroupLabels: g.labels}
+	for _, a := range g.alerts {
+		if a.Status != "resolved" {
+			n.Status = "firing"
+		}
+		n.Alerts = append(n.Alerts, a)
+	}
+	sort.Slice(n.Alerts, func(i, j int) bool {
+		return n.Alerts[i].StartsAt.Before(n.Alerts[j].StartsAt)
+	})
+	return n
+}
+
+// Dispatcher groups the alerts it is given and sends them to the sinks.
+type Dispatcher struct {
+	config Config
+	groups map[string]*group
+
+	state     *State
+	stateTime time.Time
+}
+
+func NewDispatcher(c Config) *Dispatcher {
+	return &Dispatcher{config: c, groups: map[string]*group{}, state: &State{}}
+}
+
+// Add queues alerts that started firing or got resolved.
+func (d *Dispatcher) Add(alerts []model.Alert, now time.Time) {
+	for _, a := range alerts {
+		labels := map[string]string{}
+		for _, k := range d.config.GroupBy {
+			labels[k] = a.Labels[k]
+		}
+		k := labelsString(labels)
+
+		g, ok := d.groups[k]
+		if !ok {
+			if a.Status == "resolved" {
+				// never notified, e.g. muted while firing
+				continue
+			}
+			g = &group{labels: labels, alerts: map[string]model.Alert{}, created: now, notified: map[string]bool{}}
+			d.groups[k] = g
+		}
+		g.alerts[a.ID] = a
+	}
+}
+
+func labelsString(labels map[string]string) string {
+	var pairs []string
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// reloadState rereads the state file when it changed, so that the silences
+// managed from the command line apply to a running agent.
+func (d *Dispatcher) reloadState() {
+	info, err := os.Stat(d.config.StateFile)
+	if err != nil || !info.ModTime().After(d.stateTime) {
+		return
+	}
+
+	state, err := LoadState(d.config.StateFile)
+	if err != nil {
+		log.Printf("could not load notification state: %s\n", err)
+		return
+	}
+	d.state = state
+	d.stateTime = info.ModTime()
+}
+
+// Flush sends the groups that changed since they were last sent, once they
+// waited for GroupWait, and those still firing after RepeatInterval. Muted
+// alerts are left out. With force, groups are sent without waiting.
+func (d *Dispatcher) Flush(now time.Time, force bool) {
+	d.reloadState()
+
+	for k, g := range d.groups {
+		if !force && now.Sub(g.created) < d.config.GroupWait {
+			continue
+		}
+
+		unmuted := &group{labels: g.labels, alerts: map[string]model.Alert{}}
+		resolved := false
+		for id, a := range g.alerts {
+			if a.Status == "resolved" && !g.notified[id] || d.state.Muted(a.Labels, now) {
+				continue
+			}
+			unmuted.alerts[id] = a
+			resolved = resolved || a.Status == "resolved"
+		}
+
+		key := unmuted.key()
+		repeat := key != "" && now.Sub(g.lastSent) >= d.config.RepeatInterval
+		if key != g.lastKey || resolved || repeat {
+			if len(unmuted.alerts) > 0 {
+				d.send(unmuted.notification())
+				g.lastSent = now
+			}
+			g.lastKey = key
+		}
+
+		for id, a := range g.alerts {
+			if a.Status == "resolved" {
+				delete(g.alerts, id)
+				delete(g.notified, id)
+			} else if _, ok := unmuted.alerts[id]; ok {
+				g.notified[id] = true
+			}
+		}
+		if len(g.alerts) == 0 {
+			delete(d.groups, k)
+		}
+	}
+}
+
+func (d *Dispatcher) send(n Notification) {
+	for _, s := range d.config.Sinks {
+		if err := s.Send(n); err != nil {
+			log.Printf("could not send notification to %s: %s\n", s.Name(), err)
+		}
+	}
+}
+
+// Test sends a test notification to every sink and returns the errors by
+// sink name.
+func Test(c Config) map[string]error {
+	now := time.Now()
+	n := Notification{
+		Status:      "firing",
+		GroupLabels: map[string]string{"alertname": "test"},
+		Alerts: []model.Alert{{
+			ID:       "test",
+			Name:     "test",
+			Status:   "firing",
+			Severity: "critical",
+			Labels:   map[string]string{"alertname": "test", "severity": "critical"},
+			Summary:  "test notification from pg-monitoring",
+			Expr:     "true",
+			Value:    1,
+			StartsAt: now,
+		}},
+	}
+
+	errs := map[string]error{}
+	for _, s := range c.Sinks {
+		errs[s.Name()] = s.Send(n)
+	}
+	return errs
+}
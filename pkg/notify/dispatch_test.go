@@ -0,0 +1,244 @@
+package notify
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+// recorder is a sink keeping what it is sent.
+type recorder struct {
+	sent []Notification
+}
+
+func (r *recorder) Name() string { return "recorder" }
+
+func (r *recorder) Send(n Notification) error {
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func testAlert(id, name, target, status string) model.Alert {
+	return model.Alert{
+		ID:       id,
+		Name:     name,
+		Status:   status,
+		Severity: "warning",
+		Target:   target,
+		Labels:   map[string]string{"alertname": name, "target": target},
+	}
+}
+
+func newTestDispatcher() (*Dispatcher, *recorder) {
+	r := &recorder{}
+	d := NewDispatcher(Config{
+		GroupBy:        []string{"alertname"},
+		GroupWait:      30 * time.Second,
+		RepeatInterval: time.Hour,
+		Sinks:          []Sink{r},
+	})
+	return d, r
+}
+
+// summary is the status and alert IDs of a notification.
+func summary(n Notification) string {
+	var alerts []string
+	for _, a := range n.Alerts {
+		alerts = append(alerts, a.ID+":"+a.Status)
+	}
+	sort.Strings(alerts)
+	return strings.Join(append([]string{n.Status}, alerts...), " ")
+}
+
+func TestDispatcherGrouping(t *testing.T) {
+	d, r := newTestDispatcher()
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	d.Add([]model.Alert{
+		testAlert("lag-db1", "lag", "db1", "firing"),
+		testAlert("lag-db2", "lag", "db2", "firing"),
+		testAlert("conn-db1", "connections", "db1", "firing"),
+	}, t0)
+
+	d.Flush(t0.Add(10*time.Second), false)
+	if len(r.sent) != 0 {
+		t.Fatalf("sent %d notifications before the group wait", len(r.sent))
+	}
+
+	d.Flush(t0.Add(30*time.Second), false)
+	if len(r.sent) != 2 {
+		t.Fatalf("sent %d notifications, want one per alertname", len(r.sent))
+	}
+	byName := map[string]Notification{}
+	for _, n := range r.sent {
+		byName[n.GroupLabels["alertname"]] = n
+	}
+	if got := len(byName["lag"].Alerts); got != 2 {
+		t.Errorf("lag group holds %d alerts, want 2", got)
+	}
+	if got := len(byName["connections"].Alerts); got != 1 {
+		t.Errorf("connections group holds %d alerts, want 1", got)
+	}
+}
+
+func TestDispatcherFlush(t *testing.T) {
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	firing := testAlert("a1", "lag", "db1", "firing")
+	resolved := testAlert("a1", "lag", "db1", "resolved")
+	other := testAlert("a2", "lag", "db2", "firing")
+
+	type step struct {
+		add   []model.Alert
+		after time.Duration
+		force bool
+		// notifications sent by the flush, by summary
+		want []string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "sent once",
+			steps: []step{
+				{add: []model.Alert{firing}},
+				{after: 30 * time.Second, want: []string{"firing a1:firing"}},
+				{add: []model.Alert{firing}, after: time.Minute},
+				{after: 30 * time.Minute},
+			},
+		},
+		{
+			name: "repeated while firing",
+			steps: []step{
+				{add: []model.Alert{firing}},
+				{after: 30 * time.Second, want: []string{"firing a1:firing"}},
+				{after: 30*time.Second + 59*time.Minute},
+				{after: 30*time.Second + time.Hour, want: []string{"firing a1:firing"}},
+			},
+		},
+		{
+			name: "new alert of the group",
+			steps: []step{
+				{add: []model.Alert{firing}},
+				{after: 30 * time.Second, want: []string{"firing a1:firing"}},
+				{add: []model.Alert{other}, after: time.Minute, want: []string{"firing a1:firing a2:firing"}},
+			},
+		},
+		{
+			name: "resolved",
+			steps: []step{
+				{add: []model.Alert{firing}},
+				{after: 30 * time.Second, want: []string{"firing a1:firing"}},
+				{add: []model.Alert{resolved}, after: time.Minute, want: []string{"resolved a1:resolved"}},
+				{after: 2 * time.Hour},
+			},
+		},
+		{
+			name: "resolved before sent",
+			steps: []step{
+				{add: []model.Alert{firing}, after: 10 * time.Second},
+				{add: []model.Alert{resolved}, after: 35 * time.Second},
+				{after: time.Hour},
+			},
+		},
+		{
+			name: "resolved never seen firing",
+			steps: []step{
+				{add: []model.Alert{resolved}, force: true},
+			},
+		},
+		{
+			name: "forced",
+			steps: []step{
+				{add: []model.Alert{firing}, force: true, want: []string{"firing a1:firing"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, r := newTestDispatcher()
+			for i, s := range tt.steps {
+				now := t0.Add(s.after)
+				if s.add != nil {
+					d.Add(s.add, now)
+				}
+				r.sent = nil
+				d.Flush(now, s.force)
+
+				var got []string
+				for _, n := range r.sent {
+					got = append(got, summary(n))
+				}
+				if fmt.Sprint(got) != fmt.Sprint(s.want) {
+					t.Errorf("step %d: sent %q, want %q", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatcherMuted(t *testing.T) {
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	d, r := newTestDispatcher()
+	d.state = &State{Silences: []Silence{{
+		ID:       "s1",
+		Matchers: map[string]string{"target": "db1"},
+		StartsAt: t0,
+		EndsAt:   t0.Add(time.Hour),
+	}}}
+
+	d.Add([]model.Alert{
+		testAlert("a1", "lag", "db1", "firing"),
+		testAlert("a2", "lag", "db2", "firing"),
+	}, t0)
+	d.Flush(t0.Add(time.Minute), false)
+	if len(r.sent) != 1 || summary(r.sent[0]) != "firing a2:firing" {
+		t.Fatalf("sent %v, want the unsilenced alert only", r.sent)
+	}
+
+	// the resolution of an alert muted while firing is not sent
+	r.sent = nil
+	d.Add([]model.Alert{testAlert("a1", "lag", "db1", "resolved")}, t0.Add(2*time.Minute))
+	d.Flush(t0.Add(2*time.Minute), false)
+	if len(r.sent) != 0 {
+		t.Errorf("sent %v, want nothing", r.sent)
+	}
+
+	// once the silence ends, the alerts still firing are sent
+	r.sent = nil
+	d.Add([]model.Alert{testAlert("a3", "lag", "db1", "firing")}, t0.Add(50*time.Minute))
+	d.Flush(t0.Add(61*time.Minute), false)
+	if len(r.sent) != 1 || summary(r.sent[0]) != "firing a2:firing a3:firing" {
+		t.Errorf("sent %v after the silence", r.sent)
+	}
+}
+
+func TestSeverityFilter(t *testing.T) {
+	r := &recorder{}
+	s := filtered(r, "critical")
+
+	n := Notification{Status: "firing", Alerts: []model.Alert{
+		{ID: "w", Status: "firing", Severity: "warning"},
+		{ID: "c", Status: "firing", Severity: "critical"},
+	}}
+	if err := s.Send(n); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.sent) != 1 || summary(r.sent[0]) != "firing c:firing" {
+		t.Errorf("sent %v, want the critical alert only", r.sent)
+	}
+
+	r.sent = nil
+	n.Alerts = n.Alerts[:1]
+	if err := s.Send(n); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.sent) != 0 {
+		t.Errorf("sent %v, want nothing", r.sent)
+	}
+}
@@ -0,0 +1,176 @@
+// Package notify delivers alerts to people: it groups and deduplicates them,
+// drops the silenced ones and sends the rest to webhooks, Slack, email or
+// commands.
+package notify
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	DefaultGroupWait      = 30 * time.Second
+	DefaultRepeatInterval = 4 * time.Hour
+
+	sinkSectionPrefix = "NOTIFY."
+)
+
+// Notification is a group of alerts sharing the labels the alerts are
+// grouped by.
+type Notification struct {
+	// Status is "firing" while any alert of the group fires, else "resolved".
+	Status      string            `json:"status"`
+	GroupLabels map[string]string `json:"group_labels"`
+	Alerts      []model.Alert     `json:"alerts"`
+}
+
+// Firing returns the alerts of the notification that are firing.
+func (n Notification) Firing() []model.Alert {
+	var firing []model.Alert
+	for _, a := range n.Alerts {
+		if a.Status != "resolved" {
+			firing = append(firing, a)
+		}
+	}
+	return firing
+}
+
+// Title is a one line description of the notification.
+func (n Notification) Title() string {
+	keys := make([]string, 0, len(n.GroupLabels))
+	for k := range n.GroupLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var labels []string
+	for _, k := range keys {
+		labels = append(labels, k+"="+n.GroupLabels[k])
+	}
+	return fmt.Sprintf("[%s:%d] %s", strings.ToUpper(n.Status), len(n.Firing()), strings.Join(labels, " "))
+}
+
+// Text is a plain text rendering of the notification, one line per alert.
+func (n Notification) Text() string {
+	var b strings.Builder
+	b.WriteString(n.Title())
+	b.WriteString("\n")
+	for _, a := range n.Alerts {
+		summary := a.Summary
+		if summary == "" {
+			summary = a.Expr
+		}
+		fmt.Fprintf(&b, "- [%s] %s %s: %s\n", a.Severity, a.Status, a.Name, summary)
+	}
+	return b.String()
+}
+
+// Sink sends notifications somewhere.
+type Sink interface {
+	Name() string
+	Send(n Notification) error
+}
+
+// Config is the notification configuration of the [NOTIFY] section and the
+// sinks declared in [NOTIFY.<name>] sections.
+type Config struct {
+	// labels the alerts are grouped by
+	GroupBy []string
+	// how long a new group waits for more alerts before it is first sent
+	GroupWait time.Duration
+	// how long before a notification still firing is sent again
+	RepeatInterval time.Duration
+	// file keeping the silences and maintenance windows
+	StateFile string
+	Sinks     []Sink
+}
+
+// LoadConfig reads the notification configuration from the agent
+// configuration f.
+func LoadConfig(f *ini.File) (Config, error) {
+	sec := f.Section("NOTIFY")
+	c := Config{
+		GroupBy:        splitList(sec.Key("GROUP_BY").MustString("alertname,target")),
+		GroupWait:      sec.Key("GROUP_WAIT").MustDuration(DefaultGroupWait),
+		RepeatInterval: sec.Key("REPEAT_INTERVAL").MustDuration(DefaultRepeatInterval),
+		StateFile:      sec.Key("STATE_FILE").MustString("notify.json"),
+	}
+
+	for _, sec := range f.Sections() {
+		if !strings.HasPrefix(sec.Name(), sinkSectionPrefix) {
+			continue
+		}
+		s, err := newSink(strings.TrimPrefix(sec.Name(), sinkSectionPrefix), sec)
+		if err != nil {
+			return c, err
+		}
+		c.Sinks = append(c.Sinks, filtered(s, sec.Key("MIN_SEVERITY").String()))
+	}
+	return c, nil
+}
+
+func newSink(name string, sec *ini.Section) (Sink, error) {
+	switch kind := sec.Key("TYPE").String(); kind {
+	case "webhook":
+		return &Webhook{name: name, URL: sec.Key("URL").String()}, nil
+	case "slack":
+		return &Slack{name: name, URL: sec.Key("URL").String(), Channel: sec.Key("CHANNEL").String()}, nil
+	case "email":
+		return &Email{
+			name:     name,
+			Addr:     sec.Key("SMTP_ADDR").String(),
+			Username: sec.Key("USERNAME").String(),
+			Password: sec.Key("PASSWORD").String(),
+			From:     sec.Key("FROM").String(),
+			To:       splitList(sec.Key("TO").String()),
+		}, nil
+	case "exec":
+		return &Exec{name: name, Command: sec.Key("COMMAND").String(), Timeout: sec.Key("TIMEOUT").MustDuration(30 * time.Second)}, nil
+	default:
+		return nil, fmt.Errorf("notification sink %s: unknown type %q", name, kind)
+	}
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+var severities = map[string]int{"info": 0, "warning": 1, "critical": 2}
+
+// severityFilter only passes on the alerts at or above a severity.
+type severityFilter struct {
+	Sink
+	min int
+}
+
+func filtered(s Sink, min string) Sink {
+	if min == "" {
+		return s
+	}
+	return &severityFilter{Sink: s, min: severities[min]}
+}
+
+func (f *severityFilter) Send(n Notification) error {
+	var alerts []model.Alert
+	for _, a := range n.Alerts {
+		if severities[a.Severity] >= f.min {
+			alerts = append(alerts, a)
+		}
+	}
+	if len(alerts) == 0 {
+		return nil
+	}
+	n.Alerts = alerts
+	return f.Sink.Send(n)
+}
@@ -0,0 +1,234 @@
+package notify
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Silence mutes the alerts whose labels match all its matchers between
+// StartsAt and EndsAt.
+type Silence struct {
+	ID        string            `json:"id"`
+	Matchers  map[string]string `json:"matchers"`
+	StartsAt  time.Time         `json:"starts_at"`
+	EndsAt    time.Time         `json:"ends_at"`
+	CreatedBy string            `json:"created_by,omitempty"`
+	Comment   string            `json:"comment,omitempty"`
+}
+
+func (s Silence) Active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+// MaintenanceWindow mutes the alerts whose labels match all its matchers
+// every week, on the given days, from Start (HH:MM, local time) for
+// Duration.
+type MaintenanceWindow struct {
+	ID       string            `json:"id"`
+	Matchers map[string]string `json:"matchers"`
+	// no days means every day
+	Days     []time.Weekday `json:"days,omitempty"`
+	Start    string         `json:"start"`
+	Duration Duration       `json:"duration"`
+	Comment  string         `json:"comment,omitempty"`
+}
+
+// Active tells whether now falls within an occurrence of the window,
+// including one that started on a previous day and still lasts.
+func (w MaintenanceWindow) Active(now time.Time) bool {
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return false
+	}
+
+	// occurrences that started up to Duration ago
+	days := int(math.Ceil(float64(w.Duration) / float64(24*time.Hour)))
+	for i := 0; i <= days; i++ {
+		day := now.AddDate(0, 0, -i)
+		if !w.onDay(day.Weekday()) {
+			continue
+		}
+		from := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, now.Location())
+		if !now.Before(from) && now.Before(from.Add(time.Duration(w.Duration))) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w MaintenanceWindow) onDay(d time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, day := range w.Days {
+		if day == d {
+			return true
+		}
+	}
+	return false
+}
+
+// Duration is a time.Duration written as "2h30m" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = Duration(v)
+	return err
+}
+
+// ParseWeekdays parses a comma separated list of days such as "sat,sun".
+func ParseWeekdays(s string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, name := range splitList(s) {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.HasPrefix(strings.ToLower(d.String()), strings.ToLower(name)) && len(name) >= 3 {
+				days = append(days, d)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown day %q", name)
+		}
+	}
+	return days, nil
+}
+
+func matches(matchers, labels map[string]string) bool {
+	for k, v := range matchers {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// State is the content of the state file shared by the agent and the silence
+// and maintenance commands.
+type State struct {
+	Silences    []Silence           `json:"silences"`
+	Maintenance []MaintenanceWindow `json:"maintenance"`
+}
+
+// LoadState reads the state file at path. A missing file is an empty state.
+func LoadState(path string) (*State, error) {
+	s := &State{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, json.Unmarshal(data, s)
+}
+
+// Save writes the state to path, replacing the file atomically.
+func (s *State) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".notify-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Muted tells whether an alert with the given labels is silenced or within a
+// maintenance window.
+func (s *State) Muted(labels map[string]string, now time.Time) bool {
+	for _, sil := range s.Silences {
+		if sil.Active(now) && matches(sil.Matchers, labels) {
+			return true
+		}
+	}
+	for _, w := range s.Maintenance {
+		if w.Active(now) && matches(w.Matchers, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expire ends the silence or removes the maintenance window with the given
+// ID, or a unique prefix of it.
+func (s *State) Expire(id string, now time.Time) error {
+	var found []string
+	for i := range s.Silences {
+		if strings.HasPrefix(s.Silences[i].ID, id) {
+			found = append(found, s.Silences[i].ID)
+		}
+	}
+	for _, w := range s.Maintenance {
+		if strings.HasPrefix(w.ID, id) {
+			found = append(found, w.ID)
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return fmt.Errorf("no silence or maintenance window %s", id)
+	case len(found) > 1:
+		return fmt.Errorf("%s is ambiguous", id)
+	}
+
+	for i := range s.Silences {
+		if s.Silences[i].ID == found[0] && s.Silences[i].EndsAt.After(now) {
+			s.Silences[i].EndsAt = now
+		}
+	}
+	for i, w := range s.Maintenance {
+		if w.ID == found[0] {
+			s.Maintenance = append(s.Maintenance[:i], s.Maintenance[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Prune drops the silences that ended before t.
+func (s *State) Prune(t time.Time) {
+	kept := s.Silences[:0]
+	for _, sil := range s.Silences {
+		if sil.EndsAt.After(t) {
+			kept = append(kept, sil)
+		}
+	}
+	s.Silences = kept
+}
+
+// NewID returns a random ID for a silence or maintenance window.
+func NewID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,232 @@
+package notify
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSilenceActive(t *testing.T) {
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := Silence{StartsAt: t0, EndsAt: t0.Add(time.Hour)}
+
+	tests := []struct {
+		now  time.Time
+		want bool
+	}{
+		{t0.Add(-time.Second), false},
+		{t0, true},
+		{t0.Add(59 * time.Minute), true},
+		{t0.Add(time.Hour), false},
+	}
+	for _, tt := range tests {
+		if got := s.Active(tt.now); got != tt.want {
+			t.Errorf("Active(%s) = %v, want %v", tt.now.Format(time.RFC3339), got, tt.want)
+		}
+	}
+}
+
+func TestMaintenanceWindowActive(t *testing.T) {
+	// 2021-03-01 is a Monday
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2021, 3, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		w    MaintenanceWindow
+		now  time.Time
+		want bool
+	}{
+		{
+			name: "every day, within",
+			w:    MaintenanceWindow{Start: "02:00", Duration: Duration(2 * time.Hour)},
+			now:  at(3, 3, 0),
+			want: true,
+		},
+		{
+			name: "every day, ended",
+			w:    MaintenanceWindow{Start: "02:00", Duration: Duration(2 * time.Hour)},
+			now:  at(3, 4, 0),
+		},
+		{
+			name: "every day, not started",
+			w:    MaintenanceWindow{Start: "02:00", Duration: Duration(2 * time.Hour)},
+			now:  at(3, 1, 59),
+		},
+		{
+			name: "across midnight",
+			w:    MaintenanceWindow{Start: "23:00", Duration: Duration(3 * time.Hour)},
+			now:  at(3, 1, 0),
+			want: true,
+		},
+		{
+			name: "other day",
+			w:    MaintenanceWindow{Days: []time.Weekday{time.Sunday}, Start: "02:00", Duration: Duration(time.Hour)},
+			now:  at(1, 2, 30),
+		},
+		{
+			name: "on its day",
+			w:    MaintenanceWindow{Days: []time.Weekday{time.Sunday}, Start: "02:00", Duration: Duration(time.Hour)},
+			now:  at(7, 2, 30),
+			want: true,
+		},
+		{
+			name: "started the day before",
+			w:    MaintenanceWindow{Days: []time.Weekday{time.Saturday}, Start: "22:00", Duration: Duration(4 * time.Hour)},
+			now:  at(7, 1, 0),
+			want: true,
+		},
+		{
+			name: "longer than a day",
+			w:    MaintenanceWindow{Days: []time.Weekday{time.Saturday}, Start: "20:00", Duration: Duration(36 * time.Hour)},
+			now:  at(7, 23, 0),
+			want: true,
+		},
+		{
+			name: "longer than a day, two days on",
+			w:    MaintenanceWindow{Days: []time.Weekday{time.Saturday}, Start: "20:00", Duration: Duration(36 * time.Hour)},
+			now:  at(8, 7, 0),
+			want: true,
+		},
+		{
+			name: "longer than a day, ended",
+			w:    MaintenanceWindow{Days: []time.Weekday{time.Saturday}, Start: "20:00", Duration: Duration(36 * time.Hour)},
+			now:  at(8, 8, 0),
+		},
+		{
+			name: "invalid start",
+			w:    MaintenanceWindow{Start: "2am", Duration: Duration(time.Hour)},
+			now:  at(3, 2, 30),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.w.Active(tt.now); got != tt.want {
+				t.Errorf("Active(%s) = %v, want %v", tt.now.Format(time.RFC3339), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStateMuted(t *testing.T) {
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := &State{
+		Silences: []Silence{{
+			Matchers: map[string]string{"alertname": "lag", "target": "db1"},
+			StartsAt: t0,
+			EndsAt:   t0.Add(time.Hour),
+		}},
+		Maintenance: []MaintenanceWindow{{
+			Matchers: map[string]string{"target": "db2"},
+			Start:    "02:00",
+			Duration: Duration(time.Hour),
+		}},
+	}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		now    time.Time
+		want   bool
+	}{
+		{"silenced", map[string]string{"alertname": "lag", "target": "db1", "severity": "warning"}, t0, true},
+		{"partial match", map[string]string{"alertname": "connections", "target": "db1"}, t0, false},
+		{"silence ended", map[string]string{"alertname": "lag", "target": "db1"}, t0.Add(time.Hour), false},
+		{"in maintenance", map[string]string{"alertname": "lag", "target": "db2"}, t0.Add(14*time.Hour + 30*time.Minute), true},
+		{"out of maintenance", map[string]string{"alertname": "lag", "target": "db2"}, t0, false},
+	}
+	for _, tt := range tests {
+		if got := s.Muted(tt.labels, tt.now); got != tt.want {
+			t.Errorf("%s: Muted = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStateExpire(t *testing.T) {
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	newState := func() *State {
+		return &State{
+			Silences: []Silence{
+				{ID: "ab12", StartsAt: t0, EndsAt: t0.Add(time.Hour)},
+				{ID: "ab34", StartsAt: t0, EndsAt: t0.Add(time.Hour)},
+			},
+			Maintenance: []MaintenanceWindow{{ID: "cd56", Start: "02:00", Duration: Duration(time.Hour)}},
+		}
+	}
+
+	s := newState()
+	if err := s.Expire("ab1", t0.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Silences[0].EndsAt.Equal(t0.Add(time.Minute)) || !s.Silences[1].EndsAt.Equal(t0.Add(time.Hour)) {
+		t.Errorf("silences after Expire: %+v", s.Silences)
+	}
+
+	s = newState()
+	if err := s.Expire("cd", t0); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Maintenance) != 0 {
+		t.Errorf("maintenance windows after Expire: %+v", s.Maintenance)
+	}
+
+	for _, id := range []string{"ab", "ef"} {
+		if err := newState().Expire(id, t0); err == nil {
+			t.Errorf("Expire(%q) succeeded", id)
+		}
+	}
+}
+
+func TestStateSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.json")
+
+	s, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Silences) != 0 || len(s.Maintenance) != 0 {
+		t.Errorf("missing state file loaded as %+v", s)
+	}
+
+	t0 := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.Silences = []Silence{{ID: "s1", Matchers: map[string]string{"target": "db1"}, StartsAt: t0, EndsAt: t0.Add(time.Hour)}}
+	s.Maintenance = []MaintenanceWindow{{ID: "m1", Days: []time.Weekday{time.Saturday}, Start: "22:00", Duration: Duration(150 * time.Minute)}}
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, s) {
+		t.Errorf("loaded %+v, want %+v", got, s)
+	}
+}
+
+func TestParseWeekdays(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []time.Weekday
+		wantErr bool
+	}{
+		{in: "sat,sun", want: []time.Weekday{time.Saturday, time.Sunday}},
+		{in: "Monday, wed", want: []time.Weekday{time.Monday, time.Wednesday}},
+		{in: ""},
+		{in: "mo", wantErr: true},
+		{in: "sat,xyz", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseWeekdays(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseWeekdays(%q) error = %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseWeekdays(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
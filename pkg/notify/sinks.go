@@ -0,0 +1,142 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func postJSON(url string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return nil
+}
+
+// Webhook posts notifications as JSON.
+type Webhook struct {
+	name string
+	URL  string
+}
+
+func (w *Webhook) Name() string { return w.name }
+
+func (w *Webhook) Send(n Notification) error {
+	return postJSON(w.URL, n)
+}
+
+// Slack posts notifications to a Slack incoming webhook, or to anything
+// accepting the same payload.
+type Slack struct {
+	name    string
+	URL     string
+	Channel string
+}
+
+func (s *Slack) Name() string { return s.name }
+
+func (s *Slack) Send(n Notification) error {
+	color := "good"
+	if n.Status == "firing" {
+		color = "danger"
+	}
+
+	payload := map[string]interface{}{
+		"text": n.Title(),
+		"attachments": []map[string]interface{}{{
+			"color": color,
+			"text":  strings.TrimPrefix(n.Text(), n.Title()+"\n"),
+		}},
+	}
+	if s.Channel != "" {
+		payload["channel"] = s.Channel
+	}
+	return postJSON(s.URL, payload)
+}
+
+// Email sends notifications through an SMTP server.
+type Email struct {
+	name string
+	// host:port of the SMTP server
+	Addr string
+	// PLAIN authentication is used when Username is set
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (e *Email) Name() string { return e.name }
+
+func (e *Email) Send(n Notification) error {
+	var auth smtp.Auth
+	if e.Username != "" {
+		host := e.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", n.Title())
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(n.Text(), "\n", "\r\n"))
+
+	return smtp.SendMail(e.Addr, auth, e.From, e.To, msg.Bytes())
+}
+
+// Exec runs a shell command for each notification, with the notification as
+// JSON on its standard input and NOTIFY_STATUS and NOTIFY_TITLE in its
+// environment.
+type Exec struct {
+	name    string
+	Command string
+	Timeout time.Duration
+}
+
+func (x *Exec) Name() string { return x.name }
+
+func (x *Exec) Send(n Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), x.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", x.Command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(), "NOTIFY_STATUS="+n.Status, "NOTIFY_TITLE="+n.Title())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v: %s", x.Command, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+func testNotification(status string) Notification {
+	return Notification{
+		Status:      status,
+		GroupLabels: map[string]string{"alertname": "replication_lag", "target": "db1"},
+		Alerts: []model.Alert{{
+			ID:       "a1",
+			Name:     "replication_lag",
+			Status:   status,
+			Severity: "critical",
+			Labels:   map[string]string{"alertname": "replication_lag", "target": "db1", "severity": "critical"},
+			Summary:  "replica is 120s behind",
+			StartsAt: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC),
+		}},
+	}
+}
+
+// recordServer records the bodies posted to it and answers with status.
+func recordServer(t *testing.T, status int) (*httptest.Server, *[][]byte) {
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &bodies
+}
+
+func TestWebhookSend(t *testing.T) {
+	srv, bodies := recordServer(t, http.StatusOK)
+
+	w := &Webhook{name: "hook", URL: srv.URL}
+	if err := w.Send(testNotification("firing")); err != nil {
+		t.Fatal(err)
+	}
+	if len(*bodies) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*bodies))
+	}
+
+	var got Notification
+	if err := json.Unmarshal((*bodies)[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "firing" || len(got.Alerts) != 1 || got.Alerts[0].ID != "a1" {
+		t.Errorf("posted %+v", got)
+	}
+	if got.GroupLabels["target"] != "db1" {
+		t.Errorf("group labels = %v", got.GroupLabels)
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	srv, _ := recordServer(t, http.StatusInternalServerError)
+
+	w := &Webhook{name: "hook", URL: srv.URL}
+	err := w.Send(testNotification("firing"))
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("error = %v, want the status of the answer", err)
+	}
+}
+
+func TestSlackSend(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		channel string
+		color   string
+	}{
+		{name: "firing", status: "firing", channel: "#db", color: "danger"},
+		{name: "resolved", status: "resolved", color: "good"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, bodies := recordServer(t, http.StatusOK)
+
+			s := &Slack{name: "slack", URL: srv.URL, Channel: tt.channel}
+			n := testNotification(tt.status)
+			if err := s.Send(n); err != nil {
+				t.Fatal(err)
+			}
+
+			var got struct {
+				Text        string `json:"text"`
+				Channel     string `json:"channel"`
+				Attachments []struct {
+					Color string `json:"color"`
+					Text  string `json:"text"`
+				} `json:"attachments"`
+			}
+			if err := json.Unmarshal((*bodies)[0], &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Text != n.Title() {
+				t.Errorf("text = %q, want %q", got.Text, n.Title())
+			}
+			if got.Channel != tt.channel {
+				t.Errorf("channel = %q, want %q", got.Channel, tt.channel)
+			}
+			if len(got.Attachments) != 1 {
+				t.Fatalf("got %d attachments, want 1", len(got.Attachments))
+			}
+			if got.Attachments[0].Color != tt.color {
+				t.Errorf("color = %q, want %q", got.Attachments[0].Color, tt.color)
+			}
+			if !strings.Contains(got.Attachments[0].Text, "replica is 120s behind") {
+				t.Errorf("attachment text = %q", got.Attachments[0].Text)
+			}
+		})
+	}
+}
+
+// smtpServer is a fake SMTP server accepting a single message.
+type smtpServer struct {
+	addr string
+	from string
+	rcpt []string
+	data string
+	done chan struct{}
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := &smtpServer{addr: l.Addr().String(), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.serve(conn)
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			s.rcpt = append(s.rcpt, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 end with .")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.data = data.String()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestEmailSend(t *testing.T) {
+	srv := newSMTPServer(t)
+
+	e := &Email{
+		name: "mail",
+		Addr: srv.addr,
+		From: "monitoring@example.com",
+		To:   []string{"dba@example.com", "oncall@example.com"},
+	}
+	n := testNotification("firing")
+	if err := e.Send(n); err != nil {
+		t.Fatal(err)
+	}
+	<-srv.done
+
+	if srv.from != e.From {
+		t.Errorf("MAIL FROM = %q, want %q", srv.from, e.From)
+	}
+	if strings.Join(srv.rcpt, ",") != strings.Join(e.To, ",") {
+		t.Errorf("RCPT TO = %v, want %v", srv.rcpt, e.To)
+	}
+	for _, want := range []string{
+		"Subject: " + n.Title() + "\r\n",
+		"To: dba@example.com, oncall@example.com\r\n",
+		"- [critical] firing replication_lag: replica is 120s behind\r\n",
+	} {
+		if !strings.Contains(srv.data, want) {
+			t.Errorf("message lacks %q:\n%s", want, srv.data)
+		}
+	}
+}
@@ -0,0 +1,425 @@
+// Package output prints lists of records as an aligned table, JSON, YAML or
+// CSV, with the column selection, sorting and limiting shared by the
+// inspection commands.
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type Format string
+
+const (
+	Table Format = "table"
+	// Wide is the table format with every column.
+	Wide Format = "wide"
+	JSON Format = "json"
+	YAML Format = "yaml"
+	CSV  Format = "csv"
+	// SQL prints the statements of the sql column of the lists of advice,
+	// each after a comment made of its table and reason columns.
+	SQL Format = "sql"
+)
+
+var Formats = []Format{Table, Wide, JSON, YAML, CSV, SQL}
+
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format %q, expected one of table, wide, json, yaml, csv or sql", s)
+}
+
+// textWidth is the width text values are cut to in the table format.
+const textWidth = 60
+
+// Column is a field of the records. Name, in lower case, is how the column
+// is selected and sorted by, the key of the JSON and YAML objects and, in
+// upper case, the header of the table and CSV formats.
+type Column struct {
+	Name string
+	// Wide columns are left out of the table format unless selected.
+	Wide bool
+}
+
+// List is a list of records with the same columns. Values are strings,
+// integers, floats, booleans, times, lists of integers or nil.
+type List struct {
+	Columns []Column
+	Rows    [][]interface{}
+}
+
+func NewList(columns ...Column) *List {
+	return &List{Columns: columns}
+}
+
+// Add appends a record, its values in the order of the columns.
+func (l *List) Add(values ...interface{}) {
+	if len(values) != len(l.Columns) {
+		panic(fmt.Sprintf("output: %d values for %d columns", len(values), len(l.Columns)))
+	}
+	l.Rows = append(l.Rows, values)
+}
+
+func (l *List) index(name string) int {
+	for i, c := range l.Columns {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l *List) names() string {
+	var names []string
+	for _, c := range l.Columns {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Options are the presentation flags of the inspection commands.
+type Options struct {
+	Format Format
+	// Columns are the names of the columns to show, in order; the default
+	// columns of the format if empty.
+	Columns []string
+	// Sort is the column to sort by, numbers and times biggest first and
+	// text alphabetically; the order of the collector if empty.
+	Sort    string
+	Reverse bool
+	// Top limits the number of records, none if zero.
+	Top int
+}
+
+// Filter keeps the records whose column name is value. Lists without such a
+// column are left unchanged.
+func (l *List) Filter(name, value string) {
+	c := l.index(name)
+	if c < 0 {
+		return
+	}
+	var rows [][]interface{}
+	for _, r := range l.Rows {
+		if text(r[c]) == value {
+			rows = append(rows, r)
+		}
+	}
+	l.Rows = rows
+}
+
+// Write sorts, limits and prints l to w according to o.
+func Write(w io.Writer, l *List, o Options) error {
+	rows := l.Rows
+	if o.Sort != "" {
+		c := l.index(o.Sort)
+		if c < 0 {
+			return fmt.Errorf("unknown column %q to sort by, expected one of %s", o.Sort, l.names())
+		}
+		rows = append([][]interface{}(nil), rows...)
+		sort.SliceStable(rows, func(i, j int) bool {
+			if o.Reverse {
+				return less(rows[j][c], rows[i][c])
+			}
+			return less(rows[i][c], rows[j][c])
+		})
+	} else if o.Reverse {
+		rows = append([][]interface{}(nil), rows...)
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	if o.Top > 0 && len(rows) > o.Top {
+		rows = rows[:o.Top]
+	}
+
+	var selected []int
+	if len(o.Columns) > 0 {
+		for _, name := range o.Columns {
+			c := l.index(strings.ToLower(strings.TrimSpace(name)))
+			if c < 0 {
+				return fmt.Errorf("unknown column %q, expected one of %s", name, l.names())
+			}
+			selected = append(selected, c)
+		}
+	} else {
+		for c, col := range l.Columns {
+			if !col.Wide || o.Format != Table {
+				selected = append(selected, c)
+			}
+		}
+	}
+
+	switch o.Format {
+	case JSON:
+		return writeJSON(w, l, rows, selected)
+	case YAML:
+		return writeYAML(w, l, rows, selected)
+	case CSV:
+		return writeCSV(w, l, rows, selected)
+	case SQL:
+		return writeSQL(w, l, rows)
+	default:
+		return writeTable(w, l, rows, selected, o.Format == Table)
+	}
+}
+
+// number returns the value of numbers, times and booleans for sorting.
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case time.Time:
+		return float64(v.UnixNano()), true
+	case *time.Time:
+		if v == nil {
+			return 0, false
+		}
+		return float64(v.UnixNano()), true
+	}
+	return 0, false
+}
+
+// less orders numbers biggest first and text alphabetically, missing values
+// last.
+func less(a, b interface{}) bool {
+	x, xok := number(a)
+	y, yok := number(b)
+	if xok || yok {
+		if !yok {
+			return xok
+		}
+		return xok && x > y
+	}
+	return strings.ToLower(text(a)) < strings.ToLower(text(b))
+}
+
+// cell formats v for the table formats, text on one line cut to textWidth if
+// cut is set.
+func cell(v interface{}, cut bool) string {
+	switch v := v.(type) {
+	case string:
+		s := strings.Join(strings.Fields(v), " ")
+		if cut && len([]rune(s)) > textWidth {
+			s = string([]rune(s)[:textWidth-1]) + "…"
+		}
+		return s
+	case float64:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	}
+	return text(v)
+}
+
+// text formats v for the CSV format.
+func text(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return text(*v)
+	case []int:
+		var s []string
+		for _, n := range v {
+			s = append(s, strconv.Itoa(n))
+		}
+		return strings.Join(s, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+func header(l *List, selected []int) []string {
+	var h []string
+	for _, c := range selected {
+		h = append(h, strings.ToUpper(l.Columns[c].Name))
+	}
+	return h
+}
+
+func writeTable(w io.Writer, l *List, rows [][]interface{}, selected []int, cut bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header(l, selected), "\t"))
+	for _, r := range rows {
+		var cells []string
+		for _, c := range selected {
+			cells = append(cells, cell(r[c], cut))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+func writeCSV(w io.Writer, l *List, rows [][]interface{}, selected []int) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header(l, selected)); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		var cells []string
+		for _, c := range selected {
+			cells = append(cells, text(r[c]))
+		}
+		if err := cw.Write(cells); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeSQL(w io.Writer, l *List, rows [][]interface{}) error {
+	statement, schema, table, reason := l.index("sql"), l.index("schema"), l.index("table"), l.index("reason")
+	if statement < 0 {
+		return fmt.Errorf("the sql output format needs a list of statements, expected one of %s", l.names())
+	}
+
+	var b strings.Builder
+	for i, r := range rows {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		var about []string
+		if table >= 0 {
+			name := text(r[table])
+			if schema >= 0 {
+				name = text(r[schema]) + "." + name
+			}
+			about = append(about, name)
+		}
+		if reason >= 0 {
+			about = append(about, text(r[reason]))
+		}
+		if len(about) > 0 {
+			b.WriteString("-- " + strings.Join(about, ": ") + "\n")
+		}
+		if s := text(r[statement]); s != "" {
+			b.WriteString(s + "\n")
+		} else {
+			b.WriteString("-- nothing to apply\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeJSON(w io.Writer, l *List, rows [][]interface{}, selected []int) error {
+	// built by hand to keep the keys in the order of the columns
+	var b strings.Builder
+	b.WriteString("[")
+	for i, r := range rows {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n  {")
+		for j, c := range selected {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			key, _ := json.Marshal(l.Columns[c].Name)
+			value, err := json.Marshal(r[c])
+			if err != nil {
+				return err
+			}
+			b.Write(key)
+			b.WriteString(": ")
+			b.Write(value)
+		}
+		b.WriteString("}")
+	}
+	if len(rows) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("]\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// plain matches the strings that can be written unquoted in YAML.
+var plain = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_./-]*$`)
+
+var yamlKeywords = map[string]bool{
+	"true": true, "false": true, "yes": true, "no": true, "on": true, "off": true,
+	"y": true, "n": true, "null": true, "~": true,
+}
+
+func yamlValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		if plain.MatchString(v) && !yamlKeywords[strings.ToLower(v)] {
+			return v
+		}
+		// JSON strings are valid double-quoted YAML scalars
+		s, _ := json.Marshal(v)
+		return string(s)
+	case time.Time, *time.Time:
+		if s := text(v); s != "" {
+			return strconv.Quote(s)
+		}
+		return "null"
+	case []int:
+		var s []string
+		for _, n := range v {
+			s = append(s, strconv.Itoa(n))
+		}
+		return "[" + strings.Join(s, ", ") + "]"
+	}
+	return text(v)
+}
+
+func writeYAML(w io.Writer, l *List, rows [][]interface{}, selected []int) error {
+	var b strings.Builder
+	if len(rows) == 0 {
+		b.WriteString("[]\n")
+	}
+	for _, r := range rows {
+		for j, c := range selected {
+			if j == 0 {
+				b.WriteString("- ")
+			} else {
+				b.WriteString("  ")
+			}
+			b.WriteString(l.Columns[c].Name + ": " + yamlValue(r[c]) + "\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,182 @@
+package producer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/sqlnorm"
+)
+
+// sessionQueries list the backends, with and without the query_id column
+// added to pg_stat_activity in Postgres 14.
+var sessionQueries = []string{
+	`SELECT pid, COALESCE(datname, ''), COALESCE(usename, ''), COALESCE(application_name, ''),
+			COALESCE(host(client_addr), ''), COALESCE(backend_type, ''), COALESCE(state, ''),
+			COALESCE(wait_event_type, ''), COALESCE(wait_event, ''), xact_start, query_start,
+			COALESCE(EXTRACT(EPOCH FROM now() - query_start), 0), pg_blocking_pids(pid),
+			COALESCE(query_id, 0), left(query, $1)
+		FROM pg_stat_activity
+		WHERE pid <> pg_backend_pid()
+		ORDER BY query_start NULLS LAST`,
+	`SELECT pid, COALESCE(datname, ''), COALESCE(usename, ''), COALESCE(application_name, ''),
+			COALESCE(host(client_addr), ''), COALESCE(backend_type, ''), COALESCE(state, ''),
+			COALESCE(wait_event_type, ''), COALESCE(wait_event, ''), xact_start, query_start,
+			COALESCE(EXTRACT(EPOCH FROM now() - query_start), 0), pg_blocking_pids(pid),
+			0, left(query, $1)
+		FROM pg_stat_activity
+		WHERE pid <> pg_backend_pid()
+		ORDER BY query_start NULLS LAST`,
+}
+
+// GetSessions returns the backends of the server, query texts cut at
+// sqlLength characters and transformed according to mode.
+func GetSessions(db *sql.DB, sqlLength uint, mode sqlnorm.Mode) ([]model.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	for _, q := range sessionQueries {
+		rows, err = db.QueryContext(ctx, q, sqlLength)
+		if !isUndefinedObject(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []model.Session
+	for rows.Next() {
+		var (
+			s                     model.Session
+			xactStart, queryStart sql.NullTime
+			blockedBy             pq.Int64Array
+		)
+		err := rows.Scan(&s.PID, &s.DBName, &s.UserName, &s.Application, &s.ClientAddr, &s.BackendType,
+			&s.State, &s.WaitEventType, &s.WaitEvent, &xactStart, &queryStart, &s.QuerySeconds,
+			&blockedBy, &s.QueryID, &s.Query)
+		if err != nil {
+			return nil, err
+		}
+		if xactStart.Valid {
+			s.XactStart = &xactStart.Time
+		}
+		if queryStart.Valid {
+			s.QueryStart = &queryStart.Time
+		}
+		s.BlockedBy = pids(blockedBy)
+		s.Query = mode.Apply(s.Query)
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// lockQueries list the locks waited for and those blocking them, with and
+// without the waitstart column added to pg_locks in Postgres 14.
+var lockQueries = []string{
+	`SELECT l.pid, l.locktype, l.mode, l.granted, COALESCE(d.datname, ''),
+			COALESCE(l.relation::regclass::text, ''),
+			COALESCE(EXTRACT(EPOCH FROM now() - l.waitstart), 0),
+			pg_blocking_pids(l.pid), COALESCE(left(a.query, $1), '')
+		FROM pg_locks AS l
+		LEFT JOIN pg_database AS d ON d.oid = l.database
+		LEFT JOIN pg_stat_activity AS a ON a.pid = l.pid
+		WHERE NOT l.granted
+			OR l.pid IN (SELECT unnest(pg_blocking_pids(pid)) FROM pg_locks WHERE NOT granted)
+		ORDER BY l.granted, l.pid`,
+	`SELECT l.pid, l.locktype, l.mode, l.granted, COALESCE(d.datname, ''),
+			COALESCE(l.relation::regclass::text, ''),
+			0,
+			pg_blocking_pids(l.pid), COALESCE(left(a.query, $1), '')
+		FROM pg_locks AS l
+		LEFT JOIN pg_database AS d ON d.oid = l.database
+		LEFT JOIN pg_stat_activity AS a ON a.pid = l.pid
+		WHERE NOT l.granted
+			OR l.pid IN (SELECT unnest(pg_blocking_pids(pid)) FROM pg_locks WHERE NOT granted)
+		ORDER BY l.granted, l.pid`,
+}
+
+// GetLocks returns the locks sessions wait for along with the locks of the
+// sessions blocking them. Query texts are handled as by GetSessions.
+func GetLocks(db *sql.DB, sqlLength uint, mode sqlnorm.Mode) ([]model.Lock, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	for _, q := range lockQueries {
+		rows, err = db.QueryContext(ctx, q, sqlLength)
+		if !isUndefinedObject(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locks []model.Lock
+	for rows.Next() {
+		var (
+			l         model.Lock
+			blockedBy pq.Int64Array
+		)
+		err := rows.Scan(&l.PID, &l.LockType, &l.Mode, &l.Granted, &l.DBName, &l.Relation,
+			&l.WaitSeconds, &blockedBy, &l.Query)
+		if err != nil {
+			return nil, err
+		}
+		l.BlockedBy = pids(blockedBy)
+		l.Query = mode.Apply(l.Query)
+		locks = append(locks, l)
+	}
+	return locks, rows.Err()
+}
+
+func pids(a pq.Int64Array) []int {
+	var pids []int
+	for _, pid := range a {
+		pids = append(pids, int(pid))
+	}
+	return pids
+}
+
+// GetIndexes returns the user indexes of the database connected to.
+func GetIndexes(db *sql.DB) ([]model.Index, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	q := `SELECT current_database(), s.schemaname, s.relname, s.indexrelname,
+			s.idx_scan, s.idx_tup_read, s.idx_tup_fetch, pg_relation_size(s.indexrelid),
+			i.indisunique, i.indisprimary, i.indisvalid, pg_get_indexdef(s.indexrelid)
+		FROM pg_stat_user_indexes AS s
+		JOIN pg_index AS i ON i.indexrelid = s.indexrelid
+		ORDER BY s.schemaname, s.relname, s.indexrelname`
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []model.Index
+	for rows.Next() {
+		var i model.Index
+		err := rows.Scan(&i.DBName, &i.SchemaName, &i.TableName, &i.Name,
+			&i.IdxScan, &i.IdxTupRead, &i.IdxTupFetch, &i.SizeBytes,
+			&i.Unique, &i.Primary, &i.Valid, &i.Definition)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes, rows.Err()
+}
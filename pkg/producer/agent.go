@@ -0,0 +1,552 @@
+package producer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/aggregator"
+	"github.com/pkbhowmick/pg-monitoring/pkg/alert"
+	"github.com/pkbhowmick/pg-monitoring/pkg/history"
+	"github.com/pkbhowmick/pg-monitoring/pkg/logs"
+	"github.com/pkbhowmick/pg-monitoring/pkg/notify"
+	"github.com/pkbhowmick/pg-monitoring/pkg/snapshot"
+	"github.com/pkbhowmick/pg-monitoring/pkg/sqlnorm"
+)
+
+// DefaultSnapshotRetention is how long the snapshots saved to SNAPSHOT_DIR
+// are kept.
+const DefaultSnapshotRetention = 7 * 24 * time.Hour
+
+// historyCompactInterval is how often the history store is compacted.
+const historyCompactInterval = 5 * time.Minute
+
+// agent collects and publishes the metrics of a single target, keeping the
+// state that has to survive from one cycle to the next.
+type agent struct {
+	target   Target
+	db       *sql.DB
+	progress *ProgressTracker
+	settings *SettingsTracker
+	catalog  *CatalogCache
+	plans    *PlanTracker
+	// nil unless a log directory or file is configured
+	logs *logs.Collector
+	// nil unless active session history is enabled
+	sampler *Sampler
+	// end of the window of the last active session history report
+	lastASH time.Time
+	// what becomes of query texts before they are published
+	queryText sqlnorm.Mode
+	// nil unless the query text dictionary is enabled
+	queryTexts *QueryDictionary
+	// directory the published snapshots are kept in, none if empty
+	snapshotDir       string
+	snapshotRetention time.Duration
+	// shared by the agents, nil unless the history store is enabled
+	history *history.Store
+	// nil unless anomaly detection is enabled
+	anomalies *AnomalyDetector
+	// nil unless alerting rules are configured
+	alerts *alert.Engine
+	// shared by the agents, nil unless notification sinks are configured
+	notifier *notify.Dispatcher
+}
+
+func newAgent(t Target, rules []alert.Rule, notifier *notify.Dispatcher, store *history.Store) (*agent, error) {
+	db, err := ConnectTarget(t)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &agent{
+		target:   t,
+		db:       db,
+		progress: NewProgressTracker(),
+		settings: NewSettingsTracker(),
+		catalog:  NewCatalogCache(cfg.Section("AGENT").Key("CATALOG_REFRESH").MustDuration(DefaultCatalogRefresh)),
+		plans:    NewPlanTracker(),
+		notifier: notifier,
+		history:  store,
+
+		snapshotDir:       cfg.Section("AGENT").Key("SNAPSHOT_DIR").String(),
+		snapshotRetention: cfg.Section("AGENT").Key("SNAPSHOT_RETENTION").MustDuration(DefaultSnapshotRetention),
+
+		queryText: QueryTextMode(),
+	}
+
+	if len(rules) > 0 {
+		a.alerts = alert.NewEngine(rules)
+	}
+
+	if cfg.Section("AGENT").Key("QUERY_DICTIONARY").MustBool(false) {
+		a.queryTexts = NewQueryDictionary()
+	}
+
+	if sec := cfg.Section("ANOMALY"); sec.Key("ENABLED").MustBool(false) {
+		var path string
+		if dir := sec.Key("STATE_DIR").String(); dir != "" {
+			path = filepath.Join(dir, "baselines."+t.Name+".json")
+		}
+		a.anomalies, err = NewAnomalyDetector(
+			sec.Key("THRESHOLD").MustFloat64(DefaultAnomalyThreshold),
+			sec.Key("MIN_SAMPLES").MustInt64(DefaultAnomalyMinSamples),
+			path)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	if cc := t.CollectConfig(); cc.LogDir != "" || cc.LogFile != "" {
+		span := time.Duration(cc.LogSpan) * time.Minute
+		loc, err := GetLogTimezone(db)
+		if err != nil {
+			log.Printf("could not get the log timezone of %s, reading its log as UTC: %s\n", t.Name, err)
+		}
+		a.logs, err = logs.NewCollector(cc.LogDir, cc.LogFile, span, t.LogLinePrefix, loc)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	if sec := cfg.Section("ASH"); sec.Key("ENABLED").MustBool(false) {
+		sdb, err := ConnectTarget(t)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		a.sampler = NewSampler(sdb,
+			sec.Key("SAMPLE_INTERVAL").MustDuration(DefaultSampleInterval),
+			sec.Key("BUFFER_SIZE").MustInt(DefaultASHBufferSize),
+			t.CollectConfig().SQLLength,
+			a.queryText)
+		a.sampler.Start()
+		a.lastASH = time.Now()
+	}
+	return a, nil
+}
+
+func (a *agent) Close() error {
+	if a.sampler != nil {
+		a.sampler.Stop()
+	}
+	return a.db.Close()
+}
+
+// ashDumpPageSize is the most samples sent in one reply to an active session
+// history dump request.
+const ashDumpPageSize = 1000
+
+// serveASHDumps answers requests for the raw active session history of the
+// target on ASHDumpSubject.<target>. The request is either a JSON
+// model.ASHDumpRequest or a duration such as "5m" or an RFC 3339 time to
+// limit the dump to the samples taken since. The history is sent a page at a
+// time, no larger than ashDumpPageSize samples nor the max payload of the
+// server: the reply tells the offset to request the next page at.
+func (a *agent) serveASHDumps(nc *nats.Conn) (*nats.Subscription, error) {
+	return nc.Subscribe(ASHDumpSubject+"."+a.target.Name, func(msg *nats.Msg) {
+		var req model.ASHDumpRequest
+		if arg := strings.TrimSpace(string(msg.Data)); strings.HasPrefix(arg, "{") {
+			if err := json.Unmarshal([]byte(arg), &req); err != nil {
+				log.Printf("could not decode active session history request: %s\n", err)
+				return
+			}
+		} else if arg != "" {
+			if d, err := time.ParseDuration(arg); err == nil {
+				req.Since = time.Now().Add(-d)
+			} else if t, err := time.Parse(time.RFC3339, arg); err == nil {
+				req.Since = t
+			}
+		}
+
+		samples := a.sampler.Samples(req.Since)
+		err := respondPage(nc, msg, len(samples), req.Offset, req.Limit, ashDumpPageSize, func(start, end int, next *int) interface{} {
+			return model.ASHDump{Samples: samples[start:end], Total: len(samples), NextOffset: next}
+		})
+		if err != nil {
+			log.Printf("could not send active session history: %s\n", err)
+		}
+	})
+}
+
+// respondPage answers msg with a page of a list of n elements: at most limit
+// of them, and no more than max, starting at offset. page encodes the
+// elements from start to end along with the offset of the next page, nil
+// for the last one. The page is halved until it fits in a message.
+func respondPage(nc *nats.Conn, msg *nats.Msg, n, offset, limit, max int, page func(start, end int, next *int) interface{}) error {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > n {
+		offset = n
+	}
+	if limit <= 0 || limit > max {
+		limit = max
+	}
+
+	for {
+		end := n
+		var next *int
+		if limit < n-offset {
+			end = offset + limit
+			next = &end
+		}
+		data, err := json.Marshal(page(offset, end, next))
+		if err != nil {
+			return err
+		}
+		if int64(len(data)) > nc.MaxPayload() && limit > 1 {
+			limit /= 2
+			continue
+		}
+		return msg.Respond(data)
+	}
+}
+
+func publishJSON(nc *nats.Conn, subject string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("could not encode event for %s: %s\n", subject, err)
+		return
+	}
+
+	err = PublishEvent(nc, subject, data)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// statementsLimit is the number of statements collected: all of them when
+// anomalies are detected, every statement needing a baseline of its own, or
+// snapshots are stored, reports needing to tell new statements from the ones
+// that made it to the top; the published ones otherwise.
+func (a *agent) statementsLimit() int {
+	if a.anomalies != nil || a.snapshotDir != "" || a.history != nil {
+		return 0
+	}
+	return DefaultStatementsLimit
+}
+
+// publishMetrics publishes a snapshot of the target on MetricsSubject and
+// returns it. ok is false if the snapshot could not be collected.
+func (a *agent) publishMetrics(nc *nats.Conn) (m model.Model, ok bool) {
+	m, err := getMetrics(a.db, a.statementsLimit())
+	if err != nil {
+		log.Printf("could not get database metrics of %s: %s\n", a.target.Name, err)
+		return m, false
+	}
+	m.Target = a.target.Name
+	m.Labels = a.target.Labels
+
+	if err := a.catalog.Refresh(a.db); err != nil {
+		log.Printf("could not load catalog of %s: %s\n", a.target.Name, err)
+	}
+	a.catalog.Enrich(&m)
+
+	if a.sampler != nil {
+		now := time.Now()
+		ash := a.sampler.Report(a.lastASH, now)
+		m.ASH = &ash
+		a.lastASH = now
+	}
+
+	var planChanges []model.PlanChange
+	if a.logs != nil {
+		summary, entries, err := a.logs.Collect()
+		if err != nil {
+			log.Printf("could not read server log of %s: %s\n", a.target.Name, err)
+		}
+		m.Logs = &summary
+		planChanges = a.plans.Update(entries, m.Statements)
+	}
+
+	redactMetrics(&m, a.queryText)
+
+	// only the statements with the largest total time are published, all of
+	// them going to the anomaly detector and the stored snapshots, which keep
+	// their texts when the published ones are moved to the dictionary
+	stored := m
+	n := len(m.Statements)
+	if n > DefaultStatementsLimit {
+		n = DefaultStatementsLimit
+		m.StatementsComplete = false
+	}
+	m.Statements = append([]model.Statement(nil), m.Statements[:n]...)
+
+	// texts are published before the snapshot referring to them
+	if a.queryTexts != nil {
+		if added := a.queryTexts.Compact(m.Statements); len(added) > 0 {
+			for i := range added {
+				added[i].Target = a.target.Name
+			}
+			publishJSON(nc, QueryTextSubject, added)
+		}
+	}
+
+	data, err := MarshalMetrics(m)
+	if err != nil {
+		log.Printf("could not encode database metrics: %s\n", err)
+		return m, false
+	}
+
+	err = PublishEvent(nc, MetricsSubject, data)
+	if err != nil {
+		log.Println(err)
+	}
+	if a.snapshotDir != "" {
+		a.saveSnapshot(stored)
+	}
+	if a.history != nil {
+		if err := a.history.Append(stored); err != nil {
+			log.Printf("could not store snapshot of %s in the history: %s\n", a.target.Name, err)
+		}
+	}
+
+	if change := a.settings.Update(m.Settings); change != nil {
+		change.Target = a.target.Name
+		publishJSON(nc, SettingsChangeSubject, change)
+	}
+	for _, change := range planChanges {
+		change.Target = a.target.Name
+		redactPlanChange(&change, a.queryText)
+		publishJSON(nc, PlanChangeSubject, change)
+	}
+	if a.anomalies != nil {
+		anomalies := a.anomalies.Update(stored.Statements, m.UpdatedAt)
+		for i := range anomalies {
+			anomalies[i].Target = a.target.Name
+		}
+		if len(anomalies) > 0 {
+			publishJSON(nc, AnomalySubject, anomalies)
+		}
+	}
+
+	if a.alerts != nil {
+		a.publishAlerts(nc, m)
+	}
+	return m, true
+}
+
+// saveSnapshot keeps snapshot m on disk, for reports and diffs, and removes
+// the snapshots older than the retention.
+func (a *agent) saveSnapshot(m model.Model) {
+	data, err := MarshalMetrics(m)
+	if err != nil {
+		log.Printf("could not encode snapshot of %s: %s\n", a.target.Name, err)
+		return
+	}
+	if _, err := snapshot.Save(a.snapshotDir, m, data); err != nil {
+		log.Printf("could not save snapshot of %s: %s\n", a.target.Name, err)
+		return
+	}
+	if a.snapshotRetention > 0 {
+		err := snapshot.Prune(a.snapshotDir, a.target.Name, m.UpdatedAt.Add(-a.snapshotRetention))
+		if err != nil {
+			log.Printf("could not remove old snapshots of %s: %s\n", a.target.Name, err)
+		}
+	}
+}
+
+// publishAlerts evaluates the alerting rules against snapshot m and publishes
+// the alerts that started firing or got resolved.
+func (a *agent) publishAlerts(nc *nats.Conn, m model.Model) {
+	changes, err := a.alerts.Evaluate(m)
+	if err != nil {
+		log.Printf("could not evaluate alerting rules for %s: %s\n", a.target.Name, err)
+		return
+	}
+
+	for _, al := range changes {
+		subject := AlertFiringSubject
+		if al.Status == alert.StatusResolved {
+			subject = AlertResolvedSubject
+		}
+		publishJSON(nc, subject, al)
+	}
+	if a.notifier != nil {
+		a.notifier.Add(changes, time.Now())
+	}
+}
+
+// newNotifier returns the dispatcher of the notifications of the alerts, or
+// nil if no notification sink is configured.
+func newNotifier() *notify.Dispatcher {
+	c, err := NotifyConfig()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(c.Sinks) == 0 {
+		return nil
+	}
+	return notify.NewDispatcher(c)
+}
+
+// NotifyConfig returns the notification configuration of the loaded
+// configuration.
+func NotifyConfig() (notify.Config, error) {
+	return notify.LoadConfig(cfg)
+}
+
+// OpenHistory opens the history store of [HISTORY] DIR, or returns nil if
+// the store is not enabled.
+func OpenHistory() (*history.Store, error) {
+	dir, opts := history.LoadConfig(cfg)
+	if dir == "" {
+		return nil, nil
+	}
+	return history.Open(dir, opts)
+}
+
+// HTTPListenAddr returns the address the serve command listens on.
+func HTTPListenAddr() string {
+	return cfg.Section("HTTP").Key("LISTEN").MustString(":8080")
+}
+
+// AggregatorConfig returns the configuration of the aggregate command, which
+// reads the snapshots of the agent's stream unless told otherwise.
+func AggregatorConfig() aggregator.Config {
+	c := aggregator.LoadConfig(cfg)
+	if c.Stream == "" {
+		c.Stream = StreamName()
+	}
+	c.Subject = MetricsSubject
+	return c
+}
+
+// loadRules loads the alerting rules of the file named in [ALERTING]
+// RULES_FILE, if any.
+func loadRules() []alert.Rule {
+	path := cfg.Section("ALERTING").Key("RULES_FILE").String()
+	if path == "" {
+		return nil
+	}
+
+	rules, err := alert.LoadRules(path)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return rules
+}
+
+// publishProgress publishes the running maintenance operations on
+// ProgressSubject. Nothing is sent while no operation is active.
+func (a *agent) publishProgress(nc *nats.Conn) {
+	progress, err := GetProgress(a.db)
+	if err != nil {
+		log.Printf("could not get progress of running operations on %s: %s\n", a.target.Name, err)
+		return
+	}
+
+	progress = a.progress.Update(progress)
+	if len(progress) == 0 {
+		return
+	}
+	for i := range progress {
+		progress[i].Target = a.target.Name
+	}
+
+	publishJSON(nc, ProgressSubject, progress)
+}
+
+// compactHistory compacts store every historyCompactInterval, away from the
+// publishing of the snapshots.
+func compactHistory(store *history.Store) {
+	ticker := time.NewTicker(historyCompactInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := store.Compact(now); err != nil {
+			log.Printf("could not compact the history: %s\n", err)
+		}
+	}
+}
+
+// Publish collects the metrics of every target and publishes them to NATS.
+// With a positive interval it keeps doing so every interval, otherwise it
+// publishes once.
+func Publish(interval time.Duration) {
+	LoadConfig()
+
+	nc, err := NewConnection()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer nc.Close()
+
+	targets := Targets()
+	rules := loadRules()
+	notifier := newNotifier()
+	store, err := OpenHistory()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if store != nil && interval > 0 {
+		go compactHistory(store)
+	}
+
+	var agents []*agent
+	for _, t := range targets {
+		a, err := newAgent(t, rules, notifier, store)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer a.Close()
+		agents = append(agents, a)
+
+		if a.sampler != nil {
+			sub, err := a.serveASHDumps(nc)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer sub.Unsubscribe()
+		}
+		if a.queryTexts != nil {
+			sub, err := a.serveQueryTexts(nc)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			defer sub.Unsubscribe()
+		}
+	}
+
+	var lastDrift string
+	for {
+		snapshots := map[string]model.Model{}
+		for _, a := range agents {
+			if m, ok := a.publishMetrics(nc); ok {
+				snapshots[a.target.Name] = m
+			}
+			a.publishProgress(nc)
+		}
+
+		if len(targets) > 1 {
+			drift := GetDrift(targets, snapshots)
+			// published when the differences change, including when they
+			// are gone
+			if key := driftKey(drift); key != lastDrift {
+				publishJSON(nc, DriftSubject, drift)
+				lastDrift = key
+			}
+		}
+
+		if notifier != nil {
+			notifier.Flush(time.Now(), interval <= 0)
+		}
+
+		if interval <= 0 {
+			if store != nil {
+				if err := store.Compact(time.Now()); err != nil {
+					log.Printf("could not compact the history: %s\n", err)
+				}
+			}
+			return
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,196 @@
+package producer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+const (
+	DefaultAnomalyThreshold  = 3.0
+	DefaultAnomalyMinSamples = 30
+
+	hoursPerWeek = 7 * 24
+	// how often the baselines are written to disk
+	anomalySaveInterval = 5 * time.Minute
+)
+
+// baseline is the running mean and variance of a metric, kept with Welford's
+// algorithm.
+type baseline struct {
+	N    int64   `json:"n"`
+	Mean float64 `json:"mean"`
+	M2   float64 `json:"m2"`
+}
+
+func (b *baseline) Add(x float64) {
+	b.N++
+	d := x - b.Mean
+	b.Mean += d / float64(b.N)
+	b.M2 += d * (x - b.Mean)
+}
+
+func (b *baseline) StdDev() float64 {
+	if b.N < 2 {
+		return 0
+	}
+	return math.Sqrt(b.M2 / float64(b.N-1))
+}
+
+// statementBaselines are the baselines of a statement for each hour of the
+// week.
+type statementBaselines struct {
+	MeanTime       [hoursPerWeek]baseline `json:"mean_time"`
+	CallsPerSecond [hoursPerWeek]baseline `json:"calls_per_second"`
+	LastSeen       time.Time              `json:"last_seen"`
+}
+
+// AnomalyDetector learns the usual mean latency and call rate of each
+// statement for each hour of the week, from the deltas between consecutive
+// snapshots, and reports the statements straying from them.
+type AnomalyDetector struct {
+	// number of standard deviations from the baseline making an anomaly
+	threshold float64
+	// samples a baseline needs before it is trusted
+	minSamples int64
+	// file the baselines are kept in, none if empty
+	path     string
+	lastSave time.Time
+
+	baselines map[string]*statementBaselines
+	prev      map[string]model.Statement
+	prevTime  time.Time
+}
+
+// NewAnomalyDetector returns a detector whose baselines are kept in the file
+// at path, loading those saved by a previous run.
+func NewAnomalyDetector(threshold float64, minSamples int64, path string) (*AnomalyDetector, error) {
+	d := &AnomalyDetector{
+		threshold:  threshold,
+		minSamples: minSamples,
+		path:       path,
+		baselines:  map[string]*statementBaselines{},
+	}
+	if path == "" {
+		return d, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &d.baselines); err != nil {
+		return nil, fmt.Errorf("could not load statement baselines from %s: %v", path, err)
+	}
+	return d, nil
+}
+
+func statementKey(s model.Statement) string {
+	return fmt.Sprintf("%d/%d/%d", s.DBOID, s.UserOID, s.QueryID)
+}
+
+func hourOfWeek(t time.Time) int {
+	t = t.UTC()
+	return int(t.Weekday())*24 + t.Hour()
+}
+
+// Update accounts for the statements of a new snapshot taken at now and
+// returns the anomalies found among them.
+func (d *AnomalyDetector) Update(statements []model.Statement, now time.Time) []model.Anomaly {
+	var anomalies []model.Anomaly
+	elapsed := now.Sub(d.prevTime).Seconds()
+	hour := hourOfWeek(now)
+
+	cur := make(map[string]model.Statement, len(statements))
+	for _, s := range statements {
+		k := statementKey(s)
+		cur[k] = s
+
+		prev, ok := d.prev[k]
+		calls := s.Calls - prev.Calls
+		// new statement, or statistics reset
+		if !ok || elapsed <= 0 || calls < 0 || s.TotalTime < prev.TotalTime {
+			continue
+		}
+
+		b, ok := d.baselines[k]
+		if !ok {
+			b = &statementBaselines{}
+			d.baselines[k] = b
+		}
+		b.LastSeen = now
+
+		observe := func(metric string, bl *baseline, x float64) {
+			if bl.N >= d.minSamples {
+				if sd := bl.StdDev(); sd > 0 {
+					if z := (x - bl.Mean) / sd; math.Abs(z) >= d.threshold {
+						anomalies = append(anomalies, model.Anomaly{
+							QueryID:        s.QueryID,
+							DBName:         s.DBName,
+							UserName:       s.UserName,
+							Fingerprint:    s.Fingerprint,
+							Query:          s.Query,
+							TextHash:       s.TextHash,
+							Metric:         metric,
+							HourOfWeek:     hour,
+							Observed:       x,
+							BaselineMean:   bl.Mean,
+							BaselineStdDev: sd,
+							BaselineCount:  bl.N,
+							ZScore:         z,
+							DetectedAt:     now,
+						})
+					}
+				}
+			}
+			bl.Add(x)
+		}
+
+		observe("calls_per_second", &b.CallsPerSecond[hour], float64(calls)/elapsed)
+		// no latency without calls
+		if calls > 0 {
+			observe("mean_time", &b.MeanTime[hour], (s.TotalTime-prev.TotalTime)/float64(calls))
+		}
+	}
+
+	d.prev = cur
+	d.prevTime = now
+
+	if d.path != "" && now.Sub(d.lastSave) >= anomalySaveInterval {
+		if err := d.save(now); err != nil {
+			log.Printf("could not save statement baselines: %s\n", err)
+		}
+		d.lastSave = now
+	}
+	return anomalies
+}
+
+// save writes the baselines to disk, forgetting the statements not seen for
+// more than a week first.
+func (d *AnomalyDetector) save(now time.Time) error {
+	for k, b := range d.baselines {
+		if now.Sub(b.LastSeen) > 7*24*time.Hour {
+			delete(d.baselines, k)
+		}
+	}
+
+	data, err := json.Marshal(d.baselines)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(d.path), "."+filepath.Base(d.path)+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
@@ -0,0 +1,268 @@
+package producer
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/sqlnorm"
+)
+
+const (
+	DefaultSampleInterval = time.Second
+	DefaultASHBufferSize  = 100000
+
+	// maxDBTimeEntries caps each breakdown of the published report.
+	maxDBTimeEntries = 20
+)
+
+// activityQueries sample the active sessions, with and without the query_id
+// column added to pg_stat_activity in Postgres 14.
+var activityQueries = []string{
+	`SELECT pid, COALESCE(datname, ''), COALESCE(usename, ''), COALESCE(application_name, ''),
+			COALESCE(backend_type, ''), COALESCE(wait_event_type, ''), COALESCE(wait_event, ''),
+			COALESCE(query_id, 0), left(query, $1)
+		FROM pg_stat_activity
+		WHERE state = 'active' AND pid <> pg_backend_pid()`,
+	`SELECT pid, COALESCE(datname, ''), COALESCE(usename, ''), COALESCE(application_name, ''),
+			COALESCE(backend_type, ''), COALESCE(wait_event_type, ''), COALESCE(wait_event, ''),
+			0, left(query, $1)
+		FROM pg_stat_activity
+		WHERE state = 'active' AND pid <> pg_backend_pid()`,
+}
+
+// ring is a bounded buffer of activity samples that overwrites the oldest
+// samples once it is full.
+type ring struct {
+	samples []model.ActivitySample
+	next    int
+	full    bool
+}
+
+func (r *ring) add(s model.ActivitySample) {
+	r.samples[r.next] = s
+	r.next = (r.next + 1) % len(r.samples)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// since returns the samples taken after t, oldest first.
+func (r *ring) since(t time.Time) []model.ActivitySample {
+	var ordered []model.ActivitySample
+	if r.full {
+		ordered = append(ordered, r.samples[r.next:]...)
+	}
+	ordered = append(ordered, r.samples[:r.next]...)
+
+	i := sort.Search(len(ordered), func(i int) bool {
+		return ordered[i].SampledAt.After(t)
+	})
+	return ordered[i:]
+}
+
+// Sampler polls pg_stat_activity at a fixed interval into a ring buffer, in
+// the manner of Oracle's Active Session History. It uses a connection of its
+// own so that sampling is not held up by the collection of the snapshots.
+type Sampler struct {
+	db        *sql.DB
+	interval  time.Duration
+	sqlLength uint
+	mode      sqlnorm.Mode
+	// index into activityQueries of the query the server understands
+	query int
+
+	mu   sync.Mutex
+	buf  ring
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewSampler creates a sampler keeping the last size session samples. The
+// query texts of the samples are transformed according to mode.
+func NewSampler(db *sql.DB, interval time.Duration, size int, sqlLength uint, mode sqlnorm.Mode) *Sampler {
+	if interval <= 0 {
+		interval = DefaultSampleInterval
+	}
+	if size <= 0 {
+		size = DefaultASHBufferSize
+	}
+
+	return &Sampler{
+		db:        db,
+		interval:  interval,
+		sqlLength: sqlLength,
+		mode:      mode,
+		buf:       ring{samples: make([]model.ActivitySample, size)},
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start starts sampling in the background until Stop is called.
+func (s *Sampler) Start() {
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := s.sample(); err != nil {
+				log.Printf("could not sample pg_stat_activity: %s\n", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops sampling and closes the sampler's connection.
+func (s *Sampler) Stop() {
+	close(s.stop)
+	<-s.done
+	s.db.Close()
+}
+
+func (s *Sampler) sample() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, activityQueries[s.query], s.sqlLength)
+	if isUndefinedObject(err) && s.query+1 < len(activityQueries) {
+		s.query++
+		rows, err = s.db.QueryContext(ctx, activityQueries[s.query], s.sqlLength)
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var samples []model.ActivitySample
+	for rows.Next() {
+		a := model.ActivitySample{SampledAt: now}
+		err := rows.Scan(&a.PID, &a.DBName, &a.UserName, &a.Application, &a.BackendType,
+			&a.WaitEventType, &a.WaitEvent, &a.QueryID, &a.Query)
+		if err != nil {
+			return err
+		}
+		a.Fingerprint = sqlnorm.Fingerprint(a.Query)
+		a.Query = s.mode.Apply(a.Query)
+		samples = append(samples, a)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range samples {
+		s.buf.add(a)
+	}
+	return nil
+}
+
+// Samples returns the buffered samples taken after t, oldest first.
+func (s *Sampler) Samples(t time.Time) []model.ActivitySample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.since(t)
+}
+
+// Report aggregates the samples taken in (start, end] into DB time.
+func (s *Sampler) Report(start, end time.Time) model.ActiveSessionHistory {
+	var samples []model.ActivitySample
+	for _, a := range s.Samples(start) {
+		if !a.SampledAt.After(end) {
+			samples = append(samples, a)
+		}
+	}
+	return AggregateActivity(samples, s.interval, start, end)
+}
+
+// AggregateActivity breaks the DB time of samples down by wait event, query,
+// user, application and database. Sessions that are not waiting are
+// accounted to "CPU".
+func AggregateActivity(samples []model.ActivitySample, interval time.Duration, start, end time.Time) model.ActiveSessionHistory {
+	step := interval.Seconds()
+	ash := model.ActiveSessionHistory{
+		WindowStart:           start,
+		WindowEnd:             end,
+		SampleIntervalSeconds: step,
+		Samples:               len(samples),
+		DBTimeSeconds:         float64(len(samples)) * step,
+	}
+	if window := end.Sub(start).Seconds(); window > 0 {
+		ash.AverageActiveSessions = ash.DBTimeSeconds / window
+	}
+
+	waits := map[string]float64{}
+	queries := map[string]float64{}
+	queryTexts := map[string]string{}
+	users := map[string]float64{}
+	apps := map[string]float64{}
+	dbs := map[string]float64{}
+
+	for _, a := range samples {
+		wait := "CPU"
+		if a.WaitEvent != "" {
+			wait = a.WaitEventType + ":" + a.WaitEvent
+		}
+		waits[wait] += step
+
+		query := strconv.FormatInt(a.QueryID, 10)
+		if a.QueryID == 0 {
+			query = a.Fingerprint
+		}
+		queries[query] += step
+		queryTexts[query] = a.Query
+
+		users[a.UserName] += step
+		apps[a.Application] += step
+		dbs[a.DBName] += step
+	}
+
+	ash.ByWaitEvent = dbTimes(waits, ash.DBTimeSeconds, nil)
+	ash.ByQuery = dbTimes(queries, ash.DBTimeSeconds, queryTexts)
+	ash.ByUser = dbTimes(users, ash.DBTimeSeconds, nil)
+	ash.ByApplication = dbTimes(apps, ash.DBTimeSeconds, nil)
+	ash.ByDatabase = dbTimes(dbs, ash.DBTimeSeconds, nil)
+	return ash
+}
+
+func dbTimes(seconds map[string]float64, total float64, queries map[string]string) []model.DBTime {
+	var times []model.DBTime
+	for k, s := range seconds {
+		t := model.DBTime{Key: k, Seconds: s, Percent: percent(s, total)}
+		if queries != nil {
+			t.Query = queries[k]
+		}
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool {
+		if times[i].Seconds != times[j].Seconds {
+			return times[i].Seconds > times[j].Seconds
+		}
+		return times[i].Key < times[j].Key
+	})
+	if len(times) > maxDBTimeEntries {
+		times = times[:maxDBTimeEntries]
+	}
+	return times
+}
+
+func percent(v, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return v / total * 100
+}
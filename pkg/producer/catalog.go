@@ -0,0 +1,158 @@
+package producer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+const (
+	DefaultCatalogRefresh = 5 * time.Minute
+
+	// minCatalogRefresh bounds how often unresolved OIDs trigger a reload,
+	// as statements may keep referencing dropped roles or databases.
+	minCatalogRefresh = time.Minute
+)
+
+// CatalogCache maps the OIDs of roles, databases and tablespaces to their
+// names, so the published structures can carry names next to the OIDs. It is
+// reloaded once it is older than its refresh interval, or sooner after an OID
+// could not be resolved.
+type CatalogCache struct {
+	refresh  time.Duration
+	loadedAt time.Time
+	stale    bool
+
+	roles       map[int]string
+	databases   map[int]string
+	tableSpaces map[int]model.TableSpace
+	// ordered list of the tablespaces for publishing
+	tableSpaceList []model.TableSpace
+}
+
+func NewCatalogCache(refresh time.Duration) *CatalogCache {
+	return &CatalogCache{refresh: refresh}
+}
+
+// Refresh reloads the cache from the catalogs if it is stale.
+func (c *CatalogCache) Refresh(db *sql.DB) error {
+	age := time.Since(c.loadedAt)
+	if !c.loadedAt.IsZero() && age < c.refresh && !(c.stale && age >= minCatalogRefresh) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	roles, err := queryNames(ctx, db, `SELECT oid, rolname FROM pg_roles`)
+	if err != nil {
+		return err
+	}
+	databases, err := queryNames(ctx, db, `SELECT oid, datname FROM pg_database`)
+	if err != nil {
+		return err
+	}
+	tableSpaces, err := getTableSpaces(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	c.roles = roles
+	c.databases = databases
+	c.tableSpaces = map[int]model.TableSpace{}
+	for _, ts := range tableSpaces {
+		c.tableSpaces[ts.OID] = ts
+	}
+	c.tableSpaceList = tableSpaces
+	c.loadedAt = time.Now()
+	c.stale = false
+	return nil
+}
+
+func queryNames(ctx context.Context, db *sql.DB, q string) (map[int]string, error) {
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := map[int]string{}
+	for rows.Next() {
+		var oid int
+		var name string
+		if err := rows.Scan(&oid, &name); err != nil {
+			return nil, err
+		}
+		names[oid] = name
+	}
+	return names, rows.Err()
+}
+
+func getTableSpaces(ctx context.Context, db *sql.DB) ([]model.TableSpace, error) {
+	// pg_tablespace_size() requires CREATE on the tablespace or membership in
+	// pg_read_all_stats
+	q := `SELECT t.oid, t.spcname, pg_get_userbyid(t.spcowner), pg_tablespace_location(t.oid),
+				CASE WHEN has_tablespace_privilege(t.oid, 'CREATE') OR pg_has_role('pg_read_all_stats', 'USAGE')
+					THEN pg_tablespace_size(t.oid)
+				END
+			FROM pg_tablespace AS t
+			ORDER BY t.oid ASC`
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tableSpaces []model.TableSpace
+	for rows.Next() {
+		var ts model.TableSpace
+		var size sql.NullInt64
+		if err := rows.Scan(&ts.OID, &ts.Name, &ts.OwnerName, &ts.Location, &size); err != nil {
+			return nil, err
+		}
+		ts.Size = size.Int64
+		tableSpaces = append(tableSpaces, ts)
+	}
+	return tableSpaces, rows.Err()
+}
+
+func (c *CatalogCache) lookup(names map[int]string, oid int, missed *bool) string {
+	name, ok := names[oid]
+	if !ok {
+		*missed = true
+	}
+	return name
+}
+
+// Enrich fills in the names of the OIDs referenced by m, and its list of
+// tablespaces.
+func (c *CatalogCache) Enrich(m *model.Model) {
+	missed := false
+
+	for i := range m.Statements {
+		s := &m.Statements[i]
+		s.UserName = c.lookup(c.roles, s.UserOID, &missed)
+		s.DBName = c.lookup(c.databases, s.DBOID, &missed)
+	}
+
+	for i := range m.Databases {
+		d := &m.Databases[i]
+		d.OwnerName = c.lookup(c.roles, d.DatDBA, &missed)
+		if ts, ok := c.tableSpaces[d.DatTableSpace]; ok {
+			d.TableSpace = ts.Name
+			d.TableSpaceLocation = ts.Location
+			d.TableSpaceSize = ts.Size
+		} else {
+			missed = true
+		}
+	}
+
+	m.TableSpaces = c.tableSpaceList
+
+	// something may have been created since the last refresh
+	if missed {
+		c.stale = true
+	}
+}
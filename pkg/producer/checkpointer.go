@@ -0,0 +1,54 @@
+package producer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+// GetCheckpointer returns the counters of the checkpointer and the background
+// writer, and the WAL counters on servers that have pg_stat_wal.
+func GetCheckpointer(db *sql.DB) (model.Checkpointer, *model.WAL, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var c model.Checkpointer
+
+	var version int
+	err := db.QueryRowContext(ctx, `SELECT current_setting('server_version_num')::int`).Scan(&version)
+	if err != nil {
+		return c, nil, err
+	}
+
+	// Postgres 17 moved the checkpoint counters to pg_stat_checkpointer
+	q := `SELECT checkpoints_timed, checkpoints_req, checkpoint_write_time, checkpoint_sync_time,
+				buffers_checkpoint, buffers_clean, maxwritten_clean, buffers_backend, buffers_alloc,
+				COALESCE(stats_reset, 'epoch')
+			FROM pg_stat_bgwriter`
+	if version >= 170000 {
+		q = `SELECT C.num_timed, C.num_requested, C.write_time, C.sync_time,
+				C.buffers_written, B.buffers_clean, B.maxwritten_clean, 0, B.buffers_alloc,
+				COALESCE(C.stats_reset, 'epoch')
+			FROM pg_stat_checkpointer AS C, pg_stat_bgwriter AS B`
+	}
+	err = db.QueryRowContext(ctx, q).Scan(&c.CheckpointsTimed, &c.CheckpointsRequested, &c.WriteTime, &c.SyncTime,
+		&c.BuffersCheckpoint, &c.BuffersClean, &c.MaxWrittenClean, &c.BuffersBackend, &c.BuffersAlloc,
+		&c.StatsReset)
+	if err != nil {
+		return c, nil, err
+	}
+
+	var w model.WAL
+	q = `SELECT wal_records, wal_fpi, wal_bytes::bigint, wal_buffers_full, COALESCE(stats_reset, 'epoch')
+			FROM pg_stat_wal`
+	err = db.QueryRowContext(ctx, q).Scan(&w.Records, &w.FPI, &w.Bytes, &w.BuffersFull, &w.StatsReset)
+	if isUndefinedObject(err) {
+		return c, nil, nil
+	}
+	if err != nil {
+		return c, nil, err
+	}
+	return c, &w, nil
+}
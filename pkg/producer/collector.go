@@ -0,0 +1,75 @@
+package producer
+
+import (
+	"database/sql"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/sqlnorm"
+)
+
+// Collector collects snapshots of a target the way the agent does, names
+// resolved and query texts handled according to the privacy settings, for
+// the tools reading a database directly.
+type Collector struct {
+	db      *sql.DB
+	catalog *CatalogCache
+	mode    sqlnorm.Mode
+}
+
+func NewCollector(db *sql.DB) *Collector {
+	return &Collector{db: db, catalog: NewCatalogCache(DefaultCatalogRefresh), mode: QueryTextMode()}
+}
+
+// Collect returns a snapshot of the target.
+func (c *Collector) Collect() (model.Model, error) {
+	m, err := GetMetrics(c.db)
+	if err != nil {
+		return m, err
+	}
+	if err := c.catalog.Refresh(c.db); err != nil {
+		return m, err
+	}
+	c.catalog.Enrich(&m)
+	redactMetrics(&m, c.mode)
+	return m, nil
+}
+
+// Sessions returns the backends of the target, as GetSessions.
+func (c *Collector) Sessions(sqlLength uint) ([]model.Session, error) {
+	return GetSessions(c.db, sqlLength, c.mode)
+}
+
+// Locks returns the lock waits of the target, as GetLocks.
+func (c *Collector) Locks(sqlLength uint) ([]model.Lock, error) {
+	return GetLocks(c.db, sqlLength, c.mode)
+}
+
+// Statements returns the limit statements with the largest total execution
+// time, all of them if limit is zero, as Collect does.
+func (c *Collector) Statements(limit int) ([]model.Statement, error) {
+	var m model.Model
+	var err error
+	if m.Statements, err = GetTopStatements(c.db, limit); err != nil {
+		return nil, err
+	}
+	if err := c.catalog.Refresh(c.db); err != nil {
+		return nil, err
+	}
+	c.catalog.Enrich(&m)
+	redactMetrics(&m, c.mode)
+	return m.Statements, nil
+}
+
+// Databases returns the databases of the target, as Collect does.
+func (c *Collector) Databases() ([]model.Database, error) {
+	var m model.Model
+	var err error
+	if m.Databases, err = GetDatabases(c.db); err != nil {
+		return nil, err
+	}
+	if err := c.catalog.Refresh(c.db); err != nil {
+		return nil, err
+	}
+	c.catalog.Enrich(&m)
+	return m.Databases, nil
+}
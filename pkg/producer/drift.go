@@ -0,0 +1,136 @@
+package producer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+// roleSettings only take effect on a standby, or only on a primary, and so
+// are expected to differ between the two.
+var roleSettings = map[string]bool{
+	"archive_cleanup_command":       true,
+	"hot_standby":                   true,
+	"hot_standby_feedback":          true,
+	"max_standby_archive_delay":     true,
+	"max_standby_streaming_delay":   true,
+	"primary_conninfo":              true,
+	"primary_slot_name":             true,
+	"promote_trigger_file":          true,
+	"recovery_end_command":          true,
+	"recovery_min_apply_delay":      true,
+	"recovery_target":               true,
+	"recovery_target_action":        true,
+	"recovery_target_inclusive":     true,
+	"recovery_target_lsn":           true,
+	"recovery_target_name":          true,
+	"recovery_target_time":          true,
+	"recovery_target_timeline":      true,
+	"recovery_target_xid":           true,
+	"restore_command":               true,
+	"synchronous_standby_names":     true,
+	"wal_receiver_create_temp_slot": true,
+	"wal_receiver_status_interval":  true,
+	"wal_receiver_timeout":          true,
+	"wal_retrieve_retry_interval":   true,
+}
+
+// GetDrift compares the settings of the targets that are expected to share
+// their configuration: each primary with its replicas, and all targets that
+// carry the same label. snapshots maps target names to their latest metrics;
+// targets without a snapshot are left out. The settings of roleSettings are
+// only compared within groups of primaries or of standbys.
+func GetDrift(targets []Target, snapshots map[string]model.Model) model.DriftReport {
+	report := model.DriftReport{CreatedAt: time.Now()}
+
+	replicas := map[string][]string{}
+	for _, t := range targets {
+		if t.Role == "replica" && t.Primary != "" {
+			replicas[t.Primary] = append(replicas[t.Primary], t.Name)
+		}
+	}
+	for primary, names := range replicas {
+		report.Groups = append(report.Groups, driftGroup("replication", primary, append([]string{primary}, names...), snapshots))
+	}
+
+	labelled := map[string][]string{}
+	for _, t := range targets {
+		for k, v := range t.Labels {
+			label := fmt.Sprintf("%s=%s", k, v)
+			labelled[label] = append(labelled[label], t.Name)
+		}
+	}
+	for label, names := range labelled {
+		if len(names) > 1 {
+			report.Groups = append(report.Groups, driftGroup("label", label, names, snapshots))
+		}
+	}
+
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if report.Groups[i].Kind != report.Groups[j].Kind {
+			return report.Groups[i].Kind > report.Groups[j].Kind
+		}
+		return report.Groups[i].Name < report.Groups[j].Name
+	})
+	return report
+}
+
+func driftGroup(kind, name string, targets []string, snapshots map[string]model.Model) model.DriftGroup {
+	g := model.DriftGroup{Kind: kind, Name: name}
+
+	values := map[string]map[string]model.Setting{}
+	params := map[string]bool{}
+	roles := map[bool]bool{}
+	for _, t := range targets {
+		m, ok := snapshots[t]
+		if !ok {
+			continue
+		}
+		g.Targets = append(g.Targets, t)
+		roles[m.Replication.InRecovery] = true
+		values[t] = settingValues(m.Settings.Parameters)
+		for p := range values[t] {
+			params[p] = true
+		}
+	}
+
+	for p := range params {
+		if len(roles) > 1 && roleSettings[p] {
+			continue
+		}
+		d := model.SettingDrift{Name: p, Values: map[string]string{}}
+		differs := false
+		for _, t := range g.Targets {
+			d.Values[t] = values[t][p].Setting
+			if d.Values[t] != d.Values[g.Targets[0]] {
+				differs = true
+			}
+		}
+		if differs {
+			g.Differences = append(g.Differences, d)
+		}
+	}
+	sort.Slice(g.Differences, func(i, j int) bool {
+		return g.Differences[i].Name < g.Differences[j].Name
+	})
+	return g
+}
+
+// driftKey identifies the differences found by a drift report, empty if
+// there are none.
+func driftKey(r model.DriftReport) string {
+	var groups []model.DriftGroup
+	for _, g := range r.Groups {
+		if len(g.Differences) > 0 {
+			groups = append(groups, model.DriftGroup{Kind: g.Kind, Name: g.Name, Differences: g.Differences})
+		}
+	}
+	if len(groups) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(groups)
+	return string(data)
+}
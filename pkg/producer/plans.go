@@ -0,0 +1,108 @@
+package producer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/logs"
+	"github.com/pkbhowmick/pg-monitoring/pkg/sqlnorm"
+)
+
+// planRetention is how long the plans of a query are remembered after it was
+// last seen in the log.
+const planRetention = 24 * time.Hour
+
+type queryPlans struct {
+	queryID  int64
+	query    string
+	current  string
+	shapes   map[string]*model.PlanStats
+	lastSeen time.Time
+}
+
+// PlanTracker follows the plans logged by auto_explain and reports when a
+// query switches to a plan of a different shape.
+type PlanTracker struct {
+	queries map[string]*queryPlans
+}
+
+func NewPlanTracker() *PlanTracker {
+	return &PlanTracker{queries: map[string]*queryPlans{}}
+}
+
+// Update accounts for the plans found in entries. Plans are linked to the
+// pg_stat_statements entry whose normalized text matches the logged query
+// text, unless the log carries the query ID itself.
+func (t *PlanTracker) Update(entries []logs.Entry, statements []model.Statement) []model.PlanChange {
+	var byText map[string]int64
+	var changes []model.PlanChange
+
+	for _, e := range entries {
+		p, ok := logs.ParsePlan(e)
+		if !ok {
+			continue
+		}
+		if p.Time.IsZero() {
+			p.Time = time.Now()
+		}
+
+		query := sqlnorm.Normalize(p.QueryText)
+		if p.QueryID == 0 {
+			if byText == nil {
+				byText = statementsByText(statements)
+			}
+			p.QueryID = byText[query]
+		}
+
+		key := fmt.Sprintf("id:%d", p.QueryID)
+		if p.QueryID == 0 {
+			key = "text:" + query
+		}
+
+		q, ok := t.queries[key]
+		if !ok {
+			q = &queryPlans{queryID: p.QueryID, query: query, shapes: map[string]*model.PlanStats{}}
+			t.queries[key] = q
+		}
+		q.lastSeen = p.Time
+
+		s, ok := q.shapes[p.Fingerprint]
+		if !ok {
+			s = &model.PlanStats{Fingerprint: p.Fingerprint, Shape: p.Shape, FirstSeen: p.Time}
+			q.shapes[p.Fingerprint] = s
+		}
+		s.MeanMs = (s.MeanMs*float64(s.Executions) + p.DurationMs) / float64(s.Executions+1)
+		s.Executions++
+		if p.DurationMs > s.MaxMs {
+			s.MaxMs = p.DurationMs
+		}
+		s.LastSeen = p.Time
+
+		if q.current != "" && q.current != p.Fingerprint {
+			changes = append(changes, model.PlanChange{
+				QueryID:   q.queryID,
+				Query:     q.query,
+				OldPlan:   *q.shapes[q.current],
+				NewPlan:   *s,
+				ChangedAt: p.Time,
+			})
+		}
+		q.current = p.Fingerprint
+	}
+
+	for key, q := range t.queries {
+		if time.Since(q.lastSeen) > planRetention {
+			delete(t.queries, key)
+		}
+	}
+	return changes
+}
+
+func statementsByText(statements []model.Statement) map[string]int64 {
+	byText := make(map[string]int64, len(statements))
+	for _, s := range statements {
+		byText[sqlnorm.Normalize(s.Query)] = s.QueryID
+	}
+	return byText
+}
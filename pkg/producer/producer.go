@@ -13,24 +13,54 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/pkbhowmick/pg-monitoring/model"
 	"github.com/pkbhowmick/pg-monitoring/pkg/database"
+	"github.com/pkbhowmick/pg-monitoring/pkg/subjects"
 	"github.com/tidwall/pretty"
 	"gopkg.in/ini.v1"
 )
 
+const (
+	MetricsSubject        = subjects.Metrics
+	ProgressSubject       = subjects.Progress
+	SettingsChangeSubject = subjects.SettingsChange
+	DriftSubject          = subjects.Drift
+	PlanChangeSubject     = subjects.PlanChange
+	ASHDumpSubject        = subjects.ASHDump
+	QueryTextSubject      = subjects.QueryText
+	QueryTextGetSubject   = subjects.QueryTextGet
+	AnomalySubject        = subjects.Anomaly
+	AlertFiringSubject    = subjects.AlertFiring
+	AlertResolvedSubject  = subjects.AlertResolved
+)
+
 var (
 	cfg                *ini.File
 	DBConnectionConfig string
 )
 
+// DefaultStatementsLimit is the number of statements published, the ones
+// with the largest total execution time.
+const DefaultStatementsLimit = 10
+
 func GetStatements(db *sql.DB) ([]model.Statement, error) {
+	return GetTopStatements(db, DefaultStatementsLimit)
+}
+
+// GetTopStatements returns the limit statements with the largest total
+// execution time, all of them if limit is zero.
+func GetTopStatements(db *sql.DB, limit int) ([]model.Statement, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	q := `SELECT userid, dbid, queryid, calls, query, total_exec_time, min_exec_time, max_exec_time
+	q := `SELECT userid, dbid, queryid, calls, query, total_exec_time, min_exec_time, max_exec_time,
+                 rows, shared_blks_hit, shared_blks_read, temp_blks_written
           FROM pg_stat_statements
           ORDER BY total_exec_time DESC
-          LIMIT 10`
-	rows, err := db.QueryContext(ctx, q)
+          LIMIT $1`
+	var max interface{}
+	if limit > 0 {
+		max = limit
+	}
+	rows, err := db.QueryContext(ctx, q, max)
 	if err != nil {
 		return nil, err
 	}
@@ -40,7 +70,8 @@ func GetStatements(db *sql.DB) ([]model.Statement, error) {
 
 	for rows.Next() {
 		var s model.Statement
-		err := rows.Scan(&s.UserOID, &s.DBOID, &s.QueryID, &s.Calls, &s.Query, &s.TotalTime, &s.MinTime, &s.MaxTime)
+		err := rows.Scan(&s.UserOID, &s.DBOID, &s.QueryID, &s.Calls, &s.Query, &s.TotalTime, &s.MinTime, &s.MaxTime,
+			&s.Rows, &s.SharedBlksHit, &s.SharedBlksRead, &s.TempBlksWritten)
 		if err != nil {
 			return nil, err
 		}
@@ -54,7 +85,10 @@ func GetDatabases(db *sql.DB) ([]model.Database, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	q := `SELECT D.oid, D.datname, D.datdba, D.dattablespace, s.numbackends
+	q := `SELECT D.oid, D.datname, D.datdba, D.dattablespace, s.numbackends,
+				S.xact_commit, S.xact_rollback, S.blks_read, S.blks_hit,
+				S.tup_returned, S.tup_fetched, S.tup_inserted, S.tup_updated, S.tup_deleted,
+				S.temp_files, S.temp_bytes, S.deadlocks
 			FROM pg_database AS D JOIN pg_stat_database AS S ON D.oid = S.datid
 			WHERE (NOT D.datistemplate)
 			ORDER BY D.oid ASC`
@@ -65,7 +99,10 @@ func GetDatabases(db *sql.DB) ([]model.Database, error) {
 	var databases []model.Database
 	for rows.Next() {
 		var d model.Database
-		err := rows.Scan(&d.OID, &d.Name, &d.DatDBA, &d.DatTableSpace, &d.NumBackends)
+		err := rows.Scan(&d.OID, &d.Name, &d.DatDBA, &d.DatTableSpace, &d.NumBackends,
+			&d.XactCommit, &d.XactRollback, &d.BlksRead, &d.BlksHit,
+			&d.TupReturned, &d.TupFetched, &d.TupInserted, &d.TupUpdated, &d.TupDeleted,
+			&d.TempFiles, &d.TempBytes, &d.Deadlocks)
 		if err != nil {
 			return nil, err
 		}
@@ -78,9 +115,11 @@ func GetTablesInfo(db *sql.DB) ([]model.Table, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	q := `SELECT relid, schemaname, relname, current_database(), n_tup_ins, n_live_tup
-			FROM pg_stat_user_tables
-			ORDER BY relid ASC`
+	q := `SELECT T.relid, T.schemaname, T.relname, current_database(), T.n_tup_ins, T.n_live_tup, T.n_dead_tup,
+				T.n_tup_upd, T.n_tup_del, COALESCE(T.seq_scan, 0), COALESCE(T.idx_scan, 0),
+				COALESCE(IO.heap_blks_read, 0), COALESCE(IO.heap_blks_hit, 0)
+			FROM pg_stat_user_tables AS T JOIN pg_statio_user_tables AS IO ON T.relid = IO.relid
+			ORDER BY T.relid ASC`
 
 	rows, err := db.QueryContext(ctx, q)
 	if err != nil {
@@ -92,7 +131,8 @@ func GetTablesInfo(db *sql.DB) ([]model.Table, error) {
 	for rows.Next() {
 		var t model.Table
 
-		err := rows.Scan(&t.OID, &t.SchemaName, &t.Name, &t.DBName, &t.RowsInserted, &t.RowsLive)
+		err := rows.Scan(&t.OID, &t.SchemaName, &t.Name, &t.DBName, &t.RowsInserted, &t.RowsLive, &t.RowsDead,
+			&t.RowsUpdated, &t.RowsDeleted, &t.SeqScan, &t.IdxScan, &t.HeapBlksRead, &t.HeapBlksHit)
 		if err != nil {
 			return nil, err
 		}
@@ -102,41 +142,99 @@ func GetTablesInfo(db *sql.DB) ([]model.Table, error) {
 	return tables, nil
 }
 
-func GetJsonMetrics() ([]byte, error) {
-	connConfig := database.GetDefaultCollectConfig()
-	db, err := database.GetDBConnection(DBConnectionConfig, connConfig)
+// GetMetrics collects one snapshot of every metric published on MetricsSubject.
+func GetMetrics(db *sql.DB) (model.Model, error) {
+	return getMetrics(db, DefaultStatementsLimit)
+}
+
+// getMetrics collects a snapshot holding the limit statements with the
+// largest total execution time, all of them if limit is zero.
+func getMetrics(db *sql.DB, limit int) (model.Model, error) {
+	var m model.Model
+	var err error
+
+	m.Statements, err = GetTopStatements(db, limit)
 	if err != nil {
-		fmt.Println("Error in db collection creation")
-		log.Fatalln(err)
+		return m, err
 	}
-	defer db.Close()
 
-	log.Println("Successfully connected to databases")
+	m.Databases, err = GetDatabases(db)
+	if err != nil {
+		return m, err
+	}
 
-	var model model.Model
+	m.Tables, err = GetTablesInfo(db)
+	if err != nil {
+		return m, err
+	}
 
-	model.Statements, err = GetStatements(db)
+	m.Sequences, err = GetSequences(db)
 	if err != nil {
-		return nil, err
+		return m, err
 	}
 
-	model.Databases, err = GetDatabases(db)
+	m.Wraparound, err = GetWraparound(db)
 	if err != nil {
-		return nil, err
+		return m, err
 	}
 
-	model.Tables, err = GetTablesInfo(db)
+	m.Settings, err = GetSettings(db)
+	if err != nil {
+		return m, err
+	}
+
+	m.Replication, err = GetReplication(db)
+	if err != nil {
+		return m, err
+	}
+
+	m.Checkpointer, m.WAL, err = GetCheckpointer(db)
+	if err != nil {
+		return m, err
+	}
+	m.StatementsComplete = limit == 0
+	m.UpdatedAt = time.Now()
+
+	return m, nil
+}
+
+func MarshalMetrics(m model.Model) ([]byte, error) {
+	data, err := json.Marshal(m)
 	if err != nil {
 		return nil, err
 	}
-	model.UpdatedAt = time.Now()
+	return pretty.Pretty(data), nil
+}
 
-	data, err := json.Marshal(model)
+func GetJsonMetrics() ([]byte, error) {
+	db, err := Connect()
 	if err != nil {
+		fmt.Println("Error in db collection creation")
+		log.Fatalln(err)
+	}
+	defer db.Close()
+
+	log.Println("Successfully connected to databases")
+
+	m, err := GetMetrics(db)
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := NewCatalogCache(DefaultCatalogRefresh)
+	if err := catalog.Refresh(db); err != nil {
 		return nil, err
 	}
-	jsonStr := pretty.Pretty(data)
-	return jsonStr, nil
+	catalog.Enrich(&m)
+	redactMetrics(&m, QueryTextMode())
+
+	return MarshalMetrics(m)
+}
+
+// Connect opens a connection to the database configured in app.ini.
+func Connect() (*sql.DB, error) {
+	connConfig := database.GetDefaultCollectConfig()
+	return database.GetDBConnection(DBConnectionConfig, connConfig)
 }
 
 func GetPromMetrics(res http.ResponseWriter, req *http.Request) {
@@ -190,7 +288,8 @@ func PublishEvent(nc *nats.Conn, subject string, data []byte) error {
 	return nil
 }
 
-func Publish() {
+// LoadConfig reads app.ini from the working directory.
+func LoadConfig() {
 	var err error
 	cfg, err = ini.Load("./app.ini")
 	if err != nil {
@@ -198,20 +297,4 @@ func Publish() {
 	}
 
 	DBConnectionConfig = cfg.Section("DATABASE").Key("DB_URL").String()
-
-	nc, err := NewConnection()
-	if err != nil {
-		log.Fatalln(err)
-	}
-	defer nc.Close()
-
-	jsonObj, err := GetJsonMetrics()
-	if err != nil {
-		log.Printf("could not get database metrics: %s\n", err)
-	}
-
-	err = PublishEvent(nc, "metrics.postgres", jsonObj)
-	if err != nil {
-		log.Println(err)
-	}
 }
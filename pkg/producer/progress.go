@@ -0,0 +1,126 @@
+package producer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+// progressViews lists the pg_stat_progress_* views together with a query that
+// maps each of them onto the same (pid, datname, relname, phase, done, total)
+// shape. Views that are missing on older servers are skipped.
+var progressViews = []struct {
+	command string
+	query   string
+}{
+	{"VACUUM", `SELECT pid, datname, relid::regclass::text, phase, heap_blks_scanned, heap_blks_total
+			FROM pg_stat_progress_vacuum`},
+	{"ANALYZE", `SELECT pid, datname, relid::regclass::text, phase, sample_blks_scanned, sample_blks_total
+			FROM pg_stat_progress_analyze`},
+	{"CREATE INDEX", `SELECT pid, datname, relid::regclass::text, phase,
+				CASE WHEN blocks_total > 0 THEN blocks_done ELSE tuples_done END,
+				CASE WHEN blocks_total > 0 THEN blocks_total ELSE tuples_total END
+			FROM pg_stat_progress_create_index`},
+	{"CLUSTER", `SELECT pid, datname, relid::regclass::text, phase, heap_blks_scanned, heap_blks_total
+			FROM pg_stat_progress_cluster`},
+	{"BASE BACKUP", `SELECT pid, '', '', phase, backup_streamed, COALESCE(backup_total, 0)
+			FROM pg_stat_progress_basebackup`},
+	{"COPY", `SELECT pid, datname, relid::regclass::text, command || ' ' || type,
+				CASE WHEN bytes_total > 0 THEN bytes_processed ELSE tuples_processed END,
+				bytes_total
+			FROM pg_stat_progress_copy`},
+}
+
+// isUndefinedObject reports whether err is a Postgres error caused by a
+// relation, column or function that does not exist on the server, which is
+// how older versions answer queries against newer catalog views.
+func isUndefinedObject(err error) bool {
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case "42P01", "42703", "42883":
+			return true
+		}
+	}
+	return false
+}
+
+func GetProgress(db *sql.DB) ([]model.Progress, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var progress []model.Progress
+	now := time.Now()
+
+	for _, v := range progressViews {
+		rows, err := db.QueryContext(ctx, v.query)
+		if isUndefinedObject(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			p := model.Progress{Command: v.command, SampledAt: now}
+			var dbName, relName sql.NullString
+			err := rows.Scan(&p.PID, &dbName, &relName, &p.Phase, &p.Done, &p.Total)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			p.DBName = dbName.String
+			p.RelName = relName.String
+			if p.Total > 0 {
+				p.Percent = float64(p.Done) / float64(p.Total) * 100
+			}
+			progress = append(progress, p)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return progress, nil
+}
+
+type progressKey struct {
+	command string
+	pid     int
+}
+
+// ProgressTracker remembers the previous sample of every running operation so
+// that the speed, and from it the ETA, can be derived from consecutive samples.
+type ProgressTracker struct {
+	prev map[progressKey]model.Progress
+}
+
+func NewProgressTracker() *ProgressTracker {
+	return &ProgressTracker{prev: map[progressKey]model.Progress{}}
+}
+
+// Update fills in ETASeconds for every operation that was also seen in the
+// previous sample and is still in the same phase. Operations that finished
+// since the last call are forgotten.
+func (t *ProgressTracker) Update(progress []model.Progress) []model.Progress {
+	next := make(map[progressKey]model.Progress, len(progress))
+
+	for i := range progress {
+		p := &progress[i]
+		key := progressKey{p.Command, p.PID}
+
+		if prev, ok := t.prev[key]; ok && prev.Phase == p.Phase && p.Total > 0 {
+			elapsed := p.SampledAt.Sub(prev.SampledAt).Seconds()
+			if rate := float64(p.Done-prev.Done) / elapsed; elapsed > 0 && rate > 0 {
+				p.ETASeconds = float64(p.Total-p.Done) / rate
+			}
+		}
+		next[key] = *p
+	}
+
+	t.prev = next
+	return progress
+}
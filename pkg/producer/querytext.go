@@ -0,0 +1,137 @@
+package producer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+// queryTextRetention is how long a text stays in the dictionary after the
+// statement was last published.
+const queryTextRetention = 24 * time.Hour
+
+// queryTextPageSize is the most texts sent in one reply to a resync request.
+const queryTextPageSize = 500
+
+func textHash(q string) string {
+	sum := sha256.Sum256([]byte(q))
+	return hex.EncodeToString(sum[:8])
+}
+
+type dictionaryEntry struct {
+	text     model.QueryText
+	lastSeen time.Time
+}
+
+// QueryDictionary keeps the query texts already published, so that every
+// text is sent once and snapshots only carry its hash. Texts are keyed by
+// query id and hash, as consumers resolve a statement by both. It is safe for
+// concurrent use, as resync requests are answered from the NATS goroutine.
+type QueryDictionary struct {
+	mu      sync.Mutex
+	entries map[model.QueryTextKey]*dictionaryEntry
+}
+
+func NewQueryDictionary() *QueryDictionary {
+	return &QueryDictionary{entries: map[model.QueryTextKey]*dictionaryEntry{}}
+}
+
+// Compact moves the query texts of statements into the dictionary, leaving
+// only their hash in place, and returns the texts that were not in the
+// dictionary yet.
+func (d *QueryDictionary) Compact(statements []model.Statement) []model.QueryText {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	var added []model.QueryText
+
+	for i := range statements {
+		s := &statements[i]
+		s.TextHash = textHash(s.Query)
+
+		key := model.QueryTextKey{QueryID: s.QueryID, TextHash: s.TextHash}
+		e, ok := d.entries[key]
+		if !ok {
+			e = &dictionaryEntry{text: model.QueryText{
+				QueryID:  s.QueryID,
+				TextHash: s.TextHash,
+				Query:    s.Query,
+			}}
+			d.entries[key] = e
+			added = append(added, e.text)
+		}
+		e.lastSeen = now
+		s.Query = ""
+	}
+
+	for k, e := range d.entries {
+		if now.Sub(e.lastSeen) > queryTextRetention {
+			delete(d.entries, k)
+		}
+	}
+	return added
+}
+
+// Lookup returns the texts of the given keys that are in the dictionary, or
+// all of them if no key is given.
+func (d *QueryDictionary) Lookup(keys []model.QueryTextKey) []model.QueryText {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var texts []model.QueryText
+	if len(keys) == 0 {
+		for _, e := range d.entries {
+			texts = append(texts, e.text)
+		}
+		sort.Slice(texts, func(i, j int) bool {
+			if texts[i].TextHash != texts[j].TextHash {
+				return texts[i].TextHash < texts[j].TextHash
+			}
+			return texts[i].QueryID < texts[j].QueryID
+		})
+		return texts
+	}
+
+	for _, k := range keys {
+		if e, ok := d.entries[k]; ok {
+			texts = append(texts, e.text)
+		}
+	}
+	return texts
+}
+
+// serveQueryTexts answers the resync requests of consumers that missed query
+// texts, on QueryTextGetSubject.<target>. The texts are sent a page at a time,
+// no larger than queryTextPageSize texts nor the max payload of the server:
+// the reply tells the offset to request the next page at.
+func (a *agent) serveQueryTexts(nc *nats.Conn) (*nats.Subscription, error) {
+	return nc.Subscribe(QueryTextGetSubject+"."+a.target.Name, func(msg *nats.Msg) {
+		var req model.QueryTextRequest
+		if len(msg.Data) > 0 {
+			if err := json.Unmarshal(msg.Data, &req); err != nil {
+				log.Printf("invalid query text request: %s\n", err)
+				return
+			}
+		}
+
+		texts := a.queryTexts.Lookup(req.Keys)
+		for i := range texts {
+			texts[i].Target = a.target.Name
+		}
+
+		err := respondPage(nc, msg, len(texts), req.Offset, req.Limit, queryTextPageSize, func(start, end int, next *int) interface{} {
+			return model.QueryTexts{Texts: texts[start:end], Total: len(texts), NextOffset: next}
+		})
+		if err != nil {
+			log.Printf("could not send query texts: %s\n", err)
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package producer
+
+import (
+	"log"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/sqlnorm"
+)
+
+// QueryTextMode returns what becomes of query texts before they are
+// published, as configured by QUERY_TEXT in the [PRIVACY] section. Query texts
+// are redacted unless configured otherwise.
+func QueryTextMode() sqlnorm.Mode {
+	if cfg == nil {
+		return sqlnorm.Redact
+	}
+
+	mode, err := sqlnorm.ParseMode(cfg.Section("PRIVACY").Key("QUERY_TEXT").MustString(string(sqlnorm.Redact)))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return mode
+}
+
+// redactMetrics fingerprints the query texts of m and applies mode to them.
+// Activity samples are redacted by the sampler itself.
+func redactMetrics(m *model.Model, mode sqlnorm.Mode) {
+	for i := range m.Statements {
+		s := &m.Statements[i]
+		s.Fingerprint = sqlnorm.Fingerprint(s.Query)
+		s.Query = mode.Apply(s.Query)
+	}
+
+	if m.Logs != nil {
+		for i := range m.Logs.SlowStatements {
+			s := &m.Logs.SlowStatements[i]
+			s.Fingerprint = sqlnorm.Fingerprint(s.Query)
+			s.Query = mode.Apply(s.Query)
+		}
+	}
+}
+
+func redactPlanChange(c *model.PlanChange, mode sqlnorm.Mode) {
+	c.Fingerprint = sqlnorm.Fingerprint(c.Query)
+	c.Query = mode.Apply(c.Query)
+}
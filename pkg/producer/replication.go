@@ -0,0 +1,54 @@
+package producer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+func GetReplication(db *sql.DB) (model.Replication, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var r model.Replication
+
+	q := `SELECT pg_is_in_recovery(),
+				CASE WHEN pg_is_in_recovery()
+					THEN COALESCE(EXTRACT(epoch FROM now() - pg_last_xact_replay_timestamp()), 0)
+					ELSE 0
+				END`
+	err := db.QueryRowContext(ctx, q).Scan(&r.InRecovery, &r.ReplayLagSeconds)
+	if err != nil {
+		return r, err
+	}
+
+	// a cascading standby has no current WAL position of its own
+	q = `SELECT COALESCE(application_name, ''), COALESCE(client_addr::text, ''),
+				COALESCE(state, ''), COALESCE(sync_state, ''),
+				COALESCE(EXTRACT(epoch FROM write_lag), 0),
+				COALESCE(EXTRACT(epoch FROM flush_lag), 0),
+				COALESCE(EXTRACT(epoch FROM replay_lag), 0),
+				COALESCE(pg_wal_lsn_diff(
+					CASE WHEN pg_is_in_recovery() THEN pg_last_wal_receive_lsn() ELSE pg_current_wal_lsn() END,
+					replay_lsn), 0)::bigint
+			FROM pg_stat_replication
+			ORDER BY application_name`
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return r, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s model.Standby
+		err := rows.Scan(&s.ApplicationName, &s.ClientAddr, &s.State, &s.SyncState,
+			&s.WriteLagSeconds, &s.FlushLagSeconds, &s.ReplayLagSeconds, &s.ReplayLagBytes)
+		if err != nil {
+			return r, err
+		}
+		r.Standbys = append(r.Standbys, s)
+	}
+	return r, rows.Err()
+}
@@ -0,0 +1,98 @@
+package producer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+func GetSequences(db *sql.DB) ([]model.Sequence, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// pg_depend links serial ('a') and identity ('i') sequences to the column
+	// they feed.
+	q := `SELECT s.schemaname, s.sequencename, s.data_type::text, s.last_value,
+				s.start_value, s.min_value, s.max_value, s.increment_by, s.cycle,
+				COALESCE(t.relname, ''), COALESCE(a.attname, ''),
+				COALESCE(format_type(a.atttypid, a.atttypmod), ''),
+				CASE a.atttypid
+					WHEN 'int2'::regtype THEN 32767
+					WHEN 'int4'::regtype THEN 2147483647
+					WHEN 'int8'::regtype THEN 9223372036854775807
+					ELSE 0
+				END
+			FROM pg_sequences AS s
+			JOIN pg_namespace AS n ON n.nspname = s.schemaname
+			JOIN pg_class AS c ON c.relnamespace = n.oid AND c.relname = s.sequencename
+			LEFT JOIN pg_depend AS d ON d.classid = 'pg_class'::regclass AND d.objid = c.oid
+				AND d.refclassid = 'pg_class'::regclass AND d.deptype IN ('a', 'i')
+			LEFT JOIN pg_class AS t ON t.oid = d.refobjid
+			LEFT JOIN pg_attribute AS a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+			ORDER BY s.schemaname, s.sequencename`
+
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sequences []model.Sequence
+
+	for rows.Next() {
+		var s model.Sequence
+		var last sql.NullInt64
+
+		err := rows.Scan(&s.SchemaName, &s.Name, &s.DataType, &last,
+			&s.StartValue, &s.MinValue, &s.MaxValue, &s.Increment, &s.Cycle,
+			&s.TableName, &s.ColumnName, &s.ColumnType, &s.ColumnMaxValue)
+		if err != nil {
+			return nil, err
+		}
+		if last.Valid {
+			s.LastValue = &last.Int64
+		}
+		if s.ColumnMaxValue > 0 && (s.ColumnMaxValue < s.MaxValue || -s.ColumnMaxValue-1 > s.MinValue) {
+			s.ColumnNarrower = true
+		}
+		s.PercentUsed = sequencePercentUsed(s)
+
+		sequences = append(sequences, s)
+	}
+	return sequences, rows.Err()
+}
+
+// sequencePercentUsed returns how much of the distance between the start value
+// and the limit the sequence has covered. The limit is the max (or, for
+// descending sequences, the min) value of the sequence or of the column it
+// feeds, whichever is reached first. Floats are used because the span of an
+// int8 sequence does not fit into an int64.
+func sequencePercentUsed(s model.Sequence) float64 {
+	if s.LastValue == nil {
+		return 0
+	}
+
+	var used, span float64
+	if s.Increment > 0 {
+		limit := s.MaxValue
+		if s.ColumnNarrower && s.ColumnMaxValue < limit {
+			limit = s.ColumnMaxValue
+		}
+		used = float64(*s.LastValue) - float64(s.StartValue)
+		span = float64(limit) - float64(s.StartValue)
+	} else {
+		limit := s.MinValue
+		if s.ColumnNarrower && -s.ColumnMaxValue-1 > limit {
+			limit = -s.ColumnMaxValue - 1
+		}
+		used = float64(s.StartValue) - float64(*s.LastValue)
+		span = float64(s.StartValue) - float64(limit)
+	}
+
+	if span <= 0 {
+		return 100
+	}
+	return used / span * 100
+}
@@ -0,0 +1,182 @@
+package producer
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+// sessionSettings are reported by pg_settings but describe the monitoring
+// session or the server's current state rather than its configuration. They
+// are left out of the configuration hash, change events and drift reports.
+var sessionSettings = map[string]bool{
+	"application_name":       true,
+	"in_hot_standby":         true,
+	"transaction_deferrable": true,
+	"transaction_isolation":  true,
+	"transaction_read_only":  true,
+}
+
+func isConfigSetting(s model.Setting) bool {
+	return !sessionSettings[s.Name] && s.Source != "client" && s.Source != "session"
+}
+
+func isInsufficientPrivilege(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "42501"
+}
+
+func GetSettings(db *sql.DB) (model.Settings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var settings model.Settings
+
+	q := `SELECT name, setting, COALESCE(unit, ''), source, pending_restart
+			FROM pg_settings
+			ORDER BY name ASC`
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return settings, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s model.Setting
+		err := rows.Scan(&s.Name, &s.Setting, &s.Unit, &s.Source, &s.PendingRestart)
+		if err != nil {
+			return settings, err
+		}
+		settings.Parameters = append(settings.Parameters, s)
+	}
+	if err := rows.Err(); err != nil {
+		return settings, err
+	}
+	settings.Hash = hashSettings(settings.Parameters)
+
+	// pg_file_settings is readable by superusers only by default
+	settings.FileErrors, err = getFileSettingErrors(ctx, db)
+	if err != nil && !isInsufficientPrivilege(err) {
+		return settings, err
+	}
+	return settings, nil
+}
+
+func getFileSettingErrors(ctx context.Context, db *sql.DB) ([]model.FileSettingError, error) {
+	q := `SELECT sourcefile, sourceline, COALESCE(name, ''), COALESCE(setting, ''), error
+			FROM pg_file_settings
+			WHERE error IS NOT NULL
+			ORDER BY sourcefile, sourceline`
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var errs []model.FileSettingError
+	for rows.Next() {
+		var e model.FileSettingError
+		err := rows.Scan(&e.SourceFile, &e.SourceLine, &e.Name, &e.Setting, &e.Error)
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, e)
+	}
+	return errs, rows.Err()
+}
+
+// hashSettings hashes the names and values of the configuration settings.
+// The parameters are expected to be sorted by name.
+func hashSettings(params []model.Setting) string {
+	h := sha256.New()
+	for _, s := range params {
+		if !isConfigSetting(s) {
+			continue
+		}
+		h.Write([]byte(s.Name))
+		h.Write([]byte{0})
+		h.Write([]byte(s.Setting))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func settingValues(params []model.Setting) map[string]model.Setting {
+	values := make(map[string]model.Setting, len(params))
+	for _, s := range params {
+		if isConfigSetting(s) {
+			values[s.Name] = s
+		}
+	}
+	return values
+}
+
+// SettingsTracker detects configuration changes between consecutive cycles.
+type SettingsTracker struct {
+	hash   string
+	values map[string]model.Setting
+}
+
+func NewSettingsTracker() *SettingsTracker {
+	return &SettingsTracker{}
+}
+
+// Update returns the settings that changed since the previous call, or nil if
+// none did. The first call only records the configuration.
+func (t *SettingsTracker) Update(settings model.Settings) *model.SettingsChange {
+	values := settingValues(settings.Parameters)
+	prevHash, prev := t.hash, t.values
+	t.hash, t.values = settings.Hash, values
+
+	if prev == nil || prevHash == settings.Hash {
+		return nil
+	}
+
+	change := &model.SettingsChange{
+		OldHash:   prevHash,
+		NewHash:   settings.Hash,
+		ChangedAt: time.Now(),
+	}
+	for name, s := range values {
+		if old, ok := prev[name]; !ok || old.Setting != s.Setting {
+			change.Changes = append(change.Changes, model.SettingChange{
+				Name:     name,
+				OldValue: old.Setting,
+				NewValue: s.Setting,
+				Unit:     s.Unit,
+			})
+		}
+	}
+	for name, old := range prev {
+		if _, ok := values[name]; !ok {
+			change.Changes = append(change.Changes, model.SettingChange{
+				Name:     name,
+				OldValue: old.Setting,
+				Unit:     old.Unit,
+			})
+		}
+	}
+	sort.Slice(change.Changes, func(i, j int) bool {
+		return change.Changes[i].Name < change.Changes[j].Name
+	})
+	return change
+}
+
+// GetLogTimezone returns the log_timezone of the server, which the times of
+// its log are written in.
+func GetLogTimezone(db *sql.DB) (*time.Location, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var name string
+	if err := db.QueryRowContext(ctx, `SELECT current_setting('log_timezone')`).Scan(&name); err != nil {
+		return nil, err
+	}
+	return time.LoadLocation(name)
+}
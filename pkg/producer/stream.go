@@ -0,0 +1,41 @@
+package producer
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+// DefaultStream is the JetStream stream expected to capture MetricsSubject.
+const DefaultStream = "METRICS"
+
+// StreamName returns the JetStream stream capturing the snapshots, as
+// configured by STREAM in the [NATS] section.
+func StreamName() string {
+	return cfg.Section("NATS").Key("STREAM").MustString(DefaultStream)
+}
+
+// GetStreamSnapshot returns the snapshot stored as message seq of a
+// JetStream stream.
+func GetStreamSnapshot(nc *nats.Conn, stream string, seq uint64) (model.Model, error) {
+	var m model.Model
+
+	js, err := nc.JetStream()
+	if err != nil {
+		return m, err
+	}
+	msg, err := js.GetMsg(stream, seq)
+	if err != nil {
+		return m, fmt.Errorf("could not get message %d of stream %s: %v", seq, stream, err)
+	}
+	if msg.Subject != MetricsSubject {
+		return m, fmt.Errorf("message %d of stream %s is a %s message, not a snapshot", seq, stream, msg.Subject)
+	}
+
+	if err := json.Unmarshal(msg.Data, &m); err != nil {
+		return m, fmt.Errorf("could not decode message %d of stream %s: %v", seq, stream, err)
+	}
+	return m, nil
+}
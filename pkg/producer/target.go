@@ -0,0 +1,109 @@
+package producer
+
+import (
+	"database/sql"
+	"net/url"
+	"strings"
+
+	"github.com/pkbhowmick/pg-monitoring/pkg/database"
+	"github.com/pkbhowmick/pg-monitoring/pkg/logs"
+)
+
+const (
+	DefaultTargetName = "default"
+
+	targetSectionPrefix = "TARGET."
+)
+
+// Target is a Postgres server monitored by the agent. Targets are declared in
+// app.ini as [TARGET.<name>] sections. Without any such section the agent
+// monitors the single server of the [DATABASE] section.
+type Target struct {
+	Name  string
+	DBURL string
+	// Role is either "primary" or "replica".
+	Role string
+	// Primary is the name of the target a replica streams from.
+	Primary string
+	Labels  map[string]string
+
+	// server log, see the [LOG] section
+	LogDir        string
+	LogFile       string
+	LogLinePrefix string
+}
+
+// Targets returns the targets declared in the loaded configuration.
+func Targets() []Target {
+	var targets []Target
+
+	for _, sec := range cfg.Sections() {
+		if !strings.HasPrefix(sec.Name(), targetSectionPrefix) {
+			continue
+		}
+		t := Target{
+			Name:    strings.TrimPrefix(sec.Name(), targetSectionPrefix),
+			DBURL:   sec.Key("DB_URL").String(),
+			Role:    sec.Key("ROLE").MustString("primary"),
+			Primary: sec.Key("PRIMARY").String(),
+			Labels:  parseLabels(sec.Key("LABELS").String()),
+
+			LogDir:        sec.Key("LOG_DIR").String(),
+			LogFile:       sec.Key("LOG_FILE").String(),
+			LogLinePrefix: sec.Key("LOG_LINE_PREFIX").MustString(logs.DefaultLinePrefix),
+		}
+		targets = append(targets, t)
+	}
+
+	if len(targets) == 0 {
+		sec := cfg.Section("LOG")
+		targets = append(targets, Target{
+			Name:  DefaultTargetName,
+			DBURL: DBConnectionConfig,
+			Role:  "primary",
+
+			LogDir:        sec.Key("LOG_DIR").String(),
+			LogFile:       sec.Key("LOG_FILE").String(),
+			LogLinePrefix: sec.Key("LOG_LINE_PREFIX").MustString(logs.DefaultLinePrefix),
+		})
+	}
+	return targets
+}
+
+// parseLabels parses a comma separated list of key=value pairs.
+func parseLabels(s string) map[string]string {
+	labels := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return labels
+}
+
+// CollectConfig returns the collection options of the target.
+func (t Target) CollectConfig() database.CollectConfig {
+	cc := database.GetDefaultCollectConfig()
+	cc.LogDir = t.LogDir
+	cc.LogFile = t.LogFile
+	cc.LogSpan = cfg.Section("LOG").Key("LOG_SPAN").MustUint(cc.LogSpan)
+	return cc
+}
+
+// WithDatabase returns t connecting to database name of the same server.
+func (t Target) WithDatabase(name string) Target {
+	if u, err := url.Parse(t.DBURL); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		u.Path = "/" + name
+		t.DBURL = u.String()
+		return t
+	}
+	// the last of repeated keywords wins
+	t.DBURL += " dbname='" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(name) + "'"
+	return t
+}
+
+func ConnectTarget(t Target) (*sql.DB, error) {
+	return database.GetDBConnection(t.DBURL, t.CollectConfig())
+}
@@ -0,0 +1,150 @@
+package producer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+// wraparoundLimit is the distance in transactions (or multixacts) after which
+// the ID space wraps around. The server refuses new IDs shortly before it.
+const wraparoundLimit = 1<<31 - 1
+
+const oldestTablesLimit = 10
+
+func percentOf(v, limit int64) float64 {
+	if limit <= 0 {
+		return 0
+	}
+	return float64(v) / float64(limit) * 100
+}
+
+func fillXIDAge(a *model.XIDAge, w model.Wraparound) {
+	a.FreezeMaxAgePercent = percentOf(a.XIDAge, w.FreezeMaxAge)
+	a.WraparoundPercent = percentOf(a.XIDAge, wraparoundLimit)
+	a.MultixactFreezeMaxAgePercent = percentOf(a.MXIDAge, w.MultixactFreezeMaxAge)
+	a.MultixactWraparoundPercent = percentOf(a.MXIDAge, wraparoundLimit)
+}
+
+// GetWraparound reports the transaction ID and multixact age of every
+// database and of the oldest tables in the connected database, plus whatever
+// is currently holding the xmin horizon back.
+func GetWraparound(db *sql.DB) (model.Wraparound, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var w model.Wraparound
+
+	q := `SELECT current_setting('autovacuum_freeze_max_age')::bigint,
+				current_setting('autovacuum_multixact_freeze_max_age')::bigint`
+	err := db.QueryRowContext(ctx, q).Scan(&w.FreezeMaxAge, &w.MultixactFreezeMaxAge)
+	if err != nil {
+		return w, err
+	}
+
+	q = `SELECT datname, age(datfrozenxid), mxid_age(datminmxid)
+			FROM pg_database
+			ORDER BY age(datfrozenxid) DESC`
+	w.Databases, err = queryXIDAges(ctx, db, q, false, w)
+	if err != nil {
+		return w, err
+	}
+
+	q = `SELECT current_database(), n.nspname, c.relname, age(c.relfrozenxid), mxid_age(c.relminmxid)
+			FROM pg_class AS c JOIN pg_namespace AS n ON n.oid = c.relnamespace
+			WHERE c.relkind IN ('r', 'm', 't')
+			ORDER BY age(c.relfrozenxid) DESC
+			LIMIT $1`
+	w.Tables, err = queryXIDAges(ctx, db, q, true, w, oldestTablesLimit)
+	if err != nil {
+		return w, err
+	}
+
+	w.XminHolders, err = getXminHolders(ctx, db)
+	if err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+func queryXIDAges(ctx context.Context, db *sql.DB, q string, tables bool, w model.Wraparound, args ...interface{}) ([]model.XIDAge, error) {
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ages []model.XIDAge
+	for rows.Next() {
+		var a model.XIDAge
+		if tables {
+			err = rows.Scan(&a.DBName, &a.SchemaName, &a.Name, &a.XIDAge, &a.MXIDAge)
+		} else {
+			err = rows.Scan(&a.DBName, &a.XIDAge, &a.MXIDAge)
+		}
+		if err != nil {
+			return nil, err
+		}
+		fillXIDAge(&a, w)
+		ages = append(ages, a)
+	}
+	return ages, rows.Err()
+}
+
+// getXminHolders returns the oldest running transaction, all prepared
+// transactions, replication slots with an xmin or catalog_xmin and the xmin
+// reported by hot standbys through hot_standby_feedback.
+func getXminHolders(ctx context.Context, db *sql.DB) ([]model.XminHolder, error) {
+	queries := []struct {
+		kind  string
+		query string
+	}{
+		{"transaction", `SELECT pid::text, COALESCE(datname, ''),
+					GREATEST(age(backend_xid), age(backend_xmin)), 0, xact_start
+				FROM pg_stat_activity
+				WHERE (backend_xid IS NOT NULL OR backend_xmin IS NOT NULL)
+					AND backend_type = 'client backend'
+				ORDER BY GREATEST(age(backend_xid), age(backend_xmin)) DESC
+				LIMIT 1`},
+		{"prepared_transaction", `SELECT gid, database, age(transaction), 0, prepared
+				FROM pg_prepared_xacts
+				ORDER BY age(transaction) DESC`},
+		{"replication_slot", `SELECT slot_name, COALESCE(database, ''),
+					COALESCE(age(xmin), 0), COALESCE(age(catalog_xmin), 0), NULL::timestamptz
+				FROM pg_replication_slots
+				WHERE xmin IS NOT NULL OR catalog_xmin IS NOT NULL`},
+		{"standby_feedback", `SELECT COALESCE(NULLIF(application_name, ''), client_addr::text, pid::text), '',
+					age(backend_xmin), 0, backend_start
+				FROM pg_stat_replication
+				WHERE backend_xmin IS NOT NULL`},
+	}
+
+	var holders []model.XminHolder
+	for _, q := range queries {
+		rows, err := db.QueryContext(ctx, q.query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			h := model.XminHolder{Kind: q.kind}
+			var since sql.NullTime
+			err := rows.Scan(&h.Name, &h.DBName, &h.XminAge, &h.CatalogXminAge, &since)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if since.Valid {
+				h.Since = &since.Time
+			}
+			holders = append(holders, h)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return holders, nil
+}
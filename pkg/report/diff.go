@@ -0,0 +1,268 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+// Mover is a counter of a statement, table or database that grew between two
+// snapshots.
+type Mover struct {
+	// Kind is "statement", "table" or "database".
+	Kind   string  `json:"kind"`
+	Name   string  `json:"name"`
+	Metric string  `json:"metric"`
+	Delta  float64 `json:"delta"`
+	// Rate is Delta per second.
+	Rate float64 `json:"rate"`
+}
+
+// Diff is what changed between two snapshots.
+type Diff struct {
+	Target  string    `json:"target"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Seconds float64   `json:"seconds"`
+
+	NewStatements  []string `json:"new_statements"`
+	GoneStatements []string `json:"gone_statements"`
+	NewTables      []string `json:"new_tables"`
+	GoneTables     []string `json:"gone_tables"`
+	NewDatabases   []string `json:"new_databases"`
+	GoneDatabases  []string `json:"gone_databases"`
+
+	// TopStatementsOnly is set when a snapshot only holds the top statements
+	// by total time: the statements entering or leaving the top then tell
+	// nothing, and are not listed as new or disappeared.
+	TopStatementsOnly bool `json:"top_statements_only,omitempty"`
+
+	Movers         []Mover               `json:"movers"`
+	SettingChanges []model.SettingChange `json:"setting_changes"`
+}
+
+func statementName(s model.Statement) string {
+	q := strings.Join(strings.Fields(s.Query), " ")
+	if r := []rune(q); len(r) > 60 {
+		q = string(r[:57]) + "..."
+	}
+	if q == "" {
+		q = "fingerprint " + s.Fingerprint
+	}
+	return fmt.Sprintf("%d (%s)", s.QueryID, q)
+}
+
+// added returns the names of the keys of b missing from a.
+func added(a, b map[string]string) []string {
+	var names []string
+	for k, name := range b {
+		if _, ok := a[k]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewDiff compares snapshot b with the older snapshot a and keeps the n
+// biggest movers.
+func NewDiff(a, b model.Model, n int) Diff {
+	d := Diff{
+		Target:  b.Target,
+		From:    a.UpdatedAt,
+		To:      b.UpdatedAt,
+		Seconds: b.UpdatedAt.Sub(a.UpdatedAt).Seconds(),
+	}
+
+	names := func(m model.Model) (statements, tables, databases map[string]string) {
+		statements, tables, databases = map[string]string{}, map[string]string{}, map[string]string{}
+		for _, s := range m.Statements {
+			statements[statementKey(s)] = statementName(s)
+		}
+		for _, t := range m.Tables {
+			tables[tableKey(t)] = tableKey(t)
+		}
+		for _, db := range m.Databases {
+			databases[db.Name] = db.Name
+		}
+		return
+	}
+	sa, ta, da := names(a)
+	sb, tb, db := names(b)
+	// a statement missing from a snapshot of the top ones may still be running
+	if a.StatementsComplete {
+		d.NewStatements = added(sa, sb)
+	}
+	if b.StatementsComplete {
+		d.GoneStatements = added(sb, sa)
+	}
+	d.TopStatementsOnly = !a.StatementsComplete || !b.StatementsComplete
+	d.NewTables, d.GoneTables = added(ta, tb), added(tb, ta)
+	d.NewDatabases, d.GoneDatabases = added(da, db), added(db, da)
+
+	mover := func(kind, name, metric string, delta float64) {
+		if delta == 0 {
+			return
+		}
+		m := Mover{Kind: kind, Name: name, Metric: metric, Delta: delta}
+		if d.Seconds > 0 {
+			m.Rate = delta / d.Seconds
+		}
+		d.Movers = append(d.Movers, m)
+	}
+
+	// only what both snapshots know of moved
+	for _, s := range StatementDeltas(a, b) {
+		if s.New {
+			continue
+		}
+		name := statementName(model.Statement{QueryID: s.QueryID, Query: s.Query, Fingerprint: s.Fingerprint})
+		mover("statement", name, "total_time", s.TotalTime)
+		mover("statement", name, "calls", float64(s.Calls))
+		mover("statement", name, "shared_blks_read", float64(s.SharedBlksRead))
+		mover("statement", name, "temp_blks_written", float64(s.TempBlksWritten))
+	}
+	for _, t := range TableDeltas(a, b) {
+		name := t.DBName + "." + t.SchemaName + "." + t.Name
+		if _, ok := ta[name]; !ok {
+			continue
+		}
+		mover("table", name, "seq_scan", float64(t.SeqScan))
+		mover("table", name, "rows_written", float64(t.RowsInserted+t.RowsUpdated+t.RowsDeleted))
+		mover("table", name, "heap_blks_read", float64(t.HeapBlksRead))
+	}
+	for _, db := range DatabaseDeltas(a, b, d.Seconds) {
+		if _, ok := da[db.Name]; !ok {
+			continue
+		}
+		mover("database", db.Name, "xact_commit", float64(db.XactCommit))
+		mover("database", db.Name, "blks_read", float64(db.BlksRead))
+		mover("database", db.Name, "temp_bytes", float64(db.TempBytes))
+	}
+
+	// the biggest movers of each metric, so that large counters such as
+	// blocks read do not crowd out the rest
+	sort.SliceStable(d.Movers, func(i, j int) bool {
+		return math.Abs(d.Movers[i].Delta) > math.Abs(d.Movers[j].Delta)
+	})
+	perMetric := map[string]int{}
+	kept := d.Movers[:0]
+	for _, m := range d.Movers {
+		k := m.Kind + "/" + m.Metric
+		if perMetric[k] < n {
+			perMetric[k]++
+			kept = append(kept, m)
+		}
+	}
+	d.Movers = kept
+	sort.SliceStable(d.Movers, func(i, j int) bool {
+		if d.Movers[i].Kind != d.Movers[j].Kind {
+			return d.Movers[i].Kind > d.Movers[j].Kind
+		}
+		return d.Movers[i].Metric < d.Movers[j].Metric
+	})
+
+	d.SettingChanges = SettingChanges(a, b)
+	return d
+}
+
+func (d Diff) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// sections lists the added and removed objects under their headings.
+func (d Diff) sections() []struct {
+	title string
+	names []string
+} {
+	return []struct {
+		title string
+		names []string
+	}{
+		{"New statements", d.NewStatements},
+		{"Disappeared statements", d.GoneStatements},
+		{"New tables", d.NewTables},
+		{"Disappeared tables", d.GoneTables},
+		{"New databases", d.NewDatabases},
+		{"Disappeared databases", d.GoneDatabases},
+	}
+}
+
+// WriteTable writes the diff as aligned plain text.
+func (d Diff) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s: %s -> %s (%s)\n", d.Target, d.From.Format(time.RFC3339), d.To.Format(time.RFC3339),
+		time.Duration(d.Seconds*float64(time.Second)).Round(time.Second))
+	if d.TopStatementsOnly {
+		fmt.Fprintln(tw, "Only the top statements are known, new and disappeared statements are not listed.")
+	}
+
+	for _, s := range d.sections() {
+		if len(s.names) == 0 {
+			continue
+		}
+		fmt.Fprintf(tw, "\n%s:\n", s.title)
+		for _, name := range s.names {
+			fmt.Fprintf(tw, "  %s\n", name)
+		}
+	}
+
+	if len(d.Movers) > 0 {
+		fmt.Fprint(tw, "\nBiggest movers:\nKIND\tNAME\tMETRIC\tDELTA\tPER SECOND\n")
+		for _, m := range d.Movers {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%.2f\t%.2f\n", m.Kind, m.Name, m.Metric, m.Delta, m.Rate)
+		}
+	}
+
+	if len(d.SettingChanges) > 0 {
+		fmt.Fprint(tw, "\nChanged settings:\nNAME\tOLD\tNEW\tUNIT\n")
+		for _, c := range d.SettingChanges {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Name, c.OldValue, c.NewValue, c.Unit)
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteMarkdown writes the diff as Markdown.
+func (d Diff) WriteMarkdown(w io.Writer) error {
+	cell := strings.NewReplacer("|", `\|`).Replace
+
+	fmt.Fprintf(w, "# Changes of %s\n\nFrom %s to %s.\n", d.Target, d.From.Format(time.RFC3339), d.To.Format(time.RFC3339))
+	if d.TopStatementsOnly {
+		fmt.Fprint(w, "Only the top statements are known, new and disappeared statements are not listed.\n")
+	}
+	for _, s := range d.sections() {
+		if len(s.names) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n## %s\n\n", s.title)
+		for _, name := range s.names {
+			fmt.Fprintf(w, "- %s\n", cell(name))
+		}
+	}
+
+	if len(d.Movers) > 0 {
+		fmt.Fprint(w, "\n## Biggest movers\n\n| Kind | Name | Metric | Delta | Per second |\n|---|---|---|--:|--:|\n")
+		for _, m := range d.Movers {
+			fmt.Fprintf(w, "| %s | %s | %s | %.2f | %.2f |\n", m.Kind, cell(m.Name), m.Metric, m.Delta, m.Rate)
+		}
+	}
+
+	if len(d.SettingChanges) > 0 {
+		fmt.Fprint(w, "\n## Changed settings\n\n| Setting | Old value | New value |\n|---|---|---|\n")
+		for _, c := range d.SettingChanges {
+			fmt.Fprintf(w, "| %s | %s %s | %s %s |\n", c.Name, c.OldValue, c.Unit, c.NewValue, c.Unit)
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
@@ -0,0 +1,271 @@
+package report
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var funcs = map[string]interface{}{
+	"bytes":    formatBytes,
+	"blocks":   func(n int64) string { return formatBytes(n * 8192) },
+	"rate":     func(f float64) string { return formatBytes(int64(f)) + "/s" },
+	"ms":       func(f float64) string { return fmt.Sprintf("%.2f", f) },
+	"percent":  func(f float64) string { return fmt.Sprintf("%.1f%%", f) },
+	"ratio":    func(f float64) string { return fmt.Sprintf("%.2f%%", 100*f) },
+	"float":    func(f float64) string { return fmt.Sprintf("%.2f", f) },
+	"time":     func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04:05 UTC") },
+	"duration": func(s float64) string { return time.Duration(s * float64(time.Second)).Round(time.Second).String() },
+	"query":    queryText,
+	"cell":     func(s string) string { return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s) },
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// queryText shortens a query text to fit a table cell, or names the query by
+// its fingerprint when its text was not published.
+func queryText(s StatementDelta) string {
+	q := strings.Join(strings.Fields(s.Query), " ")
+	if q == "" {
+		return "fingerprint " + s.Fingerprint
+	}
+	if r := []rune(q); len(r) > 120 {
+		q = string(r[:117]) + "..."
+	}
+	return q
+}
+
+const markdownTemplate = `# Workload report{{if .Target}}: {{.Target}}{{end}}
+
+| | |
+|---|---|
+| Begin | {{time .Begin}} |
+| End | {{time .End}} |
+| Elapsed | {{duration .Seconds}} |
+| Snapshots | {{.Snapshots}} |
+| Buffer cache hit ratio | {{ratio .HitRatio}} |
+{{- if .Waits}}
+| Average active sessions | {{float .AverageActiveSessions}} |
+{{- end}}
+
+{{define "statements"}}
+| Query ID | Database | User | Calls | Total ms | Mean ms | % time | Rows | Read | Hit ratio | Temp written | Query |
+|---|---|---|--:|--:|--:|--:|--:|--:|--:|--:|---|
+{{- range .}}
+| {{.QueryID}}{{if .New}} (new){{end}} | {{.DBName}} | {{.UserName}} | {{.Calls}} | {{ms .TotalTime}} | {{ms .MeanTime}} | {{percent .PercentOfTime}} | {{.Rows}} | {{blocks .SharedBlksRead}} | {{ratio .HitRatio}} | {{blocks .TempBlksWritten}} | {{cell (query .)}} |
+{{- end}}
+{{end}}
+{{- if .TopStatementsOnly}}
+The first snapshot only holds the top statements by total time: statements that made it to the top since are left out.
+{{end}}
+## Top SQL by time
+{{template "statements" .TopByTime}}
+## Top SQL by calls
+{{template "statements" .TopByCalls}}
+## Top SQL by I/O
+{{template "statements" .TopByIO}}
+## Top SQL by temp usage
+{{template "statements" .TopByTemp}}
+## Database activity
+
+| Database | Commits | Commits/s | Rollbacks | Read | Hit ratio | Returned | Fetched | Inserted | Updated | Deleted | Temp files | Temp bytes | Deadlocks | Backends |
+|---|--:|--:|--:|--:|--:|--:|--:|--:|--:|--:|--:|--:|--:|--:|
+{{- range .Databases}}
+| {{.Name}}{{if .StatsWereReset}} (reset){{end}} | {{.XactCommit}} | {{float .CommitsPerSec}} | {{.XactRollback}} | {{blocks .BlksRead}} | {{ratio .HitRatio}} | {{.TupReturned}} | {{.TupFetched}} | {{.TupInserted}} | {{.TupUpdated}} | {{.TupDeleted}} | {{.TempFiles}} | {{bytes .TempBytes}} | {{.Deadlocks}} | {{.NumBackends}} |
+{{- end}}
+
+## Table activity
+
+| Table | Seq scans | Index scans | Inserted | Updated | Deleted | Read | Hit ratio | Live rows | Dead rows |
+|---|--:|--:|--:|--:|--:|--:|--:|--:|--:|
+{{- range .Tables}}
+| {{.DBName}}.{{.SchemaName}}.{{.Name}} | {{.SeqScan}} | {{.IdxScan}} | {{.RowsInserted}} | {{.RowsUpdated}} | {{.RowsDeleted}} | {{blocks .HeapBlksRead}} | {{ratio .HitRatio}} | {{.RowsLive}} | {{.RowsDead}} |
+{{- end}}
+
+## Wait profile
+{{if .Waits}}
+| Wait event | DB time (s) | % DB time |
+|---|--:|--:|
+{{- range .Waits}}
+| {{.Key}} | {{float .Seconds}} | {{percent .Percent}} |
+{{- end}}
+{{else}}
+No active session history in the snapshots.
+{{end}}
+## Checkpoints and WAL
+{{with .Checkpoints}}
+| | |
+|---|--:|
+| Timed checkpoints | {{.Timed}} |
+| Requested checkpoints | {{.Requested}} |
+| Write time (ms) | {{ms .WriteTime}} |
+| Sync time (ms) | {{ms .SyncTime}} |
+| Written by checkpoints | {{blocks .BuffersCheckpoint}} |
+| Written by the background writer | {{blocks .BuffersClean}} |
+| Background writer stops | {{.MaxWrittenClean}} |
+| Written by backends | {{blocks .BuffersBackend}} |
+| Allocated | {{blocks .BuffersAlloc}} |
+{{- if .HasWAL}}
+| WAL records | {{.WALRecords}} |
+| WAL full page images | {{.WALFPI}} |
+| WAL generated | {{bytes .WALBytes}} |
+| WAL rate | {{rate .WALBytesPerSec}} |
+{{- end}}
+{{- with .LogCheckpoints}}
+| Logged checkpoints completed | {{.Completed}} |
+| Longest logged checkpoint (s) | {{float .MaxSeconds}} |
+{{- end}}
+{{if .StatsWereReset}}
+The statistics were reset during the period.
+{{end}}{{end}}
+## Setting changes
+{{if .SettingChanges}}
+| Setting | Old value | New value |
+|---|---|---|
+{{- range .SettingChanges}}
+| {{.Name}} | {{.OldValue}} {{.Unit}} | {{.NewValue}} {{.Unit}} |
+{{- end}}
+{{else}}
+No setting changed.
+{{end}}`
+
+const htmlTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Workload report{{if .Target}}: {{.Target}}{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; font-size: 0.9em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; }
+th { background: #eee; text-align: left; }
+td.n { text-align: right; font-variant-numeric: tabular-nums; }
+td.q { font-family: monospace; max-width: 50em; }
+</style>
+</head>
+<body>
+<h1>Workload report{{if .Target}}: {{.Target}}{{end}}</h1>
+<table>
+<tr><th>Begin</th><td>{{time .Begin}}</td></tr>
+<tr><th>End</th><td>{{time .End}}</td></tr>
+<tr><th>Elapsed</th><td>{{duration .Seconds}}</td></tr>
+<tr><th>Snapshots</th><td>{{.Snapshots}}</td></tr>
+<tr><th>Buffer cache hit ratio</th><td>{{ratio .HitRatio}}</td></tr>
+{{- if .Waits}}
+<tr><th>Average active sessions</th><td>{{float .AverageActiveSessions}}</td></tr>
+{{- end}}
+</table>
+{{define "statements"}}
+<table>
+<tr><th>Query ID</th><th>Database</th><th>User</th><th>Calls</th><th>Total ms</th><th>Mean ms</th><th>% time</th><th>Rows</th><th>Read</th><th>Hit ratio</th><th>Temp written</th><th>Query</th></tr>
+{{- range .}}
+<tr><td>{{.QueryID}}{{if .New}} (new){{end}}</td><td>{{.DBName}}</td><td>{{.UserName}}</td><td class="n">{{.Calls}}</td><td class="n">{{ms .TotalTime}}</td><td class="n">{{ms .MeanTime}}</td><td class="n">{{percent .PercentOfTime}}</td><td class="n">{{.Rows}}</td><td class="n">{{blocks .SharedBlksRead}}</td><td class="n">{{ratio .HitRatio}}</td><td class="n">{{blocks .TempBlksWritten}}</td><td class="q">{{query .}}</td></tr>
+{{- end}}
+</table>
+{{end}}
+{{- if .TopStatementsOnly}}
+<p>The first snapshot only holds the top statements by total time: statements that made it to the top since are left out.</p>
+{{- end}}
+<h2>Top SQL by time</h2>
+{{template "statements" .TopByTime}}
+<h2>Top SQL by calls</h2>
+{{template "statements" .TopByCalls}}
+<h2>Top SQL by I/O</h2>
+{{template "statements" .TopByIO}}
+<h2>Top SQL by temp usage</h2>
+{{template "statements" .TopByTemp}}
+<h2>Database activity</h2>
+<table>
+<tr><th>Database</th><th>Commits</th><th>Commits/s</th><th>Rollbacks</th><th>Read</th><th>Hit ratio</th><th>Returned</th><th>Fetched</th><th>Inserted</th><th>Updated</th><th>Deleted</th><th>Temp files</th><th>Temp bytes</th><th>Deadlocks</th><th>Backends</th></tr>
+{{- range .Databases}}
+<tr><td>{{.Name}}{{if .StatsWereReset}} (reset){{end}}</td><td class="n">{{.XactCommit}}</td><td class="n">{{float .CommitsPerSec}}</td><td class="n">{{.XactRollback}}</td><td class="n">{{blocks .BlksRead}}</td><td class="n">{{ratio .HitRatio}}</td><td class="n">{{.TupReturned}}</td><td class="n">{{.TupFetched}}</td><td class="n">{{.TupInserted}}</td><td class="n">{{.TupUpdated}}</td><td class="n">{{.TupDeleted}}</td><td class="n">{{.TempFiles}}</td><td class="n">{{bytes .TempBytes}}</td><td class="n">{{.Deadlocks}}</td><td class="n">{{.NumBackends}}</td></tr>
+{{- end}}
+</table>
+<h2>Table activity</h2>
+<table>
+<tr><th>Table</th><th>Seq scans</th><th>Index scans</th><th>Inserted</th><th>Updated</th><th>Deleted</th><th>Read</th><th>Hit ratio</th><th>Live rows</th><th>Dead rows</th></tr>
+{{- range .Tables}}
+<tr><td>{{.DBName}}.{{.SchemaName}}.{{.Name}}</td><td class="n">{{.SeqScan}}</td><td class="n">{{.IdxScan}}</td><td class="n">{{.RowsInserted}}</td><td class="n">{{.RowsUpdated}}</td><td class="n">{{.RowsDeleted}}</td><td class="n">{{blocks .HeapBlksRead}}</td><td class="n">{{ratio .HitRatio}}</td><td class="n">{{.RowsLive}}</td><td class="n">{{.RowsDead}}</td></tr>
+{{- end}}
+</table>
+<h2>Wait profile</h2>
+{{- if .Waits}}
+<table>
+<tr><th>Wait event</th><th>DB time (s)</th><th>% DB time</th></tr>
+{{- range .Waits}}
+<tr><td>{{.Key}}</td><td class="n">{{float .Seconds}}</td><td class="n">{{percent .Percent}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No active session history in the snapshots.</p>
+{{- end}}
+<h2>Checkpoints and WAL</h2>
+{{- with .Checkpoints}}
+<table>
+<tr><th>Timed checkpoints</th><td class="n">{{.Timed}}</td></tr>
+<tr><th>Requested checkpoints</th><td class="n">{{.Requested}}</td></tr>
+<tr><th>Write time (ms)</th><td class="n">{{ms .WriteTime}}</td></tr>
+<tr><th>Sync time (ms)</th><td class="n">{{ms .SyncTime}}</td></tr>
+<tr><th>Written by checkpoints</th><td class="n">{{blocks .BuffersCheckpoint}}</td></tr>
+<tr><th>Written by the background writer</th><td class="n">{{blocks .BuffersClean}}</td></tr>
+<tr><th>Background writer stops</th><td class="n">{{.MaxWrittenClean}}</td></tr>
+<tr><th>Written by backends</th><td class="n">{{blocks .BuffersBackend}}</td></tr>
+<tr><th>Allocated</th><td class="n">{{blocks .BuffersAlloc}}</td></tr>
+{{- if .HasWAL}}
+<tr><th>WAL records</th><td class="n">{{.WALRecords}}</td></tr>
+<tr><th>WAL full page images</th><td class="n">{{.WALFPI}}</td></tr>
+<tr><th>WAL generated</th><td class="n">{{bytes .WALBytes}}</td></tr>
+<tr><th>WAL rate</th><td class="n">{{rate .WALBytesPerSec}}</td></tr>
+{{- end}}
+{{- with .LogCheckpoints}}
+<tr><th>Logged checkpoints completed</th><td class="n">{{.Completed}}</td></tr>
+<tr><th>Longest logged checkpoint (s)</th><td class="n">{{float .MaxSeconds}}</td></tr>
+{{- end}}
+</table>
+{{- if .StatsWereReset}}
+<p>The statistics were reset during the period.</p>
+{{- end}}
+{{- end}}
+<h2>Setting changes</h2>
+{{- if .SettingChanges}}
+<table>
+<tr><th>Setting</th><th>Old value</th><th>New value</th></tr>
+{{- range .SettingChanges}}
+<tr><td>{{.Name}}</td><td>{{.OldValue}} {{.Unit}}</td><td>{{.NewValue}} {{.Unit}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No setting changed.</p>
+{{- end}}
+</body>
+</html>
+`
+
+var (
+	markdown = template.Must(template.New("report").Funcs(funcs).Parse(markdownTemplate))
+	html     = htmltemplate.Must(htmltemplate.New("report").Funcs(funcs).Parse(htmlTemplate))
+)
+
+// WriteMarkdown renders r as Markdown.
+func (r Report) WriteMarkdown(w io.Writer) error {
+	return markdown.Execute(w, r)
+}
+
+// WriteHTML renders r as a standalone HTML page.
+func (r Report) WriteHTML(w io.Writer) error {
+	return html.Execute(w, r)
+}
@@ -0,0 +1,454 @@
+// Package report builds workload reports from stored snapshots: what the
+// server did between two of them, in the manner of an AWR report.
+package report
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/producer"
+)
+
+// DefaultTop is the number of rows of the top lists.
+const DefaultTop = 10
+
+// StatementDelta is what a statement did between the two snapshots.
+type StatementDelta struct {
+	QueryID     int64  `json:"query_id"`
+	DBName      string `json:"db_name,omitempty"`
+	UserName    string `json:"user_name,omitempty"`
+	Fingerprint string `json:"fingerprint"`
+	Query       string `json:"query,omitempty"`
+	// New is set for statements that started running between the two
+	// snapshots, whose counters are taken whole.
+	New             bool    `json:"new,omitempty"`
+	Calls           int64   `json:"calls"`
+	TotalTime       float64 `json:"total_time"`
+	MeanTime        float64 `json:"mean_time"`
+	Rows            int64   `json:"rows"`
+	SharedBlksHit   int64   `json:"shared_blks_hit"`
+	SharedBlksRead  int64   `json:"shared_blks_read"`
+	TempBlksWritten int64   `json:"temp_blks_written"`
+	HitRatio        float64 `json:"hit_ratio"`
+	// PercentOfTime is the share of the time of all reported statements.
+	PercentOfTime float64 `json:"percent_of_time"`
+}
+
+type DatabaseDelta struct {
+	Name           string  `json:"name"`
+	XactCommit     int64   `json:"xact_commit"`
+	XactRollback   int64   `json:"xact_rollback"`
+	CommitsPerSec  float64 `json:"commits_per_sec"`
+	BlksRead       int64   `json:"blks_read"`
+	BlksHit        int64   `json:"blks_hit"`
+	HitRatio       float64 `json:"hit_ratio"`
+	TupReturned    int64   `json:"tup_returned"`
+	TupFetched     int64   `json:"tup_fetched"`
+	TupInserted    int64   `json:"tup_inserted"`
+	TupUpdated     int64   `json:"tup_updated"`
+	TupDeleted     int64   `json:"tup_deleted"`
+	TempFiles      int64   `json:"temp_files"`
+	TempBytes      int64   `json:"temp_bytes"`
+	Deadlocks      int64   `json:"deadlocks"`
+	NumBackends    int     `json:"num_backends"`
+	StatsWereReset bool    `json:"stats_were_reset,omitempty"`
+}
+
+type TableDelta struct {
+	DBName       string  `json:"db_name"`
+	SchemaName   string  `json:"schema_name"`
+	Name         string  `json:"name"`
+	SeqScan      int64   `json:"seq_scan"`
+	IdxScan      int64   `json:"idx_scan"`
+	RowsInserted int64   `json:"rows_inserted"`
+	RowsUpdated  int64   `json:"rows_updated"`
+	RowsDeleted  int64   `json:"rows_deleted"`
+	HeapBlksRead int64   `json:"heap_blks_read"`
+	HeapBlksHit  int64   `json:"heap_blks_hit"`
+	HitRatio     float64 `json:"hit_ratio"`
+	RowsLive     int     `json:"rows_live"`
+	RowsDead     int     `json:"rows_dead"`
+}
+
+// CheckpointDelta is the checkpoint, background writer and WAL activity
+// between the two snapshots.
+type CheckpointDelta struct {
+	Timed             int64   `json:"timed"`
+	Requested         int64   `json:"requested"`
+	WriteTime         float64 `json:"write_time"`
+	SyncTime          float64 `json:"sync_time"`
+	BuffersCheckpoint int64   `json:"buffers_checkpoint"`
+	BuffersClean      int64   `json:"buffers_clean"`
+	MaxWrittenClean   int64   `json:"maxwritten_clean"`
+	BuffersBackend    int64   `json:"buffers_backend"`
+	BuffersAlloc      int64   `json:"buffers_alloc"`
+	// WAL counters, unknown without pg_stat_wal
+	HasWAL         bool    `json:"has_wal"`
+	WALRecords     int64   `json:"wal_records"`
+	WALFPI         int64   `json:"wal_fpi"`
+	WALBytes       int64   `json:"wal_bytes"`
+	WALBytesPerSec float64 `json:"wal_bytes_per_sec"`
+	WALBuffersFull int64   `json:"wal_buffers_full"`
+	StatsWereReset bool    `json:"stats_were_reset,omitempty"`
+	// checkpoints logged by the server, when its log is collected
+	LogCheckpoints *model.CheckpointLogStats `json:"log_checkpoints,omitempty"`
+}
+
+// Report is what the server did between two snapshots.
+type Report struct {
+	Target    string    `json:"target"`
+	Begin     time.Time `json:"begin"`
+	End       time.Time `json:"end"`
+	Seconds   float64   `json:"seconds"`
+	Snapshots int       `json:"snapshots"`
+
+	TopByTime  []StatementDelta `json:"top_by_time"`
+	TopByCalls []StatementDelta `json:"top_by_calls"`
+	TopByIO    []StatementDelta `json:"top_by_io"`
+	TopByTemp  []StatementDelta `json:"top_by_temp"`
+	// TopStatementsOnly is set when the first snapshot only holds the top
+	// statements by total time, the top lists then missing those that made
+	// it to the top in between.
+	TopStatementsOnly bool `json:"top_statements_only,omitempty"`
+
+	Databases []DatabaseDelta `json:"databases"`
+	Tables    []TableDelta    `json:"tables"`
+	// HitRatio is the buffer cache hit ratio of all databases.
+	HitRatio float64 `json:"hit_ratio"`
+
+	// wait profile from the active session history of the snapshots, if any
+	AverageActiveSessions float64        `json:"average_active_sessions"`
+	Waits                 []model.DBTime `json:"waits"`
+
+	Checkpoints    CheckpointDelta       `json:"checkpoints"`
+	SettingChanges []model.SettingChange `json:"setting_changes"`
+}
+
+// counter returns the increase of a cumulative counter, or cur alone if the
+// counter went backwards because the statistics were reset.
+func counter(prev, cur int64) (int64, bool) {
+	if cur < prev {
+		return cur, true
+	}
+	return cur - prev, false
+}
+
+func ratio(hit, read int64) float64 {
+	if hit+read == 0 {
+		return 0
+	}
+	return float64(hit) / float64(hit+read)
+}
+
+func statementKey(s model.Statement) string {
+	return fmt.Sprintf("%d/%d/%d", s.DBOID, s.UserOID, s.QueryID)
+}
+
+// StatementDeltas returns what the statements of b did since a. Statements
+// missing from a are left out unless a holds every statement: they may only
+// have made it to the top since, and what they did in between is unknown.
+func StatementDeltas(a, b model.Model) []StatementDelta {
+	prev := map[string]model.Statement{}
+	for _, s := range a.Statements {
+		prev[statementKey(s)] = s
+	}
+
+	var deltas []StatementDelta
+	var total float64
+	for _, s := range b.Statements {
+		p, ok := prev[statementKey(s)]
+		if !ok && !a.StatementsComplete {
+			continue
+		}
+		// reset statistics
+		if ok && (s.Calls < p.Calls || s.TotalTime < p.TotalTime) {
+			p = model.Statement{}
+		}
+
+		d := StatementDelta{
+			QueryID:         s.QueryID,
+			DBName:          s.DBName,
+			UserName:        s.UserName,
+			Fingerprint:     s.Fingerprint,
+			Query:           s.Query,
+			New:             !ok,
+			Calls:           s.Calls - p.Calls,
+			TotalTime:       s.TotalTime - p.TotalTime,
+			Rows:            s.Rows - p.Rows,
+			SharedBlksHit:   s.SharedBlksHit - p.SharedBlksHit,
+			SharedBlksRead:  s.SharedBlksRead - p.SharedBlksRead,
+			TempBlksWritten: s.TempBlksWritten - p.TempBlksWritten,
+		}
+		if d.Calls == 0 {
+			continue
+		}
+		d.MeanTime = d.TotalTime / float64(d.Calls)
+		d.HitRatio = ratio(d.SharedBlksHit, d.SharedBlksRead)
+		total += d.TotalTime
+		deltas = append(deltas, d)
+	}
+
+	for i := range deltas {
+		if total > 0 {
+			deltas[i].PercentOfTime = 100 * deltas[i].TotalTime / total
+		}
+	}
+	return deltas
+}
+
+// DatabaseDeltas returns what the databases of b did since a.
+func DatabaseDeltas(a, b model.Model, seconds float64) []DatabaseDelta {
+	prev := map[string]model.Database{}
+	for _, d := range a.Databases {
+		prev[d.Name] = d
+	}
+
+	var deltas []DatabaseDelta
+	for _, d := range b.Databases {
+		p := prev[d.Name]
+		dd := DatabaseDelta{Name: d.Name, NumBackends: d.NumBackends}
+
+		var reset bool
+		// the counters are reset together
+		if dd.XactCommit, reset = counter(p.XactCommit, d.XactCommit); reset {
+			p = model.Database{}
+			dd.XactCommit = d.XactCommit
+			dd.StatsWereReset = true
+		}
+		dd.XactRollback = d.XactRollback - p.XactRollback
+		dd.BlksRead = d.BlksRead - p.BlksRead
+		dd.BlksHit = d.BlksHit - p.BlksHit
+		dd.TupReturned = d.TupReturned - p.TupReturned
+		dd.TupFetched = d.TupFetched - p.TupFetched
+		dd.TupInserted = d.TupInserted - p.TupInserted
+		dd.TupUpdated = d.TupUpdated - p.TupUpdated
+		dd.TupDeleted = d.TupDeleted - p.TupDeleted
+		dd.TempFiles = d.TempFiles - p.TempFiles
+		dd.TempBytes = d.TempBytes - p.TempBytes
+		dd.Deadlocks = d.Deadlocks - p.Deadlocks
+		dd.HitRatio = ratio(dd.BlksHit, dd.BlksRead)
+		if seconds > 0 {
+			dd.CommitsPerSec = float64(dd.XactCommit) / seconds
+		}
+		deltas = append(deltas, dd)
+	}
+	return deltas
+}
+
+func tableKey(t model.Table) string {
+	return t.DBName + "." + t.SchemaName + "." + t.Name
+}
+
+// TableDeltas returns what the tables of b did since a, busiest first.
+func TableDeltas(a, b model.Model) []TableDelta {
+	prev := map[string]model.Table{}
+	for _, t := range a.Tables {
+		prev[tableKey(t)] = t
+	}
+
+	var deltas []TableDelta
+	for _, t := range b.Tables {
+		p := prev[tableKey(t)]
+		if t.SeqScan < p.SeqScan || t.IdxScan < p.IdxScan || int64(t.RowsInserted) < int64(p.RowsInserted) {
+			p = model.Table{}
+		}
+
+		td := TableDelta{
+			DBName:       t.DBName,
+			SchemaName:   t.SchemaName,
+			Name:         t.Name,
+			SeqScan:      t.SeqScan - p.SeqScan,
+			IdxScan:      t.IdxScan - p.IdxScan,
+			RowsInserted: int64(t.RowsInserted - p.RowsInserted),
+			RowsUpdated:  t.RowsUpdated - p.RowsUpdated,
+			RowsDeleted:  t.RowsDeleted - p.RowsDeleted,
+			HeapBlksRead: t.HeapBlksRead - p.HeapBlksRead,
+			HeapBlksHit:  t.HeapBlksHit - p.HeapBlksHit,
+			RowsLive:     t.RowsLive,
+			RowsDead:     t.RowsDead,
+		}
+		td.HitRatio = ratio(td.HeapBlksHit, td.HeapBlksRead)
+		deltas = append(deltas, td)
+	}
+
+	sort.SliceStable(deltas, func(i, j int) bool {
+		return deltas[i].activity() > deltas[j].activity()
+	})
+	return deltas
+}
+
+func (t TableDelta) activity() int64 {
+	return t.SeqScan + t.IdxScan + t.RowsInserted + t.RowsUpdated + t.RowsDeleted
+}
+
+// CheckpointDeltas returns the checkpoint and WAL activity of b since a.
+func CheckpointDeltas(a, b model.Model, seconds float64) CheckpointDelta {
+	p, c := a.Checkpointer, b.Checkpointer
+	reset := !c.StatsReset.Equal(p.StatsReset) || c.CheckpointsTimed < p.CheckpointsTimed
+	if reset {
+		p = model.Checkpointer{}
+	}
+
+	d := CheckpointDelta{
+		Timed:             c.CheckpointsTimed - p.CheckpointsTimed,
+		Requested:         c.CheckpointsRequested - p.CheckpointsRequested,
+		WriteTime:         c.WriteTime - p.WriteTime,
+		SyncTime:          c.SyncTime - p.SyncTime,
+		BuffersCheckpoint: c.BuffersCheckpoint - p.BuffersCheckpoint,
+		BuffersClean:      c.BuffersClean - p.BuffersClean,
+		MaxWrittenClean:   c.MaxWrittenClean - p.MaxWrittenClean,
+		BuffersBackend:    c.BuffersBackend - p.BuffersBackend,
+		BuffersAlloc:      c.BuffersAlloc - p.BuffersAlloc,
+		StatsWereReset:    reset,
+	}
+
+	if a.WAL != nil && b.WAL != nil {
+		pw, cw := *a.WAL, *b.WAL
+		if !cw.StatsReset.Equal(pw.StatsReset) || cw.Bytes < pw.Bytes {
+			pw = model.WAL{}
+			d.StatsWereReset = true
+		}
+		d.HasWAL = true
+		d.WALRecords = cw.Records - pw.Records
+		d.WALFPI = cw.FPI - pw.FPI
+		d.WALBytes = cw.Bytes - pw.Bytes
+		d.WALBuffersFull = cw.BuffersFull - pw.BuffersFull
+		if seconds > 0 {
+			d.WALBytesPerSec = float64(d.WALBytes) / seconds
+		}
+	}
+	return d
+}
+
+// SettingChanges returns the settings that differ between a and b.
+func SettingChanges(a, b model.Model) []model.SettingChange {
+	t := producer.NewSettingsTracker()
+	t.Update(a.Settings)
+	change := t.Update(b.Settings)
+	if change == nil {
+		return nil
+	}
+	sort.Slice(change.Changes, func(i, j int) bool {
+		return change.Changes[i].Name < change.Changes[j].Name
+	})
+	return change.Changes
+}
+
+func top(deltas []StatementDelta, n int, less func(a, b StatementDelta) bool) []StatementDelta {
+	sorted := append([]StatementDelta(nil), deltas...)
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// Build builds the report of the activity between the first and the last of
+// snapshots, which must be of the same target and in order. The snapshots in
+// between contribute their active session history and server log summaries.
+func Build(snapshots []model.Model, n int) (Report, error) {
+	if len(snapshots) < 2 {
+		return Report{}, fmt.Errorf("a report needs at least two snapshots, got %d", len(snapshots))
+	}
+	a, b := snapshots[0], snapshots[len(snapshots)-1]
+	if a.Target != b.Target {
+		return Report{}, fmt.Errorf("the snapshots are of different targets: %q and %q", a.Target, b.Target)
+	}
+	if !b.UpdatedAt.After(a.UpdatedAt) {
+		return Report{}, fmt.Errorf("the second snapshot (%s) is not newer than the first (%s)",
+			b.UpdatedAt.Format(time.RFC3339), a.UpdatedAt.Format(time.RFC3339))
+	}
+
+	r := Report{
+		Target:    b.Target,
+		Begin:     a.UpdatedAt,
+		End:       b.UpdatedAt,
+		Seconds:   b.UpdatedAt.Sub(a.UpdatedAt).Seconds(),
+		Snapshots: len(snapshots),
+	}
+
+	statements := StatementDeltas(a, b)
+	r.TopStatementsOnly = !a.StatementsComplete
+	r.TopByTime = top(statements, n, func(x, y StatementDelta) bool { return x.TotalTime > y.TotalTime })
+	r.TopByCalls = top(statements, n, func(x, y StatementDelta) bool { return x.Calls > y.Calls })
+	r.TopByIO = top(statements, n, func(x, y StatementDelta) bool { return x.SharedBlksRead > y.SharedBlksRead })
+	r.TopByTemp = top(statements, n, func(x, y StatementDelta) bool { return x.TempBlksWritten > y.TempBlksWritten })
+
+	r.Databases = DatabaseDeltas(a, b, r.Seconds)
+	var hit, read int64
+	for _, d := range r.Databases {
+		hit += d.BlksHit
+		read += d.BlksRead
+	}
+	r.HitRatio = ratio(hit, read)
+
+	r.Tables = TableDeltas(a, b)
+	if len(r.Tables) > 2*n {
+		r.Tables = r.Tables[:2*n]
+	}
+
+	r.Checkpoints = CheckpointDeltas(a, b, r.Seconds)
+	r.SettingChanges = SettingChanges(a, b)
+
+	// active session history covers the time since the previous snapshot
+	waits := map[string]float64{}
+	var dbTime, window float64
+	for _, m := range snapshots[1:] {
+		if m.ASH != nil {
+			dbTime += m.ASH.DBTimeSeconds
+			window += m.ASH.WindowEnd.Sub(m.ASH.WindowStart).Seconds()
+			for _, w := range m.ASH.ByWaitEvent {
+				waits[w.Key] += w.Seconds
+			}
+		}
+	}
+	r.Checkpoints.LogCheckpoints = logCheckpoints(snapshots)
+	if window > 0 {
+		r.AverageActiveSessions = dbTime / window
+	}
+	for k, s := range waits {
+		w := model.DBTime{Key: k, Seconds: s}
+		if dbTime > 0 {
+			w.Percent = 100 * s / dbTime
+		}
+		r.Waits = append(r.Waits, w)
+	}
+	sort.Slice(r.Waits, func(i, j int) bool { return r.Waits[i].Seconds > r.Waits[j].Seconds })
+
+	return r, nil
+}
+
+// logCheckpoints sums the checkpoints logged during the report. The log
+// summary of a snapshot covers the LOG_SPAN before it, so the summaries of
+// snapshots taken more often overlap: only the ones that do not are summed,
+// latest first, down to the first snapshot. It returns nil if the logs were
+// not collected.
+func logCheckpoints(snapshots []model.Model) *model.CheckpointLogStats {
+	begin := snapshots[0].UpdatedAt
+	var c *model.CheckpointLogStats
+	var covered time.Time
+	for i := len(snapshots) - 1; i > 0; i-- {
+		l := snapshots[i].Logs
+		if l == nil || l.WindowStart.Before(begin) {
+			continue
+		}
+		if c == nil {
+			c = &model.CheckpointLogStats{}
+		} else if l.WindowEnd.After(covered) {
+			continue
+		}
+		covered = l.WindowStart
+
+		c.Timed += l.Checkpoints.Timed
+		c.Requested += l.Checkpoints.Requested
+		c.Completed += l.Checkpoints.Completed
+		c.BuffersWritten += l.Checkpoints.BuffersWritten
+		c.TotalSeconds += l.Checkpoints.TotalSeconds
+		if l.Checkpoints.MaxSeconds > c.MaxSeconds {
+			c.MaxSeconds = l.Checkpoints.MaxSeconds
+		}
+	}
+	return c
+}
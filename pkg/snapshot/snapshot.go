@@ -0,0 +1,138 @@
+// Package snapshot keeps the published snapshots on disk so that reports and
+// diffs can be built from them later, offline.
+//
+// Snapshots are stored as the JSON published on metrics.postgres, one file per
+// snapshot, in a directory per target:
+//
+//	<dir>/<target>/20060102T150405Z.json
+//
+// The agent only publishes JSON, so only JSON payloads are read: there is no
+// protobuf encoding of the snapshots to decode.
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+)
+
+const (
+	fileTimeFormat = "20060102T150405Z"
+	fileExt        = ".json"
+)
+
+// DefaultTarget is the directory of the snapshots that name no target.
+const DefaultTarget = "default"
+
+func targetDir(dir, target string) string {
+	if target == "" {
+		target = DefaultTarget
+	}
+	return filepath.Join(dir, target)
+}
+
+// Save writes data, the encoded snapshot m, to the directory of its target.
+func Save(dir string, m model.Model, data []byte) (string, error) {
+	tdir := targetDir(dir, m.Target)
+	if err := os.MkdirAll(tdir, 0755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(tdir, m.UpdatedAt.UTC().Format(fileTimeFormat)+fileExt)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
+
+// Load reads the snapshot at path.
+func Load(path string) (model.Model, error) {
+	var m model.Model
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '{' {
+		return m, fmt.Errorf("could not decode snapshot %s: not a JSON object, the only encoding of the snapshots published", path)
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("could not decode snapshot %s: %v", path, err)
+	}
+	return m, nil
+}
+
+// File is a stored snapshot.
+type File struct {
+	Path string
+	Time time.Time
+}
+
+// List returns the snapshots of target taken between from and to, oldest
+// first. A zero from or to leaves that end of the range open.
+func List(dir, target string, from, to time.Time) ([]File, error) {
+	entries, err := ioutil.ReadDir(targetDir(dir, target))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		t, err := time.Parse(fileTimeFormat, strings.TrimSuffix(name, fileExt))
+		if err != nil {
+			continue
+		}
+		if !from.IsZero() && t.Before(from) || !to.IsZero() && t.After(to) {
+			continue
+		}
+		files = append(files, File{Path: filepath.Join(targetDir(dir, target), name), Time: t})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Time.Before(files[j].Time) })
+	return files, nil
+}
+
+// Targets returns the targets that have snapshots in dir.
+func Targets(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []string
+	for _, e := range entries {
+		if e.IsDir() {
+			targets = append(targets, e.Name())
+		}
+	}
+	return targets, nil
+}
+
+// Prune removes the snapshots of target taken before t.
+func Prune(dir, target string, t time.Time) error {
+	files, err := List(dir, target, time.Time{}, t)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.Time.Before(t) {
+			if err := os.Remove(f.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
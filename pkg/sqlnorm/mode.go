@@ -0,0 +1,39 @@
+package sqlnorm
+
+import "fmt"
+
+// Mode says what becomes of query texts before they leave the agent.
+type Mode string
+
+const (
+	// Raw keeps query texts as they are.
+	Raw Mode = "raw"
+	// Redact replaces query texts by their normalized form, which contains
+	// no literals.
+	Redact Mode = "redact"
+	// Hash replaces query texts by their fingerprint.
+	Hash Mode = "hash"
+	// Drop removes query texts altogether.
+	Drop Mode = "drop"
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case Raw, Redact, Hash, Drop:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown query text mode %q, expected one of raw, redact, hash or drop", s)
+}
+
+// Apply transforms the query text q according to the mode.
+func (m Mode) Apply(q string) string {
+	switch m {
+	case Redact:
+		return Normalize(q)
+	case Hash:
+		return Fingerprint(q)
+	case Drop:
+		return ""
+	}
+	return q
+}
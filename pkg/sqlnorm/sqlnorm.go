@@ -0,0 +1,314 @@
+// Package sqlnorm normalizes SQL text so that statements that only differ in
+// their constants, parameters, comments, spacing, keyword case or the length
+// of their IN-lists compare equal, and derives stable fingerprints from it.
+package sqlnorm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode"
+)
+
+const (
+	// Placeholder replaces constants and parameters in normalized text.
+	Placeholder = "?"
+	// ListPlaceholder replaces the elements of an IN-list.
+	ListPlaceholder = "..."
+)
+
+// expressionKeywords are the keywords that may directly precede a negative
+// number.
+var expressionKeywords = map[string]bool{
+	"and": true, "between": true, "by": true, "case": true, "else": true,
+	"having": true, "in": true, "is": true, "like": true, "limit": true,
+	"not": true, "offset": true, "on": true, "or": true, "return": true,
+	"select": true, "set": true, "then": true, "values": true, "when": true,
+	"where": true,
+}
+
+type tokenKind int
+
+const (
+	word tokenKind = iota
+	quotedIdent
+	constant
+	operator
+	punct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentChar(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isOperatorChar(r rune) bool {
+	return strings.ContainsRune("+-*/<>=~!@#%^&|`?", r)
+}
+
+// lex splits q into tokens. It is lenient: unterminated strings and the like
+// simply run to the end of the text.
+func lex(q string) []token {
+	rs := []rune(q)
+	var tokens []token
+
+	// a minus sign directly followed by a digit belongs to the number unless
+	// it follows an operand, as in "x-1"
+	afterOperand := func() bool {
+		if len(tokens) == 0 {
+			return false
+		}
+		t := tokens[len(tokens)-1]
+		switch t.kind {
+		case constant, quotedIdent:
+			return true
+		case word:
+			return !expressionKeywords[t.text]
+		}
+		return t.text == ")" || t.text == "]"
+	}
+
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '-' && i+1 < len(rs) && rs[i+1] == '-':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			i = skipBlockComment(rs, i)
+
+		// collapsed list of text that was normalized before
+		case r == '.' && i+2 < len(rs) && rs[i+1] == '.' && rs[i+2] == '.':
+			i += 3
+			tokens = append(tokens, token{constant, ListPlaceholder})
+
+		case r == '\'':
+			i = skipString(rs, i)
+			tokens = append(tokens, token{constant, Placeholder})
+
+		case (r == 'e' || r == 'E' || r == 'b' || r == 'B' || r == 'x' || r == 'X' || r == 'n' || r == 'N') &&
+			i+1 < len(rs) && rs[i+1] == '\'':
+			i = skipString(rs, i+1)
+			tokens = append(tokens, token{constant, Placeholder})
+
+		case r == '$' && i+1 < len(rs) && unicode.IsDigit(rs[i+1]):
+			i++
+			for i < len(rs) && unicode.IsDigit(rs[i]) {
+				i++
+			}
+			tokens = append(tokens, token{constant, Placeholder})
+
+		case r == '$':
+			if end, ok := skipDollarQuote(rs, i); ok {
+				i = end
+				tokens = append(tokens, token{constant, Placeholder})
+			} else {
+				tokens = append(tokens, token{operator, "$"})
+				i++
+			}
+
+		case r == '"':
+			j := i + 1
+			for j < len(rs) {
+				if rs[j] == '"' {
+					if j+1 < len(rs) && rs[j+1] == '"' {
+						j += 2
+						continue
+					}
+					j++
+					break
+				}
+				j++
+			}
+			tokens = append(tokens, token{quotedIdent, string(rs[i:j])})
+			i = j
+
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])) ||
+			(r == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1]) && !afterOperand()):
+			i = skipNumber(rs, i)
+			tokens = append(tokens, token{constant, Placeholder})
+
+		case isIdentStart(r):
+			j := i
+			for j < len(rs) && isIdentChar(rs[j]) {
+				j++
+			}
+			tokens = append(tokens, token{word, strings.ToLower(string(rs[i:j]))})
+			i = j
+
+		case isOperatorChar(r):
+			j := i
+			for j < len(rs) && isOperatorChar(rs[j]) {
+				j++
+			}
+			tokens = append(tokens, token{operator, string(rs[i:j])})
+			i = j
+
+		default:
+			tokens = append(tokens, token{punct, string(r)})
+			i++
+		}
+	}
+	return tokens
+}
+
+// skipString returns the index following the string literal starting at the
+// quote at rs[i]. Doubled quotes and backslash escapes are both accepted.
+func skipString(rs []rune, i int) int {
+	for i++; i < len(rs); i++ {
+		switch rs[i] {
+		case '\\':
+			i++
+		case '\'':
+			if i+1 < len(rs) && rs[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(rs)
+}
+
+// skipDollarQuote returns the index following the dollar-quoted string
+// starting at rs[i], if there is one.
+func skipDollarQuote(rs []rune, i int) (int, bool) {
+	j := i + 1
+	for j < len(rs) && rs[j] != '$' {
+		if !isIdentStart(rs[j]) && !(j > i+1 && unicode.IsDigit(rs[j])) {
+			return 0, false
+		}
+		j++
+	}
+	if j >= len(rs) {
+		return 0, false
+	}
+
+	tag := rs[i : j+1]
+	for k := j + 1; k+len(tag) <= len(rs); k++ {
+		if string(rs[k:k+len(tag)]) == string(tag) {
+			return k + len(tag), true
+		}
+	}
+	return len(rs), true
+}
+
+// skipBlockComment returns the index following the comment starting at
+// rs[i]. Block comments nest in Postgres.
+func skipBlockComment(rs []rune, i int) int {
+	depth := 0
+	for i < len(rs) {
+		switch {
+		case rs[i] == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			depth++
+			i += 2
+		case rs[i] == '*' && i+1 < len(rs) && rs[i+1] == '/':
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+func skipNumber(rs []rune, i int) int {
+	if rs[i] == '-' {
+		i++
+	}
+	for i < len(rs) {
+		r := rs[i]
+		switch {
+		case unicode.IsDigit(r) || r == '.' || r == '_':
+			i++
+		case (r == 'e' || r == 'E') && i+1 < len(rs) &&
+			(unicode.IsDigit(rs[i+1]) || ((rs[i+1] == '+' || rs[i+1] == '-') && i+2 < len(rs) && unicode.IsDigit(rs[i+2]))):
+			i += 2
+		case (r == 'x' || r == 'X' || r == 'o' || r == 'O') && i > 0 && rs[i-1] == '0':
+			i++
+			for i < len(rs) && (unicode.IsDigit(rs[i]) || strings.ContainsRune("abcdefABCDEF_", rs[i])) {
+				i++
+			}
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func join(tokens []token) string {
+	texts := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		texts = append(texts, t.text)
+	}
+	return strings.Join(texts, " ")
+}
+
+// collapseLists replaces IN-lists made of constants only, such as
+// "in ( ? , ? , ? )", by "in ( ... )".
+func collapseLists(tokens []token) []token {
+	out := tokens[:0:0]
+	for i := 0; i < len(tokens); i++ {
+		out = append(out, tokens[i])
+		if tokens[i].kind != word || tokens[i].text != "in" || i+1 >= len(tokens) || tokens[i+1].text != "(" {
+			continue
+		}
+
+		// skip "? ,"* "?" and expect the closing parenthesis
+		j := i + 2
+		for j+1 < len(tokens) && tokens[j].kind == constant && tokens[j+1].text == "," {
+			j += 2
+		}
+		if j+1 < len(tokens) && tokens[j].kind == constant && tokens[j+1].text == ")" {
+			j++
+			out = append(out, tokens[i+1], token{constant, ListPlaceholder}, tokens[j])
+			i = j
+		}
+	}
+	return out
+}
+
+// Normalize strips comments, replaces constants and $n parameters with
+// Placeholder, collapses IN-lists, lowercases keywords and unquoted
+// identifiers and separates tokens by a single space. Query texts as logged by
+// the server and as stored by pg_stat_statements normalize to the same text.
+func Normalize(q string) string {
+	tokens := collapseLists(lex(q))
+	for len(tokens) > 0 && tokens[len(tokens)-1].text == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	return join(tokens)
+}
+
+// Fingerprint identifies the normalized form of q. Queries that normalize to
+// the same text have the same fingerprint.
+func Fingerprint(q string) string {
+	sum := sha256.Sum256([]byte(Normalize(q)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Tokens returns the tokens of the normalized form of q, for the callers
+// looking into its structure.
+func Tokens(q string) []string {
+	var texts []string
+	for _, t := range collapseLists(lex(q)) {
+		texts = append(texts, t.text)
+	}
+	return texts
+}
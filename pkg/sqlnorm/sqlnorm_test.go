@@ -0,0 +1,199 @@
+package sqlnorm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []token
+	}{
+		{
+			name: "words and punctuation",
+			in:   "SELECT a, B FROM t;",
+			want: []token{{word, "select"}, {word, "a"}, {punct, ","}, {word, "b"}, {word, "from"}, {word, "t"}, {punct, ";"}},
+		},
+		{
+			name: "quoted identifier keeps its case",
+			in:   `select "MyCol" from "My ""T"""`,
+			want: []token{{word, "select"}, {quotedIdent, `"MyCol"`}, {word, "from"}, {quotedIdent, `"My ""T"""`}},
+		},
+		{
+			name: "strings",
+			in:   `'it''s' E'a\'b' x'1f' N'n'`,
+			want: []token{{constant, "?"}, {constant, "?"}, {constant, "?"}, {constant, "?"}},
+		},
+		{
+			name: "dollar quotes",
+			in:   "$$a 'b'$$ $fn$ x $$ y $fn$",
+			want: []token{{constant, "?"}, {constant, "?"}},
+		},
+		{
+			name: "parameters",
+			in:   "a = $1 and b = $12",
+			want: []token{{word, "a"}, {operator, "="}, {constant, "?"}, {word, "and"}, {word, "b"}, {operator, "="}, {constant, "?"}},
+		},
+		{
+			name: "numbers",
+			in:   "1 1.5 .5 1e10 2.5E-3 0x1F 1_000",
+			want: []token{{constant, "?"}, {constant, "?"}, {constant, "?"}, {constant, "?"}, {constant, "?"}, {constant, "?"}, {constant, "?"}},
+		},
+		{
+			name: "negative number after keyword",
+			in:   "where a > -1",
+			want: []token{{word, "where"}, {word, "a"}, {operator, ">"}, {constant, "?"}},
+		},
+		{
+			name: "minus after operand",
+			in:   "x-1",
+			want: []token{{word, "x"}, {operator, "-"}, {constant, "?"}},
+		},
+		{
+			name: "minus after parenthesis",
+			in:   "(a)-1",
+			want: []token{{punct, "("}, {word, "a"}, {punct, ")"}, {operator, "-"}, {constant, "?"}},
+		},
+		{
+			name: "comments",
+			in:   "select /* a /* nested */ comment */ 1 -- trailing\n, 2",
+			want: []token{{word, "select"}, {constant, "?"}, {punct, ","}, {constant, "?"}},
+		},
+		{
+			name: "unterminated string",
+			in:   "select 'abc",
+			want: []token{{word, "select"}, {constant, "?"}},
+		},
+		{
+			name: "operators",
+			in:   "a <> b and c::int >= 1",
+			want: []token{{word, "a"}, {operator, "<>"}, {word, "b"}, {word, "and"}, {word, "c"}, {punct, ":"}, {punct, ":"}, {word, "int"}, {operator, ">="}, {constant, "?"}},
+		},
+		{
+			name: "normalized text",
+			in:   "in ( ... )",
+			want: []token{{word, "in"}, {punct, "("}, {constant, "..."}, {punct, ")"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lex(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lex(%q) =\n%v\nwant\n%v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"SELECT * FROM users WHERE id = 42", "select * from users where id = ?"},
+		{"select *\n\tfrom users  where id=$1;", "select * from users where id = ?"},
+		{"SELECT name FROM users WHERE email = 'a@b.c' AND age > -3", "select name from users where email = ? and age > ?"},
+		{`SELECT "Name" FROM "Users"`, `select "Name" from "Users"`},
+		{"select 1; ;", "select ?"},
+		{"update t set a = a-1 where b = 2", "update t set a = a - ? where b = ?"},
+		{"/* app:web */ select 1 -- done", "select ?"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Normalize(tt.in); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeInLists(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"select * from t where id in (1)", "select * from t where id in ( ... )"},
+		{"select * from t where id in (1, 2, 3)", "select * from t where id in ( ... )"},
+		{"select * from t where id IN ($1, $2)", "select * from t where id in ( ... )"},
+		{"select * from t where s in ('a', 'b') and id in (1,2)", "select * from t where s in ( ... ) and id in ( ... )"},
+		// lists holding anything but constants are kept
+		{"select * from t where id in (a, 1)", "select * from t where id in ( a , ? )"},
+		{"select * from t where id in (select id from u)", "select * from t where id in ( select id from u )"},
+		{"select * from t where id in ()", "select * from t where id in ( )"},
+		// already normalized text is stable
+		{"select * from t where id in ( ... )", "select * from t where id in ( ... )"},
+	}
+
+	for _, tt := range tests {
+		if got := Normalize(tt.in); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	same := [][]string{
+		{
+			"SELECT * FROM users WHERE id = 1",
+			"select * from users where id = $1",
+			"select *   from users\nwhere id = 12345; -- by id",
+		},
+		{
+			"select * from t where id in (1, 2, 3)",
+			"select * from t where id in ($1)",
+			"select * from t where id in ( ... )",
+		},
+	}
+	for _, queries := range same {
+		want := Fingerprint(queries[0])
+		for _, q := range queries[1:] {
+			if got := Fingerprint(q); got != want {
+				t.Errorf("Fingerprint(%q) = %s, want %s, that of %q", q, got, want, queries[0])
+			}
+		}
+	}
+
+	different := [][2]string{
+		{"select * from users where id = 1", "select * from users where name = 1"},
+		{`select * from "Users"`, "select * from users"},
+		{"select * from t where id in (1, 2)", "select * from t where id in (a, 2)"},
+	}
+	for _, pair := range different {
+		if Fingerprint(pair[0]) == Fingerprint(pair[1]) {
+			t.Errorf("%q and %q have the same fingerprint", pair[0], pair[1])
+		}
+	}
+
+	if got := len(Fingerprint("select 1")); got != 16 {
+		t.Errorf("fingerprint has %d characters, want 16", got)
+	}
+}
+
+func TestModeApply(t *testing.T) {
+	const q = "SELECT * FROM t WHERE id = 7"
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{"raw", q},
+		{"redact", "select * from t where id = ?"},
+		{"hash", Fingerprint(q)},
+		{"drop", ""},
+	}
+
+	for _, tt := range tests {
+		m, err := ParseMode(tt.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.Apply(q); got != tt.want {
+			t.Errorf("%s: Apply = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+
+	if _, err := ParseMode("mask"); err == nil {
+		t.Error("ParseMode(mask) succeeded")
+	}
+}
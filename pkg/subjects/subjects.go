@@ -0,0 +1,19 @@
+// Package subjects lists the NATS subjects the agent publishes to and answers
+// requests on, for the agent and its consumers alike.
+package subjects
+
+const (
+	Metrics        = "metrics.postgres"
+	Progress       = "metrics.postgres.progress"
+	SettingsChange = "metrics.postgres.settings.changed"
+	Drift          = "metrics.postgres.drift"
+	PlanChange     = "metrics.postgres.plans.changed"
+	QueryText      = "metrics.postgres.querytext"
+	Anomaly        = "metrics.postgres.anomalies"
+	AlertFiring    = "alerts.postgres.firing"
+	AlertResolved  = "alerts.postgres.resolved"
+
+	// request subjects, suffixed with the name of the target
+	ASHDump      = "metrics.postgres.ash.dump"
+	QueryTextGet = "metrics.postgres.querytext.get"
+)
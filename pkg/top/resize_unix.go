@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package top
+
+import (
+	"os"
+	"syscall"
+)
+
+// resizeSignals are the signals telling the terminal was resized.
+var resizeSignals = []os.Signal{syscall.SIGWINCH}
@@ -0,0 +1,7 @@
+package top
+
+import "os"
+
+// resizeSignals are the signals telling the terminal was resized: none on
+// Windows, where the size is only read at start.
+var resizeSignals []os.Signal
@@ -0,0 +1,147 @@
+package top
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/consumer"
+	"github.com/pkbhowmick/pg-monitoring/pkg/subjects"
+)
+
+// Frame is what is shown at a refresh.
+type Frame struct {
+	Snapshot model.Model
+	// Live is false when the sessions and locks could not be read, which is
+	// the case of the NATS source.
+	Live     bool
+	Sessions []model.Session
+	Locks    []model.Lock
+}
+
+// Source provides the frames.
+type Source interface {
+	// Name describes the source in the header.
+	Name() string
+	// Frame returns the current frame, or errNoData if there is none yet.
+	Frame() (Frame, error)
+	Close() error
+}
+
+var errNoData = errors.New("waiting for data")
+
+// Collector reads a database, as the collector of the agent does.
+type Collector interface {
+	Collect() (model.Model, error)
+	Sessions(sqlLength uint) ([]model.Session, error)
+	Locks(sqlLength uint) ([]model.Lock, error)
+}
+
+// DBSource reads a database directly, with the collectors of the agent.
+type DBSource struct {
+	name      string
+	collector Collector
+	sqlLength uint
+}
+
+func NewDBSource(name string, c Collector, sqlLength uint) *DBSource {
+	return &DBSource{name: name, collector: c, sqlLength: sqlLength}
+}
+
+func (s *DBSource) Name() string { return s.name }
+
+func (s *DBSource) Frame() (Frame, error) {
+	var f Frame
+	var err error
+
+	f.Snapshot, err = s.collector.Collect()
+	if err != nil {
+		return f, err
+	}
+	f.Snapshot.Target = s.name
+
+	if f.Sessions, err = s.collector.Sessions(s.sqlLength); err != nil {
+		return f, err
+	}
+	if f.Locks, err = s.collector.Locks(s.sqlLength); err != nil {
+		return f, err
+	}
+	f.Live = true
+	return f, nil
+}
+
+func (s *DBSource) Close() error { return nil }
+
+// NATSSource follows the snapshots an agent publishes for a target. Frames
+// only change as often as the agent publishes.
+type NATSSource struct {
+	consumer *consumer.Consumer
+
+	mu   sync.Mutex
+	name string
+	// target followed, the first one published if none was given, as the
+	// rates are computed between snapshots of the same server
+	target string
+	locked bool
+	latest *model.Model
+}
+
+// NewNATSSource subscribes to the snapshots of target, the first target
+// published if empty, from the stream if one is named.
+func NewNATSSource(nc *nats.Conn, target, stream string) (*NATSSource, error) {
+	s := &NATSSource{name: "nats " + nc.ConnectedUrl(), target: target, locked: target != ""}
+	opts := consumer.Options{
+		Subjects: []string{subjects.Metrics},
+		Stream:   stream,
+		// show something at once rather than after a publishing interval
+		DeliverLast: true,
+	}
+	if target != "" {
+		opts.Targets = []string{target}
+		s.name += " " + target
+	}
+
+	var err error
+	s.consumer, err = consumer.Subscribe(nc, opts, func(msg consumer.Message) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.locked {
+			s.target, s.locked = msg.Metrics.Target, true
+			s.name += " " + s.target
+		}
+		if msg.Metrics.Target != s.target {
+			return
+		}
+		s.latest = msg.Metrics
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *NATSSource) Name() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.name
+}
+
+func (s *NATSSource) Frame() (Frame, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latest == nil {
+		return Frame{}, errNoData
+	}
+	return Frame{Snapshot: *s.latest}, nil
+}
+
+func (s *NATSSource) Close() error {
+	return s.consumer.Close()
+}
+
+// age is how long ago a frame was collected.
+func (f Frame) age(now time.Time) time.Duration {
+	return now.Sub(f.Snapshot.UpdatedAt).Round(time.Second)
+}
@@ -0,0 +1,299 @@
+package top
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkbhowmick/pg-monitoring/model"
+	"github.com/pkbhowmick/pg-monitoring/pkg/report"
+)
+
+type column struct {
+	title string
+	width int
+	// right-aligned numbers, sorted biggest first
+	numeric bool
+}
+
+// row is a line of a tab. values holds the numbers the numeric columns sort
+// by; detail is shown in full below the table for the selected row.
+type row struct {
+	cells  []string
+	values []float64
+	detail string
+}
+
+// tab is a view of the frames. rows is given the two latest distinct
+// snapshots, prev being zero at first.
+type tab struct {
+	name    string
+	columns []column
+	// column sorted by at first
+	sortBy int
+	rows   func(prev Frame, cur Frame) ([]row, string)
+}
+
+var tabs = []tab{activityTab, statementsTab, tablesTab, locksTab, replicationTab}
+
+func fmtInt(v int64) string { return strconv.FormatInt(v, 10) }
+
+func fmtFloat(v float64, digits int) string { return strconv.FormatFloat(v, 'f', digits, 64) }
+
+func fmtSeconds(s float64) string {
+	d := time.Duration(s * float64(time.Second))
+	switch {
+	case d < time.Minute:
+		return fmtFloat(s, 1) + "s"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	default:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+}
+
+func fmtBytes(n int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	v := float64(n)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmtInt(n) + units[0]
+	}
+	return fmtFloat(v, 1) + units[i]
+}
+
+// oneLine flattens a query text for a table cell.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func pidList(pids []int) string {
+	var s []string
+	for _, pid := range pids {
+		s = append(s, strconv.Itoa(pid))
+	}
+	return strings.Join(s, ",")
+}
+
+var activityTab = tab{
+	name: "Activity",
+	columns: []column{
+		{title: "PID", width: 7, numeric: true},
+		{title: "DATABASE", width: 12},
+		{title: "USER", width: 12},
+		{title: "APPLICATION", width: 14},
+		{title: "STATE", width: 10},
+		{title: "WAIT", width: 18},
+		{title: "RUNNING", width: 8, numeric: true},
+		{title: "BLOCKED BY", width: 10},
+		{title: "QUERY", width: 0},
+	},
+	sortBy: 6,
+	rows: func(prev, cur Frame) ([]row, string) {
+		if !cur.Live {
+			return ashRows(cur.Snapshot.ASH)
+		}
+
+		var rows []row
+		for _, s := range cur.Sessions {
+			if s.State == "" || s.State == "idle" {
+				continue
+			}
+			wait := s.WaitEventType
+			if s.WaitEvent != "" {
+				wait += ":" + s.WaitEvent
+			}
+			rows = append(rows, row{
+				cells: []string{strconv.Itoa(s.PID), s.DBName, s.UserName, s.Application, s.State, wait,
+					fmtSeconds(s.QuerySeconds), pidList(s.BlockedBy), oneLine(s.Query)},
+				values: []float64{float64(s.PID), 0, 0, 0, 0, 0, s.QuerySeconds, 0, 0},
+				detail: s.Query,
+			})
+		}
+		return rows, fmt.Sprintf("%d active sessions of %d", len(rows), len(cur.Sessions))
+	},
+}
+
+// ashRows shows the breakdown of the DB time by query of the snapshot when
+// the sessions cannot be read live.
+func ashRows(ash *model.ActiveSessionHistory) ([]row, string) {
+	if ash == nil {
+		return nil, "live activity needs a database connection; enable [ASH] for a summary over NATS"
+	}
+
+	var rows []row
+	for _, q := range ash.ByQuery {
+		rows = append(rows, row{
+			cells:  []string{"", "", "", "", "", "", fmtFloat(q.Seconds, 1), "", oneLine(q.Query)},
+			values: []float64{0, 0, 0, 0, 0, 0, q.Seconds, 0, 0},
+			detail: q.Query,
+		})
+	}
+	return rows, fmt.Sprintf("DB time by query from %s to %s, %.1f average active sessions (RUNNING is DB seconds)",
+		ash.WindowStart.Format("15:04:05"), ash.WindowEnd.Format("15:04:05"), ash.AverageActiveSessions)
+}
+
+var statementsTab = tab{
+	name: "Statements",
+	columns: []column{
+		{title: "DELTA MS", width: 10, numeric: true},
+		{title: "CALLS", width: 8, numeric: true},
+		{title: "MEAN MS", width: 9, numeric: true},
+		{title: "ROWS", width: 8, numeric: true},
+		{title: "HIT %", width: 6, numeric: true},
+		{title: "DATABASE", width: 12},
+		{title: "USER", width: 12},
+		{title: "QUERY", width: 0},
+	},
+	sortBy: 0,
+	rows: func(prev, cur Frame) ([]row, string) {
+		if prev.Snapshot.UpdatedAt.IsZero() {
+			return nil, "waiting for a second snapshot to compute the deltas"
+		}
+
+		var rows []row
+		for _, d := range report.StatementDeltas(prev.Snapshot, cur.Snapshot) {
+			if d.New {
+				// cumulative since the statistics were reset, not a delta
+				continue
+			}
+			query := d.Query
+			if query == "" {
+				query = d.Fingerprint
+			}
+			rows = append(rows, row{
+				cells: []string{fmtFloat(d.TotalTime, 1), fmtInt(d.Calls), fmtFloat(d.MeanTime, 2), fmtInt(d.Rows),
+					fmtFloat(100*d.HitRatio, 1), d.DBName, d.UserName, oneLine(query)},
+				values: []float64{d.TotalTime, float64(d.Calls), d.MeanTime, float64(d.Rows), d.HitRatio, 0, 0, 0},
+				detail: query,
+			})
+		}
+		return rows, fmt.Sprintf("activity over the last %s", cur.Snapshot.UpdatedAt.Sub(prev.Snapshot.UpdatedAt).Round(time.Second))
+	},
+}
+
+var tablesTab = tab{
+	name: "Tables",
+	columns: []column{
+		{title: "TABLE", width: 36},
+		{title: "SEQ SCAN", width: 9, numeric: true},
+		{title: "IDX SCAN", width: 9, numeric: true},
+		{title: "INS", width: 8, numeric: true},
+		{title: "UPD", width: 8, numeric: true},
+		{title: "DEL", width: 8, numeric: true},
+		{title: "LIVE", width: 10, numeric: true},
+		{title: "DEAD", width: 10, numeric: true},
+		{title: "DEAD %", width: 7, numeric: true},
+		{title: "HIT %", width: 6, numeric: true},
+	},
+	sortBy: 7,
+	rows: func(prev, cur Frame) ([]row, string) {
+		// the first snapshot alone shows the totals
+		deltas := report.TableDeltas(prev.Snapshot, cur.Snapshot)
+		var rows []row
+		for _, t := range deltas {
+			dead := 0.0
+			if t.RowsLive+t.RowsDead > 0 {
+				dead = 100 * float64(t.RowsDead) / float64(t.RowsLive+t.RowsDead)
+			}
+			name := t.DBName + "." + t.SchemaName + "." + t.Name
+			rows = append(rows, row{
+				cells: []string{name, fmtInt(t.SeqScan), fmtInt(t.IdxScan), fmtInt(t.RowsInserted), fmtInt(t.RowsUpdated),
+					fmtInt(t.RowsDeleted), strconv.Itoa(t.RowsLive), strconv.Itoa(t.RowsDead), fmtFloat(dead, 1),
+					fmtFloat(100*t.HitRatio, 1)},
+				values: []float64{0, float64(t.SeqScan), float64(t.IdxScan), float64(t.RowsInserted), float64(t.RowsUpdated),
+					float64(t.RowsDeleted), float64(t.RowsLive), float64(t.RowsDead), dead, t.HitRatio},
+				detail: name,
+			})
+		}
+		if prev.Snapshot.UpdatedAt.IsZero() {
+			return rows, "totals since the statistics were reset"
+		}
+		return rows, fmt.Sprintf("scans and writes over the last %s", cur.Snapshot.UpdatedAt.Sub(prev.Snapshot.UpdatedAt).Round(time.Second))
+	},
+}
+
+var locksTab = tab{
+	name: "Locks",
+	columns: []column{
+		{title: "PID", width: 7, numeric: true},
+		{title: "STATUS", width: 8},
+		{title: "LOCK", width: 14},
+		{title: "MODE", width: 20},
+		{title: "RELATION", width: 24},
+		{title: "WAITING", width: 8, numeric: true},
+		{title: "BLOCKED BY", width: 10},
+		{title: "QUERY", width: 0},
+	},
+	sortBy: 5,
+	rows: func(prev, cur Frame) ([]row, string) {
+		if !cur.Live {
+			return nil, "locks need a database connection"
+		}
+
+		var rows []row
+		waiting := 0
+		for _, l := range cur.Locks {
+			status, wait := "granted", ""
+			if !l.Granted {
+				status, wait = "waiting", fmtSeconds(l.WaitSeconds)
+				waiting++
+			}
+			rows = append(rows, row{
+				cells:  []string{strconv.Itoa(l.PID), status, l.LockType, l.Mode, l.Relation, wait, pidList(l.BlockedBy), oneLine(l.Query)},
+				values: []float64{float64(l.PID), 0, 0, 0, 0, l.WaitSeconds, 0, 0},
+				detail: l.Query,
+			})
+		}
+		return rows, fmt.Sprintf("%d lock waits", waiting)
+	},
+}
+
+var replicationTab = tab{
+	name: "Replication",
+	columns: []column{
+		{title: "STANDBY", width: 20},
+		{title: "ADDRESS", width: 16},
+		{title: "STATE", width: 10},
+		{title: "SYNC", width: 8},
+		{title: "WRITE LAG", width: 10, numeric: true},
+		{title: "FLUSH LAG", width: 10, numeric: true},
+		{title: "REPLAY LAG", width: 10, numeric: true},
+		{title: "LAG BYTES", width: 10, numeric: true},
+	},
+	sortBy: 7,
+	rows: func(prev, cur Frame) ([]row, string) {
+		r := cur.Snapshot.Replication
+		var rows []row
+		for _, s := range r.Standbys {
+			rows = append(rows, row{
+				cells: []string{s.ApplicationName, s.ClientAddr, s.State, s.SyncState, fmtSeconds(s.WriteLagSeconds),
+					fmtSeconds(s.FlushLagSeconds), fmtSeconds(s.ReplayLagSeconds), fmtBytes(s.ReplayLagBytes)},
+				values: []float64{0, 0, 0, 0, s.WriteLagSeconds, s.FlushLagSeconds, s.ReplayLagSeconds, float64(s.ReplayLagBytes)},
+				detail: s.ApplicationName + " " + s.ClientAddr,
+			})
+		}
+		if r.InRecovery {
+			return rows, "replica, replaying " + fmtSeconds(r.ReplayLagSeconds) + " behind its primary"
+		}
+		return rows, fmt.Sprintf("primary with %d standbys", len(r.Standbys))
+	},
+}
+
+// less orders rows by column c, numbers biggest first.
+func less(a, b row, c int, numeric bool) bool {
+	if numeric {
+		x, y := a.values[c], b.values[c]
+		if math.IsNaN(y) {
+			return !math.IsNaN(x)
+		}
+		return x > y
+	}
+	return strings.ToLower(a.cells[c]) < strings.ToLower(b.cells[c])
+}
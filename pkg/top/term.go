@@ -0,0 +1,142 @@
+package top
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// The terminal is driven with ANSI escape sequences, its mode being set with
+// stty(1).
+const (
+	altScreenOn  = "\x1b[?1049h"
+	altScreenOff = "\x1b[?1049l"
+	cursorHide   = "\x1b[?25l"
+	cursorShow   = "\x1b[?25h"
+	home         = "\x1b[H"
+	clearLine    = "\x1b[K"
+	clearBelow   = "\x1b[J"
+	reverse      = "\x1b[7m"
+	bold         = "\x1b[1m"
+	dim          = "\x1b[2m"
+	reset        = "\x1b[0m"
+)
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// terminal restores the mode it found the terminal in on restore.
+type terminal struct {
+	saved string
+}
+
+// openTerminal makes keys readable one at a time, unechoed, and switches to
+// the alternate screen.
+func openTerminal() (*terminal, error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, err
+	}
+	os.Stdout.WriteString(altScreenOn + cursorHide)
+	return &terminal{saved: saved}, nil
+}
+
+func (t *terminal) restore() {
+	os.Stdout.WriteString(cursorShow + altScreenOff)
+	stty(t.saved)
+}
+
+// size reads the size of the terminal, 24x80 if unknown.
+func size() (rows, cols int) {
+	out, err := stty("size")
+	if err == nil {
+		if f := strings.Fields(out); len(f) == 2 {
+			rows, _ = strconv.Atoi(f[0])
+			cols, _ = strconv.Atoi(f[1])
+		}
+	}
+	if rows <= 0 || cols <= 0 {
+		return 24, 80
+	}
+	return rows, cols
+}
+
+// key is a key press: a character or one of the named keys.
+type key string
+
+const (
+	keyUp     key = "up"
+	keyDown   key = "down"
+	keyLeft   key = "left"
+	keyRight  key = "right"
+	keyPgUp   key = "pgup"
+	keyPgDown key = "pgdown"
+	keyEnter  key = "enter"
+	keyEsc    key = "esc"
+	keyBack   key = "backspace"
+	keyTab    key = "tab"
+)
+
+// readKeys sends the keys typed on stdin to keys until stdin is closed.
+func readKeys(keys chan<- key) {
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		for _, k := range parseKeys(buf[:n]) {
+			keys <- k
+		}
+	}
+}
+
+var escapes = map[string]key{
+	"\x1b[A": keyUp, "\x1b[B": keyDown, "\x1b[C": keyRight, "\x1b[D": keyLeft,
+	"\x1bOA": keyUp, "\x1bOB": keyDown, "\x1bOC": keyRight, "\x1bOD": keyLeft,
+	"\x1b[5~": keyPgUp, "\x1b[6~": keyPgDown,
+}
+
+func parseKeys(b []byte) []key {
+	var keys []key
+	for len(b) > 0 {
+		if b[0] == 0x1b {
+			matched := false
+			for seq, k := range escapes {
+				if strings.HasPrefix(string(b), seq) {
+					keys = append(keys, k)
+					b = b[len(seq):]
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				keys = append(keys, keyEsc)
+				b = b[1:]
+			}
+			continue
+		}
+
+		switch b[0] {
+		case '\r', '\n':
+			keys = append(keys, keyEnter)
+		case '\t':
+			keys = append(keys, keyTab)
+		case 0x7f, 0x08:
+			keys = append(keys, keyBack)
+		default:
+			keys = append(keys, key(b[:1]))
+		}
+		b = b[1:]
+	}
+	return keys
+}
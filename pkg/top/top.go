@@ -0,0 +1,387 @@
+// Package top is a full-screen terminal view of a Postgres server in the
+// manner of top(1), fed from the database itself or from what an agent
+// publishes on NATS.
+package top
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+const DefaultInterval = 2 * time.Second
+
+const help = "1-5/tab switch  ↑↓ select  s sort  r reverse  / filter  enter full text  p pause  q quit"
+
+// view is the state of the screen.
+type view struct {
+	source   Source
+	interval time.Duration
+
+	tab      int
+	sortBy   []int
+	reversed []bool
+
+	filter  string
+	editing bool
+
+	selected int
+	offset   int
+	detail   bool
+	paused   bool
+
+	prev, cur Frame
+	err       error
+
+	// size of the terminal, read again when it is resized
+	height, width int
+}
+
+// Run shows the frames of src, refreshed every interval, until q is pressed.
+func Run(src Source, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	v := &view{source: src, interval: interval}
+	for _, t := range tabs {
+		v.sortBy = append(v.sortBy, t.sortBy)
+		v.reversed = append(v.reversed, false)
+	}
+
+	term, err := openTerminal()
+	if err != nil {
+		return fmt.Errorf("could not set up the terminal: %v", err)
+	}
+	defer term.restore()
+
+	keys := make(chan key)
+	go readKeys(keys)
+
+	v.height, v.width = size()
+	resized := make(chan os.Signal, 1)
+	if len(resizeSignals) > 0 {
+		signal.Notify(resized, resizeSignals...)
+		defer signal.Stop(resized)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	type result struct {
+		f   Frame
+		err error
+	}
+	frames := make(chan result, 1)
+	refresh := func() {
+		go func() {
+			f, err := src.Frame()
+			frames <- result{f, err}
+		}()
+	}
+	refresh()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	pending := true
+
+	for {
+		v.render()
+		select {
+		case <-stop:
+			return nil
+		case <-resized:
+			v.height, v.width = size()
+		case r := <-frames:
+			pending = false
+			v.update(r.f, r.err)
+		case <-ticker.C:
+			if !pending && !v.paused {
+				pending = true
+				refresh()
+			}
+		case k, ok := <-keys:
+			if !ok || v.handle(k) {
+				return nil
+			}
+		}
+	}
+}
+
+func (v *view) update(f Frame, err error) {
+	v.err = err
+	if err != nil {
+		return
+	}
+	// deltas are computed between distinct snapshots, NATS sources
+	// repeating the last one until the agent publishes again
+	if !f.Snapshot.UpdatedAt.Equal(v.cur.Snapshot.UpdatedAt) {
+		if !v.cur.Snapshot.UpdatedAt.IsZero() {
+			v.prev = v.cur
+		}
+	}
+	v.cur = f
+}
+
+// handle acts on a key and tells whether to quit.
+func (v *view) handle(k key) bool {
+	if v.editing {
+		switch k {
+		case keyEnter:
+			v.editing = false
+		case keyEsc:
+			v.editing = false
+			v.filter = ""
+		case keyBack:
+			if v.filter != "" {
+				_, n := utf8.DecodeLastRuneInString(v.filter)
+				v.filter = v.filter[:len(v.filter)-n]
+			}
+		default:
+			// named keys are longer
+			if len(k) == 1 {
+				v.filter += string(k)
+			}
+		}
+		v.selected, v.offset = 0, 0
+		return false
+	}
+
+	switch k {
+	case "q", "Q":
+		return true
+	case "1", "2", "3", "4", "5":
+		v.switchTab(int(k[0] - '1'))
+	case keyTab, keyRight:
+		v.switchTab((v.tab + 1) % len(tabs))
+	case keyLeft:
+		v.switchTab((v.tab + len(tabs) - 1) % len(tabs))
+	case keyUp, "k":
+		v.selected--
+	case keyDown, "j":
+		v.selected++
+	case keyPgUp:
+		v.selected -= 10
+	case keyPgDown:
+		v.selected += 10
+	case "s":
+		v.sortBy[v.tab] = (v.sortBy[v.tab] + 1) % len(tabs[v.tab].columns)
+	case "S":
+		n := len(tabs[v.tab].columns)
+		v.sortBy[v.tab] = (v.sortBy[v.tab] + n - 1) % n
+	case "r":
+		v.reversed[v.tab] = !v.reversed[v.tab]
+	case "/":
+		v.editing = true
+	case keyEsc:
+		v.filter = ""
+		v.detail = false
+	case keyEnter, "f":
+		v.detail = !v.detail
+	case "p":
+		v.paused = !v.paused
+	}
+	return false
+}
+
+func (v *view) switchTab(i int) {
+	if i >= 0 && i < len(tabs) && i != v.tab {
+		v.tab, v.selected, v.offset, v.detail = i, 0, 0, false
+	}
+}
+
+// rows returns the rows of the current tab, filtered and sorted.
+func (v *view) rows() ([]row, string) {
+	t := tabs[v.tab]
+	all, info := t.rows(v.prev, v.cur)
+
+	var rows []row
+	filter := strings.ToLower(v.filter)
+	for _, r := range all {
+		if filter == "" || strings.Contains(strings.ToLower(strings.Join(r.cells, " ")), filter) {
+			rows = append(rows, r)
+		}
+	}
+
+	c, rev := v.sortBy[v.tab], v.reversed[v.tab]
+	numeric := t.columns[c].numeric
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rev {
+			return less(rows[j], rows[i], c, numeric)
+		}
+		return less(rows[i], rows[j], c, numeric)
+	})
+	return rows, info
+}
+
+// fit pads or cuts s to width columns.
+func fit(s string, width int, right bool) string {
+	n := utf8.RuneCountInString(s)
+	if n > width {
+		r := []rune(s)
+		if width <= 1 {
+			return string(r[:width])
+		}
+		return string(r[:width-1]) + "…"
+	}
+	pad := strings.Repeat(" ", width-n)
+	if right {
+		return pad + s
+	}
+	return s + pad
+}
+
+// wrap cuts s into lines of at most width columns.
+func wrap(s string, width int) []string {
+	var lines []string
+	for _, para := range strings.Split(strings.TrimRight(s, "\n"), "\n") {
+		r := []rune(strings.ReplaceAll(para, "\t", "    "))
+		for len(r) > width {
+			lines = append(lines, string(r[:width]))
+			r = r[width:]
+		}
+		lines = append(lines, string(r))
+	}
+	return lines
+}
+
+func (v *view) render() {
+	height, width := v.height, v.width
+	var b strings.Builder
+	b.WriteString(home)
+	line := func(s string) {
+		b.WriteString(s + reset + clearLine + "\n")
+	}
+
+	now := time.Now()
+	status := v.source.Name()
+	if !v.cur.Snapshot.UpdatedAt.IsZero() {
+		status += fmt.Sprintf("  %s (%s ago)", v.cur.Snapshot.UpdatedAt.Format("15:04:05"), v.cur.age(now))
+	}
+	if v.paused {
+		status += "  PAUSED"
+	}
+	line(bold + fit("pg-monitoring top  "+status, width, false))
+
+	var bar strings.Builder
+	for i, t := range tabs {
+		label := fmt.Sprintf(" %d %s ", i+1, t.name)
+		if i == v.tab {
+			bar.WriteString(reverse + label + reset)
+		} else {
+			bar.WriteString(label)
+		}
+		bar.WriteString(" ")
+	}
+	line(bar.String())
+
+	rows, info := v.rows()
+	switch {
+	case v.err == errNoData:
+		info = "waiting for a snapshot..."
+	case v.err != nil:
+		info = "error: " + v.err.Error()
+	}
+	if v.filter != "" {
+		info = fmt.Sprintf("filter %q, %d rows  %s", v.filter, len(rows), info)
+	}
+	line(dim + fit(info, width, false))
+
+	// header
+	t := tabs[v.tab]
+	widths := make([]int, len(t.columns))
+	used := 0
+	for i, c := range t.columns {
+		widths[i] = c.width
+		used += c.width + 1
+	}
+	for i, c := range t.columns {
+		if c.width == 0 {
+			widths[i] = width - used
+			if widths[i] < 10 {
+				widths[i] = 10
+			}
+		}
+	}
+	cells := func(r []string, sortMark bool) string {
+		var s strings.Builder
+		for i, c := range t.columns {
+			text := r[i]
+			if sortMark && i == v.sortBy[v.tab] {
+				if v.reversed[v.tab] {
+					text += "▲"
+				} else {
+					text += "▼"
+				}
+			}
+			s.WriteString(fit(text, widths[i], c.numeric) + " ")
+		}
+		return fit(s.String(), width, false)
+	}
+	titles := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		titles[i] = c.title
+	}
+	line(bold + reverse + cells(titles, true))
+
+	// rows, above the full text of the selected row if shown
+	room := height - 5
+	var detail []string
+	if v.detail && len(rows) > 0 {
+		detail = wrap(rows[clamp(v.selected, 0, len(rows)-1)].detail, width)
+		if max := room / 2; len(detail) > max {
+			detail = append(detail[:max-1], "…")
+		}
+		room -= len(detail) + 1
+	}
+	if room < 1 {
+		room = 1
+	}
+
+	v.selected = clamp(v.selected, 0, len(rows)-1)
+	if v.selected < v.offset {
+		v.offset = v.selected
+	}
+	if v.selected >= v.offset+room {
+		v.offset = v.selected - room + 1
+	}
+	for i := 0; i < room; i++ {
+		n := v.offset + i
+		switch {
+		case n >= len(rows):
+			line("")
+		case n == v.selected:
+			line(reverse + cells(rows[n].cells, false))
+		default:
+			line(cells(rows[n].cells, false))
+		}
+	}
+	if detail != nil {
+		line(dim + strings.Repeat("─", width))
+		for _, l := range detail {
+			line(l)
+		}
+	}
+
+	if v.editing {
+		b.WriteString(fit("/"+v.filter+"_", width, false) + reset + clearLine)
+	} else {
+		b.WriteString(dim + fit(help, width, false) + reset + clearLine)
+	}
+	b.WriteString(clearBelow)
+	os.Stdout.WriteString(b.String())
+}
+
+func clamp(n, lo, hi int) int {
+	if n > hi {
+		n = hi
+	}
+	if n < lo {
+		n = lo
+	}
+	return n
+}